@@ -0,0 +1,151 @@
+// Package prompt reads passwords for the vsafe command line tools without
+// putting them on the command line. A password comes from a file
+// descriptor, from an environment variable, or from a prompt on the
+// terminal in that order of preference.
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	// Insecure is the password that the vsafe tools used to default to.
+	// Read refuses it.
+	Insecure = "password"
+)
+
+var (
+	// Indicates the insecure default password.
+	ErrInsecure = errors.New(
+		"prompt: Refusing insecure password \"password\".")
+	// Indicates an empty password.
+	ErrEmpty = errors.New("prompt: Empty password.")
+	// Indicates that a new password was re-typed incorrectly.
+	ErrMismatch = errors.New("prompt: Passwords do not match.")
+	// Indicates that there is no terminal to prompt on.
+	ErrNoTerminal = errors.New("prompt: No terminal to prompt for password.")
+)
+
+// Flags says where to read one password from. Use AddFlags to create.
+type Flags struct {
+	name string
+	fd   int
+	env  string
+}
+
+// AddFlags adds the -<name>_fd and -<name>_env flags to f which tell
+// where to read the password described by usage from.
+func AddFlags(f *flag.FlagSet, name, usage string) *Flags {
+	result := &Flags{name: name}
+	f.IntVar(
+		&result.fd,
+		name+"_fd",
+		-1,
+		fmt.Sprintf("Read %s from this file descriptor", usage))
+	f.StringVar(
+		&result.env,
+		name+"_env",
+		"",
+		fmt.Sprintf("Read %s from this environment variable", usage))
+	return result
+}
+
+// Read reads the password. If neither the file descriptor flag nor the
+// environment variable flag is set, Read shows prompt on the terminal and
+// reads the password without echoing it. Read returns ErrInsecure for the
+// insecure default password and ErrEmpty for an empty password.
+func (f *Flags) Read(prompt string) (string, error) {
+	if f.fd >= 0 || f.env != "" {
+		return f.readNonInteractive()
+	}
+	return check(readTerminal(prompt))
+}
+
+// ReadNew works like Read except that it asks for the password twice when
+// prompting on the terminal and returns ErrMismatch if the two differ.
+func (f *Flags) ReadNew(prompt string) (string, error) {
+	if f.fd >= 0 || f.env != "" {
+		return f.readNonInteractive()
+	}
+	password, err := check(readTerminal(prompt))
+	if err != nil {
+		return "", err
+	}
+	retyped, err := readTerminal("Retype " + strings.ToLower(prompt))
+	if err != nil {
+		return "", err
+	}
+	if retyped != password {
+		return "", ErrMismatch
+	}
+	return password, nil
+}
+
+func (f *Flags) readNonInteractive() (string, error) {
+	if f.fd >= 0 {
+		file := os.NewFile(uintptr(f.fd), f.name)
+		if file == nil {
+			return "", fmt.Errorf("prompt: Bad file descriptor %d.", f.fd)
+		}
+		defer file.Close()
+		return check(readLine(file))
+	}
+	password, ok := os.LookupEnv(f.env)
+	if !ok {
+		return "", fmt.Errorf(
+			"prompt: Environment variable %s not set.", f.env)
+	}
+	return check(password, nil)
+}
+
+// readLine returns the first line of r without the line ending.
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readTerminal prompts for a password on standard input if it is a
+// terminal. Otherwise, such as when standard input is a pipe, it prompts
+// on the controlling terminal.
+func readTerminal(prompt string) (string, error) {
+	in, out := os.Stdin, os.Stderr
+	if !term.IsTerminal(int(in.Fd())) {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return "", ErrNoTerminal
+		}
+		defer tty.Close()
+		in, out = tty, tty
+	}
+	fmt.Fprint(out, prompt)
+	password, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(out)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+func check(password string, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", ErrEmpty
+	}
+	if password == Insecure {
+		return "", ErrInsecure
+	}
+	return password, nil
+}
@@ -0,0 +1,62 @@
+package prompt_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/keep94/vsafe/apps/prompt"
+)
+
+func TestReadEnv(t *testing.T) {
+	t.Setenv("VSAFE_TEST_PASSWORD", "s3cret")
+	flags := newFlags(t, "-password_env", "VSAFE_TEST_PASSWORD")
+	password, err := flags.Read("Password: ")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if password != "s3cret" {
+		t.Errorf("Expected s3cret, got %q", password)
+	}
+	t.Setenv("VSAFE_TEST_PASSWORD", prompt.Insecure)
+	if _, err := flags.Read("Password: "); err != prompt.ErrInsecure {
+		t.Errorf("Expected ErrInsecure, got %v", err)
+	}
+	t.Setenv("VSAFE_TEST_PASSWORD", "")
+	if _, err := flags.ReadNew("Password: "); err != prompt.ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+	flags = newFlags(t, "-password_env", "VSAFE_TEST_UNSET")
+	if _, err := flags.Read("Password: "); err == nil {
+		t.Error("Expected error for unset variable")
+	}
+}
+
+func TestReadFd(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Error creating pipe: %v", err)
+	}
+	defer w.Close()
+	fmt.Fprint(w, "s3cret\r\nignored\n")
+	w.Close()
+	flags := newFlags(t, "-password_fd", fmt.Sprint(r.Fd()))
+	password, err := flags.ReadNew("Password: ")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if password != "s3cret" {
+		t.Errorf("Expected s3cret, got %q", password)
+	}
+}
+
+func newFlags(t *testing.T, args ...string) *prompt.Flags {
+	t.Helper()
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	result := prompt.AddFlags(flagSet, "password", "test password")
+	if err := flagSet.Parse(args); err != nil {
+		t.Fatalf("Error parsing flags: %v", err)
+	}
+	return result
+}
@@ -0,0 +1,403 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/http_util"
+	"github.com/keep94/toolbox/idset"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/common"
+	"github.com/keep94/vsafe/vsafedb"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kAdmin = "admin"
+)
+
+var (
+	kErrUserFieldRequired = errors.New("User field required")
+	kErrNameFieldRequired = errors.New("Name field required")
+	kErrPasswordMismatch  = errors.New("Password re-typed incorrectly.")
+	kErrNameTaken         = errors.New("That name is already taken.")
+)
+
+var (
+	kTemplateSpec = `
+<html>
+<head>
+  <title>Vsafe using Go</title>
+  <link rel="stylesheet" type="text/css" href="/static/theme.css" />
+  <link rel="shortcut icon" href="/images/favicon.ico" type="image/x-icon" />
+  <script type="text/javascript" src="/static/vsafe.js"></script>
+</head>
+<body>
+<h2>Users sharing your vault</h2>
+<a href="/vsafe/home">Back</a>
+<br><br>
+{{if .Error}}
+  <span class="error">{{.Error.Error}}</span>
+{{end}}
+{{if .Message}}
+  <font color="#006600"><b>{{.Message}}</b></font>
+{{end}}
+<table>
+{{range .Users}}
+  <tr class="lineitem">
+    <td>{{.Name}}</td>
+    <td>{{if .Owner}}&nbsp;{{else}}master{{end}}</td>
+    <td>{{.GetRole}}</td>
+    <td>{{if .IsRestricted}}{{$.CategoryNames .Categories}}{{else}}all categories{{end}}</td>
+  </tr>
+{{end}}
+</table>
+<br>
+<form method="post">
+<input type="hidden" name="xsrf" value="{{.Xsrf}}">
+<table>
+  <tr>
+    <td>Existing user:</td>
+    <td>
+      <select name="user" size=1>
+{{with .GetSelection .UserSelections "user"}}
+        <option value="{{.Value}}">{{.Name}}</option>
+{{else}}
+        <option value="">--Select One--</option>
+{{end}}
+{{range .UserSelections}}
+        <option value="{{.Value}}">{{.Name}}</option>
+{{end}}
+      </select>
+    </td>
+  </tr>
+  <tr>
+    <td>New user:</td>
+    <td><input type="text" name="name" value="{{.Get "name"}}" size="40"></td>
+  </tr>
+  <tr>
+    <td>Role:</td>
+    <td>
+      <select name="role" size=1>
+{{$role := .Get "role"}}
+{{range .Roles}}
+        <option value="{{.}}" {{if eq $role .String}}selected{{end}}>{{.}}</option>
+{{end}}
+      </select>
+    </td>
+  </tr>
+  <tr>
+    <td>Initial password:</td>
+    <td><input type="password" name="password" autocomplete="new-password"></td>
+  </tr>
+  <tr>
+    <td>Verify:</td>
+    <td><input type="password" name="verify" autocomplete="new-password"></td>
+  </tr>
+  <tr>
+    <td valign="top">Only categories:</td>
+    <td>
+{{range .Categories}}
+      <input type="checkbox" name="cat" value="{{.Id}}" {{if index $.CatMap .Id}}checked{{end}}>{{.Name}}<br>
+{{end}}
+    </td>
+  </tr>
+{{if .NeedsReauth}}
+  <tr>
+    <td>Your password:</td>
+    <td><input type="password" name="reauth"></td>
+  </tr>
+{{end}}
+</table>
+<br>
+<input type="submit" name="add" value="Add">
+<input type="submit" name="setrole" value="Set role">
+<input type="submit" name="setcats" value="Set categories">
+<input type="submit" name="reset" value="Reset password">
+<input type="submit" name="remove" value="Remove" data-confirm="Are you sure you want to remove this user?">
+</form>
+</body>
+</html>`
+)
+
+var (
+	kTemplate *template.Template
+)
+
+type Store interface {
+	vsafedb.AddUserRunner
+	vsafedb.UserByIdRunner
+	vsafedb.UsersByOwnerRunner
+	vsafedb.UpdateUserRunner
+	vsafedb.RemoveUserRunner
+	vsafedb.CategoriesByOwnerRunner
+	vsafedb.MembershipsByUserRunner
+	vsafedb.RemoveMembershipRunner
+}
+
+// Handler lets master users manage the users who share their key.
+// Sub-users get 403 Forbidden.
+type Handler struct {
+	Doer  db.Doer
+	Store Store
+	// How long after entering their password a master user may change
+	// users without entering their password again.
+	ReauthWindow time.Duration
+	// The policy new passwords must meet
+	PasswordPolicy vsafe.PasswordPolicy
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	session := common.GetUserSession(r)
+	if session.User.Owner != 0 {
+		http_util.Error(w, http.StatusForbidden)
+		return
+	}
+	key := session.Key()
+	message := ""
+	var err error
+	var values http_util.Values
+	var catMap map[int64]bool
+	if r.Method == "POST" {
+		id, _ := strconv.ParseInt(r.Form.Get("user"), 10, 64)
+		name := r.Form.Get("name")
+		password := r.Form.Get("password")
+		role, roleErr := vsafe.ParseRole(r.Form.Get("role"))
+		catMap = toCatMap(r.Form["cat"])
+		if !common.VerifyXsrfToken(r, kAdmin) {
+			err = common.ErrXsrf
+		} else if http_util.HasParam(r.Form, "add") {
+			if roleErr != nil {
+				err = roleErr
+			} else if strings.TrimSpace(name) == "" {
+				err = kErrNameFieldRequired
+			} else if err = h.checkPassword(
+				&vsafe.User{Name: name}, r.Form); err != nil {
+				// Do nothing
+			} else if err = common.VerifyReauth(
+				w, r, h.ReauthWindow); err == nil {
+				err = h.addUser(name, password, key, role)
+				message = fmt.Sprintf("User %s added.", name)
+			}
+		} else if http_util.HasParam(r.Form, "setrole") {
+			if id == 0 {
+				err = kErrUserFieldRequired
+			} else if roleErr != nil {
+				err = roleErr
+			} else if err = common.VerifyReauth(
+				w, r, h.ReauthWindow); err == nil {
+				var user *vsafe.User
+				user, err = h.setRole(id, key.Id, role)
+				if err == nil {
+					message = fmt.Sprintf(
+						"%s is now %s.", user.Name, user.Role)
+				}
+			}
+		} else if http_util.HasParam(r.Form, "setcats") {
+			if id == 0 {
+				err = kErrUserFieldRequired
+			} else if err = common.VerifyReauth(
+				w, r, h.ReauthWindow); err == nil {
+				var user *vsafe.User
+				user, err = h.setCategories(id, key.Id, idset.New(catMap))
+				if err == nil {
+					message = fmt.Sprintf(
+						"Categories of %s changed.", user.Name)
+				}
+			}
+		} else if http_util.HasParam(r.Form, "reset") {
+			if id == 0 {
+				err = kErrUserFieldRequired
+			} else if err = checkPasswordsMatch(r.Form); err != nil {
+				// Do nothing
+			} else if err = common.VerifyReauth(
+				w, r, h.ReauthWindow); err == nil {
+				var user *vsafe.User
+				user, err = h.resetPassword(id, password, key)
+				if err == nil {
+					message = fmt.Sprintf(
+						"Password of %s reset.", user.Name)
+				}
+			}
+		} else if http_util.HasParam(r.Form, "remove") {
+			if id == 0 {
+				err = kErrUserFieldRequired
+			} else if err = common.VerifyReauth(
+				w, r, h.ReauthWindow); err == nil {
+				var oldName string
+				oldName, err = h.removeUser(id, key.Id)
+				message = fmt.Sprintf("User %s removed.", oldName)
+			}
+		}
+		if err == vsafedb.ErrNameTaken {
+			err = kErrNameTaken
+		}
+		if err != nil {
+			values = http_util.Values{Values: r.Form}
+			message = ""
+		} else {
+			catMap = nil
+		}
+	}
+	ctx := r.Context()
+	categories, readErr := vsafedb.CategoriesByOwnerContext(
+		ctx, h.Store, nil, key.Id)
+	if readErr != nil {
+		http_util.ReportError(w, "Error reading database.", readErr)
+		return
+	}
+	var users []vsafe.User
+	readErr = vsafedb.UsersByOwnerContext(
+		ctx, h.Store, nil, key.Id, consume2.AppendTo(&users))
+	if readErr != nil {
+		http_util.ReportError(w, "Error reading database.", readErr)
+		return
+	}
+	http_util.WriteTemplate(
+		w,
+		kTemplate,
+		&view{
+			Users:          users,
+			UserSelections: subUserSelections(users),
+			Roles:          vsafe.AllRoles,
+			Categories:     categories,
+			CatMap:         catMap,
+			Values:         values,
+			Error:          err,
+			Message:        message,
+			NeedsReauth:    !common.IsReauthenticated(r, h.ReauthWindow),
+			Xsrf:           common.NewXsrfToken(r, kAdmin)})
+}
+
+func (h *Handler) addUser(
+	name, password string, key *vsafe.Key, role vsafe.Role) error {
+	var user vsafe.User
+	if err := user.InitWithKey(name, password, key); err != nil {
+		return err
+	}
+	user.Role = role
+	return h.Store.AddUser(nil, &user)
+}
+
+func (h *Handler) resetPassword(
+	id int64, password string, key *vsafe.Key) (
+	user *vsafe.User, err error) {
+	err = h.Doer.Do(func(t db.Transaction) error {
+		var err error
+		user, err = vsafedb.ResetPassword(
+			h.Store, t, id, key, password, &h.PasswordPolicy)
+		return err
+	})
+	return
+}
+
+func (h *Handler) setRole(id, owner int64, role vsafe.Role) (
+	user *vsafe.User, err error) {
+	err = h.Doer.Do(func(t db.Transaction) error {
+		var err error
+		user, err = vsafedb.SetRole(h.Store, t, id, owner, role)
+		return err
+	})
+	return
+}
+
+func (h *Handler) setCategories(
+	id, owner int64, categories idset.IdSet) (user *vsafe.User, err error) {
+	err = h.Doer.Do(func(t db.Transaction) error {
+		var err error
+		user, err = vsafedb.SetCategories(h.Store, t, id, owner, categories)
+		return err
+	})
+	return
+}
+
+func (h *Handler) removeUser(id, owner int64) (oldName string, err error) {
+	err = h.Doer.Do(func(t db.Transaction) error {
+		var err error
+		oldName, err = vsafedb.RemoveSubUser(h.Store, t, id, owner)
+		return err
+	})
+	return
+}
+
+// checkPassword checks that the new password of user in values was
+// re-typed correctly and meets the password policy.
+func (h *Handler) checkPassword(
+	user *vsafe.User, values map[string][]string) error {
+	if err := checkPasswordsMatch(values); err != nil {
+		return err
+	}
+	return h.PasswordPolicy.Check(user, firstValue(values["password"]))
+}
+
+func checkPasswordsMatch(values map[string][]string) error {
+	if firstValue(values["password"]) != firstValue(values["verify"]) {
+		return kErrPasswordMismatch
+	}
+	return nil
+}
+
+func toCatMap(cats []string) map[int64]bool {
+	result := make(map[int64]bool, len(cats))
+	for _, cat := range cats {
+		id, err := strconv.ParseInt(cat, 10, 64)
+		if err == nil {
+			result[id] = true
+		}
+	}
+	return result
+}
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func subUserSelections(users []vsafe.User) http_util.Selections {
+	var result http_util.Selections
+	for i := range users {
+		if users[i].Owner == 0 {
+			continue
+		}
+		result = append(result, http_util.Selection{
+			Value: strconv.FormatInt(users[i].Id, 10),
+			Name:  users[i].Name})
+	}
+	return result
+}
+
+type view struct {
+	http_util.Values
+	Users          []vsafe.User
+	UserSelections http_util.Selections
+	Roles          []vsafe.Role
+	Categories     []vsafe.Category
+	CatMap         map[int64]bool
+	Error          error
+	Message        string
+	NeedsReauth    bool
+	Xsrf           string
+}
+
+// CategoryNames returns the names of the categories in ids.
+func (v *view) CategoryNames(ids idset.IdSet) string {
+	var names []string
+	for _, category := range v.Categories {
+		if ids.Contains(category.Id) {
+			names = append(names, category.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func init() {
+	kTemplate = common.NewTemplate("admin", kTemplateSpec)
+}
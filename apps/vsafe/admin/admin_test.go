@@ -0,0 +1,313 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/admin"
+	"github.com/keep94/vsafe/apps/vsafe/common"
+	"github.com/keep94/vsafe/apps/vsafe/fixture"
+	"github.com/keep94/vsafe/vsafedb"
+)
+
+const (
+	kReauthWindow = 5 * time.Minute
+	kAdmin        = "admin"
+)
+
+func TestAddUser(t *testing.T) {
+	f := newFixture(t)
+	w := f.post(&f.Master, f.addForm(), true, time.Now())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "User carol added.") {
+		t.Error("Expected user added message")
+	}
+	var user vsafe.User
+	if err := f.Store.UserByName(nil, "carol", &user); err != nil {
+		t.Fatalf("Expected carol to be added, got %v", err)
+	}
+	if user.Owner != f.Key.Id {
+		t.Errorf("Expected owner %d, got %d", f.Key.Id, user.Owner)
+	}
+	if user.Role != vsafe.RoleEditor {
+		t.Errorf("Expected %v, got %v", vsafe.RoleEditor, user.Role)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	f := newFixture(t)
+	w := f.post(&f.Master, f.setRoleForm(), true, time.Now())
+	if !strings.Contains(w.Body.String(), "alice is now read-only.") {
+		t.Error("Expected role changed message")
+	}
+	if out := f.sub().Role; out != vsafe.RoleReadOnly {
+		t.Errorf("Expected %v, got %v", vsafe.RoleReadOnly, out)
+	}
+}
+
+func TestSetCategories(t *testing.T) {
+	f := newFixture(t)
+	w := f.post(&f.Master, f.setCatsForm(), true, time.Now())
+	if !strings.Contains(w.Body.String(), "Categories of alice changed.") {
+		t.Error("Expected categories changed message")
+	}
+	user := f.sub()
+	if !user.IsRestricted() || !user.CanSeeCategory(f.category.Id) {
+		t.Errorf("Expected alice to see only category %d", f.category.Id)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	f := newFixture(t)
+	w := f.post(&f.Master, f.resetForm(), true, time.Now())
+	if !strings.Contains(w.Body.String(), "Password of alice reset.") {
+		t.Error("Expected password reset message")
+	}
+	user := f.sub()
+	if _, err := user.VerifyPassword("new password"); err != nil {
+		t.Errorf("Expected new password to work, got %v", err)
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	f := newFixture(t)
+	w := f.post(&f.Master, f.removeForm(), true, time.Now())
+	if !strings.Contains(w.Body.String(), "User alice removed.") {
+		t.Error("Expected user removed message")
+	}
+	var user vsafe.User
+	if err := f.Store.UserById(nil, f.subId, &user); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected alice to be removed, got %v", err)
+	}
+}
+
+func TestAdminSubUserForbidden(t *testing.T) {
+	f := newFixture(t)
+	// Even sub-users with the admin role can't manage users
+	admin := f.AddUser(t, "dave", vsafe.RoleAdmin)
+	w := f.Serve(t, f.handler, "GET", "/vsafe/admin", nil, &fixture.Session{
+		User: admin, LastAuth: time.Now(), XsrfAction: kAdmin})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for GET, got %d", w.Code)
+	}
+	for name, form := range f.forms() {
+		if w := f.post(admin, form, true, time.Now()); w.Code != http.StatusForbidden {
+			t.Errorf("%s: Expected 403, got %d", name, w.Code)
+		}
+	}
+	f.verifyUnchanged(t)
+}
+
+func TestAdminOtherVault(t *testing.T) {
+	f := newFixture(t)
+	// eve shares the key of mallory, another master user
+	var mallory vsafe.User
+	if err := mallory.Init("mallory", fixture.Password); err != nil {
+		t.Fatalf("Error initializing user: %v", err)
+	}
+	if err := f.Store.AddUser(nil, &mallory); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	key, err := mallory.VerifyPassword(fixture.Password)
+	if err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	var eve vsafe.User
+	if err := eve.InitWithKey("eve", fixture.Password, key); err != nil {
+		t.Fatalf("Error initializing user: %v", err)
+	}
+	eve.Role = vsafe.RoleEditor
+	if err := f.Store.AddUser(nil, &eve); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	for name, form := range f.forms() {
+		if name == "add" {
+			continue
+		}
+		form.Set("user", strconv.FormatInt(eve.Id, 10))
+		w := f.post(&f.Master, form, true, time.Now())
+		if !strings.Contains(w.Body.String(), vsafedb.ErrNoSuchId.Error()) {
+			t.Errorf("%s: Expected no such id error", name)
+		}
+	}
+	var user vsafe.User
+	if err := f.Store.UserById(nil, eve.Id, &user); err != nil {
+		t.Fatalf("Expected eve to remain, got %v", err)
+	}
+	if user.Role != vsafe.RoleEditor || user.IsRestricted() {
+		t.Error("Expected eve to be unchanged")
+	}
+	if _, err := user.VerifyPassword(fixture.Password); err != nil {
+		t.Errorf("Expected eve's password to be unchanged, got %v", err)
+	}
+}
+
+func TestAdminXsrf(t *testing.T) {
+	f := newFixture(t)
+	for name, form := range f.forms() {
+		w := f.post(&f.Master, form, false, time.Now())
+		if !strings.Contains(w.Body.String(), common.ErrXsrf.Error()) {
+			t.Errorf("%s: Expected xsrf error for missing token", name)
+		}
+		form.Set("xsrf", "1234:abcd")
+		w = f.post(&f.Master, form, false, time.Now())
+		if !strings.Contains(w.Body.String(), common.ErrXsrf.Error()) {
+			t.Errorf("%s: Expected xsrf error for wrong token", name)
+		}
+	}
+	f.verifyUnchanged(t)
+}
+
+func TestAdminReauth(t *testing.T) {
+	f := newFixture(t)
+	expired := time.Now().Add(-2 * kReauthWindow)
+	for name, form := range f.forms() {
+		w := f.post(&f.Master, form, true, expired)
+		if !strings.Contains(w.Body.String(), common.ErrReauthRequired.Error()) {
+			t.Errorf("%s: Expected reauth required error", name)
+		}
+		form.Set("reauth", "wrong")
+		w = f.post(&f.Master, form, true, expired)
+		if !strings.Contains(w.Body.String(), common.ErrReauthFailed.Error()) {
+			t.Errorf("%s: Expected reauth failed error", name)
+		}
+	}
+	f.verifyUnchanged(t)
+	form := f.setRoleForm()
+	form.Set("reauth", fixture.Password)
+	w := f.post(&f.Master, form, true, expired)
+	if !strings.Contains(w.Body.String(), "alice is now read-only.") {
+		t.Error("Expected role changed message")
+	}
+}
+
+type adminFixture struct {
+	*fixture.Vault
+	t        *testing.T
+	handler  http.Handler
+	subId    int64
+	category vsafe.Category
+}
+
+func newFixture(t *testing.T) *adminFixture {
+	f := &adminFixture{Vault: fixture.NewVault(t), t: t}
+	f.subId = f.AddUser(t, "alice", vsafe.RoleEditor).Id
+	f.category = vsafe.Category{Owner: f.Key.Id, Name: "Bank"}
+	if err := f.Store.AddCategory(nil, &f.category); err != nil {
+		t.Fatalf("Error adding category: %v", err)
+	}
+	f.handler = &admin.Handler{
+		Doer:         f.Doer,
+		Store:        f.Store,
+		ReauthWindow: kReauthWindow,
+	}
+	return f
+}
+
+// sub returns the sub-user alice as stored.
+func (f *adminFixture) sub() *vsafe.User {
+	f.t.Helper()
+	var user vsafe.User
+	if err := f.Store.UserById(nil, f.subId, &user); err != nil {
+		f.t.Fatalf("Error reading user: %v", err)
+	}
+	return &user
+}
+
+// verifyUnchanged verifies that carol was not added and that alice is
+// as newFixture created her.
+func (f *adminFixture) verifyUnchanged(t *testing.T) {
+	t.Helper()
+	var user vsafe.User
+	if err := f.Store.UserByName(nil, "carol", &user); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected no carol, got %v", err)
+	}
+	if err := f.Store.UserById(nil, f.subId, &user); err != nil {
+		t.Fatalf("Expected alice to remain, got %v", err)
+	}
+	if user.Role != vsafe.RoleEditor {
+		t.Errorf("Expected alice's role to be unchanged, got %v", user.Role)
+	}
+	if user.IsRestricted() {
+		t.Error("Expected alice's categories to be unchanged")
+	}
+	if _, err := user.VerifyPassword(fixture.Password); err != nil {
+		t.Errorf("Expected alice's password to be unchanged, got %v", err)
+	}
+}
+
+func (f *adminFixture) addForm() url.Values {
+	return url.Values{
+		"add":      {"Add"},
+		"name":     {"carol"},
+		"password": {"carol's password"},
+		"verify":   {"carol's password"},
+		"role":     {vsafe.RoleEditor.String()},
+	}
+}
+
+func (f *adminFixture) setRoleForm() url.Values {
+	return url.Values{
+		"setrole": {"Set role"},
+		"user":    {strconv.FormatInt(f.subId, 10)},
+		"role":    {vsafe.RoleReadOnly.String()},
+	}
+}
+
+func (f *adminFixture) setCatsForm() url.Values {
+	return url.Values{
+		"setcats": {"Set categories"},
+		"user":    {strconv.FormatInt(f.subId, 10)},
+		"cat":     {strconv.FormatInt(f.category.Id, 10)},
+	}
+}
+
+func (f *adminFixture) resetForm() url.Values {
+	return url.Values{
+		"reset":    {"Reset password"},
+		"user":     {strconv.FormatInt(f.subId, 10)},
+		"password": {"new password"},
+		"verify":   {"new password"},
+	}
+}
+
+func (f *adminFixture) removeForm() url.Values {
+	return url.Values{
+		"remove": {"Remove"},
+		"user":   {strconv.FormatInt(f.subId, 10)},
+	}
+}
+
+// forms returns a form for each action of the admin page by action name.
+func (f *adminFixture) forms() map[string]url.Values {
+	return map[string]url.Values{
+		"add":     f.addForm(),
+		"setrole": f.setRoleForm(),
+		"setcats": f.setCatsForm(),
+		"reset":   f.resetForm(),
+		"remove":  f.removeForm(),
+	}
+}
+
+// post posts form to the admin page on behalf of user who last entered
+// their password at lastAuth. If withXsrf is true, post adds a valid xsrf
+// token to form.
+func (f *adminFixture) post(
+	user *vsafe.User,
+	form url.Values,
+	withXsrf bool,
+	lastAuth time.Time) *httptest.ResponseRecorder {
+	session := &fixture.Session{User: user, LastAuth: lastAuth}
+	if withXsrf {
+		session.XsrfAction = kAdmin
+	}
+	return f.Serve(f.t, f.handler, "POST", "/vsafe/admin", form, session)
+}
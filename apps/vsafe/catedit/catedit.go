@@ -1,6 +1,7 @@
 package catedit
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/keep94/toolbox/db"
@@ -12,6 +13,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -28,6 +30,7 @@ var (
 <html>
   <head>
     <link rel="stylesheet" type="text/css" href="/static/theme.css" />
+    <script type="text/javascript" src="/static/vsafe.js"></script>
   </head>
 <body>
 <h2>Edit categories</h2>
@@ -61,11 +64,17 @@ var (
     <td>New category:</td>
     <td><input type="text" name="name" value="{{.Get "name"}}" size="40"></td>
   </tr>
+{{if .NeedsReauth}}
+  <tr>
+    <td>Your password:</td>
+    <td><input type="password" name="reauth"> (needed to remove)</td>
+  </tr>
+{{end}}
 </table>
 <br>
 <input type="submit" name="add" value="Add">
 <input type="submit" name="rename" value="Rename">
-<input type="submit" name="remove" value="Remove" onclick="return confirm('Are you sure you want to remove this category?');">
+<input type="submit" name="remove" value="Remove" data-confirm="Are you sure you want to remove this category?">
 </form>
 </body>
 </html>`
@@ -79,6 +88,7 @@ type CategoryStore interface {
 	vsafedb.AddCategoryRunner
 	vsafedb.CategoryByIdRunner
 	vsafedb.CategoriesByOwnerRunner
+	vsafedb.EntriesByOwnerRunner
 	vsafedb.UpdateCategoryRunner
 	vsafedb.RemoveCategoryRunner
 }
@@ -86,12 +96,19 @@ type CategoryStore interface {
 type Handler struct {
 	Doer  db.Doer
 	Store CategoryStore
+	// How long after entering their password a user may remove categories
+	// without entering their password again.
+	ReauthWindow time.Duration
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	session := common.GetUserSession(r)
-	owner := session.User.GetOwner()
+	if !session.User.CanEditCategories() {
+		http_util.Error(w, http.StatusForbidden)
+		return
+	}
+	key := session.VaultKey()
 	message := ""
 	var err error
 	var values http_util.Values
@@ -103,7 +120,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if strings.TrimSpace(name) == "" {
 				err = kErrNameFieldRequired
 			} else {
-				err = h.addCategory(owner, name)
+				err = h.addCategory(r.Context(), key.Id, name)
 				message = fmt.Sprintf("Category %s added.", name)
 			}
 		} else if http_util.HasParam(r.Form, "rename") {
@@ -115,7 +132,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				err = kErrNameFieldRequired
 			} else {
 				var oldName string
-				oldName, err = h.renameCategory(id, owner, name)
+				oldName, err = h.renameCategory(
+					r.Context(), id, session.User, key, name)
 				message = fmt.Sprintf(
 					"Category %s renamed to %s.", oldName, name)
 			}
@@ -123,9 +141,11 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			id, _ := strconv.ParseInt(r.Form.Get("cat"), 10, 64)
 			if id == 0 {
 				err = kErrIdFieldRequired
-			} else {
+			} else if err = common.VerifyReauth(
+				w, r, h.ReauthWindow); err == nil {
 				var oldName string
-				oldName, err = h.removeCategory(id, owner)
+				oldName, err = h.removeCategory(
+					r.Context(), id, session.User, key)
 				message = fmt.Sprintf(
 					"Category %s removed.", oldName)
 			}
@@ -135,7 +155,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			message = ""
 		}
 	}
-	categories, readErr := h.Store.CategoriesByOwner(nil, owner)
+	counts, readErr := vsafedb.CategoryCountsByOwnerContext(
+		r.Context(), h.Store, nil, key.Id)
 	if readErr != nil {
 		http_util.ReportError(w, "Error reading database.", readErr)
 		return
@@ -144,42 +165,68 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w,
 		kTemplate,
 		&view{
-			CatSelections: common.CatSelections(categories),
+			CatSelections: catSelections(counts),
 			Values:        values,
 			Error:         err,
 			Message:       message,
+			NeedsReauth:   !common.IsReauthenticated(r, h.ReauthWindow),
 			Xsrf:          common.NewXsrfToken(r, kCatEdit)})
 }
 
-func (h *Handler) addCategory(owner int64, name string) error {
+func (h *Handler) addCategory(
+	ctx context.Context, owner int64, name string) error {
 	category := vsafe.Category{Name: name, Owner: owner}
-	return h.Store.AddCategory(nil, &category)
+	return vsafedb.AddCategoryContext(ctx, h.Store, nil, &category)
 }
 
-func (h *Handler) renameCategory(id, owner int64, newName string) (
-	oldName string, err error) {
+func (h *Handler) renameCategory(
+	ctx context.Context,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key,
+	newName string) (oldName string, err error) {
 	err = h.Doer.Do(func(t db.Transaction) error {
 		var err error
-		oldName, err = vsafedb.UpdateCategory(h.Store, t, id, owner, newName)
+		oldName, err = vsafedb.UpdateCategoryContext(
+			ctx, h.Store, t, id, user, key, newName)
 		return err
 	})
 	return
 }
 
-func (h *Handler) removeCategory(id, owner int64) (
+func (h *Handler) removeCategory(
+	ctx context.Context, id int64, user *vsafe.User, key *vsafe.Key) (
 	oldName string, err error) {
 	err = h.Doer.Do(func(t db.Transaction) error {
 		var err error
-		oldName, err = vsafedb.RemoveCategory(h.Store, t, id, owner)
+		oldName, err = vsafedb.RemoveCategoryContext(
+			ctx, h.Store, t, id, user, key)
 		return err
 	})
 	return
 }
 
+// catSelections works like common.CatSelections but shows how many
+// entries are in each category.
+func catSelections(counts []vsafedb.CategoryCount) http_util.Selections {
+	result := make(http_util.Selections, len(counts))
+	for i := range result {
+		format := "%s (%d entries)"
+		if counts[i].Entries == 1 {
+			format = "%s (%d entry)"
+		}
+		result[i] = http_util.Selection{
+			Value: strconv.FormatInt(counts[i].Id, 10),
+			Name:  fmt.Sprintf(format, counts[i].Name, counts[i].Entries)}
+	}
+	return result
+}
+
 type view struct {
 	http_util.Values
 	Error         error
 	Message       string
+	NeedsReauth   bool
 	Xsrf          string
 	CatSelections http_util.Selections
 }
@@ -1,7 +1,7 @@
 package chpasswd
 
 import (
-	"fmt"
+	"errors"
 	"github.com/keep94/toolbox/db"
 	"github.com/keep94/toolbox/http_util"
 	"github.com/keep94/vsafe"
@@ -11,10 +11,6 @@ import (
 	"net/http"
 )
 
-const (
-	kMinPasswordLength = 6
-)
-
 const (
 	kChPasswd = "chpasswd"
 )
@@ -74,6 +70,8 @@ type UserStore interface {
 type Handler struct {
 	Store UserStore
 	Doer  db.Doer
+	// The policy new passwords must meet
+	PasswordPolicy vsafe.PasswordPolicy
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -110,35 +108,39 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 					Message: "Password re-typed incorrectly."})
 			return
 		}
-		if len(new) < kMinPasswordLength {
+		// Entering the old password counts as re-entering the password so
+		// changing the password never relies on an earlier login.
+		err := common.Reauthenticate(w, r, old)
+		if err == nil {
+			err = h.Doer.Do(func(t db.Transaction) error {
+				user, err := vsafedb.ChangePassword(
+					h.Store, t, session.User.Id, old, new, &h.PasswordPolicy)
+				if err != nil {
+					return err
+				}
+				session.User = user
+				return nil
+			})
+		}
+		if err == vsafe.ErrWrongPassword {
 			http_util.WriteTemplate(
 				w,
 				kTemplate,
 				&view{
-					Name: session.User.Name,
-					Xsrf: common.NewXsrfToken(r, kChPasswd),
-					Message: fmt.Sprintf(
-						"Password must be at least %d characters.",
-						kMinPasswordLength)})
+					Name:    session.User.Name,
+					Xsrf:    common.NewXsrfToken(r, kChPasswd),
+					Message: "Old password wrong."})
 			return
 		}
-		err := h.Doer.Do(func(t db.Transaction) error {
-			user, err := vsafedb.ChangePassword(
-				h.Store, t, session.User.Id, old, new)
-			if err != nil {
-				return err
-			}
-			session.User = user
-			return nil
-		})
-		if err == vsafe.ErrWrongPassword {
+		var passwordErr *vsafe.PasswordError
+		if errors.As(err, &passwordErr) {
 			http_util.WriteTemplate(
 				w,
 				kTemplate,
 				&view{
 					Name:    session.User.Name,
 					Xsrf:    common.NewXsrfToken(r, kChPasswd),
-					Message: "Old password wrong."})
+					Message: passwordErr.Error()})
 			return
 		}
 		if err != nil {
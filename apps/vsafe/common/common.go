@@ -11,7 +11,10 @@ import (
 	"github.com/keep94/vsafe/vsafedb"
 	"html/template"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,14 +23,65 @@ const (
 )
 
 const (
-	// Set to the same thing as kSessionTimeout in vsafe.go
-	kXsrfTimeout = 60 * time.Minute
+	kRedirectPrefix  = "/vsafe/"
+	kDefaultRedirect = "/vsafe/home"
+)
+
+const (
+	kMinPollInterval = 5 * time.Second
+	kMaxPollInterval = time.Minute
 )
 
 var (
 	ErrXsrf = errors.New("Page had grown stale. Please resubmit.")
+	// Returned when an action requires the user to re-enter their password.
+	ErrReauthRequired = errors.New("Please re-enter your password to continue.")
+	// Returned when the re-entered password is wrong.
+	ErrReauthFailed = errors.New("Password incorrect.")
 )
 
+// DefaultSessionTimeouts are the session timeouts used when none are
+// configured.
+var DefaultSessionTimeouts = SessionTimeouts{
+	Idle:     time.Hour,
+	Absolute: 12 * time.Hour,
+}
+
+// SessionTimeouts controls how long a user stays logged in.
+type SessionTimeouts struct {
+	// How long a session may go unused before it expires.
+	Idle time.Duration
+	// How long a session may last after login no matter how much it is
+	// used. 0 means no limit.
+	Absolute time.Duration
+}
+
+// ForUser returns these timeouts adjusted for user. The timeouts stored
+// with a user can only shorten these timeouts, never lengthen them.
+func (t SessionTimeouts) ForUser(user *vsafe.User) SessionTimeouts {
+	result := t
+	if user.IdleTimeout > 0 && user.IdleTimeout < result.Idle {
+		result.Idle = user.IdleTimeout
+	}
+	if user.SessionLifetime > 0 && (result.Absolute == 0 || user.SessionLifetime < result.Absolute) {
+		result.Absolute = user.SessionLifetime
+	}
+	return result
+}
+
+// PollInterval returns how often pages should poll the server to learn
+// that their session has expired.
+func (t SessionTimeouts) PollInterval() time.Duration {
+	result := t.Idle / 60
+	if result < kMinPollInterval {
+		return kMinPollInterval
+	}
+	if result > kMaxPollInterval {
+		return kMaxPollInterval
+	}
+	return result
+}
+
 // NewGorillaSession creates a gorilla session for the vsafe app.
 func NewGorillaSession(
 	sessionStore sessions.Store,
@@ -106,6 +160,119 @@ func (s *UserSession) SetKey(key *vsafe.Key) {
 	}
 }
 
+// MemberKey returns the key that opens the collection memberships of the
+// current logged in user. See vsafe.User.MemberKey.
+func (s *UserSession) MemberKey() *vsafe.Key {
+	result := s.Values[kMemberKeyKey]
+	if result == nil {
+		return nil
+	}
+	return result.(*vsafe.Key)
+}
+
+// SetMemberKey sets the key that opens the collection memberships of the
+// current logged in user.
+func (s *UserSession) SetMemberKey(key *vsafe.Key) {
+	if key == nil {
+		delete(s.Values, kMemberKeyKey)
+	} else {
+		s.Values[kMemberKeyKey] = key
+	}
+}
+
+// VaultKey returns the key of the vault the current logged in user is
+// viewing. Unless the user has switched to a collection, VaultKey returns
+// the same key as Key.
+func (s *UserSession) VaultKey() *vsafe.Key {
+	result := s.Values[kVaultKeyKey]
+	if result == nil {
+		return s.Key()
+	}
+	return result.(*vsafe.Key)
+}
+
+// SetVaultKey sets the key of the vault the current logged in user is
+// viewing. Passing nil switches back to the user's own vault.
+func (s *UserSession) SetVaultKey(key *vsafe.Key) {
+	if key == nil {
+		delete(s.Values, kVaultKeyKey)
+	} else {
+		s.Values[kVaultKeyKey] = key
+	}
+}
+
+// LastAuth returns the time the current logged in user last entered their
+// password and true. If that time is unknown, LastAuth returns the zero
+// time and false.
+func (s *UserSession) LastAuth() (time.Time, bool) {
+	result, ok := s.Values[kLastAuthKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	return result.(time.Time), true
+}
+
+// SetLastAuth sets the time the current logged in user last entered their
+// password.
+func (s *UserSession) SetLastAuth(lastAuth time.Time) {
+	s.Values[kLastAuthKey] = lastAuth
+}
+
+// IsAuthRecent returns true if the current logged in user entered their
+// password within window of now.
+func (s *UserSession) IsAuthRecent(window time.Duration, now time.Time) bool {
+	lastAuth, ok := s.LastAuth()
+	if !ok {
+		return false
+	}
+	return now.Sub(lastAuth) < window
+}
+
+// Timeouts returns the timeouts of this session. If no timeouts were
+// stored, Timeouts returns DefaultSessionTimeouts.
+func (s *UserSession) Timeouts() SessionTimeouts {
+	result, ok := s.Values[kTimeoutsKey]
+	if !ok {
+		return DefaultSessionTimeouts
+	}
+	return result.(SessionTimeouts)
+}
+
+// SetTimeouts sets the timeouts of this session.
+func (s *UserSession) SetTimeouts(timeouts SessionTimeouts) {
+	s.Values[kTimeoutsKey] = timeouts
+}
+
+// LastActive returns the time this session was last used and true. If
+// that time is unknown, LastActive returns the zero time and false.
+func (s *UserSession) LastActive() (time.Time, bool) {
+	result, ok := s.Values[kLastActiveKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	return result.(time.Time), true
+}
+
+// SetLastActive sets the time this session was last used.
+func (s *UserSession) SetLastActive(lastActive time.Time) {
+	s.Values[kLastActiveKey] = lastActive
+}
+
+// Expired returns true if, as of now, this session has gone unused or has
+// lasted longer than its timeouts allow.
+func (s *UserSession) Expired(now time.Time) bool {
+	timeouts := s.Timeouts()
+	lastActive, ok := s.LastActive()
+	if ok && timeouts.Idle > 0 && now.Sub(lastActive) >= timeouts.Idle {
+		return true
+	}
+	lastLogin, ok := s.LastLogin()
+	if ok && timeouts.Absolute > 0 && now.Sub(lastLogin) >= timeouts.Absolute {
+		return true
+	}
+	return false
+}
+
 // NewTemplate returns a new template instance. name is the name
 // of the template; templateStr is the template string.
 func NewTemplate(name, templateStr string) *template.Template {
@@ -115,7 +282,8 @@ func NewTemplate(name, templateStr string) *template.Template {
 // NewXsrfToken creates a new xsrf token for given action.
 func NewXsrfToken(r *http.Request, action string) string {
 	userSession := GetUserSession(r)
-	return userSession.NewXsrfToken(action, time.Now().Add(kXsrfTimeout))
+	return userSession.NewXsrfToken(
+		action, time.Now().Add(userSession.Timeouts().Idle))
 }
 
 // VerifyXsrfToken verifies the xsrf token for given action.
@@ -125,6 +293,48 @@ func VerifyXsrfToken(r *http.Request, action string) bool {
 	return userSession.VerifyXsrfToken(r.Form.Get("xsrf"), action, time.Now())
 }
 
+// IsReauthenticated returns true if the current logged in user entered
+// their password within window.
+func IsReauthenticated(r *http.Request, window time.Duration) bool {
+	return GetUserSession(r).IsAuthRecent(window, time.Now())
+}
+
+// Reauthenticate verifies that password belongs to the current logged in
+// user. On success, it records the current time as the last time the user
+// entered their password and saves the session. Reauthenticate must be
+// called before anything is written to w.
+func Reauthenticate(
+	w http.ResponseWriter, r *http.Request, password string) error {
+	session := GetUserSession(r)
+	if _, err := session.User.VerifyPassword(password); err != nil {
+		return err
+	}
+	session.SetLastAuth(time.Now())
+	return session.Save(r, w)
+}
+
+// VerifyReauth returns nil if the current logged in user entered their
+// password within window. Otherwise, VerifyReauth re-authenticates the
+// user with the password found under "reauth" in the request returning
+// ErrReauthRequired if there is no such password or ErrReauthFailed if
+// the password is wrong. VerifyReauth must be called before anything is
+// written to w.
+func VerifyReauth(
+	w http.ResponseWriter, r *http.Request, window time.Duration) error {
+	if IsReauthenticated(r, window) {
+		return nil
+	}
+	password := r.Form.Get("reauth")
+	if password == "" {
+		return ErrReauthRequired
+	}
+	err := Reauthenticate(w, r, password)
+	if err == vsafe.ErrWrongPassword {
+		return ErrReauthFailed
+	}
+	return err
+}
+
 type userGetter struct {
 	vsafedb.UserByIdRunner
 }
@@ -141,8 +351,38 @@ type sessionKeyType int
 
 const (
 	kKeyKey sessionKeyType = iota
+	kLastAuthKey
+	kTimeoutsKey
+	kLastActiveKey
+	kVaultKeyKey
+	kMemberKeyKey
 )
 
+// RedirectTarget returns the URL to redirect to given prev, an untrusted
+// value usually from the "prev" request parameter. RedirectTarget accepts
+// only relative URLs on this server with paths under /vsafe/. For anything
+// else, RedirectTarget returns the URL of the home page.
+func RedirectTarget(prev string) *url.URL {
+	u, err := url.Parse(prev)
+	if err != nil || !isSafeRedirect(u) {
+		return &url.URL{Path: kDefaultRedirect}
+	}
+	return &url.URL{
+		Path:     path.Clean(u.Path),
+		RawQuery: u.RawQuery,
+		Fragment: u.Fragment}
+}
+
+func isSafeRedirect(u *url.URL) bool {
+	if u.Scheme != "" || u.Host != "" || u.User != nil || u.Opaque != "" {
+		return false
+	}
+	if strings.Contains(u.Path, "\\") {
+		return false
+	}
+	return strings.HasPrefix(path.Clean(u.Path), kRedirectPrefix)
+}
+
 // CatSelections converts a list of categories to selections for a combo box
 func CatSelections(cats []vsafe.Category) http_util.Selections {
 	result := make(http_util.Selections, len(cats))
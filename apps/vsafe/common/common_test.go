@@ -5,6 +5,7 @@ import (
 	"github.com/keep94/vsafe"
 	"github.com/keep94/vsafe/apps/vsafe/common"
 	"testing"
+	"time"
 )
 
 func TestKey(t *testing.T) {
@@ -22,3 +23,129 @@ func TestKey(t *testing.T) {
 		t.Error("Expected nil again")
 	}
 }
+
+func TestLastAuth(t *testing.T) {
+	userSession := common.CreateUserSession(
+		&sessions.Session{Values: make(map[interface{}]interface{})})
+	now := time.Date(2022, 5, 14, 13, 0, 0, 0, time.UTC)
+	if _, ok := userSession.LastAuth(); ok {
+		t.Error("Expected no last auth time")
+	}
+	if userSession.IsAuthRecent(5*time.Minute, now) {
+		t.Error("Expected auth not to be recent")
+	}
+	userSession.SetLastAuth(now)
+	if out, ok := userSession.LastAuth(); !ok || !out.Equal(now) {
+		t.Errorf("Expected %v, got %v", now, out)
+	}
+	if !userSession.IsAuthRecent(5*time.Minute, now.Add(4*time.Minute)) {
+		t.Error("Expected auth to be recent")
+	}
+	if userSession.IsAuthRecent(5*time.Minute, now.Add(5*time.Minute)) {
+		t.Error("Expected auth not to be recent")
+	}
+}
+
+func TestSessionTimeoutsForUser(t *testing.T) {
+	timeouts := common.SessionTimeouts{Idle: time.Hour, Absolute: 8 * time.Hour}
+	if out := timeouts.ForUser(&vsafe.User{}); out != timeouts {
+		t.Errorf("Expected %v, got %v", timeouts, out)
+	}
+	user := vsafe.User{IdleTimeout: 10 * time.Minute, SessionLifetime: 2 * time.Hour}
+	expected := common.SessionTimeouts{Idle: 10 * time.Minute, Absolute: 2 * time.Hour}
+	if out := timeouts.ForUser(&user); out != expected {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+	// Users can't lengthen timeouts
+	user = vsafe.User{IdleTimeout: 2 * time.Hour, SessionLifetime: 9 * time.Hour}
+	if out := timeouts.ForUser(&user); out != timeouts {
+		t.Errorf("Expected %v, got %v", timeouts, out)
+	}
+	// Users can limit sessions when the server doesn't
+	timeouts = common.SessionTimeouts{Idle: time.Hour}
+	expected = common.SessionTimeouts{Idle: time.Hour, Absolute: 9 * time.Hour}
+	if out := timeouts.ForUser(&user); out != expected {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	assertDuration(
+		t,
+		time.Minute,
+		common.SessionTimeouts{Idle: time.Hour}.PollInterval())
+	assertDuration(
+		t,
+		30*time.Second,
+		common.SessionTimeouts{Idle: 30 * time.Minute}.PollInterval())
+	assertDuration(
+		t,
+		5*time.Second,
+		common.SessionTimeouts{Idle: time.Minute}.PollInterval())
+	assertDuration(
+		t,
+		time.Minute,
+		common.SessionTimeouts{Idle: 24 * time.Hour}.PollInterval())
+}
+
+func TestExpired(t *testing.T) {
+	userSession := common.CreateUserSession(
+		&sessions.Session{Values: make(map[interface{}]interface{})})
+	now := time.Date(2022, 5, 14, 13, 0, 0, 0, time.UTC)
+	if out := userSession.Timeouts(); out != common.DefaultSessionTimeouts {
+		t.Errorf("Expected %v, got %v", common.DefaultSessionTimeouts, out)
+	}
+	userSession.SetTimeouts(
+		common.SessionTimeouts{Idle: 10 * time.Minute, Absolute: time.Hour})
+	userSession.SetLastLogin(now)
+	userSession.SetLastActive(now)
+	if userSession.Expired(now.Add(9 * time.Minute)) {
+		t.Error("Session should not be idle")
+	}
+	if !userSession.Expired(now.Add(10 * time.Minute)) {
+		t.Error("Session should be idle")
+	}
+	userSession.SetLastActive(now.Add(55 * time.Minute))
+	if userSession.Expired(now.Add(59 * time.Minute)) {
+		t.Error("Session should not be expired")
+	}
+	if !userSession.Expired(now.Add(60 * time.Minute)) {
+		t.Error("Session should be expired")
+	}
+}
+
+func assertDuration(t *testing.T, expected, actual time.Duration) {
+	t.Helper()
+	if expected != actual {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestRedirectTarget(t *testing.T) {
+	testCases := []struct {
+		prev     string
+		expected string
+	}{
+		{"/vsafe/home", "/vsafe/home"},
+		{"/vsafe/home?q=foo&cat=3#7", "/vsafe/home?q=foo&cat=3#7"},
+		{"/vsafe/single?id=3&prev=%2Fvsafe%2Fhome", "/vsafe/single?id=3&prev=%2Fvsafe%2Fhome"},
+		{"/vsafe/./home", "/vsafe/home"},
+		{"", "/vsafe/home"},
+		{"/", "/vsafe/home"},
+		{"/vsafe", "/vsafe/home"},
+		{"/auth/login", "/vsafe/home"},
+		{"/vsafe/../auth/login", "/vsafe/home"},
+		{"http://evil.com/vsafe/home", "/vsafe/home"},
+		{"https://evil.com", "/vsafe/home"},
+		{"//evil.com/vsafe/home", "/vsafe/home"},
+		{"/\\evil.com/vsafe/home", "/vsafe/home"},
+		{"/vsafe/\\\\evil.com", "/vsafe/home"},
+		{"javascript:alert(1)", "/vsafe/home"},
+		{"%zz", "/vsafe/home"},
+	}
+	for _, tc := range testCases {
+		if out := common.RedirectTarget(tc.prev).String(); out != tc.expected {
+			t.Errorf("For %q, expected %q, got %q", tc.prev, tc.expected, out)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package fixture provides helpers to test the handlers of the vsafe web
+// app against an in-memory database.
+package fixture
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keep94/context"
+	"github.com/keep94/ramstore"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/common"
+	"github.com/keep94/vsafe/vsafedb/for_memory"
+)
+
+const (
+	// The password of every user a Vault creates
+	Password = "secret"
+)
+
+// Vault is an in-memory database holding one master user.
+type Vault struct {
+	Store        for_memory.Store
+	Doer         db.Doer
+	SessionStore *ramstore.RAMStore
+	// The master user, named "bob"
+	Master vsafe.User
+	// The key of the master user
+	Key *vsafe.Key
+}
+
+// NewVault returns a new Vault.
+func NewVault(t *testing.T) *Vault {
+	t.Helper()
+	dbase := for_memory.NewDb()
+	v := &Vault{
+		Store:        for_memory.New(dbase),
+		Doer:         for_memory.NewDoer(dbase),
+		SessionStore: ramstore.NewRAMStore(3600),
+	}
+	if err := v.Master.Init("bob", Password); err != nil {
+		t.Fatalf("Error initializing user: %v", err)
+	}
+	if err := v.Store.AddUser(nil, &v.Master); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	var err error
+	if v.Key, err = v.Master.VerifyPassword(Password); err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	return v
+}
+
+// AddUser adds a user with given name and role who shares the key of the
+// master user.
+func (v *Vault) AddUser(
+	t *testing.T, name string, role vsafe.Role) *vsafe.User {
+	t.Helper()
+	var user vsafe.User
+	if err := user.InitWithKey(name, Password, v.Key); err != nil {
+		t.Fatalf("Error initializing user: %v", err)
+	}
+	user.Role = role
+	if err := v.Store.AddUser(nil, &user); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	return &user
+}
+
+// Session describes the logged in session on whose behalf Serve sends
+// requests.
+type Session struct {
+	// The logged in user
+	User *vsafe.User
+	// When the user last entered their password
+	LastAuth time.Time
+	// If set, Serve adds a valid xsrf token for this action to the request.
+	XsrfAction string
+}
+
+// Serve sends a request with given method, target, and form to handler
+// on behalf of session and returns the response.
+func (v *Vault) Serve(
+	t *testing.T,
+	handler http.Handler,
+	method, target string,
+	form url.Values,
+	session *Session) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(
+		method, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ParseForm()
+	defer context.Clear(r)
+	userSession, err := common.NewUserSession(v.Store, v.SessionStore, r)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	userSession.SetUserId(session.User.Id)
+	userSession.User = session.User
+	userSession.SetKey(v.Key)
+	userSession.SetLastAuth(session.LastAuth)
+	if session.XsrfAction != "" {
+		r.Form.Set("xsrf", common.NewXsrfToken(r, session.XsrfAction))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
@@ -1,18 +1,25 @@
 package home
 
 import (
+	"errors"
+	"github.com/keep94/consume2"
 	"github.com/keep94/toolbox/http_util"
 	"github.com/keep94/vsafe"
 	"github.com/keep94/vsafe/apps/vsafe/common"
+	"github.com/keep94/vsafe/apps/vsafe/secret"
+	"github.com/keep94/vsafe/apps/vsafe/vaults"
 	"github.com/keep94/vsafe/vsafedb"
 	"html/template"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 const (
-	kRowsAtTop = 1
+	kRowsAtTop   = 1
+	kPageSize    = 50
+	kPageNoParam = "pageNo"
 )
 
 var (
@@ -22,11 +29,25 @@ var (
   <title>Vsafe using Go</title>
   <link rel="stylesheet" type="text/css" href="/static/theme.css" />
   <link rel="shortcut icon" href="/images/favicon.ico" type="image/x-icon" />
+  <script type="text/javascript" src="/static/vsafe.js"></script>
 </head>
-<body>
+<body data-secret-xsrf="{{.SecretXsrf}}" data-clipboard-clear="{{.ClipboardClearMillis}}">
 <h2>Vsafe using Go for {{.Name}} {{.BuildId}}</h2>
+<form method="post" action="/vsafe/vaults">
+  <input type="hidden" name="xsrf" value="{{.VaultXsrf}}" />
+  Vault:
+  <select name="id" size=1>
+    <option value="0"{{if .IsOwnVault}} selected{{end}}>Your vault</option>
+{{range .Vaults}}
+    <option value="{{.Id}}"{{if .Current}} selected{{end}}>{{.Name}}</option>
+{{end}}
+  </select>
+  <input type="submit" name="switch" value="Switch" />
+  <a href="/vsafe/vaults">Manage vaults</a>
+</form>
 <form action="/vsafe/home">
-  <input type="text" name="q" value="{{.Get "q"}}" />
+  <input type="hidden" name="sort" value="{{.Get "sort"}}" />
+  <input type="text" name="q" value="{{.Get "q"}}" title="title: url: desc: cat: user: domain: &quot;phrase&quot; -exclude OR" />
   <select name="cat" size=1>
 {{with .GetSelection .CatSelections "cat"}}
     <option value="{{.Value}}">{{.Name}}</option>
@@ -37,22 +58,49 @@ var (
 {{end}}
   <input type="submit" value="Search" />
 </form>
+{{if .Error}}
+  <span class="error">{{.Error.Error}}</span>
+{{end}}
+{{if .CanEditEntries}}
 <form method="post" action="{{.EntryLink 0}}">
    <input type="submit" accesskey="n" value="New Entry (Ctrl+Alt+N)">
 </form>
 &nbsp;
 &nbsp;
+{{end}}
+<a href="/vsafe/profile">Profile</a>
+&nbsp;
+&nbsp;
 <a href="/vsafe/chpasswd">Change password</a>
 &nbsp;
 &nbsp;
+{{if .CanEditCategories}}
 <a href="/vsafe/catedit">Edit categories</a>
 &nbsp;
 &nbsp;
+{{end}}
+<a href="/vsafe/shares">Shared entries{{if .ShareCount}} ({{.ShareCount}} new){{end}}</a>
+&nbsp;
+&nbsp;
+{{if .IsMaster}}
+<a href="/vsafe/admin">Manage users</a>
+&nbsp;
+&nbsp;
+{{end}}
 <a href="/vsafe/logout">Sign out</a>
 &nbsp;
 &nbsp;
 <br/>
 <br/>
+{{if .NeedsReauth}}
+Your password (needed to copy): <input type="password" id="reauth" autocomplete="off" />
+<br/>
+<br/>
+{{end}}
+{{if .IsPaged}}
+Showing {{.First}}-{{.Last}} of {{.Total}} entries
+<br/>
+{{end}}
 <table>
   <tr>
     <td>
@@ -69,6 +117,13 @@ var (
         <a href="{{.SortBy "newest"}}">Newest First</a>
       {{end}}
     </td>
+    <td>
+      {{if .Equals "sort" "best"}}
+        Best Match
+      {{else}}
+        <a href="{{.SortBy "best"}}">Best Match</a>
+      {{end}}
+    </td>
   </tr>
  {{with $top := .}}
  {{range $idx, $element := .Entries}}
@@ -86,6 +141,10 @@ var (
         <input type="submit" value="View">
       </form>
    </td>
+    <td rowspan="2" bgcolor="#FFFFFF">
+      <input type="button" value="Copy user" data-copy-secret="uname" data-id="{{.Id}}">
+      <input type="button" value="Copy password" data-copy-secret="password" data-id="{{.Id}}">
+   </td>
   </tr>
   <tr>
     {{if .Desc}}
@@ -97,6 +156,14 @@ var (
  {{end}}
  {{end}}
 </table>
+{{if .IsPaged}}
+{{with .PageBreadCrumb}}
+<br/>
+{{if .PageNo}}<a href="{{.PrevPageLink}}">&lt; Previous</a>&nbsp;{{end}}
+Page {{.DisplayPageNo}}
+{{if not .End}}&nbsp;<a href="{{.NextPageLink}}">Next &gt;</a>{{end}}
+{{end}}
+{{end}}
 </body>
 </html>`
 )
@@ -108,11 +175,19 @@ var (
 type Store interface {
 	vsafedb.EntriesByOwnerRunner
 	vsafedb.CategoriesByOwnerRunner
+	vsafedb.SharesByRecipientRunner
+	vsafedb.MembershipsByUserRunner
+	vsafedb.CollectionByIdRunner
 }
 
 type Handler struct {
 	Store   Store
 	BuildId string
+	// How long after entering their password a user may copy secrets
+	// without entering their password again.
+	ReauthWindow time.Duration
+	// How long a copied secret stays on the clipboard.
+	ClipboardClear time.Duration
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -121,43 +196,120 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	sortBy := r.Form.Get("sort")
 	id, _ := strconv.ParseInt(r.Form.Get("id"), 10, 64)
 	catId, _ := strconv.ParseInt(r.Form.Get("cat"), 10, 64)
-	categories, err := h.Store.CategoriesByOwner(nil, session.Key().Id)
+	ctx := r.Context()
+	categories, err := vsafedb.CategoriesByOwnerContext(
+		ctx, h.Store, nil, session.VaultKey().Id)
 	if err != nil {
 		http_util.ReportError(w, "Error reading database", err)
 		return
 	}
-	entries, err := vsafedb.Entries(h.Store, session.Key().Id, r.Form.Get("q"), catId)
+	categories = vsafedb.VisibleCategories(session.User, categories)
+	pageNo, _ := strconv.Atoi(r.Form.Get(kPageNoParam))
+	page, err := vsafedb.PagedEntriesContext(
+		ctx,
+		h.Store,
+		session.User,
+		session.VaultKey(),
+		&vsafedb.EntriesOptions{
+			Query:     r.Form.Get("q"),
+			CatId:     catId,
+			Order:     toEntryOrder(sortBy),
+			Offset:    pageNo * kPageSize,
+			Limit:     kPageSize,
+			CurrentId: id})
+	// A bad query is the user's mistake, so show it on the page.
+	var queryErr error
+	if errors.Is(err, vsafedb.ErrBadQuery) {
+		queryErr = err
+		page = &vsafedb.EntriesPage{}
+	} else if err != nil {
+		http_util.ReportError(w, "Error reading database", err)
+		return
+	}
+	var received []vsafe.Share
+	err = vsafedb.SharesByRecipientContext(
+		ctx,
+		h.Store,
+		nil,
+		session.User.GetOwner(),
+		consume2.AppendTo(&received))
 	if err != nil {
 		http_util.ReportError(w, "Error reading database", err)
 		return
 	}
-	switch sortBy {
-	case "newest":
-		vsafedb.Reverse(entries)
-	default:
-		vsafedb.SortByTitle(entries)
+	collections, err := vsafedb.Collections(h.Store, nil, session.User)
+	if err != nil {
+		http_util.ReportError(w, "Error reading database", err)
+		return
 	}
+	vaultKeyId := session.VaultKey().Id
+	vaultViews := make([]vaultView, len(collections))
+	for i := range collections {
+		vaultViews[i] = vaultView{
+			Collection: collections[i],
+			Current:    collections[i].KeyId() == vaultKeyId}
+	}
+	pageBreadCrumb := &http_util.PageBreadCrumb{
+		URL:         http_util.WithParams(r.URL, "id", "0"),
+		PageNoParam: kPageNoParam,
+		PageNo:      page.Offset / kPageSize,
+		End:         page.End()}
 	http_util.WriteTemplate(
 		w,
 		kTemplate,
 		&view{
-			Values:        http_util.Values{Values: r.Form},
-			Name:          session.User.Name,
-			Entries:       entries,
-			Url:           r.URL,
-			Id:            id,
-			CatSelections: common.CatSelections(categories),
-			BuildId:       h.BuildId})
+			Values:               http_util.Values{Values: r.Form},
+			Name:                 session.User.Name,
+			Entries:              page.Entries,
+			Offset:               page.Offset,
+			Total:                page.Total,
+			PageBreadCrumb:       pageBreadCrumb,
+			Error:                queryErr,
+			Url:                  r.URL,
+			Id:                   id,
+			CatSelections:        common.CatSelections(categories),
+			BuildId:              h.BuildId,
+			Vaults:               vaultViews,
+			IsOwnVault:           vaultKeyId == session.Key().Id,
+			VaultXsrf:            vaults.NewXsrfToken(r),
+			IsMaster:             session.User.Owner == 0,
+			ShareCount:           len(received),
+			CanEditEntries:       session.User.CanEditEntries(),
+			CanEditCategories:    session.User.CanEditCategories(),
+			NeedsReauth:          !common.IsReauthenticated(r, h.ReauthWindow),
+			SecretXsrf:           secret.NewXsrfToken(r),
+			ClipboardClearMillis: h.ClipboardClear.Milliseconds()})
 }
 
 type view struct {
 	http_util.Values
-	Name          string
-	Entries       []*vsafe.Entry
-	Url           *url.URL
-	Id            int64
-	CatSelections http_util.Selections
-	BuildId       string
+	Name              string
+	Entries           []*vsafe.Entry
+	Offset            int
+	Total             int
+	PageBreadCrumb    *http_util.PageBreadCrumb
+	Error             error
+	Url               *url.URL
+	Id                int64
+	CatSelections     http_util.Selections
+	BuildId           string
+	Vaults            []vaultView
+	IsOwnVault        bool
+	VaultXsrf         string
+	IsMaster          bool
+	ShareCount        int
+	CanEditEntries    bool
+	CanEditCategories bool
+	NeedsReauth       bool
+	SecretXsrf        string
+	// How long a copied secret stays on the clipboard in milliseconds.
+	ClipboardClearMillis int64
+}
+
+type vaultView struct {
+	vsafe.Collection
+	// True if this collection is the current vault
+	Current bool
 }
 
 func (v *view) HasAnchor(idx int) bool {
@@ -179,13 +331,40 @@ func (v *view) SortBy(sortBy string) *url.URL {
 	return http_util.WithParams(
 		v.Url,
 		"sort", sortBy,
-		"id", "0")
+		"id", "0",
+		kPageNoParam, "0")
+}
+
+// First returns the 1-based position of the first entry on this page.
+func (v *view) First() int {
+	return v.Offset + 1
+}
+
+// Last returns the 1-based position of the last entry on this page.
+func (v *view) Last() int {
+	return v.Offset + len(v.Entries)
+}
+
+// IsPaged returns true if the entries don't fit on one page.
+func (v *view) IsPaged() bool {
+	return v.Total > kPageSize
 }
 
 func (v *view) IsCurrent(id int64) bool {
 	return id == v.Id
 }
 
+func toEntryOrder(sortBy string) vsafedb.EntryOrder {
+	switch sortBy {
+	case "newest":
+		return vsafedb.NewestFirst
+	case "best":
+		return vsafedb.BestMatch
+	default:
+		return vsafedb.ByTitle
+	}
+}
+
 func init() {
 	kTemplate = common.NewTemplate("home", kTemplateSpec)
 }
@@ -2,12 +2,14 @@ package login
 
 import (
 	"github.com/keep94/sessions"
+	"github.com/keep94/toolbox/db"
 	"github.com/keep94/toolbox/http_util"
 	"github.com/keep94/vsafe"
 	"github.com/keep94/vsafe/apps/vsafe/common"
 	"github.com/keep94/vsafe/vsafedb"
 	"html/template"
 	"net/http"
+	"time"
 )
 
 var (
@@ -45,9 +47,21 @@ var (
 	kTemplate *template.Template
 )
 
+type Store interface {
+	vsafedb.UserByNameRunner
+	vsafedb.UserByIdRunner
+	vsafedb.UpdateUserRunner
+	vsafedb.MembershipsByUserRunner
+	vsafedb.AddMembershipRunner
+	vsafedb.RemoveMembershipRunner
+}
+
 type Handler struct {
 	SessionStore sessions.Store
-	Store        vsafedb.UserByNameRunner
+	Doer         db.Doer
+	Store        Store
+	// The server's session timeouts. Users may have shorter ones.
+	Timeouts common.SessionTimeouts
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -58,7 +72,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		userName := r.Form.Get("name")
 		password := r.Form.Get("password")
 		var user vsafe.User
-		err := h.Store.UserByName(nil, userName, &user)
+		err := vsafedb.UserByNameContext(
+			r.Context(), h.Store, nil, userName, &user)
 		if err == vsafedb.ErrNoSuchId {
 			http_util.WriteTemplate(w, kTemplate, "Login incorrect.")
 			return
@@ -76,6 +91,24 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http_util.ReportError(w, "Error verifying password", err)
 			return
 		}
+		// Vaults created before entries could be shared and users created
+		// before they had their own key pairs for collections get their
+		// key pairs the first time they log in.
+		var memberKey *vsafe.Key
+		err = h.Doer.Do(func(t db.Transaction) error {
+			var err error
+			memberKey, err = vsafedb.MemberKey(
+				h.Store, t, &user, key, password)
+			if err != nil {
+				return err
+			}
+			_, err = vsafedb.EnsureKeyPair(h.Store, t, &user, key)
+			return err
+		})
+		if err != nil {
+			http_util.ReportError(w, "Error creating key pair", err)
+			return
+		}
 		gs, err := common.NewGorillaSession(h.SessionStore, r)
 		if err != nil {
 			http_util.ReportError(w, "Error creating session", err)
@@ -84,9 +117,17 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		session := common.CreateUserSession(gs)
 		session.SetUserId(user.Id)
 		session.SetKey(key)
+		session.SetMemberKey(memberKey)
+		session.SetVaultKey(nil)
+		now := time.Now()
+		session.SetLastAuth(now)
+		session.SetLastLogin(now)
+		session.SetLastActive(now)
+		session.SetTimeouts(h.Timeouts.ForUser(&user))
 		session.ID = "" // For added security, force a new session ID
 		session.Save(r, w)
-		http_util.Redirect(w, r, r.Form.Get("prev"))
+		http_util.Redirect(
+			w, r, common.RedirectTarget(r.Form.Get("prev")).String())
 	}
 }
 
@@ -0,0 +1,108 @@
+package login_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/keep94/ramstore"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/login"
+	"github.com/keep94/vsafe/vsafedb/for_memory"
+)
+
+func TestLoginRedirect(t *testing.T) {
+	handler := newHandler(t)
+	testCases := []struct {
+		prev     string
+		expected string
+	}{
+		{"/vsafe/single?id=3", "/vsafe/single?id=3"},
+		{"", "/vsafe/home"},
+		{"http://evil.com/vsafe/home", "/vsafe/home"},
+		{"//evil.com", "/vsafe/home"},
+		{"/auth/poll", "/vsafe/home"},
+	}
+	for _, tc := range testCases {
+		w := postLogin(handler, "bob", "secret", tc.prev)
+		if w.Code != http.StatusFound {
+			t.Errorf("For %q, expected 302, got %d", tc.prev, w.Code)
+		}
+		if out := w.Header().Get("Location"); out != tc.expected {
+			t.Errorf("For %q, expected %q, got %q", tc.prev, tc.expected, out)
+		}
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	handler := newHandler(t)
+	w := postLogin(handler, "bob", "wrong", "http://evil.com")
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+	if out := w.Header().Get("Location"); out != "" {
+		t.Errorf("Expected no redirect, got %q", out)
+	}
+}
+
+func TestLoginMemberKeyPair(t *testing.T) {
+	dbase := for_memory.NewDb()
+	store := for_memory.New(dbase)
+	var user vsafe.User
+	if err := user.Init("bob", "secret"); err != nil {
+		t.Fatalf("Error initializing user: %v", err)
+	}
+	// Users created before collections had no key pair of their own
+	user.MemberPublicKey = ""
+	user.MemberPrivateKey = ""
+	if err := store.AddUser(nil, &user); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	handler := newHandlerWithDb(dbase)
+	if w := postLogin(handler, "bob", "secret", ""); w.Code != http.StatusFound {
+		t.Fatalf("Expected 302, got %d", w.Code)
+	}
+	var stored vsafe.User
+	if err := store.UserById(nil, user.Id, &stored); err != nil {
+		t.Fatalf("Error reading user: %v", err)
+	}
+	if _, err := stored.MemberKey("secret"); err != nil {
+		t.Errorf("Expected login to add member key pair, got %v", err)
+	}
+}
+
+func newHandler(t *testing.T) *login.Handler {
+	dbase := for_memory.NewDb()
+	store := for_memory.New(dbase)
+	var user vsafe.User
+	if err := user.Init("bob", "secret"); err != nil {
+		t.Fatalf("Error initializing user: %v", err)
+	}
+	if err := store.AddUser(nil, &user); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	return newHandlerWithDb(dbase)
+}
+
+func newHandlerWithDb(dbase *for_memory.Db) *login.Handler {
+	return &login.Handler{
+		SessionStore: ramstore.NewRAMStore(3600),
+		Doer:         for_memory.NewDoer(dbase),
+		Store:        for_memory.New(dbase),
+	}
+}
+
+func postLogin(
+	handler http.Handler, name, password, prev string) *httptest.ResponseRecorder {
+	form := url.Values{"name": {name}, "password": {password}}
+	r := httptest.NewRequest(
+		"POST",
+		"/auth/login?"+url.Values{"prev": {prev}}.Encode(),
+		strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
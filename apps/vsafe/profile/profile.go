@@ -0,0 +1,173 @@
+// Package profile shows the current user's name, master user, and role and
+// lets users change their name.
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/http_util"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/common"
+	"github.com/keep94/vsafe/vsafedb"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	kProfile = "profile"
+)
+
+var (
+	kTemplateSpec = `
+<html>
+<head>
+  <title>Vsafe using Go</title>
+  <link rel="stylesheet" type="text/css" href="/static/theme.css" />
+  <link rel="shortcut icon" href="/images/favicon.ico" type="image/x-icon" />
+</head>
+<body>
+<h2>Profile</h2>
+<a href="/vsafe/home">Back</a>
+<br><br>
+{{if .Error}}
+  <span class="error">{{.Error.Error}}</span>
+  <br>
+{{end}}
+{{if .Message}}
+  <font color="#006600"><b>{{.Message}}</b></font>
+  <br>
+{{end}}
+<table>
+  <tr>
+    <td>Name:</td>
+    <td>{{.Name}}</td>
+  </tr>
+  <tr>
+    <td>Master user:</td>
+    <td>{{if .Master}}{{.Master}}{{else}}You{{end}}</td>
+  </tr>
+  <tr>
+    <td>Role:</td>
+    <td>{{.Role}}</td>
+  </tr>
+</table>
+{{if .CanRename}}
+<h3>Change name</h3>
+<form method="post">
+  <input type="hidden" name="xsrf" value="{{.Xsrf}}">
+  <table>
+    <tr>
+      <td>New name: </td>
+      <td><input type="text" name="name"></td>
+    </tr>
+{{if .NeedsReauth}}
+    <tr>
+      <td>Your password: </td>
+      <td><input type="password" name="reauth" autocomplete="off"></td>
+    </tr>
+{{end}}
+  </table>
+  <input type="submit" name="rename" value="Change name">
+</form>
+{{end}}
+</body>
+</html>`
+)
+
+var (
+	kTemplate *template.Template
+)
+
+var (
+	kErrNameRequired = errors.New("Name required.")
+	kErrNameTaken    = errors.New("That name is already taken.")
+)
+
+type Store interface {
+	vsafedb.UserByIdRunner
+	vsafedb.UserByNameRunner
+	vsafedb.UpdateUserRunner
+}
+
+// Handler shows the profile of the current user. Users who may change
+// their password may also change their name.
+type Handler struct {
+	Doer  db.Doer
+	Store Store
+	// How long after entering their password a user may change their name
+	// without entering their password again.
+	ReauthWindow time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	session := common.GetUserSession(r)
+	message := ""
+	var err error
+	if r.Method == "POST" && session.User.CanRename() {
+		name := strings.TrimSpace(r.Form.Get("name"))
+		if !common.VerifyXsrfToken(r, kProfile) {
+			err = common.ErrXsrf
+		} else if name == "" {
+			err = kErrNameRequired
+		} else if err = common.VerifyReauth(
+			w, r, h.ReauthWindow); err == nil {
+			err = h.Doer.Do(func(t db.Transaction) error {
+				_, err := vsafedb.RenameUser(h.Store, t, session.User.Id, name)
+				return err
+			})
+			if err == nil {
+				session.User.Name = name
+				message = fmt.Sprintf("Your name is now %s.", name)
+			}
+		}
+		if err == vsafedb.ErrNameTaken {
+			err = kErrNameTaken
+		}
+	}
+	masterName := ""
+	if session.User.Owner != 0 {
+		var master vsafe.User
+		if readErr := vsafedb.UserByIdContext(
+			r.Context(),
+			h.Store,
+			nil,
+			session.User.Owner,
+			&master); readErr != nil {
+			masterName = fmt.Sprintf("(%d)", session.User.Owner)
+		} else {
+			masterName = master.Name
+		}
+	}
+	http_util.WriteTemplate(
+		w,
+		kTemplate,
+		&view{
+			Name:        session.User.Name,
+			Master:      masterName,
+			Role:        session.User.GetRole(),
+			CanRename:   session.User.CanRename(),
+			NeedsReauth: !common.IsReauthenticated(r, h.ReauthWindow),
+			Error:       err,
+			Message:     message,
+			Xsrf:        common.NewXsrfToken(r, kProfile)})
+}
+
+type view struct {
+	Name string
+	// The name of the master user or empty if the user is a master user
+	Master      string
+	Role        vsafe.Role
+	CanRename   bool
+	NeedsReauth bool
+	Error       error
+	Message     string
+	Xsrf        string
+}
+
+func init() {
+	kTemplate = common.NewTemplate("profile", kTemplateSpec)
+}
@@ -0,0 +1,89 @@
+// Package secret serves a single decrypted field of an entry so that pages
+// can copy it to the clipboard without ever showing it.
+package secret
+
+import (
+	"fmt"
+	"github.com/keep94/toolbox/http_util"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/common"
+	"github.com/keep94/vsafe/vsafedb"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	kSecret = "secret"
+)
+
+// NewXsrfToken creates the xsrf token pages must send along with requests
+// for secrets.
+func NewXsrfToken(r *http.Request) string {
+	return common.NewXsrfToken(r, kSecret)
+}
+
+// Handler serves the user name or password of an entry as plain text.
+// Requests must be POST requests with "id", "field", and "xsrf" parameters.
+// "field" is either "uname" or "password". Handler responds with
+// 403 Forbidden if the user has not entered their password within
+// ReauthWindow and the request has no correct "reauth" parameter.
+type Handler struct {
+	Store vsafedb.EntryByIdRunner
+	// How long after entering their password a user may fetch secrets
+	// without entering their password again.
+	ReauthWindow time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http_util.Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	if !common.VerifyXsrfToken(r, kSecret) {
+		http.Error(w, common.ErrXsrf.Error(), http.StatusForbidden)
+		return
+	}
+	err := common.VerifyReauth(w, r, h.ReauthWindow)
+	if err == common.ErrReauthRequired || err == common.ErrReauthFailed {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http_util.ReportError(w, "Error verifying password.", err)
+		return
+	}
+	id, _ := strconv.ParseInt(r.Form.Get("id"), 10, 64)
+	session := common.GetUserSession(r)
+	var entry vsafe.Entry
+	err = vsafedb.EntryByIdContext(
+		r.Context(),
+		h.Store,
+		nil,
+		id,
+		session.User,
+		session.VaultKey(),
+		&entry)
+	if err == vsafedb.ErrNoSuchId {
+		http_util.Error(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http_util.ReportError(w, "Error reading database.", err)
+		return
+	}
+	var value string
+	switch r.Form.Get("field") {
+	case "uname":
+		value = entry.UName
+	case "password":
+		value = entry.Password
+	default:
+		http_util.Error(w, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, value)
+}
@@ -0,0 +1,133 @@
+package secret_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/fixture"
+	"github.com/keep94/vsafe/apps/vsafe/secret"
+	"github.com/keep94/vsafe/vsafedb"
+)
+
+const (
+	kReauthWindow = 5 * time.Minute
+	kSecret       = "secret"
+)
+
+func TestSecret(t *testing.T) {
+	f := newFixture(t)
+	form := url.Values{"id": {f.entryId}, "field": {"password"}}
+	w := f.serve("POST", form, true, time.Now())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if out := w.Body.String(); out != "open sesame" {
+		t.Errorf("Expected %q, got %q", "open sesame", out)
+	}
+	if out := w.Header().Get("Cache-Control"); out != "no-store" {
+		t.Errorf("Expected no-store, got %q", out)
+	}
+	form.Set("field", "uname")
+	w = f.serve("POST", form, true, time.Now())
+	if out := w.Body.String(); out != "bob@example.com" {
+		t.Errorf("Expected %q, got %q", "bob@example.com", out)
+	}
+}
+
+func TestSecretMethodNotAllowed(t *testing.T) {
+	f := newFixture(t)
+	form := url.Values{"id": {f.entryId}, "field": {"password"}}
+	if w := f.serve("GET", form, true, time.Now()); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+}
+
+func TestSecretXsrf(t *testing.T) {
+	f := newFixture(t)
+	form := url.Values{"id": {f.entryId}, "field": {"password"}}
+	if w := f.serve("POST", form, false, time.Now()); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for missing xsrf, got %d", w.Code)
+	}
+	form.Set("xsrf", "1234:abcd")
+	if w := f.serve("POST", form, false, time.Now()); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for wrong xsrf, got %d", w.Code)
+	}
+}
+
+func TestSecretReauth(t *testing.T) {
+	f := newFixture(t)
+	expired := time.Now().Add(-2 * kReauthWindow)
+	form := url.Values{"id": {f.entryId}, "field": {"password"}}
+	w := f.serve("POST", form, true, expired)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without reauth, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "open sesame") {
+		t.Error("Expected password not to be revealed")
+	}
+	form.Set("reauth", "wrong")
+	if w := f.serve("POST", form, true, expired); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for wrong reauth, got %d", w.Code)
+	}
+	form.Set("reauth", "secret")
+	w = f.serve("POST", form, true, expired)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for correct reauth, got %d", w.Code)
+	}
+	if out := w.Body.String(); out != "open sesame" {
+		t.Errorf("Expected %q, got %q", "open sesame", out)
+	}
+}
+
+func TestSecretBadRequest(t *testing.T) {
+	f := newFixture(t)
+	form := url.Values{"id": {"9999"}, "field": {"password"}}
+	if w := f.serve("POST", form, true, time.Now()); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+	form = url.Values{"id": {f.entryId}, "field": {"desc"}}
+	if w := f.serve("POST", form, true, time.Now()); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", w.Code)
+	}
+}
+
+type secretFixture struct {
+	*fixture.Vault
+	t       *testing.T
+	handler http.Handler
+	entryId string
+}
+
+func newFixture(t *testing.T) *secretFixture {
+	f := &secretFixture{Vault: fixture.NewVault(t), t: t}
+	entry := vsafe.Entry{
+		Title: "Bank", UName: "bob@example.com", Password: "open sesame"}
+	id, err := vsafedb.AddEntry(f.Store, nil, &f.Master, f.Key, &entry)
+	if err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+	f.entryId = strconv.FormatInt(id, 10)
+	f.handler = &secret.Handler{Store: f.Store, ReauthWindow: kReauthWindow}
+	return f
+}
+
+// serve sends form to the handler on behalf of the master user who last
+// entered their password at lastAuth. If withXsrf is true, serve adds a
+// valid xsrf token to form.
+func (f *secretFixture) serve(
+	method string,
+	form url.Values,
+	withXsrf bool,
+	lastAuth time.Time) *httptest.ResponseRecorder {
+	session := &fixture.Session{User: &f.Master, LastAuth: lastAuth}
+	if withXsrf {
+		session.XsrfAction = kSecret
+	}
+	return f.Serve(f.t, f.handler, method, "/vsafe/secret", form, session)
+}
@@ -0,0 +1,242 @@
+// Package shares shows the entries shared with and by the current user's
+// vault and lets users accept, decline, or revoke them.
+package shares
+
+import (
+	"context"
+	"fmt"
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/http_util"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/common"
+	"github.com/keep94/vsafe/vsafedb"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+const (
+	kShares = "shares"
+)
+
+var (
+	kTemplateSpec = `
+<html>
+<head>
+  <title>Vsafe using Go</title>
+  <link rel="stylesheet" type="text/css" href="/static/theme.css" />
+  <link rel="shortcut icon" href="/images/favicon.ico" type="image/x-icon" />
+  <script type="text/javascript" src="/static/vsafe.js"></script>
+</head>
+<body>
+<h2>Shared entries</h2>
+<a href="/vsafe/home">Back</a>
+<br><br>
+{{if .Error}}
+  <span class="error">{{.Error.Error}}</span>
+{{end}}
+{{if .Message}}
+  <font color="#006600"><b>{{.Message}}</b></font>
+{{end}}
+<h3>Shared with you</h3>
+{{if .Received}}
+<table>
+{{range .Received}}
+  <tr class="lineitem">
+    <td>{{.Entry.Title}}</td>
+    <td>{{if .Entry.Url}}{{.Entry.Url}}{{else}}&nbsp;{{end}}</td>
+    <td>from {{.Name}}</td>
+    <td>
+{{if $.CanAccept}}
+      <form method="post">
+        <input type="hidden" name="xsrf" value="{{$.Xsrf}}">
+        <input type="hidden" name="id" value="{{.Id}}">
+        <input type="submit" name="accept" value="Accept">
+      </form>
+{{end}}
+    </td>
+    <td>
+{{if $.CanEdit}}
+      <form method="post">
+        <input type="hidden" name="xsrf" value="{{$.Xsrf}}">
+        <input type="hidden" name="id" value="{{.Id}}">
+        <input type="submit" name="remove" value="Decline" data-confirm="Are you sure you want to decline this entry?">
+      </form>
+{{end}}
+    </td>
+  </tr>
+{{end}}
+</table>
+{{else}}
+Nothing has been shared with you.
+{{end}}
+<h3>Shared by you</h3>
+{{if .Sent}}
+<table>
+{{range .Sent}}
+  <tr class="lineitem">
+    <td>{{.Entry.Title}}</td>
+    <td>to {{.Name}}</td>
+    <td>
+{{if $.CanEdit}}
+      <form method="post">
+        <input type="hidden" name="xsrf" value="{{$.Xsrf}}">
+        <input type="hidden" name="id" value="{{.Id}}">
+        <input type="submit" name="remove" value="Revoke" data-confirm="Are you sure you want to revoke this entry?">
+      </form>
+{{end}}
+    </td>
+  </tr>
+{{end}}
+</table>
+{{else}}
+You have not shared anything that is still waiting to be accepted.
+{{end}}
+</body>
+</html>`
+)
+
+var (
+	kTemplate *template.Template
+)
+
+type Store interface {
+	vsafedb.UserByIdRunner
+	vsafedb.EntryByIdRunner
+	vsafedb.AddEntryRunner
+	vsafedb.ShareByIdRunner
+	vsafedb.SharesByRecipientRunner
+	vsafedb.SharesBySenderRunner
+	vsafedb.RemoveShareRunner
+}
+
+// Handler shows the entries shared with and by the current user's vault.
+// Entries shared with the vault can be accepted into it or declined;
+// entries shared by the vault can be revoked until they are accepted.
+type Handler struct {
+	Doer  db.Doer
+	Store Store
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	session := common.GetUserSession(r)
+	message := ""
+	var err error
+	if r.Method == "POST" {
+		id, _ := strconv.ParseInt(r.Form.Get("id"), 10, 64)
+		if !common.VerifyXsrfToken(r, kShares) {
+			err = common.ErrXsrf
+		} else if http_util.HasParam(r.Form, "accept") {
+			err = h.Doer.Do(func(t db.Transaction) error {
+				_, err := vsafedb.AcceptShare(
+					h.Store, t, id, session.User, session.Key())
+				return err
+			})
+			message = "Entry added to your vault."
+		} else if http_util.HasParam(r.Form, "remove") {
+			err = h.Doer.Do(func(t db.Transaction) error {
+				return vsafedb.RemoveShare(h.Store, t, id, session.User)
+			})
+			message = "Entry removed."
+		}
+		if err != nil {
+			message = ""
+		}
+	}
+	ctx := r.Context()
+	owner := session.User.GetOwner()
+	var master vsafe.User
+	readErr := vsafedb.UserByIdContext(ctx, h.Store, nil, owner, &master)
+	var received, sent []vsafe.Share
+	if readErr == nil {
+		readErr = vsafedb.SharesByRecipientContext(
+			ctx, h.Store, nil, owner, consume2.AppendTo(&received))
+	}
+	if readErr == nil {
+		readErr = vsafedb.SharesBySenderContext(
+			ctx, h.Store, nil, owner, consume2.AppendTo(&sent))
+	}
+	if readErr != nil {
+		http_util.ReportError(w, "Error reading database.", readErr)
+		return
+	}
+	names := make(map[int64]string)
+	receivedViews := make([]shareView, len(received))
+	for i := range received {
+		receivedViews[i] = shareView{
+			Id:   received[i].Id,
+			Name: h.userName(ctx, names, received[i].Sender)}
+		if openErr := received[i].Open(
+			&master, session.Key(), &receivedViews[i].Entry); openErr != nil {
+			receivedViews[i].Entry.Title = "(unreadable)"
+		}
+	}
+	sentViews := make([]shareView, len(sent))
+	for i := range sent {
+		sentViews[i] = shareView{
+			Id:   sent[i].Id,
+			Name: h.userName(ctx, names, sent[i].Recipient)}
+		if getErr := vsafedb.EntryByIdContext(
+			ctx,
+			h.Store,
+			nil,
+			sent[i].EntryId,
+			session.User,
+			session.VaultKey(),
+			&sentViews[i].Entry); getErr != nil {
+			sentViews[i].Entry = vsafe.Entry{Title: "(not in current vault)"}
+		}
+	}
+	http_util.WriteTemplate(
+		w,
+		kTemplate,
+		&view{
+			Received:  receivedViews,
+			Sent:      sentViews,
+			CanAccept: session.User.CanEditEntries() && !session.User.IsRestricted(),
+			CanEdit:   session.User.CanEditEntries(),
+			Error:     err,
+			Message:   message,
+			Xsrf:      common.NewXsrfToken(r, kShares)})
+}
+
+// userName returns the name of the user with given id caching names
+// in names.
+func (h *Handler) userName(
+	ctx context.Context, names map[int64]string, id int64) string {
+	name, ok := names[id]
+	if !ok {
+		var user vsafe.User
+		if err := vsafedb.UserByIdContext(
+			ctx, h.Store, nil, id, &user); err != nil {
+			name = fmt.Sprintf("(%d)", id)
+		} else {
+			name = user.Name
+		}
+		names[id] = name
+	}
+	return name
+}
+
+type shareView struct {
+	Id int64
+	// The name of the master user of the other vault
+	Name  string
+	Entry vsafe.Entry
+}
+
+type view struct {
+	Received  []shareView
+	Sent      []shareView
+	CanAccept bool
+	CanEdit   bool
+	Error     error
+	Message   string
+	Xsrf      string
+}
+
+func init() {
+	kTemplate = common.NewTemplate("shares", kTemplateSpec)
+}
@@ -14,6 +14,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -28,6 +29,10 @@ const (
 var (
 	kErrTooManyCategories = errors.New("No more than 10 categories can be selected")
 	kErrTitleRequired     = errors.New("Title required")
+	kErrReadOnly          = errors.New("You are not allowed to change entries.")
+	kErrCategoryRequired  = errors.New("Select at least one of your categories.")
+	kErrNoSuchCategory    = errors.New("No such category.")
+	kErrSameVault         = errors.New("That user already shares your vault.")
 )
 
 var (
@@ -39,7 +44,7 @@ var (
   <link rel="shortcut icon" href="/images/favicon.ico" type="image/x-icon" />
   <script type="text/javascript" src="/static/vsafe.js"></script>
 </head>
-<body>
+<body data-poll-url="/auth/poll?kid={{.KeyId}}" data-poll-millis="{{.PollMillis}}" data-clipboard-clear="{{.ClipboardClearMillis}}">
 {{if .Error}}
   <span class="error">{{.Error}}</span>
 {{end}}
@@ -49,7 +54,7 @@ var (
   <table>
     <tr>
       <td align="right">URL: </td>
-      <td><input type="text" id="url" name="url" value="{{.Get "url"}}" size="50" />&nbsp;&nbsp;<a href="#" onclick="window.open(document.getElementById('url').value, '_blank'); return false">Open page</a></td>
+      <td><input type="text" id="url" name="url" value="{{.Get "url"}}" size="50" />&nbsp;&nbsp;<a href="#" data-open="url">Open page</a></td>
     </tr>
     <tr>
       <td align="right">Title: </td>
@@ -62,20 +67,52 @@ var (
   </table>
   <hr/>
   <b>Everything below is encrypted</b>
+{{if .Locked}}
+  <br/>
+  <i>Re-enter your password to see or change encrypted fields.</i>
+  <table>
+    <tr>
+      <td align="right">Your password: </td>
+      <td><input type="password" name="reauth" size="20" />&nbsp;<input type="submit" name="unlock" value="Unlock" /></td>
+    </tr>
+  </table>
+{{else}}
+  <input type="hidden" name="secrets" value="1">
   <table>
     <tr>
       <td align="right">User Name: </td>
-      <td><input type="text" name="uname" value="{{.Get "uname"}}" size="20" /></td>
+      <td>
+        <input type="password" id="uname" name="uname" value="{{.Get "uname"}}" size="20" autocomplete="off" />
+        <input type="button" value="Show" data-reveal="uname" />
+        <input type="button" value="Copy" data-copy="uname" />
+      </td>
     </tr>
     <tr>
       <td align="right">Password: </td>
-      <td><input type="text" name="password" value="{{.Get "password"}}" size="20" /></td>
+      <td>
+        <input type="password" id="password" name="password" value="{{.Get "password"}}" size="20" autocomplete="off" />
+        <input type="button" value="Show" data-reveal="password" />
+        <input type="button" value="Copy" data-copy="password" />
+      </td>
     </tr>
    <tr>
-      <td align="right">Special: </td>
-      <td><textarea name="special" rows="6" cols="75">{{.Get "special"}}</textarea></td>
+      <td align="right" valign="top">Special: </td>
+      <td>
+        <input type="button" value="Show" data-reveal="special" /><br/>
+        <textarea id="special" name="special" rows="6" cols="75" hidden>{{.Get "special"}}</textarea>
+      </td>
     </tr>
+{{if and .ExistingEntry (not .ReadOnly)}}
+    <tr>
+      <td align="right">Share with: </td>
+      <td>
+        <input type="text" name="recipient" value="{{.Get "recipient"}}" size="20" />
+        <input type="submit" name="share" value="Share" />
+      </td>
+    </tr>
+{{end}}
   </table>
+{{end}}
   <table>
     {{with $top:=.}}
     {{range .CatRows}}
@@ -93,18 +130,18 @@ var (
   </table>
   <table>
     <tr>
+{{if .ReadOnly}}
+      <td><input type="submit" name="cancel" value="Back" /></td>
+{{else}}
       <td><input type="submit" name="save" value="Save" /></td>
       <td><input type="submit" name="cancel" value="Cancel" /></td>
-{{if .ExistingEntry}}
-      <td><input type="submit" name="delete" value="Delete" onclick="return confirm('Are you sure you want to delete this entry?')"/></td>
+{{end}}
+{{if and .ExistingEntry (not .ReadOnly)}}
+      <td><input type="submit" name="delete" value="Delete" data-confirm="Are you sure you want to delete this entry?"/></td>
 {{end}}
    </tr>
  </table>
 </form>
-<script type="text/javascript">
-  var autoLogout = new AutoLogout("/auth/poll?kid={{.KeyId}}", "/auth/login", 60000);
-  autoLogout.start();
-</script>
 </body>
 </html>`
 )
@@ -114,6 +151,9 @@ var (
 )
 
 type Store interface {
+	vsafedb.UserByIdRunner
+	vsafedb.UserByNameRunner
+	vsafedb.AddShareRunner
 	vsafedb.AddEntryRunner
 	vsafedb.UpdateEntryRunner
 	vsafedb.RemoveEntryRunner
@@ -124,6 +164,11 @@ type Store interface {
 type Handler struct {
 	Doer  db.Doer
 	Store Store
+	// How long after entering their password a user may see encrypted
+	// fields or delete entries without entering their password again.
+	ReauthWindow time.Duration
+	// How long a copied secret stays on the clipboard.
+	ClipboardClear time.Duration
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -141,12 +186,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) doPost(w http.ResponseWriter, r *http.Request, id int64) {
 	var err error
 	session := common.GetUserSession(r)
-	categories, err := h.Store.CategoriesByOwner(nil, session.Key().Id)
+	ctx := r.Context()
+	categories, err := vsafedb.CategoriesByOwnerContext(
+		ctx, h.Store, nil, session.VaultKey().Id)
 	if err != nil {
 		http_util.ReportError(w, "Error reading database.", err)
 		return
 	}
-	catRows := toCatRows(categories)
+	visible := vsafedb.VisibleCategories(session.User, categories)
+	catRows := toCatRows(visible)
 	catMap, err := toCatMap(r.Form["cat"])
 	if err != nil {
 		http_util.ReportError(w, "Error setting checkboxes", err)
@@ -154,27 +202,85 @@ func (h *Handler) doPost(w http.ResponseWriter, r *http.Request, id int64) {
 	}
 	if !common.VerifyXsrfToken(r, kSingle) {
 		err = common.ErrXsrf
+	} else if http_util.HasParam(r.Form, "unlock") {
+		if err = common.VerifyReauth(w, r, h.ReauthWindow); err == nil {
+			h.doGet(w, r, id)
+			return
+		}
 	} else if http_util.HasParam(r.Form, "delete") {
 		if isIdValid(id) {
-			err = h.Store.RemoveEntry(nil, id, session.User.GetOwner())
+			err = common.VerifyReauth(w, r, h.ReauthWindow)
+			if err == nil {
+				err = h.Doer.Do(func(t db.Transaction) error {
+					return vsafedb.RemoveEntryContext(
+						ctx, h.Store, t, id, session.User, session.VaultKey())
+				})
+			}
+		}
+	} else if http_util.HasParam(r.Form, "share") {
+		if isIdValid(id) {
+			err = common.VerifyReauth(w, r, h.ReauthWindow)
+			if err == nil {
+				err = h.Doer.Do(func(t db.Transaction) error {
+					_, err := vsafedb.ShareEntry(
+						h.Store,
+						t,
+						id,
+						session.User,
+						session.VaultKey(),
+						r.Form.Get("recipient"))
+					return err
+				})
+			}
+			if err == nil {
+				http_util.Redirect(w, r, "/vsafe/shares")
+				return
+			}
 		}
 	} else if http_util.HasParam(r.Form, "cancel") {
 		// Do nothing
 	} else {
+		// Encrypted fields of an existing entry are only changed if they
+		// were shown on the page.
+		withSecrets := !isIdValid(id) || http_util.HasParam(r.Form, "secrets")
+		if isIdValid(id) && withSecrets {
+			err = common.VerifyReauth(w, r, h.ReauthWindow)
+		}
+		if err == nil && !hasCategories(visible, catMap) {
+			err = kErrNoSuchCategory
+		}
+		if err == nil && !session.User.CanSee(idset.New(catMap)) {
+			err = kErrCategoryRequired
+		}
 		var mutation vsafe.EntryUpdater
-		mutation, err = toEntry(r.Form, catMap)
+		if err == nil {
+			mutation, err = toEntry(r.Form, catMap, withSecrets)
+		}
 		if err == nil {
 			if isIdValid(id) {
 				tag, _ := strconv.ParseUint(r.Form.Get("etag"), 10, 64)
 				err = h.Doer.Do(func(t db.Transaction) error {
-					return vsafedb.UpdateEntryWithEtag(
-						h.Store, t, id, tag, session.Key(), mutation)
+					return vsafedb.UpdateEntryWithEtagContext(
+						ctx,
+						h.Store,
+						t,
+						id,
+						tag,
+						session.User,
+						session.VaultKey(),
+						mutation)
 				})
 			} else {
 				var newId int64
 				var entry vsafe.Entry
 				mutation(&entry)
-				newId, err = vsafedb.AddEntry(h.Store, nil, session.Key(), &entry)
+				newId, err = vsafedb.AddEntryContext(
+					ctx,
+					h.Store,
+					nil,
+					session.User,
+					session.VaultKey(),
+					&entry)
 				if err == nil {
 					id = newId
 				}
@@ -184,6 +290,12 @@ func (h *Handler) doPost(w http.ResponseWriter, r *http.Request, id int64) {
 	if err == vsafedb.ErrConcurrentModification {
 		err = errors.New("Someone else updated this entry after you started. Click cancel and try again.")
 	}
+	if err == vsafedb.ErrPermissionDenied {
+		err = kErrReadOnly
+	}
+	if err == vsafedb.ErrSameVault {
+		err = kErrSameVault
+	}
 	if err != nil {
 		http_util.WriteTemplate(
 			w,
@@ -191,9 +303,13 @@ func (h *Handler) doPost(w http.ResponseWriter, r *http.Request, id int64) {
 			newView(
 				r.Form,
 				isIdValid(id),
-				session.Key().Id,
+				h.isLocked(r, isIdValid(id)),
+				!session.User.CanEditEntries(),
+				session.VaultKey().Id,
 				catRows,
 				catMap,
+				h.ClipboardClear,
+				session.Timeouts().PollInterval(),
 				common.NewXsrfToken(r, kSingle),
 				err))
 	} else {
@@ -203,16 +319,24 @@ func (h *Handler) doPost(w http.ResponseWriter, r *http.Request, id int64) {
 
 func (h *Handler) doGet(w http.ResponseWriter, r *http.Request, id int64) {
 	session := common.GetUserSession(r)
-	categories, err := h.Store.CategoriesByOwner(nil, session.Key().Id)
+	ctx := r.Context()
+	categories, err := vsafedb.CategoriesByOwnerContext(
+		ctx, h.Store, nil, session.VaultKey().Id)
 	if err != nil {
 		http_util.ReportError(w, "Error reading database.", err)
 		return
 	}
-	catRows := toCatRows(categories)
+	catRows := toCatRows(vsafedb.VisibleCategories(session.User, categories))
 	if isIdValid(id) {
 		var entryWithEtag vsafe.Entry
-		err := vsafedb.EntryById(
-			h.Store, nil, id, session.Key(), &entryWithEtag)
+		err := vsafedb.EntryByIdContext(
+			ctx,
+			h.Store,
+			nil,
+			id,
+			session.User,
+			session.VaultKey(),
+			&entryWithEtag)
 		if err == vsafedb.ErrNoSuchId {
 			fmt.Fprintln(w, "No entry found.")
 			return
@@ -232,9 +356,13 @@ func (h *Handler) doGet(w http.ResponseWriter, r *http.Request, id int64) {
 			newView(
 				fromEntry(&entryWithEtag),
 				true,
-				session.Key().Id,
+				h.isLocked(r, true),
+				!session.User.CanEditEntries(),
+				session.VaultKey().Id,
 				catRows,
 				catMap,
+				h.ClipboardClear,
+				session.Timeouts().PollInterval(),
 				common.NewXsrfToken(r, kSingle),
 				nil))
 	} else {
@@ -251,14 +379,25 @@ func (h *Handler) doGet(w http.ResponseWriter, r *http.Request, id int64) {
 			newView(
 				initValues,
 				false,
-				session.Key().Id,
+				false,
+				!session.User.CanEditEntries(),
+				session.VaultKey().Id,
 				catRows,
 				nil,
+				h.ClipboardClear,
+				session.Timeouts().PollInterval(),
 				common.NewXsrfToken(r, kSingle),
 				nil))
 	}
 }
 
+// isLocked returns true if encrypted fields must be hidden. Encrypted
+// fields of existing entries are hidden unless the user entered their
+// password recently.
+func (h *Handler) isLocked(r *http.Request, existingEntry bool) bool {
+	return existingEntry && !common.IsReauthenticated(r, h.ReauthWindow)
+}
+
 func withId(url *url.URL, id int64) *url.URL {
 	idStr := strconv.FormatInt(id, 10)
 	result := *http_util.WithParams(url, "id", idStr)
@@ -266,7 +405,10 @@ func withId(url *url.URL, id int64) *url.URL {
 	return &result
 }
 
-func toEntry(values url.Values, catMap map[int64]bool) (mutation vsafe.EntryUpdater, err error) {
+func toEntry(
+	values url.Values,
+	catMap map[int64]bool,
+	withSecrets bool) (mutation vsafe.EntryUpdater, err error) {
 	if len(catMap) > kMaxCategories {
 		err = kErrTooManyCategories
 		return
@@ -303,17 +445,19 @@ func toEntry(values url.Values, catMap map[int64]bool) (mutation vsafe.EntryUpda
 			entryPtr.Desc = desc
 			changed = true
 		}
-		if entryPtr.UName != uName {
-			entryPtr.UName = uName
-			changed = true
-		}
-		if entryPtr.Password != password {
-			entryPtr.Password = password
-			changed = true
-		}
-		if entryPtr.Special != special {
-			entryPtr.Special = special
-			changed = true
+		if withSecrets {
+			if entryPtr.UName != uName {
+				entryPtr.UName = uName
+				changed = true
+			}
+			if entryPtr.Password != password {
+				entryPtr.Password = password
+				changed = true
+			}
+			if entryPtr.Special != special {
+				entryPtr.Special = special
+				changed = true
+			}
 		}
 		if entryPtr.Categories != categories {
 			entryPtr.Categories = categories
@@ -336,6 +480,17 @@ func fromEntry(entry *vsafe.Entry) url.Values {
 	return result
 }
 
+func withoutSecrets(values url.Values) url.Values {
+	result := make(url.Values, len(values))
+	for k, v := range values {
+		result[k] = v
+	}
+	result.Del("uname")
+	result.Del("password")
+	result.Del("special")
+	return result
+}
+
 func safeUrlParse(str string) (*url.URL, error) {
 	str = strings.TrimSpace(str)
 	if str == "" {
@@ -352,12 +507,7 @@ func safeUrlString(u *url.URL) string {
 }
 
 func goBack(w http.ResponseWriter, r *http.Request, id int64) {
-	var u *url.URL
-	u, err := url.Parse(r.Form.Get("prev"))
-	if err != nil {
-		http_util.ReportError(w, "Error parsing prev url", err)
-		return
-	}
+	u := common.RedirectTarget(r.Form.Get("prev"))
 	http_util.Redirect(w, r, withId(u, id).String())
 }
 
@@ -373,6 +523,21 @@ func toCatMap(cats []string) (map[int64]bool, error) {
 	return result, nil
 }
 
+// hasCategories returns true if every id in catMap is the id of one of
+// categories.
+func hasCategories(categories []vsafe.Category, catMap map[int64]bool) bool {
+	ids := make(map[int64]bool, len(categories))
+	for _, category := range categories {
+		ids[category.Id] = true
+	}
+	for id := range catMap {
+		if !ids[id] {
+			return false
+		}
+	}
+	return true
+}
+
 func toCatRows(cats []vsafe.Category) (result [][]*vsafe.Category) {
 	var row []*vsafe.Category
 	for _, cat := range cats {
@@ -396,28 +561,45 @@ type view struct {
 	http_util.Values
 	Error         error
 	ExistingEntry bool
+	Locked        bool
+	ReadOnly      bool
 	KeyId         int64
 	Xsrf          string
 	CatRows       [][]*vsafe.Category
 	CatMap        map[int64]bool
+	// How long a copied secret stays on the clipboard in milliseconds.
+	ClipboardClearMillis int64
+	// How often to check for an expired session in milliseconds.
+	PollMillis int64
 }
 
 func newView(
 	values url.Values,
 	existingEntry bool,
+	locked bool,
+	readOnly bool,
 	keyId int64,
 	catRows [][]*vsafe.Category,
 	catMap map[int64]bool,
+	clipboardClear time.Duration,
+	pollInterval time.Duration,
 	xsrf string,
 	err error) *view {
+	if locked {
+		values = withoutSecrets(values)
+	}
 	return &view{
-		Values:        http_util.Values{Values: values},
-		ExistingEntry: existingEntry,
-		KeyId:         keyId,
-		CatRows:       catRows,
-		CatMap:        catMap,
-		Xsrf:          xsrf,
-		Error:         err}
+		Values:               http_util.Values{Values: values},
+		ExistingEntry:        existingEntry,
+		Locked:               locked,
+		ReadOnly:             readOnly,
+		KeyId:                keyId,
+		CatRows:              catRows,
+		CatMap:               catMap,
+		ClipboardClearMillis: clipboardClear.Milliseconds(),
+		PollMillis:           pollInterval.Milliseconds(),
+		Xsrf:                 xsrf,
+		Error:                err}
 }
 
 func isIdValid(id int64) bool {
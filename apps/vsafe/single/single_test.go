@@ -0,0 +1,89 @@
+package single_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keep94/consume2"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/fixture"
+	"github.com/keep94/vsafe/apps/vsafe/single"
+)
+
+func TestGoBack(t *testing.T) {
+	handler := &single.Handler{}
+	testCases := []struct {
+		prev     string
+		expected string
+	}{
+		{"/vsafe/home?q=foo", "/vsafe/home?id=5&q=foo#5"},
+		{"", "/vsafe/home?id=5#5"},
+		{"http://evil.com/vsafe/home", "/vsafe/home?id=5#5"},
+		{"//evil.com/vsafe/home", "/vsafe/home?id=5#5"},
+		{"/vsafe/../static/vsafe.js", "/vsafe/home?id=5#5"},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest(
+			"GET",
+			"/vsafe/single?"+url.Values{
+				"id": {"5"}, "prev": {tc.prev}}.Encode(),
+			nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusFound {
+			t.Errorf("For %q, expected 302, got %d", tc.prev, w.Code)
+		}
+		if out := w.Header().Get("Location"); out != tc.expected {
+			t.Errorf("For %q, expected %q, got %q", tc.prev, tc.expected, out)
+		}
+	}
+}
+
+func TestAddEntryCategories(t *testing.T) {
+	vault := fixture.NewVault(t)
+	handler := &single.Handler{
+		Doer: vault.Doer, Store: vault.Store, ReauthWindow: time.Minute}
+	mine := vsafe.Category{Owner: vault.Key.Id, Name: "mine"}
+	theirs := vsafe.Category{Owner: vault.Key.Id + 1, Name: "theirs"}
+	for _, category := range []*vsafe.Category{&mine, &theirs} {
+		if err := vault.Store.AddCategory(nil, category); err != nil {
+			t.Fatalf("Error adding category: %v", err)
+		}
+	}
+	session := &fixture.Session{
+		User: &vault.Master, LastAuth: time.Now(), XsrfAction: "single"}
+	form := url.Values{
+		"etag":  {"new"},
+		"title": {"Bank"},
+		"cat":   {strconv.FormatInt(theirs.Id, 10)},
+	}
+	w := vault.Serve(t, handler, "POST", "/vsafe/single", form, session)
+	if !strings.Contains(w.Body.String(), "No such category.") {
+		t.Error("Expected no such category error")
+	}
+	var entries []vsafe.Entry
+	if err := vault.Store.EntriesByOwner(
+		nil, vault.Key.Id, consume2.AppendTo(&entries)); err != nil {
+		t.Fatalf("Error reading entries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no entries, got %d", len(entries))
+	}
+	form.Set("cat", strconv.FormatInt(mine.Id, 10))
+	w = vault.Serve(t, handler, "POST", "/vsafe/single", form, session)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected 302, got %d", w.Code)
+	}
+	if err := vault.Store.EntriesByOwner(
+		nil, vault.Key.Id, consume2.AppendTo(&entries)); err != nil {
+		t.Fatalf("Error reading entries: %v", err)
+	}
+	if len(entries) != 1 || !entries[0].Categories.Contains(mine.Id) {
+		t.Errorf("Expected one entry in category mine, got %v", entries)
+	}
+}
@@ -35,7 +35,8 @@ AutoLogout.prototype._initRequest = function() {
 };
 
 AutoLogout.prototype._handleLogout = function() {
-  window.location = this._login_url + '?prev=' + escape(document.URL);
+  window.location = this._login_url + '?prev=' +
+      encodeURIComponent(location.pathname + location.search);
 };
 
 AutoLogout.prototype._delayTime = function() {
@@ -66,6 +67,120 @@ AutoLogout.prototype._ping = function() {
   req.open("GET", this._ping_url, true);
   req.send(null);
 };
+
+function toggleSecret(id, button) {
+  var field = document.getElementById(id);
+  var hidden;
+  if (field.tagName == "TEXTAREA") {
+    hidden = !field.hidden;
+    field.hidden = hidden;
+  } else {
+    hidden = field.type != "password";
+    field.type = hidden ? "password" : "text";
+  }
+  button.value = hidden ? "Show" : "Hide";
+}
+
+function Clipboard(clear_delay) {
+  this._clear_delay = clear_delay;
+  this._timer = null;
+}
+
+Clipboard.prototype.copyField = function(id) {
+  this.copy(document.getElementById(id).value);
+};
+
+Clipboard.prototype.copySecret = function(secret_url, xsrf, id, field) {
+  var req = new XMLHttpRequest();
+  var that = this;
+  var reauth = document.getElementById("reauth");
+  var params = "xsrf=" + encodeURIComponent(xsrf) +
+      "&id=" + encodeURIComponent(id) +
+      "&field=" + encodeURIComponent(field);
+  if (reauth) {
+    params += "&reauth=" + encodeURIComponent(reauth.value);
+  }
+  req.onreadystatechange = function() {
+    if (req.readyState == 4) {
+      if (req.status == 200) {
+        if (reauth) {
+          reauth.value = "";
+        }
+        that.copy(req.responseText);
+      } else {
+        alert(req.responseText);
+      }
+    }
+  };
+  req.open("POST", secret_url, true);
+  req.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+  req.send(params);
+};
+
+Clipboard.prototype.copy = function(text) {
+  this._write(text);
+  if (this._timer) {
+    clearTimeout(this._timer);
+  }
+  var that = this;
+  this._timer = setTimeout(function() {
+    that._timer = null;
+    that._write("");
+  }, this._clear_delay);
+};
+
+Clipboard.prototype._write = function(text) {
+  if (navigator.clipboard && window.isSecureContext) {
+    navigator.clipboard.writeText(text);
+    return;
+  }
+  var handler = function(e) {
+    e.clipboardData.setData("text/plain", text);
+    e.preventDefault();
+  };
+  document.addEventListener("copy", handler);
+  document.execCommand("copy");
+  document.removeEventListener("copy", handler);
+};
+
+// Pages configure scripts with data attributes instead of inline script
+// so that the content security policy can forbid inline script.
+function bindAll(selector, event, f) {
+  var elements = document.querySelectorAll(selector);
+  for (var i = 0; i < elements.length; i++) {
+    elements[i].addEventListener(event, f);
+  }
+}
+
+document.addEventListener("DOMContentLoaded", function() {
+  var data = document.body.dataset;
+  if (data.pollUrl) {
+    var autoLogout = new AutoLogout(
+        data.pollUrl, "/auth/login", parseInt(data.pollMillis, 10));
+    autoLogout.start();
+  }
+  var clipboard = new Clipboard(parseInt(data.clipboardClear || "0", 10));
+  bindAll("[data-confirm]", "click", function(e) {
+    if (!confirm(this.dataset.confirm)) {
+      e.preventDefault();
+    }
+  });
+  bindAll("[data-open]", "click", function(e) {
+    e.preventDefault();
+    window.open(document.getElementById(this.dataset.open).value, "_blank");
+  });
+  bindAll("[data-reveal]", "click", function() {
+    toggleSecret(this.dataset.reveal, this);
+  });
+  bindAll("[data-copy]", "click", function() {
+    clipboard.copyField(this.dataset.copy);
+  });
+  bindAll("[data-copy-secret]", "click", function() {
+    clipboard.copySecret(
+        "/vsafe/secret", data.secretXsrf, this.dataset.id,
+        this.dataset.copySecret);
+  });
+});
 `
 )
 
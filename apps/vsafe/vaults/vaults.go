@@ -0,0 +1,301 @@
+// Package vaults lets users switch between their own vault and the
+// collections they belong to, create collections, and add members to them.
+package vaults
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/http_util"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/common"
+	"github.com/keep94/vsafe/vsafedb"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	kVaults = "vaults"
+)
+
+var (
+	kTemplateSpec = `
+<html>
+<head>
+  <title>Vsafe using Go</title>
+  <link rel="stylesheet" type="text/css" href="/static/theme.css" />
+  <link rel="shortcut icon" href="/images/favicon.ico" type="image/x-icon" />
+  <script type="text/javascript" src="/static/vsafe.js"></script>
+</head>
+<body>
+<h2>Vaults</h2>
+<a href="/vsafe/home">Back</a>
+<br><br>
+{{if .Error}}
+  <span class="error">{{.Error.Error}}</span>
+{{end}}
+{{if .Message}}
+  <font color="#006600"><b>{{.Message}}</b></font>
+{{end}}
+<table>
+  <tr class="lineitem">
+    <td>Your vault</td>
+    <td>&nbsp;</td>
+    <td>
+{{if .IsCurrent 0}}
+      <b>current</b>
+{{else}}
+      <form method="post">
+        <input type="hidden" name="xsrf" value="{{.Xsrf}}">
+        <input type="hidden" name="id" value="0">
+        <input type="submit" name="switch" value="Switch">
+      </form>
+{{end}}
+    </td>
+    <td>&nbsp;</td>
+    <td>&nbsp;</td>
+  </tr>
+{{range .Collections}}
+  <tr class="lineitem">
+    <td>{{.Name}}</td>
+    <td>{{range $i, $m := .Members}}{{if $i}}, {{end}}{{$m}}{{end}}</td>
+    <td>
+{{if $.IsCurrent .Id}}
+      <b>current</b>
+{{else}}
+      <form method="post">
+        <input type="hidden" name="xsrf" value="{{$.Xsrf}}">
+        <input type="hidden" name="id" value="{{.Id}}">
+        <input type="submit" name="switch" value="Switch">
+      </form>
+{{end}}
+    </td>
+    <td>
+{{if $.CanEdit}}
+      <form method="post">
+        <input type="hidden" name="xsrf" value="{{$.Xsrf}}">
+        <input type="hidden" name="id" value="{{.Id}}">
+        <input type="text" name="name" placeholder="user name">
+        <input type="submit" name="addmember" value="Add member">
+      </form>
+{{end}}
+    </td>
+    <td>
+      <form method="post">
+        <input type="hidden" name="xsrf" value="{{$.Xsrf}}">
+        <input type="hidden" name="id" value="{{.Id}}">
+        <input type="submit" name="leave" value="Leave" data-confirm="Are you sure you want to leave this collection?">
+      </form>
+    </td>
+  </tr>
+{{end}}
+</table>
+{{if .CanEdit}}
+<h3>New collection</h3>
+<form method="post">
+  <input type="hidden" name="xsrf" value="{{.Xsrf}}">
+  <input type="text" name="name">
+  <input type="submit" name="create" value="Create">
+</form>
+{{end}}
+</body>
+</html>`
+)
+
+var (
+	kTemplate *template.Template
+)
+
+var (
+	kErrNameRequired  = errors.New("Name required.")
+	kErrReadOnly      = errors.New("You are not allowed to change collections.")
+	kErrNoSuchMember  = errors.New("No user has that name.")
+	kErrAlreadyMember = errors.New("That user is already a member.")
+	kErrNoKeyPair     = errors.New("Collections need a key that users get when they log in. Log in again or ask the new member to log in first.")
+)
+
+// NewXsrfToken creates the xsrf token pages must send along with requests
+// to switch vaults.
+func NewXsrfToken(r *http.Request) string {
+	return common.NewXsrfToken(r, kVaults)
+}
+
+type Store interface {
+	vsafedb.UserByIdRunner
+	vsafedb.UserByNameRunner
+	vsafedb.UpdateUserRunner
+	vsafedb.AddCollectionRunner
+	vsafedb.CollectionByIdRunner
+	vsafedb.AddMembershipRunner
+	vsafedb.MembershipsByUserRunner
+	vsafedb.MembershipsByCollectionRunner
+	vsafedb.RemoveMembershipRunner
+}
+
+// Handler shows the vaults of the current user. A POST with a "switch"
+// parameter makes the vault with the given "id" current and goes back to
+// the home page; an "id" of 0 means the user's own vault.
+type Handler struct {
+	Doer  db.Doer
+	Store Store
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	session := common.GetUserSession(r)
+	message := ""
+	var err error
+	if r.Method == "POST" {
+		id, _ := strconv.ParseInt(r.Form.Get("id"), 10, 64)
+		name := r.Form.Get("name")
+		if !common.VerifyXsrfToken(r, kVaults) {
+			err = common.ErrXsrf
+		} else if http_util.HasParam(r.Form, "switch") {
+			if err = h.switchVault(session, id); err == nil {
+				session.Save(r, w)
+				http_util.Redirect(w, r, "/vsafe/home")
+				return
+			}
+		} else if http_util.HasParam(r.Form, "create") {
+			if strings.TrimSpace(name) == "" {
+				err = kErrNameRequired
+			} else {
+				err = h.Doer.Do(func(t db.Transaction) error {
+					_, _, err := vsafedb.CreateCollection(
+						h.Store, t, name, session.User)
+					return err
+				})
+				message = fmt.Sprintf("Collection %s created.", name)
+			}
+		} else if http_util.HasParam(r.Form, "addmember") {
+			err = h.Doer.Do(func(t db.Transaction) error {
+				return vsafedb.AddMember(
+					h.Store, t, id, session.User, session.MemberKey(), name)
+			})
+			message = fmt.Sprintf("%s added.", name)
+		} else if http_util.HasParam(r.Form, "leave") {
+			err = h.Doer.Do(func(t db.Transaction) error {
+				return vsafedb.LeaveCollection(h.Store, t, id, session.User)
+			})
+			if err == nil && isCurrent(session, id) {
+				session.SetVaultKey(nil)
+				session.Save(r, w)
+			}
+			message = "You left the collection."
+		}
+		if err != nil {
+			message = ""
+		}
+	}
+	collections, readErr := vsafedb.Collections(h.Store, nil, session.User)
+	if readErr != nil {
+		http_util.ReportError(w, "Error reading database.", readErr)
+		return
+	}
+	collectionViews := make([]collectionView, len(collections))
+	for i := range collections {
+		collectionViews[i].Collection = collections[i]
+		collectionViews[i].Members, readErr = h.memberNames(collections[i].Id)
+		if readErr != nil {
+			http_util.ReportError(w, "Error reading database.", readErr)
+			return
+		}
+	}
+	http_util.WriteTemplate(
+		w,
+		kTemplate,
+		&view{
+			Collections:  collectionViews,
+			CurrentKeyId: session.VaultKey().Id,
+			OwnKeyId:     session.Key().Id,
+			CanEdit:      session.User.CanEditEntries(),
+			Error:        toDisplayError(err),
+			Message:      message,
+			Xsrf:         NewXsrfToken(r)})
+}
+
+// switchVault makes the collection with given id the current vault of the
+// session. An id of 0 means the user's own vault.
+func (h *Handler) switchVault(session *common.UserSession, id int64) error {
+	if id == 0 {
+		session.SetVaultKey(nil)
+		return nil
+	}
+	key, err := vsafedb.CollectionKey(
+		h.Store, nil, id, session.User, session.MemberKey())
+	if err != nil {
+		return err
+	}
+	session.SetVaultKey(key)
+	return nil
+}
+
+func (h *Handler) memberNames(collectionId int64) ([]string, error) {
+	var memberships []vsafe.Membership
+	if err := h.Store.MembershipsByCollection(
+		nil, collectionId, consume2.AppendTo(&memberships)); err != nil {
+		return nil, err
+	}
+	result := make([]string, len(memberships))
+	for i := range memberships {
+		var user vsafe.User
+		if err := h.Store.UserById(nil, memberships[i].User, &user); err != nil {
+			result[i] = fmt.Sprintf("(%d)", memberships[i].User)
+		} else {
+			result[i] = user.Name
+		}
+	}
+	return result, nil
+}
+
+func toDisplayError(err error) error {
+	switch err {
+	case vsafedb.ErrPermissionDenied:
+		return kErrReadOnly
+	case vsafedb.ErrNoSuchMember:
+		return kErrNoSuchMember
+	case vsafedb.ErrAlreadyMember:
+		return kErrAlreadyMember
+	case vsafe.ErrNoKeyPair:
+		return kErrNoKeyPair
+	}
+	return err
+}
+
+type collectionView struct {
+	vsafe.Collection
+	// The names of the members
+	Members []string
+}
+
+type view struct {
+	Collections  []collectionView
+	CurrentKeyId int64
+	OwnKeyId     int64
+	CanEdit      bool
+	Error        error
+	Message      string
+	Xsrf         string
+}
+
+// IsCurrent returns true if the collection with given id is the current
+// vault. An id of 0 means the user's own vault.
+func (v *view) IsCurrent(id int64) bool {
+	if id == 0 {
+		return v.CurrentKeyId == v.OwnKeyId
+	}
+	return v.CurrentKeyId == (&vsafe.Collection{Id: id}).KeyId()
+}
+
+// isCurrent returns true if the collection with given id is the current
+// vault of session.
+func isCurrent(session *common.UserSession, id int64) bool {
+	return session.VaultKey().Id == (&vsafe.Collection{Id: id}).KeyId()
+}
+
+func init() {
+	kTemplate = common.NewTemplate("vaults", kTemplateSpec)
+}
@@ -1,38 +1,42 @@
 package main
 
 import (
-	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/keep94/context"
 	"github.com/keep94/ramstore"
 	"github.com/keep94/sessions"
 	"github.com/keep94/toolbox/build"
 	"github.com/keep94/toolbox/db"
-	"github.com/keep94/toolbox/db/sqlite3_db"
 	"github.com/keep94/toolbox/http_util"
 	"github.com/keep94/toolbox/logging"
 	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/vsafe/admin"
 	"github.com/keep94/vsafe/apps/vsafe/catedit"
 	"github.com/keep94/vsafe/apps/vsafe/chpasswd"
 	"github.com/keep94/vsafe/apps/vsafe/common"
 	"github.com/keep94/vsafe/apps/vsafe/home"
 	"github.com/keep94/vsafe/apps/vsafe/login"
 	"github.com/keep94/vsafe/apps/vsafe/logout"
+	"github.com/keep94/vsafe/apps/vsafe/profile"
+	"github.com/keep94/vsafe/apps/vsafe/secret"
+	"github.com/keep94/vsafe/apps/vsafe/shares"
 	"github.com/keep94/vsafe/apps/vsafe/single"
 	"github.com/keep94/vsafe/apps/vsafe/static"
-	"github.com/keep94/vsafe/vsafedb/for_sqlite"
+	"github.com/keep94/vsafe/apps/vsafe/vaults"
+	"github.com/keep94/vsafe/vsafedb/backend"
 	"github.com/keep94/weblogs"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	// Set to the same thing as kXsrfTimeout in common/common.go
-	kSessionTimeout = 3600
+	kContentSecurityPolicy = "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self'; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+	kHSTSMaxAge            = 365 * 24 * time.Hour
 )
 
 var (
@@ -41,6 +45,11 @@ var (
 	fPort   string
 	fDb     string
 	fIcon   string
+	fReauth time.Duration
+	fClear  time.Duration
+	fIdle   time.Duration
+	fMaxAge time.Duration
+	fPolicy vsafe.PasswordPolicy
 )
 
 var (
@@ -49,9 +58,10 @@ var (
 
 var (
 	kDoer         db.Doer
-	kStore        for_sqlite.Store
-	kSessionStore = ramstore.NewRAMStore(kSessionTimeout)
-	kPollingStore = asPollingStore(kSessionStore)
+	kStore        backend.Store
+	kTimeouts     common.SessionTimeouts
+	kSessionStore *ramstore.RAMStore
+	kPollingStore *ramstore.RAMStore
 )
 
 func main() {
@@ -62,6 +72,9 @@ func main() {
 		return
 	}
 	setupDb(fDb)
+	useTLS := fSSLCrt != "" && fSSLKey != ""
+	setupSessions(
+		common.SessionTimeouts{Idle: fIdle, Absolute: fMaxAge}, useTLS)
 	mux := http.NewServeMux()
 	http.HandleFunc("/", rootRedirect)
 	http.Handle("/static/", http.StripPrefix("/static", static.New()))
@@ -74,25 +87,68 @@ func main() {
 	}
 	http.Handle(
 		"/auth/login",
-		&login.Handler{SessionStore: kSessionStore, Store: kStore})
+		&login.Handler{
+			SessionStore: kSessionStore,
+			Doer:         kDoer,
+			Store:        kStore,
+			Timeouts:     kTimeouts,
+		})
 	http.Handle(
 		"/auth/poll", pollHandler{})
 	http.Handle(
 		"/vsafe/", &authHandler{mux})
 	version, _ := build.MainVersion()
-	mux.Handle("/vsafe/catedit", &catedit.Handler{Store: kStore, Doer: kDoer})
-	mux.Handle("/vsafe/chpasswd", &chpasswd.Handler{Store: kStore, Doer: kDoer})
+	mux.Handle(
+		"/vsafe/admin",
+		&admin.Handler{
+			Store:          kStore,
+			Doer:           kDoer,
+			ReauthWindow:   fReauth,
+			PasswordPolicy: fPolicy})
+	mux.Handle(
+		"/vsafe/catedit",
+		&catedit.Handler{Store: kStore, Doer: kDoer, ReauthWindow: fReauth})
+	mux.Handle(
+		"/vsafe/chpasswd",
+		&chpasswd.Handler{
+			Store:          kStore,
+			Doer:           kDoer,
+			PasswordPolicy: fPolicy})
 	mux.Handle(
 		"/vsafe/home",
-		&home.Handler{Store: kStore, BuildId: build.BuildId(version)},
+		&home.Handler{
+			Store:          kStore,
+			BuildId:        build.BuildId(version),
+			ReauthWindow:   fReauth,
+			ClipboardClear: fClear,
+		},
 	)
 	mux.Handle("/vsafe/logout", &logout.Handler{})
-	mux.Handle("/vsafe/single", &single.Handler{Store: kStore, Doer: kDoer})
+	mux.Handle(
+		"/vsafe/single",
+		&single.Handler{
+			Store:          kStore,
+			Doer:           kDoer,
+			ReauthWindow:   fReauth,
+			ClipboardClear: fClear,
+		})
+	mux.Handle(
+		"/vsafe/profile",
+		&profile.Handler{Store: kStore, Doer: kDoer, ReauthWindow: fReauth})
+	mux.Handle(
+		"/vsafe/shares",
+		&shares.Handler{Store: kStore, Doer: kDoer})
+	mux.Handle(
+		"/vsafe/vaults",
+		&vaults.Handler{Store: kStore, Doer: kDoer})
+	mux.Handle(
+		"/vsafe/secret",
+		&secret.Handler{Store: kStore, ReauthWindow: fReauth})
 	defaultHandler := context.ClearHandler(
 		weblogs.HandlerWithOptions(
-			http.DefaultServeMux,
+			&securityHandler{Handler: http.DefaultServeMux, TLS: useTLS},
 			&weblogs.Options{Logger: logging.ApacheCommonLoggerWithLatency()}))
-	if fSSLCrt != "" && fSSLKey != "" {
+	if useTLS {
 		if err := http.ListenAndServeTLS(
 			fPort, fSSLCrt, fSSLKey, defaultHandler); err != nil {
 			fmt.Println(err)
@@ -109,7 +165,7 @@ type authHandler struct {
 }
 
 func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	user, _, err := authorizeSession(r, kSessionStore)
+	session, err := authorizeSession(r, kSessionStore)
 	if err == errNotLoggedIn {
 		redirectString := r.URL.String()
 		// Never have login page redirect to logout page
@@ -126,17 +182,44 @@ func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http_util.ReportError(w, "Error reading database.", err)
 		return
 	}
-	logging.SetUserName(r, user.Name)
+	session.SetLastActive(time.Now())
+	if err := session.Save(r, w); err != nil {
+		http_util.ReportError(w, "Error saving session.", err)
+		return
+	}
+	logging.SetUserName(r, session.User.Name)
 	h.ServeMux.ServeHTTP(w, r)
 }
 
+// securityHandler adds headers to every response that tell browsers to
+// restrict what pages can do.
+type securityHandler struct {
+	http.Handler
+	// True if the server uses TLS.
+	TLS bool
+}
+
+func (h *securityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Set("Content-Security-Policy", kContentSecurityPolicy)
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Referrer-Policy", "no-referrer")
+	if h.TLS {
+		header.Set(
+			"Strict-Transport-Security",
+			fmt.Sprintf("max-age=%d", int64(kHSTSMaxAge/time.Second)))
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
 type pollHandler struct {
 }
 
 func (h pollHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	keyId, _ := strconv.ParseInt(r.Form.Get("kid"), 10, 64)
-	user, key, err := authorizeSession(r, kPollingStore)
+	session, err := authorizeSession(r, kPollingStore)
 	if err == errNotLoggedIn {
 		http_util.Error(w, 401)
 		return
@@ -145,8 +228,8 @@ func (h pollHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http_util.ReportError(w, "Error reading database.", err)
 		return
 	}
-	logging.SetUserName(r, user.Name)
-	if keyId != key.Id {
+	logging.SetUserName(r, session.User.Name)
+	if keyId != session.VaultKey().Id {
 		http_util.Error(w, 401)
 		return
 	}
@@ -155,16 +238,19 @@ func (h pollHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func authorizeSession(
 	r *http.Request,
-	sessionStore sessions.Store) (*vsafe.User, *vsafe.Key, error) {
+	sessionStore sessions.Store) (*common.UserSession, error) {
 	session, err := common.NewUserSession(kStore, sessionStore, r)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	key := session.Key()
 	if session.User == nil || key == nil || key.Id != session.User.GetOwner() {
-		return nil, nil, errNotLoggedIn
+		return nil, errNotLoggedIn
+	}
+	if session.Expired(time.Now()) {
+		return nil, errNotLoggedIn
 	}
-	return session.User, key, nil
+	return session, nil
 }
 
 func rootRedirect(w http.ResponseWriter, r *http.Request) {
@@ -179,18 +265,62 @@ func init() {
 	flag.StringVar(&fSSLCrt, "ssl_crt", "", "SSL Certificate file")
 	flag.StringVar(&fSSLKey, "ssl_key", "", "SSL Key file")
 	flag.StringVar(&fPort, "http", ":8080", "Port to bind")
-	flag.StringVar(&fDb, "db", "", "Path to database file")
+	flag.StringVar(&fDb, "db", "", backend.Usage)
 	flag.StringVar(&fIcon, "icon", "", "Path to icon file")
+	flag.DurationVar(
+		&fReauth,
+		"reauth",
+		5*time.Minute,
+		"How long a password entry allows seeing secrets and deleting")
+	flag.DurationVar(
+		&fIdle,
+		"idle_timeout",
+		common.DefaultSessionTimeouts.Idle,
+		"How long a session may go unused before it expires")
+	flag.DurationVar(
+		&fMaxAge,
+		"session_lifetime",
+		common.DefaultSessionTimeouts.Absolute,
+		"How long a session may last after login; 0 means no limit")
+	flag.DurationVar(
+		&fClear,
+		"clipboard_clear",
+		30*time.Second,
+		"How long copied secrets stay on the clipboard")
+	flag.IntVar(
+		&fPolicy.MinLength,
+		"min_password_length",
+		vsafe.DefaultPasswordPolicy.MinLength,
+		"Minimum length of new passwords")
+	flag.IntVar(
+		&fPolicy.MinStrength,
+		"min_password_strength",
+		vsafe.DefaultPasswordPolicy.MinStrength,
+		"Minimum strength of new passwords from 0 to 4")
 }
 
-func setupDb(filepath string) {
-	rawdb, err := sql.Open("sqlite3", filepath)
+func setupDb(location string) {
+	dbase, err := backend.Open(location)
 	if err != nil {
 		panic(err)
 	}
-	dbase := sqlite3_db.New(rawdb)
-	kDoer = sqlite3_db.NewDoer(dbase)
-	kStore = for_sqlite.New(dbase)
+	if err := dbase.Migrate(); err != nil {
+		fmt.Printf("Unable to migrate database - %v\n", err)
+		os.Exit(1)
+	}
+	kDoer = dbase.Doer
+	kStore = dbase.Store
+}
+
+func setupSessions(timeouts common.SessionTimeouts, useTLS bool) {
+	kTimeouts = timeouts
+	kSessionStore = ramstore.NewRAMStore(int(timeouts.Idle / time.Second))
+	kSessionStore.Options.HttpOnly = true
+	if useTLS {
+		kSessionStore.Options.Secure = true
+		kSessionStore.Options.SameSite = http.SameSiteStrictMode
+	}
+	kPollingStore = asPollingStore(kSessionStore)
 }
 
 func asPollingStore(store *ramstore.RAMStore) *ramstore.RAMStore {
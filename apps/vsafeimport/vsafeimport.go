@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -9,17 +8,16 @@ import (
 	"os"
 
 	"github.com/keep94/toolbox/db"
-	"github.com/keep94/toolbox/db/sqlite3_db"
 	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/prompt"
 	"github.com/keep94/vsafe/vsafedb"
-	"github.com/keep94/vsafe/vsafedb/for_sqlite"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/keep94/vsafe/vsafedb/backend"
 )
 
 var (
 	fDb       string
 	fName     string
-	fPassword string
+	fPassword *prompt.Flags
 )
 
 type jsonEntry struct {
@@ -33,27 +31,35 @@ type jsonEntry struct {
 
 func main() {
 	flag.Parse()
-	if fDb == "" || fName == "" || fPassword == "" {
-		fmt.Println("Need to specify -db, -name, and -password flag.")
+	if fDb == "" || fName == "" {
+		fmt.Println("Need to specify -db and -name flag.")
 		flag.Usage()
 		return
 	}
-	rawdb, err := sql.Open("sqlite3", fDb)
+	password, err := fPassword.Read("User password: ")
 	if err != nil {
-		fmt.Printf("Unable to open database - %s\n", fDb)
+		fmt.Printf("Error reading user password - %v\n", err)
+		return
+	}
+	dbase, err := backend.Open(fDb)
+	if err != nil {
+		fmt.Printf("Unable to open database %s - %v\n", fDb, err)
 		return
 	}
-	dbase := sqlite3_db.New(rawdb)
 	defer dbase.Close()
-	store := for_sqlite.New(dbase)
-	doer := sqlite3_db.NewDoer(dbase)
+	if err = dbase.CheckVersion(); err != nil {
+		fmt.Printf("Database not ready - %v\n", err)
+		return
+	}
+	store := dbase.Store
+	doer := dbase.Doer
 	var user vsafe.User
 	if err = store.UserByName(nil, fName, &user); err != nil {
 		fmt.Printf("Error retrieving user - %v\n", err)
 		return
 	}
 	var key *vsafe.Key
-	if key, err = user.VerifyPassword(fPassword); err != nil {
+	if key, err = user.VerifyPassword(password); err != nil {
 		fmt.Printf("Error verifying user password - %v\n", err)
 		return
 	}
@@ -64,7 +70,7 @@ func main() {
 		return
 	}
 	err = doer.Do(func(t db.Transaction) error {
-		return doImport(t, store, entryList, key)
+		return doImport(t, store, entryList, &user, key)
 	})
 	if err != nil {
 		fmt.Printf("Import failed - %v\n", err)
@@ -75,9 +81,11 @@ func doImport(
 	t db.Transaction,
 	store vsafedb.AddEntryRunner,
 	entryList []*jsonEntry,
+	user *vsafe.User,
 	key *vsafe.Key) error {
 	for i := range entryList {
-		if err := doSingleImport(t, store, entryList[i], key); err != nil {
+		if err := doSingleImport(
+			t, store, entryList[i], user, key); err != nil {
 			return err
 		}
 	}
@@ -88,6 +96,7 @@ func doSingleImport(
 	t db.Transaction,
 	store vsafedb.AddEntryRunner,
 	jentry *jsonEntry,
+	user *vsafe.User,
 	key *vsafe.Key) error {
 	var entry vsafe.Entry
 	var err error
@@ -100,12 +109,12 @@ func doSingleImport(
 	entry.UName = jentry.UName
 	entry.Password = jentry.Password
 	entry.Special = jentry.Special
-	_, err = vsafedb.AddEntry(store, t, key, &entry)
+	_, err = vsafedb.AddEntry(store, t, user, key, &entry)
 	return err
 }
 
 func init() {
-	flag.StringVar(&fDb, "db", "", "Path to vsafe file")
+	flag.StringVar(&fDb, "db", "", backend.Usage)
 	flag.StringVar(&fName, "name", "", "User name")
-	flag.StringVar(&fPassword, "password", "password", "User password")
+	fPassword = prompt.AddFlags(flag.CommandLine, "password", "user password")
 }
@@ -1,24 +1,23 @@
 package main
 
 import (
-	"database/sql"
 	"flag"
 	"fmt"
 	"log"
 	"syscall"
 
 	"github.com/keep94/consume2"
-	"github.com/keep94/toolbox/db/sqlite3_db"
 	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/prompt"
 	"github.com/keep94/vsafe/vsafedb"
-	"github.com/keep94/vsafe/vsafedb/for_sqlite"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/keep94/vsafe/vsafedb/backend"
 	"golang.org/x/term"
 )
 
 var (
-	fDb   string
-	fUser string
+	fDb       string
+	fUser     string
+	fPassword *prompt.Flags
 )
 
 type store interface {
@@ -35,8 +34,11 @@ func main() {
 	}
 	dbase := openDb(fDb)
 	defer dbase.Close()
-	store := for_sqlite.New(dbase)
-	userPassword := getPassword("Enter user password: ")
+	store := dbase.Store
+	userPassword, err := fPassword.Read("Enter user password: ")
+	if err != nil {
+		log.Fatal(err)
+	}
 	searchPassword := getPassword("Enter password to search for: ")
 	fmt.Println()
 	showResults(store, userPassword, searchPassword)
@@ -90,15 +92,19 @@ func getKey(store vsafedb.UserByNameRunner, password string) *vsafe.Key {
 	return key
 }
 
-func openDb(filepath string) *sqlite3_db.Db {
-	rawdb, err := sql.Open("sqlite3", filepath)
+func openDb(location string) *backend.Db {
+	dbase, err := backend.Open(location)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return sqlite3_db.New(rawdb)
+	if err := dbase.CheckVersion(); err != nil {
+		log.Fatal(err)
+	}
+	return dbase
 }
 
 func init() {
-	flag.StringVar(&fDb, "db", "", "Path to database file")
+	flag.StringVar(&fDb, "db", "", backend.Usage)
 	flag.StringVar(&fUser, "user", "", "Name of user")
+	fPassword = prompt.AddFlags(flag.CommandLine, "password", "user password")
 }
@@ -1,23 +1,27 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 
 	"github.com/keep94/consume2"
-	"github.com/keep94/toolbox/db/sqlite3_db"
+	"github.com/keep94/toolbox/db"
 	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/apps/prompt"
 	"github.com/keep94/vsafe/vsafedb"
-	"github.com/keep94/vsafe/vsafedb/for_sqlite"
-	"github.com/keep94/vsafe/vsafedb/sqlite_setup"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/keep94/vsafe/vsafedb/backend"
 )
 
 const (
 	kDbFlag   = "db"
 	kNameFlag = "name"
+	kRoleFlag = "role"
+)
+
+var (
+	errMasterRole = errors.New("master users are always admins")
 )
 
 func main() {
@@ -26,6 +30,11 @@ func main() {
 		fmt.Println("  list   list the users")
 		fmt.Println("  add    add a user")
 		fmt.Println("  remove remove user")
+		fmt.Println("  timeouts set session timeouts of a user")
+		fmt.Println("  role   set role of a user")
+		fmt.Println("  transfer make a sub-user the master of its vault")
+		fmt.Println("  rename rename a user")
+		fmt.Println("  migrate upgrade the database schema")
 		return
 	}
 	switch os.Args[1] {
@@ -41,6 +50,26 @@ func main() {
 		if !doRemove(os.Args[2:]) {
 			os.Exit(1)
 		}
+	case "timeouts":
+		if !doTimeouts(os.Args[2:]) {
+			os.Exit(1)
+		}
+	case "role":
+		if !doRole(os.Args[2:]) {
+			os.Exit(1)
+		}
+	case "rename":
+		if !doRename(os.Args[2:]) {
+			os.Exit(1)
+		}
+	case "transfer":
+		if !doTransfer(os.Args[2:]) {
+			os.Exit(1)
+		}
+	case "migrate":
+		if !doMigrate(os.Args[2:]) {
+			os.Exit(1)
+		}
 	default:
 		fmt.Printf("%q is not a valid command.\n", os.Args[1])
 		os.Exit(2)
@@ -60,13 +89,29 @@ func checkDbAndName(f *flag.FlagSet, db, name string) {
 }
 
 func addDbFlag(f *flag.FlagSet) *string {
-	return f.String(kDbFlag, "", "Path to vsafe file")
+	return f.String(kDbFlag, "", backend.Usage)
 }
 
 func addNameFlag(f *flag.FlagSet) *string {
 	return f.String(kNameFlag, "", "User name")
 }
 
+func addRoleFlag(f *flag.FlagSet) *string {
+	return f.String(
+		kRoleFlag,
+		vsafe.RoleAdmin.String(),
+		"User role: admin, editor, or read-only")
+}
+
+func parseRole(f *flag.FlagSet, name string) vsafe.Role {
+	role, err := vsafe.ParseRole(name)
+	if err != nil {
+		fmt.Fprintf(f.Output(), "%q is not a valid role.\n", name)
+		os.Exit(2)
+	}
+	return role
+}
+
 func doList(args []string) bool {
 	flags := flag.NewFlagSet("list", flag.ExitOnError)
 	dbPath := addDbFlag(flags)
@@ -85,11 +130,33 @@ func doAdd(args []string) bool {
 	flags := flag.NewFlagSet("add", flag.ExitOnError)
 	dbPath := addDbFlag(flags)
 	name := addNameFlag(flags)
-	password := flags.String("password", "password", "User password")
+	passwordFlags := prompt.AddFlags(flags, "password", "user password")
 	masterName := flags.String("master", "", "Master user name")
-	masterPassword := flags.String("mp", "password", "Master password")
+	masterFlags := prompt.AddFlags(flags, "mp", "master password")
+	roleName := addRoleFlag(flags)
+	var policy vsafe.PasswordPolicy
+	flags.IntVar(
+		&policy.MinLength,
+		"min_password_length",
+		vsafe.DefaultPasswordPolicy.MinLength,
+		"Minimum length of new passwords")
+	flags.IntVar(
+		&policy.MinStrength,
+		"min_password_strength",
+		vsafe.DefaultPasswordPolicy.MinStrength,
+		"Minimum strength of new passwords from 0 to 4")
 	flags.Parse(args)
 	checkDbAndName(flags, *dbPath, *name)
+	role := parseRole(flags, *roleName)
+	password, err := passwordFlags.ReadNew("New user password: ")
+	if err != nil {
+		fmt.Printf("Error reading user password - %v\n", err)
+		return false
+	}
+	if err := policy.Check(&vsafe.User{Name: *name}, password); err != nil {
+		fmt.Printf("Password rejected - %v\n", err)
+		return false
+	}
 	dbase := openDb(*dbPath)
 	defer dbase.Close()
 	store, ok := initDb(dbase)
@@ -97,21 +164,27 @@ func doAdd(args []string) bool {
 		return false
 	}
 	var user vsafe.User
-	var err error
 	if *masterName == "" {
-		err = user.Init(*name, *password)
+		err = user.Init(*name, password)
 	} else {
 		var master vsafe.User
 		if err = store.UserByName(nil, *masterName, &master); err != nil {
 			fmt.Printf("Error retrieving master user - %v\n", err)
 			return false
 		}
+		var masterPassword string
+		if masterPassword, err = masterFlags.Read(
+			"Master password: "); err != nil {
+			fmt.Printf("Error reading master password - %v\n", err)
+			return false
+		}
 		var key *vsafe.Key
-		if key, err = master.VerifyPassword(*masterPassword); err != nil {
+		if key, err = master.VerifyPassword(masterPassword); err != nil {
 			fmt.Printf("Error verifying master password - %v\n", err)
 			return false
 		}
-		err = user.InitWithKey(*name, *password, key)
+		err = user.InitWithKey(*name, password, key)
+		user.Role = role
 	}
 	if err != nil {
 		fmt.Printf("Error initializing user -%v\n", err)
@@ -128,6 +201,10 @@ func doRemove(args []string) bool {
 	flags := flag.NewFlagSet("remove", flag.ExitOnError)
 	dbPath := addDbFlag(flags)
 	name := addNameFlag(flags)
+	cascade := flags.Bool(
+		"cascade", false, "Also remove entries and categories of a master user")
+	dryRun := flags.Bool(
+		"dry_run", false, "Only report what would be removed")
 	flags.Parse(args)
 	checkDbAndName(flags, *dbPath, *name)
 	dbase := openDb(*dbPath)
@@ -136,29 +213,237 @@ func doRemove(args []string) bool {
 	if !ok {
 		return false
 	}
-	if err := store.RemoveUser(nil, *name); err != nil {
-		fmt.Printf("Error removing user - %v\n", err)
+	if *dryRun {
+		removal, err := vsafedb.PlanUserRemoval(store, nil, *name)
+		if err != nil {
+			fmt.Printf("Error reading user - %v\n", err)
+			return false
+		}
+		printRemoval("Would remove", removal)
+		if err := removal.Check(*cascade); err != nil {
+			fmt.Printf("Removal would fail - %v\n", removalErrorStr(err))
+			return false
+		}
+		return true
+	}
+	var removal *vsafedb.UserRemoval
+	err := dbase.Doer.Do(func(t db.Transaction) error {
+		var err error
+		removal, err = vsafedb.RemoveUser(store, t, *name, *cascade)
+		return err
+	})
+	if err != nil {
+		fmt.Printf("Error removing user - %v\n", removalErrorStr(err))
 		return false
 	}
+	printRemoval("Removed", removal)
 	return true
 }
 
-func openDb(dbPath string) *sqlite3_db.Db {
-	rawdb, err := sql.Open("sqlite3", dbPath)
+func doTimeouts(args []string) bool {
+	flags := flag.NewFlagSet("timeouts", flag.ExitOnError)
+	dbPath := addDbFlag(flags)
+	name := addNameFlag(flags)
+	idle := flags.Duration(
+		"idle", 0, "How long sessions may go unused; 0 means server setting")
+	lifetime := flags.Duration(
+		"lifetime", 0, "How long sessions may last; 0 means server setting")
+	flags.Parse(args)
+	checkDbAndName(flags, *dbPath, *name)
+	dbase := openDb(*dbPath)
+	defer dbase.Close()
+	store, ok := initDb(dbase)
+	if !ok {
+		return false
+	}
+	err := dbase.Doer.Do(func(t db.Transaction) error {
+		var user vsafe.User
+		if err := store.UserByName(t, *name, &user); err != nil {
+			return err
+		}
+		user.IdleTimeout = *idle
+		user.SessionLifetime = *lifetime
+		return store.UpdateUser(t, &user)
+	})
 	if err != nil {
-		fmt.Printf("Unable to open database - %s\n", dbPath)
-		os.Exit(1)
+		fmt.Printf("Error setting timeouts - %v\n", err)
+		return false
+	}
+	return true
+}
+
+func doRole(args []string) bool {
+	flags := flag.NewFlagSet("role", flag.ExitOnError)
+	dbPath := addDbFlag(flags)
+	name := addNameFlag(flags)
+	roleName := addRoleFlag(flags)
+	flags.Parse(args)
+	checkDbAndName(flags, *dbPath, *name)
+	role := parseRole(flags, *roleName)
+	dbase := openDb(*dbPath)
+	defer dbase.Close()
+	store, ok := initDb(dbase)
+	if !ok {
+		return false
+	}
+	err := dbase.Doer.Do(func(t db.Transaction) error {
+		var user vsafe.User
+		if err := store.UserByName(t, *name, &user); err != nil {
+			return err
+		}
+		if user.Owner == 0 {
+			return errMasterRole
+		}
+		user.Role = role
+		return store.UpdateUser(t, &user)
+	})
+	if err != nil {
+		fmt.Printf("Error setting role - %v\n", err)
+		return false
+	}
+	return true
+}
+
+func doRename(args []string) bool {
+	flags := flag.NewFlagSet("rename", flag.ExitOnError)
+	dbPath := addDbFlag(flags)
+	name := addNameFlag(flags)
+	newName := flags.String("new", "", "New user name")
+	flags.Parse(args)
+	checkDbAndName(flags, *dbPath, *name)
+	checkStrFlag(flags, "new", *newName)
+	dbase := openDb(*dbPath)
+	defer dbase.Close()
+	store, ok := initDb(dbase)
+	if !ok {
+		return false
+	}
+	err := dbase.Doer.Do(func(t db.Transaction) error {
+		var user vsafe.User
+		if err := store.UserByName(t, *name, &user); err != nil {
+			return err
+		}
+		_, err := vsafedb.RenameUser(store, t, user.Id, *newName)
+		return err
+	})
+	if err == vsafedb.ErrNameTaken {
+		fmt.Printf("Error renaming user - %q is already taken\n", *newName)
+		return false
 	}
-	return sqlite3_db.New(rawdb)
+	if err != nil {
+		fmt.Printf("Error renaming user - %v\n", err)
+		return false
+	}
+	return true
 }
 
-func initDb(dbase *sqlite3_db.Db) (store for_sqlite.Store, ok bool) {
-	err := dbase.Do(sqlite_setup.SetUpTables)
+func doTransfer(args []string) bool {
+	flags := flag.NewFlagSet("transfer", flag.ExitOnError)
+	dbPath := addDbFlag(flags)
+	name := addNameFlag(flags)
+	flags.Parse(args)
+	checkDbAndName(flags, *dbPath, *name)
+	dbase := openDb(*dbPath)
+	defer dbase.Close()
+	store, ok := initDb(dbase)
+	if !ok {
+		return false
+	}
+	var oldMaster *vsafe.User
+	err := dbase.Doer.Do(func(t db.Transaction) error {
+		var user vsafe.User
+		if err := store.UserByName(t, *name, &user); err != nil {
+			return err
+		}
+		var err error
+		oldMaster, err = vsafedb.TransferOwnership(store, t, user.Id)
+		return err
+	})
 	if err != nil {
-		fmt.Printf("Unable to create tables - %v\n", err)
+		fmt.Printf("Error transferring ownership - %v\n", err)
+		return false
+	}
+	fmt.Printf(
+		"%s is now the master user; %s is now an admin sub-user.\n",
+		*name,
+		oldMaster.Name)
+	return true
+}
+
+func doMigrate(args []string) bool {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbPath := addDbFlag(flags)
+	dryRun := flags.Bool(
+		"dry_run", false, "Only list the migrations that would run")
+	fts := flags.Bool(
+		"fts",
+		false,
+		"Add a full text index for searching sqlite entries. Needs -tags sqlite_fts5")
+	flags.Parse(args)
+	checkStrFlag(flags, kDbFlag, *dbPath)
+	dbase := openDb(*dbPath)
+	defer dbase.Close()
+	version, err := dbase.Version()
+	if err != nil {
+		fmt.Printf("Error reading schema version - %v\n", err)
+		return false
+	}
+	latest := dbase.LatestVersion()
+	if version > latest {
+		fmt.Printf(
+			"Schema version %d is newer than supported version %d.\n",
+			version, latest)
+		return false
+	}
+	if version == latest {
+		fmt.Printf("Schema is up to date at version %d.\n", version)
+	} else {
+		for _, migration := range dbase.Migrations(version) {
+			fmt.Printf("%3d %s\n", migration.Version, migration.Description)
+		}
+		if *dryRun {
+			fmt.Printf(
+				"Would migrate schema from version %d to %d.\n",
+				version, latest)
+		} else {
+			if err := dbase.Migrate(); err != nil {
+				fmt.Printf("Error migrating schema - %v\n", err)
+				return false
+			}
+			fmt.Printf(
+				"Migrated schema from version %d to %d.\n", version, latest)
+		}
+	}
+	if !*fts {
+		return true
+	}
+	if *dryRun {
+		fmt.Println("Would add full text index.")
+		return true
+	}
+	if err := dbase.AddSearchIndex(); err != nil {
+		fmt.Printf("Error adding full text index - %v\n", err)
+		return false
+	}
+	fmt.Println("Added full text index.")
+	return true
+}
+
+func openDb(dbPath string) *backend.Db {
+	dbase, err := backend.Open(dbPath)
+	if err != nil {
+		fmt.Printf("Unable to open database %s - %v\n", dbPath, err)
+		os.Exit(1)
+	}
+	return dbase
+}
+
+func initDb(dbase *backend.Db) (store backend.Store, ok bool) {
+	if err := dbase.Migrate(); err != nil {
+		fmt.Printf("Unable to set up database - %v\n", err)
 		return
 	}
-	return for_sqlite.New(dbase), true
+	return dbase.Store, true
 }
 
 func listUsers(store vsafedb.UsersRunner) bool {
@@ -172,11 +457,53 @@ func listUsers(store vsafedb.UsersRunner) bool {
 		usersById[user.Id] = user
 	}
 	for _, user := range users {
-		fmt.Printf("%-20s %-20s\n", user.Name, ownerStr(usersById, user.GetOwner()))
+		fmt.Printf(
+			"%-20s %-20s %-10s\n",
+			user.Name,
+			ownerStr(usersById, user.GetOwner()),
+			user.GetRole())
 	}
 	return true
 }
 
+func printRemoval(verb string, removal *vsafedb.UserRemoval) {
+	fmt.Printf("%s user %s\n", verb, removal.User.Name)
+	if len(removal.SubUsers) > 0 {
+		fmt.Printf("  %d sub-users:\n", len(removal.SubUsers))
+		for _, user := range removal.SubUsers {
+			fmt.Printf("    %s\n", user.Name)
+		}
+	}
+	if len(removal.Categories) > 0 {
+		fmt.Printf("  %d categories:\n", len(removal.Categories))
+		for _, category := range removal.Categories {
+			fmt.Printf("    %s\n", category.Name)
+		}
+	}
+	if len(removal.Entries) > 0 {
+		fmt.Printf("  %d entries:\n", len(removal.Entries))
+		for _, entry := range removal.Entries {
+			fmt.Printf("    %s\n", entry.Title)
+		}
+	}
+	if len(removal.Shares) > 0 {
+		fmt.Printf("  %d pending shares\n", len(removal.Shares))
+	}
+	if len(removal.Memberships) > 0 {
+		fmt.Printf("  %d collection memberships\n", len(removal.Memberships))
+	}
+}
+
+func removalErrorStr(err error) string {
+	switch err {
+	case vsafedb.ErrHasSubUsers:
+		return "user has sub-users; use transfer or remove them first"
+	case vsafedb.ErrHasEntries:
+		return "user has entries or categories; use -cascade to remove them"
+	}
+	return err.Error()
+}
+
 func ownerStr(userMap map[int64]*vsafe.User, ownerId int64) string {
 	if userMap[ownerId] == nil {
 		return fmt.Sprintf("(%d)", ownerId)
@@ -0,0 +1,195 @@
+package vsafe
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"github.com/keep94/toolbox/kdf"
+	"github.com/keep94/vsafe/aes"
+	"golang.org/x/crypto/curve25519"
+	"strings"
+)
+
+const (
+	kCollectionKeySize = 32
+	kMemberSaltSize    = 16
+)
+
+// Collection represents a vault that several users may share. Each
+// collection has its own random key which is wrapped separately for each
+// member. Entries and categories in a collection have an Owner of
+// KeyId().
+type Collection struct {
+	// The ID of the collection
+	Id int64
+	// The name of the collection
+	Name string
+}
+
+// KeyId returns the ID of this collection's key. Collection key IDs are
+// negative so that they never collide with the master user IDs that
+// identify personal vaults.
+func (c *Collection) KeyId() int64 {
+	return -c.Id
+}
+
+// NewKey returns a new random key for this collection.
+func (c *Collection) NewKey() *Key {
+	return &Key{Id: c.KeyId(), Value: kdf.Random(kCollectionKeySize)}
+}
+
+// HasMemberKeyPair returns true if this user has a key pair for
+// collection memberships.
+func (u *User) HasMemberKeyPair() bool {
+	return u.MemberPublicKey != "" && u.MemberPrivateKey != ""
+}
+
+// InitMemberKeyPair gives this user a new X25519 key pair for collection
+// memberships. password is this user's password which is used to encrypt
+// the private half. Memberships sealed for the old key pair can no
+// longer be opened.
+func (u *User) InitMemberKeyPair(password string) error {
+	private := kdf.Random(curve25519.ScalarSize)
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	u.MemberPublicKey = base64.StdEncoding.EncodeToString(public)
+	return u.setMemberPrivateKey(private, password)
+}
+
+// MemberKey returns the key that opens the collection memberships of this
+// user. Unlike the key from VerifyPassword, which every user sharing a
+// master has, only this user can get this key because it needs this
+// user's own password. If this user has no key pair for memberships,
+// MemberKey returns ErrNoKeyPair.
+func (u *User) MemberKey(password string) (*Key, error) {
+	private, err := u.memberPrivateKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{Id: u.Id, Value: private}, nil
+}
+
+// changeMemberPassword encrypts the private half of this user's key pair
+// for memberships with newPass instead of oldPass.
+func (u *User) changeMemberPassword(oldPass, newPass string) error {
+	if !u.HasMemberKeyPair() {
+		return nil
+	}
+	private, err := u.memberPrivateKey(oldPass)
+	if err != nil {
+		return err
+	}
+	return u.setMemberPrivateKey(private, newPass)
+}
+
+// setMemberPrivateKey stores private encrypted with a key derived from
+// password and a new random salt. The salt goes in front of the
+// encrypted private key.
+func (u *User) setMemberPrivateKey(private []byte, password string) error {
+	salt := kdf.Random(kMemberSaltSize)
+	encrypted, err := aes.EncryptB(
+		private, kdf.KDF([]byte(password), salt, kdf.DefaultReps))
+	if err != nil {
+		return err
+	}
+	u.MemberPrivateKey = base64.StdEncoding.EncodeToString(salt) + ":" + encrypted
+	return nil
+}
+
+func (u *User) memberPrivateKey(password string) ([]byte, error) {
+	if !u.HasMemberKeyPair() {
+		return nil, ErrNoKeyPair
+	}
+	parts := strings.SplitN(u.MemberPrivateKey, ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrWrongPassword
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	private, err := aes.DecryptB(
+		parts[1], kdf.KDF([]byte(password), salt, kdf.DefaultReps))
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	// The wrong password may still decrypt to something, so check that
+	// the private key matches the public one.
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	expected, err := base64.StdEncoding.DecodeString(u.MemberPublicKey)
+	if err != nil || !hmac.Equal(public, expected) {
+		return nil, ErrWrongPassword
+	}
+	return private, nil
+}
+
+// Membership grants a user access to a collection. It holds the key of
+// the collection sealed with the public key of the member's own key pair
+// for memberships so that other users sharing the member's master can't
+// open it.
+type Membership struct {
+	// The ID of the membership
+	Id int64
+	// The ID of the collection
+	Collection int64
+	// The ID of the member
+	User int64
+	// The sealed key of the collection
+	Key string
+}
+
+// Init initializes this membership so that member can use collectionKey.
+// If member has no key pair for memberships, Init returns ErrNoKeyPair.
+func (m *Membership) Init(collectionKey *Key, member *User) error {
+	if !member.HasMemberKeyPair() {
+		return ErrNoKeyPair
+	}
+	sealed, err := sealTo(collectionKey.Value, member.MemberPublicKey)
+	if err != nil {
+		return err
+	}
+	m.Collection = -collectionKey.Id
+	m.User = member.Id
+	m.Key = sealed
+	return nil
+}
+
+// Open returns the key of the collection. memberKey is the key from
+// member.MemberKey. If memberKey is nil or belongs to a different user,
+// Open returns ErrNoKeyPair or ErrKeyMismatch.
+func (m *Membership) Open(member *User, memberKey *Key) (*Key, error) {
+	if memberKey == nil || !member.HasMemberKeyPair() {
+		return nil, ErrNoKeyPair
+	}
+	if memberKey.Id != member.Id || m.User != member.Id {
+		return nil, ErrKeyMismatch
+	}
+	value, err := openWith(m.Key, memberKey.Value, member.MemberPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) != kCollectionKeySize {
+		return nil, ErrBadShare
+	}
+	return &Key{Id: -m.Collection, Value: value}, nil
+}
+
+// Rewrap seals the collection key in this membership for member's own
+// key pair. Rewrap is for memberships from before members had their own
+// key pairs when the collection key was sealed with the key pair of the
+// member's master. master is that master user, and key is the member's
+// key.
+func (m *Membership) Rewrap(master *User, key *Key, member *User) error {
+	value, err := open(m.Key, master, key)
+	if err != nil {
+		return err
+	}
+	if len(value) != kCollectionKeySize {
+		return ErrBadShare
+	}
+	return m.Init(&Key{Id: -m.Collection, Value: value}, member)
+}
@@ -0,0 +1,46 @@
+package vsafe
+
+import (
+	"testing"
+)
+
+func TestMembershipRewrap(t *testing.T) {
+	master := User{Id: 1}
+	if err := master.Init("master", "secret"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	key, err := master.VerifyPassword("secret")
+	if err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	member := User{Id: 2}
+	if err := member.InitWithKey("member", "memberpass", key); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	collection := Collection{Id: 4}
+	collectionKey := collection.NewKey()
+	// Memberships used to be sealed with the master's key pair
+	sealed, err := seal(collectionKey.Value, &master)
+	if err != nil {
+		t.Fatalf("Error sealing key: %v", err)
+	}
+	membership := Membership{Collection: 4, User: 2, Key: sealed}
+	if err := membership.Rewrap(&master, key, &member); err != nil {
+		t.Fatalf("Error rewrapping membership: %v", err)
+	}
+	memberKey, err := member.MemberKey("memberpass")
+	if err != nil {
+		t.Fatalf("Error getting member key: %v", err)
+	}
+	opened, err := membership.Open(&member, memberKey)
+	if err != nil {
+		t.Fatalf("Error opening membership: %v", err)
+	}
+	if !opened.Equal(collectionKey) {
+		t.Error("Expected to get collection key back")
+	}
+	// Rewrapping again fails because the master can no longer open it
+	if err := membership.Rewrap(&master, key, &member); err != ErrBadShare {
+		t.Errorf("Expected ErrBadShare, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package vsafe_test
+
+import (
+	"github.com/keep94/vsafe"
+	"testing"
+)
+
+func TestMembershipInitOpen(t *testing.T) {
+	master := vsafe.User{Id: 2}
+	if err := master.Init("master", "secret"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	key, err := master.VerifyPassword("secret")
+	if err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	member := vsafe.User{Id: 3}
+	if err := member.InitWithKey("member", "memberpass", key); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	collection := vsafe.Collection{Id: 4, Name: "team"}
+	collectionKey := collection.NewKey()
+	if collectionKey.Id != -4 {
+		t.Errorf("Expected key id -4, got %d", collectionKey.Id)
+	}
+	var membership vsafe.Membership
+	if err := membership.Init(collectionKey, &member); err != nil {
+		t.Fatalf("Error creating membership: %v", err)
+	}
+	if membership.Collection != 4 || membership.User != 3 {
+		t.Errorf("Wrong membership: %+v", membership)
+	}
+	if _, err := member.MemberKey("secret"); err != vsafe.ErrWrongPassword {
+		t.Errorf("Expected ErrWrongPassword, got %v", err)
+	}
+	memberKey, err := member.MemberKey("memberpass")
+	if err != nil {
+		t.Fatalf("Error getting member key: %v", err)
+	}
+	opened, err := membership.Open(&member, memberKey)
+	if err != nil {
+		t.Fatalf("Error opening membership: %v", err)
+	}
+	if !opened.Equal(collectionKey) {
+		t.Error("Expected to get collection key back")
+	}
+	if _, err := membership.Open(&member, nil); err != vsafe.ErrNoKeyPair {
+		t.Errorf("Expected ErrNoKeyPair, got %v", err)
+	}
+	// Changing the password keeps the member key
+	if err := member.ChangePassword("memberpass", "newpass"); err != nil {
+		t.Fatalf("Error changing password: %v", err)
+	}
+	if _, err := member.MemberKey("memberpass"); err != vsafe.ErrWrongPassword {
+		t.Errorf("Expected ErrWrongPassword, got %v", err)
+	}
+	newMemberKey, err := member.MemberKey("newpass")
+	if err != nil {
+		t.Fatalf("Error getting member key: %v", err)
+	}
+	if !newMemberKey.Equal(memberKey) {
+		t.Error("Expected member key to survive password change")
+	}
+}
+
+func TestMembershipSibling(t *testing.T) {
+	master := vsafe.User{Id: 1}
+	if err := master.Init("master", "secret"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	key, err := master.VerifyPassword("secret")
+	if err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	a := vsafe.User{Id: 2}
+	if err := a.InitWithKey("a", "apass", key); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	b := vsafe.User{Id: 3}
+	if err := b.InitWithKey("b", "bpass", key); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	collection := vsafe.Collection{Id: 4}
+	var membership vsafe.Membership
+	if err := membership.Init(collection.NewKey(), &a); err != nil {
+		t.Fatalf("Error creating membership: %v", err)
+	}
+	// b shares a's vault key but still can't open a's membership
+	bKey, err := b.VerifyPassword("bpass")
+	if err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	if !bKey.Equal(key) {
+		t.Fatal("Expected siblings to share the vault key")
+	}
+	bMemberKey, err := b.MemberKey("bpass")
+	if err != nil {
+		t.Fatalf("Error getting member key: %v", err)
+	}
+	if _, err := membership.Open(&a, bMemberKey); err != vsafe.ErrKeyMismatch {
+		t.Errorf("Expected ErrKeyMismatch, got %v", err)
+	}
+	siblingView := membership
+	siblingView.User = b.Id
+	if _, err := siblingView.Open(&b, bMemberKey); err != vsafe.ErrBadShare {
+		t.Errorf("Expected ErrBadShare, got %v", err)
+	}
+	if err := siblingView.Rewrap(&master, bKey, &b); err == nil {
+		t.Error("Expected sibling not to rewrap membership")
+	}
+}
+
+func TestMembershipInitNoKeyPair(t *testing.T) {
+	member := vsafe.User{Id: 2}
+	collection := vsafe.Collection{Id: 4}
+	var membership vsafe.Membership
+	if err := membership.Init(
+		collection.NewKey(), &member); err != vsafe.ErrNoKeyPair {
+		t.Errorf("Expected ErrNoKeyPair, got %v", err)
+	}
+	if _, err := member.MemberKey("password"); err != vsafe.ErrNoKeyPair {
+		t.Errorf("Expected ErrNoKeyPair, got %v", err)
+	}
+}
@@ -9,12 +9,14 @@ require (
 	github.com/keep94/sessions v0.1.0
 	github.com/keep94/toolbox v0.14.0
 	github.com/keep94/weblogs v1.0.1
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.16
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
 	golang.org/x/term v0.0.0-20210615171337-6886f2dfbf5b
 )
 
 require (
 	github.com/keep94/securecookie v0.1.1 // indirect
-	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a // indirect
-	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
+	golang.org/x/sys v0.4.0 // indirect
 )
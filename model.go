@@ -9,6 +9,7 @@ import (
 	"github.com/keep94/toolbox/kdf"
 	"github.com/keep94/vsafe/aes"
 	"net/url"
+	"time"
 )
 
 var (
@@ -16,6 +17,8 @@ var (
 	ErrWrongPassword = errors.New("vsafe: Wrong Password.")
 	// Invalid key provided to decrypt an entry.
 	ErrKeyMismatch = errors.New("vsafe: Key Mismatch.")
+	// Unrecognized role name.
+	ErrNoSuchRole = errors.New("vsafe: No such role.")
 )
 
 // Key instances are used to encrypt / decrypt user name, password, and
@@ -38,6 +41,46 @@ func (k *Key) Equal(other *Key) bool {
 	return k.Id == other.Id && hmac.Equal(k.Value, other.Value)
 }
 
+// Role determines what a user who shares a master user's key may do.
+// Master users may always do everything regardless of their role.
+type Role int
+
+const (
+	// Users with this role may do everything.
+	RoleAdmin Role = iota
+	// Users with this role may add, change, and remove entries and change
+	// their own password.
+	RoleEditor
+	// Users with this role may only view entries.
+	RoleReadOnly
+)
+
+// AllRoles lists all the roles from most to least powerful.
+var AllRoles = []Role{RoleAdmin, RoleEditor, RoleReadOnly}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, error) {
+	for _, role := range AllRoles {
+		if role.String() == name {
+			return role, nil
+		}
+	}
+	return 0, ErrNoSuchRole
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleAdmin:
+		return "admin"
+	case RoleEditor:
+		return "editor"
+	case RoleReadOnly:
+		return "read-only"
+	default:
+		return "unknown"
+	}
+}
+
 // User instances represent a user of the vsafe app.
 type User struct {
 	// User ID
@@ -54,16 +97,46 @@ type User struct {
 	// The checksum of the user's key. Used to verify that the password for
 	// a user is correct.
 	Checksum string
+	// If non-zero, how long this user's sessions may go unused before
+	// they expire.
+	IdleTimeout time.Duration
+	// If non-zero, how long this user's sessions may last after login.
+	SessionLifetime time.Duration
+	// What this user may do. Ignored for master users.
+	Role Role
+	// If non-empty, this user may only see entries belonging to at least
+	// one of these categories. Ignored for master users.
+	Categories idset.IdSet
+	// The public half of this user's key pair for sharing entries base64
+	// encoded. Only master users have key pairs.
+	PublicKey string
+	// The private half of this user's key pair for sharing entries encrypted
+	// with this user's key.
+	PrivateKey string
+	// Comma separated digests of this user's recent passwords, most recent
+	// first. See PasswordPolicy.
+	PasswordHistory string
+	// The public half of this user's own key pair for collection
+	// memberships base64 encoded. Every user has one.
+	MemberPublicKey string
+	// The private half of this user's key pair for collection memberships
+	// encrypted with this user's password.
+	MemberPrivateKey string
 }
 
 // Init initializes this user instance with a user name and password so that
-// this user is a master user and has its own random key.
+// this user is a master user and has its own random key and key pair.
 func (u *User) Init(name, password string) error {
-	return u.InitWithKey(name, password, &Key{Id: 0, Value: kdf.Random(32)})
+	key := &Key{Id: 0, Value: kdf.Random(32)}
+	if err := u.InitWithKey(name, password, key); err != nil {
+		return err
+	}
+	return u.InitKeyPair(key)
 }
 
 // InitWithKey initializes this user instance with a user name and password
-// so that the user uses key as its key.
+// so that the user uses key as its key. InitWithKey also gives the user a
+// new key pair for collection memberships.
 func (u *User) InitWithKey(name, password string, key *Key) (err error) {
 	u.Owner = key.Id
 	u.Name = name
@@ -77,7 +150,7 @@ func (u *User) InitWithKey(name, password string, key *Key) (err error) {
 	}
 	u.Checksum = base64.StdEncoding.EncodeToString(
 		kdf.NewHMAC(key.Value, kdf.DefaultReps))
-	return
+	return u.InitMemberKeyPair(password)
 }
 
 // VerifyPassword verifies that password is the password for this user.
@@ -91,17 +164,85 @@ func (u *User) VerifyPassword(password string) (*Key, error) {
 	return &Key{Id: u.GetOwner(), Value: key}, nil
 }
 
-// ChangePassword changes the password of this user.
+// ChangePassword changes the password of this user. ChangePassword adds
+// oldPass to the recent passwords of this user. ChangePassword does not
+// enforce any PasswordPolicy; callers do that with PasswordPolicy.Check.
 func (u *User) ChangePassword(oldPass, newPass string) error {
 	var key []byte
 	var err error
 	if key, err = u.verifyPassword(oldPass); err != nil {
 		return err
 	}
+	if err = u.changeMemberPassword(oldPass, newPass); err != nil {
+		return err
+	}
+	u.rememberPassword(oldPass)
 	u.Key, err = aes.EncryptB(key, kdf.KDF([]byte(newPass), kdf.DefaultSalt, kdf.DefaultReps))
 	return err
 }
 
+// GetRole returns the role of this user. Master users are always admins.
+func (u *User) GetRole() Role {
+	if u.Owner == 0 {
+		return RoleAdmin
+	}
+	return u.Role
+}
+
+// CanEditEntries returns true if this user may add, change, or remove
+// entries.
+func (u *User) CanEditEntries() bool {
+	role := u.GetRole()
+	return role == RoleAdmin || role == RoleEditor
+}
+
+// CanEditCategories returns true if this user may add, rename, or remove
+// categories.
+func (u *User) CanEditCategories() bool {
+	return u.GetRole() == RoleAdmin
+}
+
+// CanRename returns true if this user may change their own name. Read-only
+// users keep the name their admin gave them so that admins can tell who
+// is who.
+func (u *User) CanRename() bool {
+	return u.GetRole() != RoleReadOnly
+}
+
+// IsRestricted returns true if this user may see only the entries in
+// certain categories.
+func (u *User) IsRestricted() bool {
+	return u.Owner != 0 && u.Categories != ""
+}
+
+// CanSeeCategory returns true if this user may see the category with
+// given id.
+func (u *User) CanSeeCategory(id int64) bool {
+	return !u.IsRestricted() || u.Categories.Contains(id)
+}
+
+// CanSee returns true if this user may see an entry belonging to
+// categories.
+func (u *User) CanSee(categories idset.IdSet) bool {
+	if !u.IsRestricted() {
+		return true
+	}
+	allowed, err := u.Categories.Map()
+	if err != nil {
+		return false
+	}
+	ids, err := categories.Map()
+	if err != nil {
+		return false
+	}
+	for id := range ids {
+		if allowed[id] {
+			return true
+		}
+	}
+	return false
+}
+
 // GetOwner returns the ID of the master user of this user. In the case
 // that this user is a master user, GetOwner only works correctly after
 // this user has been saved in persistent storage and has an ID.
@@ -134,7 +275,8 @@ func (u *User) verifyPassword(password string) ([]byte, error) {
 type Category struct {
 	// Category id
 	Id int64
-	// The owner which corresponds to the master user ID.
+	// The owner which corresponds to the master user ID or, for categories
+	// in a collection, the key ID of the collection.
 	Owner int64
 	// Category name
 	Name string
@@ -145,7 +287,8 @@ type Category struct {
 type Entry struct {
 	// The ID of the entry
 	Id int64
-	// The owner of the entry which corresponds to the master user ID.
+	// The owner of the entry which corresponds to the master user ID or, for
+	// entries in a collection, the key ID of the collection.
 	Owner int64
 	// The URL of the website. May be nil.
 	Url *url.URL
@@ -65,6 +65,30 @@ func TestUser(t *testing.T) {
 	}
 }
 
+func TestCanSee(t *testing.T) {
+	master := vsafe.User{Id: 1, Categories: "3"}
+	if master.IsRestricted() || !master.CanSee("4") {
+		t.Error("Master users should see everything")
+	}
+	sub := vsafe.User{Id: 2, Owner: 1}
+	if sub.IsRestricted() || !sub.CanSee("") {
+		t.Error("Sub-users without categories should see everything")
+	}
+	sub.Categories = "3,5"
+	if !sub.IsRestricted() {
+		t.Error("Expected sub-user to be restricted")
+	}
+	if !sub.CanSee("1,5") {
+		t.Error("Expected sub-user to see entry in category 5")
+	}
+	if sub.CanSee("1,4") || sub.CanSee("") {
+		t.Error("Expected sub-user not to see entries outside 3 and 5")
+	}
+	if !sub.CanSeeCategory(3) || sub.CanSeeCategory(4) {
+		t.Error("CanSeeCategory wrong")
+	}
+}
+
 func TestChangePassword(t *testing.T) {
 	user := vsafe.User{Id: 1}
 	var err error
@@ -143,3 +167,24 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Errorf("Expected ErrKeyMismatch, got %v", err)
 	}
 }
+
+func TestCanRename(t *testing.T) {
+	testCases := []struct {
+		user     vsafe.User
+		expected bool
+	}{
+		{vsafe.User{}, true},
+		{vsafe.User{Owner: 1, Role: vsafe.RoleAdmin}, true},
+		{vsafe.User{Owner: 1, Role: vsafe.RoleEditor}, true},
+		{vsafe.User{Owner: 1, Role: vsafe.RoleReadOnly}, false},
+		// Master users are always admins
+		{vsafe.User{Role: vsafe.RoleReadOnly}, true},
+	}
+	for _, tc := range testCases {
+		if out := tc.user.CanRename(); out != tc.expected {
+			t.Errorf(
+				"For owner %d role %v, expected %v, got %v",
+				tc.user.Owner, tc.user.Role, tc.expected, out)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package vsafe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/keep94/toolbox/kdf"
+	"strings"
+	"unicode"
+)
+
+const (
+	// How many previous passwords a user may not reuse.
+	kPasswordHistoryLength = 5
+	// Size of the random salt of each digest in the password history.
+	kPasswordSaltSize = 16
+)
+
+var (
+	// The password is shorter than the policy allows.
+	ErrPasswordTooShort = errors.New("vsafe: Password too short.")
+	// The password is weaker than the policy allows.
+	ErrPasswordTooWeak = errors.New("vsafe: Password too weak.")
+	// The password is the same as the user name.
+	ErrPasswordIsName = errors.New("vsafe: Password is user name.")
+	// The password is the current or a previous password of the user.
+	ErrPasswordReused = errors.New("vsafe: Password reused.")
+)
+
+// PasswordError explains why a password does not meet a PasswordPolicy.
+// Its Error method returns a message fit to show to users. Use errors.Is
+// to compare it with ErrPasswordTooShort, ErrPasswordTooWeak,
+// ErrPasswordIsName, or ErrPasswordReused.
+type PasswordError struct {
+	// One of ErrPasswordTooShort, ErrPasswordTooWeak, ErrPasswordIsName, or
+	// ErrPasswordReused
+	Reason error
+	// The message to show to users
+	Message string
+}
+
+func (e *PasswordError) Error() string {
+	return e.Message
+}
+
+func (e *PasswordError) Unwrap() error {
+	return e.Reason
+}
+
+// PasswordPolicy determines which passwords users may choose.
+type PasswordPolicy struct {
+	// The minimum number of characters in a password.
+	MinLength int
+	// The minimum strength of a password as computed by PasswordStrength.
+	MinStrength int
+}
+
+// DefaultPasswordPolicy is the password policy used when none is
+// configured.
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8, MinStrength: 2}
+
+// Check returns nil if user may choose password as their new password.
+// Otherwise Check returns a *PasswordError explaining why not. Besides
+// enforcing the minimum length and strength, Check refuses the user's
+// name and the user's current and recent passwords. user may be a new user
+// with only a name.
+func (p *PasswordPolicy) Check(user *User, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return &PasswordError{
+			Reason: ErrPasswordTooShort,
+			Message: fmt.Sprintf(
+				"Password must be at least %d characters.", p.MinLength),
+		}
+	}
+	if PasswordStrength(password) < p.MinStrength {
+		return &PasswordError{
+			Reason:  ErrPasswordTooWeak,
+			Message: "Password too weak. Mix upper and lower case letters, digits, and symbols or use a longer password.",
+		}
+	}
+	if strings.EqualFold(password, user.Name) {
+		return &PasswordError{
+			Reason:  ErrPasswordIsName,
+			Message: "Password must differ from user name.",
+		}
+	}
+	if user.UsedPassword(password) {
+		return &PasswordError{
+			Reason:  ErrPasswordReused,
+			Message: "Password was used before. Choose a new one.",
+		}
+	}
+	return nil
+}
+
+// PasswordStrength scores password from 0 to 4. A password scores one
+// point for each of lower case letters, upper case letters, digits, and
+// other characters that it contains plus one point for being at least 16
+// characters long.
+func PasswordStrength(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	result := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			result++
+		}
+	}
+	if len([]rune(password)) >= 16 {
+		result++
+	}
+	if result > 4 {
+		result = 4
+	}
+	return result
+}
+
+// UsedPassword returns true if password is the current password of this
+// user or one of the passwords this user had recently.
+func (u *User) UsedPassword(password string) bool {
+	if u.Key == "" {
+		return false
+	}
+	if _, err := u.verifyPassword(password); err == nil {
+		return true
+	}
+	for _, used := range strings.Split(u.PasswordHistory, ",") {
+		if checkPasswordDigest(used, password) {
+			return true
+		}
+	}
+	return false
+}
+
+// rememberPassword adds password to the recent passwords of this user.
+func (u *User) rememberPassword(password string) {
+	history := []string{passwordDigest(password)}
+	if u.PasswordHistory != "" {
+		history = append(history, strings.Split(u.PasswordHistory, ",")...)
+	}
+	if len(history) > kPasswordHistoryLength {
+		history = history[:kPasswordHistoryLength]
+	}
+	u.PasswordHistory = strings.Join(history, ",")
+}
+
+// passwordDigest returns a digest of password for the password history.
+// The digest has a random salt in front so that users with the same
+// password get different digests.
+func passwordDigest(password string) string {
+	salt := kdf.Random(kPasswordSaltSize)
+	return base64.StdEncoding.EncodeToString(salt) + ":" + saltedDigest(
+		password, salt)
+}
+
+// checkPasswordDigest returns true if digest, which came from
+// passwordDigest, is a digest of password.
+func checkPasswordDigest(digest, password string) bool {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(
+		[]byte(saltedDigest(password, salt)), []byte(parts[1]))
+}
+
+func saltedDigest(password string, salt []byte) string {
+	digest := sha256.Sum256(kdf.KDF([]byte(password), salt, kdf.DefaultReps))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
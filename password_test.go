@@ -0,0 +1,101 @@
+package vsafe_test
+
+import (
+	"errors"
+	"github.com/keep94/vsafe"
+	"testing"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	verifyStrength(t, "", 0)
+	verifyStrength(t, "abcdef", 1)
+	verifyStrength(t, "abcDEF", 2)
+	verifyStrength(t, "abcDEF12", 3)
+	verifyStrength(t, "abcDEF12!", 4)
+	verifyStrength(t, "correcthorsebatterystaple", 2)
+	verifyStrength(t, "Correct1horse!battery", 4)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &vsafe.PasswordPolicy{MinLength: 8, MinStrength: 2}
+	user := &vsafe.User{Name: "keep94stuff"}
+	verifyPolicy(t, policy, user, "Ab1", vsafe.ErrPasswordTooShort)
+	verifyPolicy(t, policy, user, "abcdefgh", vsafe.ErrPasswordTooWeak)
+	verifyPolicy(t, policy, user, "KEEP94STUFF", vsafe.ErrPasswordIsName)
+	verifyPolicy(t, policy, user, "abcdefg1", nil)
+}
+
+func TestPasswordPolicyReused(t *testing.T) {
+	policy := &vsafe.PasswordPolicy{}
+	var user vsafe.User
+	if err := user.Init("keep94", "first"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	if err := user.ChangePassword("first", "second"); err != nil {
+		t.Fatalf("Error changing password: %v", err)
+	}
+	verifyPolicy(t, policy, &user, "first", vsafe.ErrPasswordReused)
+	verifyPolicy(t, policy, &user, "second", vsafe.ErrPasswordReused)
+	verifyPolicy(t, policy, &user, "third", nil)
+	passwords := []string{"second", "third", "fourth", "fifth", "sixth", "seventh"}
+	for i := 1; i < len(passwords); i++ {
+		if err := user.ChangePassword(passwords[i-1], passwords[i]); err != nil {
+			t.Fatalf("Error changing password: %v", err)
+		}
+	}
+	// Only the 5 most recent passwords are remembered.
+	verifyPolicy(t, policy, &user, "first", nil)
+	verifyPolicy(t, policy, &user, "second", vsafe.ErrPasswordReused)
+}
+
+func TestPasswordHistorySalted(t *testing.T) {
+	var first, second vsafe.User
+	if err := first.Init("first", "old"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	if err := second.Init("second", "old"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	if err := first.ChangePassword("old", "new1"); err != nil {
+		t.Fatalf("Error changing password: %v", err)
+	}
+	if err := second.ChangePassword("old", "new2"); err != nil {
+		t.Fatalf("Error changing password: %v", err)
+	}
+	// Users with the same old password get different digests.
+	if first.PasswordHistory == second.PasswordHistory {
+		t.Error("Expected each password digest to have its own salt")
+	}
+	if !first.UsedPassword("old") || !second.UsedPassword("old") {
+		t.Error("Expected old password to be in history")
+	}
+	if first.UsedPassword("new2") {
+		t.Error("Expected other user's password not to be in history")
+	}
+}
+
+func verifyStrength(t *testing.T, password string, expected int) {
+	t.Helper()
+	if actual := vsafe.PasswordStrength(password); actual != expected {
+		t.Errorf("Expected strength %d for %q, got %d", expected, password, actual)
+	}
+}
+
+func verifyPolicy(
+	t *testing.T,
+	policy *vsafe.PasswordPolicy,
+	user *vsafe.User,
+	password string,
+	expected error) {
+	t.Helper()
+	err := policy.Check(user, password)
+	if expected == nil {
+		if err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", password, err)
+		}
+		return
+	}
+	if !errors.Is(err, expected) {
+		t.Errorf("Expected %v for %q, got %v", expected, password, err)
+	}
+}
@@ -0,0 +1,207 @@
+package vsafe
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/keep94/toolbox/kdf"
+	"github.com/keep94/vsafe/aes"
+	"golang.org/x/crypto/curve25519"
+	"net/url"
+	"strings"
+)
+
+var (
+	// The user has no key pair for sharing entries.
+	ErrNoKeyPair = errors.New("vsafe: No key pair.")
+	// The sealed contents of a share are malformed.
+	ErrBadShare = errors.New("vsafe: Bad share.")
+)
+
+// HasKeyPair returns true if this user has a key pair for sharing entries.
+// Only master users have key pairs.
+func (u *User) HasKeyPair() bool {
+	return u.PublicKey != "" && u.PrivateKey != ""
+}
+
+// InitKeyPair gives this user a new X25519 key pair for sharing entries.
+// key is this user's key which is used to encrypt the private half.
+func (u *User) InitKeyPair(key *Key) error {
+	private := kdf.Random(curve25519.ScalarSize)
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	encrypted, err := aes.EncryptB(private, key.Value)
+	if err != nil {
+		return err
+	}
+	u.PublicKey = base64.StdEncoding.EncodeToString(public)
+	u.PrivateKey = encrypted
+	return nil
+}
+
+// Share represents an entry that the owner of one vault sends to the
+// owner of another. The contents of the entry are sealed with the
+// recipient's public key so that only the recipient can read them.
+type Share struct {
+	// The ID of the share
+	Id int64
+	// The master user ID of the sender
+	Sender int64
+	// The master user ID of the recipient
+	Recipient int64
+	// The ID of the shared entry in the sender's vault
+	EntryId int64
+	// The sealed contents of the entry
+	Payload string
+}
+
+// sharedEntry is what gets sealed in a share.
+type sharedEntry struct {
+	Url      string `json:"url,omitempty"`
+	Title    string `json:"title"`
+	Desc     string `json:"desc,omitempty"`
+	UName    string `json:"uname,omitempty"`
+	Password string `json:"password,omitempty"`
+	Special  string `json:"special,omitempty"`
+}
+
+// Seal stores the contents of entry in this share so that only the owner
+// of recipient can read them. entry must be decrypted. recipient must be a
+// master user with a key pair. Categories of entry are not shared.
+func (s *Share) Seal(entry *Entry, recipient *User) error {
+	contents := sharedEntry{
+		Title:    entry.Title,
+		Desc:     entry.Desc,
+		UName:    entry.UName,
+		Password: entry.Password,
+		Special:  entry.Special,
+	}
+	if entry.Url != nil {
+		contents.Url = entry.Url.String()
+	}
+	plain, err := json.Marshal(&contents)
+	if err != nil {
+		return err
+	}
+	payload, err := seal(plain, recipient)
+	if err != nil {
+		return err
+	}
+	s.Recipient = recipient.Id
+	s.Payload = payload
+	return nil
+}
+
+// Open reads the contents of this share into entry. recipient is the
+// master user receiving this share, and key is recipient's key.
+func (s *Share) Open(recipient *User, key *Key, entry *Entry) error {
+	plain, err := open(s.Payload, recipient, key)
+	if err != nil {
+		return err
+	}
+	var contents sharedEntry
+	if err := json.Unmarshal(plain, &contents); err != nil {
+		return ErrBadShare
+	}
+	var u *url.URL
+	if contents.Url != "" {
+		if u, err = url.Parse(contents.Url); err != nil {
+			return ErrBadShare
+		}
+	}
+	*entry = Entry{
+		Url:      u,
+		Title:    contents.Title,
+		Desc:     contents.Desc,
+		UName:    contents.UName,
+		Password: contents.Password,
+		Special:  contents.Special,
+	}
+	return nil
+}
+
+// seal encrypts plain so that only the owner of recipient can decrypt it.
+// recipient must be a master user with a key pair.
+func seal(plain []byte, recipient *User) (string, error) {
+	if recipient.PublicKey == "" {
+		return "", ErrNoKeyPair
+	}
+	return sealTo(plain, recipient.PublicKey)
+}
+
+// open decrypts payload from seal. recipient is the master user payload
+// was sealed for, and key is recipient's key.
+func open(payload string, recipient *User, key *Key) ([]byte, error) {
+	if !recipient.HasKeyPair() {
+		return nil, ErrNoKeyPair
+	}
+	private, err := aes.DecryptB(recipient.PrivateKey, key.Value)
+	if err != nil {
+		return nil, err
+	}
+	return openWith(payload, private, recipient.PublicKey)
+}
+
+// sealTo encrypts plain so that only the holder of the private half of
+// publicKey can decrypt it. publicKey is base64 encoded.
+func sealTo(plain []byte, publicKey string) (string, error) {
+	public, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return "", err
+	}
+	ephemeral := kdf.Random(curve25519.ScalarSize)
+	ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	secret, err := curve25519.X25519(ephemeral, public)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := aes.Encrypt(
+		string(plain), shareKey(secret, ephemeralPublic, public))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ephemeralPublic) + ":" + sealed, nil
+}
+
+// openWith decrypts payload from sealTo. private is the private half of
+// publicKey.
+func openWith(payload string, private []byte, publicKey string) ([]byte, error) {
+	public, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(payload, ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrBadShare
+	}
+	ephemeralPublic, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrBadShare
+	}
+	secret, err := curve25519.X25519(private, ephemeralPublic)
+	if err != nil {
+		return nil, ErrBadShare
+	}
+	plain, err := aes.Decrypt(
+		parts[1], shareKey(secret, ephemeralPublic, public))
+	if err != nil {
+		return nil, ErrBadShare
+	}
+	return []byte(plain), nil
+}
+
+// shareKey derives the AES key for a share from the X25519 shared secret
+// and both public keys.
+func shareKey(secret, ephemeralPublic, public []byte) []byte {
+	h := sha256.New()
+	h.Write(secret)
+	h.Write(ephemeralPublic)
+	h.Write(public)
+	return h.Sum(nil)
+}
@@ -0,0 +1,78 @@
+package vsafe_test
+
+import (
+	"github.com/keep94/vsafe"
+	"net/url"
+	"testing"
+)
+
+func TestShareSealOpen(t *testing.T) {
+	sender := vsafe.User{Id: 1}
+	if err := sender.Init("sender", "password"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	recipient := vsafe.User{Id: 2}
+	if err := recipient.Init("recipient", "secret"); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	if !recipient.HasKeyPair() {
+		t.Fatal("Expected recipient to have a key pair")
+	}
+	home, _ := url.Parse("http://www.example.com")
+	entry := vsafe.Entry{
+		Id:         5,
+		Owner:      1,
+		Url:        home,
+		Title:      "Example",
+		Desc:       "Example site",
+		UName:      "joe",
+		Password:   "abc123",
+		Special:    "PIN 1234",
+		Categories: "3",
+	}
+	var share vsafe.Share
+	if err := share.Seal(&entry, &recipient); err != nil {
+		t.Fatalf("Error sealing share: %v", err)
+	}
+	if share.Recipient != 2 {
+		t.Errorf("Expected recipient 2, got %d", share.Recipient)
+	}
+	key, err := recipient.VerifyPassword("secret")
+	if err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	var opened vsafe.Entry
+	if err := share.Open(&recipient, key, &opened); err != nil {
+		t.Fatalf("Error opening share: %v", err)
+	}
+	expected := vsafe.Entry{
+		Url:      home,
+		Title:    "Example",
+		Desc:     "Example site",
+		UName:    "joe",
+		Password: "abc123",
+		Special:  "PIN 1234",
+	}
+	if opened.Url.String() != expected.Url.String() {
+		t.Errorf("Expected %v, got %v", expected.Url, opened.Url)
+	}
+	opened.Url = expected.Url
+	if opened != expected {
+		t.Errorf("Expected %v, got %v", expected, opened)
+	}
+	senderKey, err := sender.VerifyPassword("password")
+	if err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	if err := share.Open(&sender, senderKey, &opened); err == nil {
+		t.Error("Expected sender not to be able to open share")
+	}
+}
+
+func TestShareSealNoKeyPair(t *testing.T) {
+	var share vsafe.Share
+	if err := share.Seal(
+		&vsafe.Entry{Title: "x"}, &vsafe.User{Id: 3}); err != vsafe.ErrNoKeyPair {
+		t.Errorf("Expected ErrNoKeyPair, got %v", err)
+	}
+}
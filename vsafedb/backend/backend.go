@@ -0,0 +1,271 @@
+// Package backend opens a vsafe datastore by location so that the vsafe
+// apps can run on any database that vsafedb supports.
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/db/sqlite3_db"
+	"github.com/keep94/vsafe/vsafedb"
+	"github.com/keep94/vsafe/vsafedb/bolt_setup"
+	"github.com/keep94/vsafe/vsafedb/for_bolt"
+	"github.com/keep94/vsafe/vsafedb/for_postgres"
+	"github.com/keep94/vsafe/vsafedb/for_sqlite"
+	"github.com/keep94/vsafe/vsafedb/postgres_setup"
+	"github.com/keep94/vsafe/vsafedb/schema"
+	"github.com/keep94/vsafe/vsafedb/sqlite_setup"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Usage describes the locations that Open accepts. Apps use it in the
+// help text of their -db flag.
+const Usage = "Path to sqlite database file, bolt:<path> for a bolt file, or postgres:// URL"
+
+const (
+	kBoltPrefix = "bolt:"
+
+	kSqliteForeignKeys = "_foreign_keys=on"
+
+	// How long to wait for another process to release a bolt file
+	kBoltTimeout = 5 * time.Second
+)
+
+// Store is everything the vsafe apps need from a datastore.
+type Store interface {
+	vsafedb.AddUserRunner
+	vsafedb.UserByIdRunner
+	vsafedb.UserByNameRunner
+	vsafedb.UsersRunner
+	vsafedb.UsersByOwnerRunner
+	vsafedb.UpdateUserRunner
+	vsafedb.RemoveUserRunner
+	vsafedb.AddCategoryRunner
+	vsafedb.CategoryByIdRunner
+	vsafedb.CategoriesByOwnerRunner
+	vsafedb.UpdateCategoryRunner
+	vsafedb.RemoveCategoryRunner
+	vsafedb.AddEntryRunner
+	vsafedb.EntryByIdRunner
+	vsafedb.EntriesByOwnerRunner
+	vsafedb.UpdateEntryRunner
+	vsafedb.RemoveEntryRunner
+	vsafedb.AddShareRunner
+	vsafedb.ShareByIdRunner
+	vsafedb.SharesByRecipientRunner
+	vsafedb.SharesBySenderRunner
+	vsafedb.UpdateShareRunner
+	vsafedb.RemoveShareRunner
+	vsafedb.AddCollectionRunner
+	vsafedb.CollectionByIdRunner
+	vsafedb.AddMembershipRunner
+	vsafedb.MembershipsByUserRunner
+	vsafedb.MembershipsByCollectionRunner
+	vsafedb.RemoveMembershipRunner
+}
+
+var (
+	// Indicates that the datastore can't have a full text index.
+	ErrNoSearchIndex = errors.New(
+		"backend: Only sqlite databases have a full text index.")
+)
+
+// Migration describes one schema migration.
+type Migration struct {
+	Version     int
+	Description string
+}
+
+// Db is an open vsafe datastore.
+type Db struct {
+	// The datastore
+	Store Store
+	// Runs several Store operations in one transaction
+	Doer   db.Doer
+	schema schemaOps
+	close  func() error
+}
+
+// Open opens the datastore at location. A location starting with
+// postgres:// or postgresql:// is a PostgreSQL URL. A location of the form
+// bolt:<path> is the path of a bolt file, which Open creates if needed.
+// Any other location is the path of a sqlite database file. Open does not
+// check or change the schema; call Migrate or CheckVersion next.
+//
+// Only one process at a time may open a bolt file. Open waits a few
+// seconds for another process to release it and then gives up.
+func Open(location string) (*Db, error) {
+	if strings.HasPrefix(location, kBoltPrefix) {
+		bdb, err := bolt.Open(
+			strings.TrimPrefix(location, kBoltPrefix),
+			0600,
+			&bolt.Options{Timeout: kBoltTimeout})
+		if err != nil {
+			return nil, err
+		}
+		return &Db{
+			Store:  for_bolt.New(bdb),
+			Doer:   for_bolt.NewDoer(bdb),
+			schema: boltSchema(bdb),
+			close:  bdb.Close,
+		}, nil
+	}
+	if strings.HasPrefix(location, "postgres://") ||
+		strings.HasPrefix(location, "postgresql://") {
+		rawdb, err := sql.Open("postgres", location)
+		if err != nil {
+			return nil, err
+		}
+		dbase := sqlite3_db.New(rawdb)
+		return &Db{
+			Store: for_postgres.New(dbase),
+			Doer:  sqlite3_db.NewDoer(dbase),
+			schema: sqlSchema(
+				dbase,
+				postgres_setup.Version,
+				postgres_setup.Migrate,
+				postgres_setup.CheckVersion,
+				describe(postgres_setup.Migrations)),
+			close: dbase.Close,
+		}, nil
+	}
+	rawdb, err := sql.Open("sqlite3", sqliteDSN(location))
+	if err != nil {
+		return nil, err
+	}
+	dbase := sqlite3_db.New(rawdb)
+	sqliteSchema := sqlSchema(
+		dbase,
+		sqlite_setup.Version,
+		sqlite_setup.Migrate,
+		sqlite_setup.CheckVersion,
+		describe(sqlite_setup.Migrations))
+	sqliteSchema.addSearchIndex = func() error {
+		return dbase.Do(sqlite_setup.AddSearchIndex)
+	}
+	return &Db{
+		Store:  for_sqlite.New(dbase),
+		Doer:   sqlite3_db.NewDoer(dbase),
+		schema: sqliteSchema,
+		close:  dbase.Close,
+	}, nil
+}
+
+// Close closes this datastore.
+func (d *Db) Close() error {
+	return d.close()
+}
+
+// Migrate upgrades the schema of this datastore to the latest version.
+// Migrate returns an error if the schema is newer than this program
+// supports.
+func (d *Db) Migrate() error {
+	return d.schema.migrate()
+}
+
+// CheckVersion returns nil if the schema of this datastore is at the
+// latest version and an error otherwise.
+func (d *Db) CheckVersion() error {
+	return d.schema.checkVersion()
+}
+
+// AddSearchIndex adds a full text index for searching entries. Only
+// sqlite databases support the index, and only programs built with the
+// sqlite_fts5 tag can add it or use a database that has it. For other
+// datastores, AddSearchIndex returns ErrNoSearchIndex.
+func (d *Db) AddSearchIndex() error {
+	if d.schema.addSearchIndex == nil {
+		return ErrNoSearchIndex
+	}
+	return d.schema.addSearchIndex()
+}
+
+// Version returns the schema version of this datastore.
+func (d *Db) Version() (int, error) {
+	return d.schema.version()
+}
+
+// LatestVersion returns the schema version that this program supports.
+func (d *Db) LatestVersion() int {
+	return len(d.schema.migrations)
+}
+
+// Migrations returns the migrations that upgrade the schema from version
+// to the latest version in order.
+func (d *Db) Migrations(version int) []Migration {
+	if version < 0 {
+		version = 0
+	}
+	if version > len(d.schema.migrations) {
+		return nil
+	}
+	return d.schema.migrations[version:]
+}
+
+// schemaOps are the schema operations of one kind of datastore.
+type schemaOps struct {
+	version        func() (int, error)
+	migrate        func() error
+	checkVersion   func() error
+	addSearchIndex func() error
+	migrations     []Migration
+}
+
+func sqlSchema(
+	dbase *sqlite3_db.Db,
+	version func(tx *sql.Tx) (int, error),
+	migrate func(tx *sql.Tx) error,
+	checkVersion func(tx *sql.Tx) error,
+	migrations []Migration) schemaOps {
+	return schemaOps{
+		version: func() (result int, err error) {
+			err = dbase.Do(func(tx *sql.Tx) (err error) {
+				result, err = version(tx)
+				return
+			})
+			return
+		},
+		migrate:      func() error { return dbase.Do(migrate) },
+		checkVersion: func() error { return dbase.Do(checkVersion) },
+		migrations:   migrations,
+	}
+}
+
+func boltSchema(bdb *bolt.DB) schemaOps {
+	return schemaOps{
+		version: func() (result int, err error) {
+			err = bdb.View(func(tx *bolt.Tx) (err error) {
+				result, err = bolt_setup.Version(tx)
+				return
+			})
+			return
+		},
+		migrate:      func() error { return bdb.Update(bolt_setup.Migrate) },
+		checkVersion: func() error { return bdb.View(bolt_setup.CheckVersion) },
+		migrations:   describe(bolt_setup.Migrations),
+	}
+}
+
+// sqliteDSN turns on foreign keys which for_sqlite needs.
+func sqliteDSN(location string) string {
+	if strings.Contains(location, "?") {
+		return location + "&" + kSqliteForeignKeys
+	}
+	return location + "?" + kSqliteForeignKeys
+}
+
+// describe returns the versions and descriptions of migrations.
+func describe[T any](migrations []schema.Migration[T]) []Migration {
+	var result []Migration
+	for _, m := range migrations {
+		result = append(
+			result,
+			Migration{Version: m.Version, Description: m.Description})
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package backend_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb/backend"
+)
+
+func TestOpenSqlite(t *testing.T) {
+	verifyOpen(t, filepath.Join(t.TempDir(), "vsafe.db"))
+}
+
+func TestOpenBolt(t *testing.T) {
+	verifyOpen(t, "bolt:"+filepath.Join(t.TempDir(), "vsafe.bolt"))
+}
+
+func verifyOpen(t *testing.T, location string) {
+	t.Helper()
+	dbase, err := backend.Open(location)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer dbase.Close()
+	if version, err := dbase.Version(); err != nil || version != 0 {
+		t.Fatalf("Expected version 0, got %d, %v", version, err)
+	}
+	if len(dbase.Migrations(0)) != dbase.LatestVersion() {
+		t.Errorf("Expected %d migrations", dbase.LatestVersion())
+	}
+	if err := dbase.CheckVersion(); err == nil {
+		t.Error("Expected CheckVersion to fail before Migrate")
+	}
+	if err := dbase.Migrate(); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	if err := dbase.CheckVersion(); err != nil {
+		t.Errorf("Expected latest version, got %v", err)
+	}
+	if migrations := dbase.Migrations(dbase.LatestVersion()); len(migrations) != 0 {
+		t.Errorf("Expected no pending migrations, got %v", migrations)
+	}
+	user := vsafe.User{Name: "keep94", Key: "key", Checksum: "checksum"}
+	if err := dbase.Store.AddUser(nil, &user); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	var fetched vsafe.User
+	if err := dbase.Store.UserByName(nil, "keep94", &fetched); err != nil {
+		t.Fatalf("Error reading user: %v", err)
+	}
+	if fetched.Id != user.Id {
+		t.Errorf("Expected user %d, got %d", user.Id, fetched.Id)
+	}
+}
@@ -0,0 +1,121 @@
+// Package bolt_setup sets up a bolt database for vsafe app.
+//
+// A bolt database keeps each kind of record in its own bucket and each
+// secondary index in a bucket of its own. Like sqlite_setup, bolt_setup
+// records the schema version in the database and upgrades a database by
+// running each pending migration in order.
+package bolt_setup
+
+import (
+	"strconv"
+
+	"github.com/keep94/vsafe/vsafedb/schema"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrNewerSchema is schema.ErrNewerSchema.
+	ErrNewerSchema = schema.ErrNewerSchema
+	// ErrOlderSchema is schema.ErrOlderSchema.
+	ErrOlderSchema = schema.ErrOlderSchema
+)
+
+var (
+	kSchemaBucket = []byte("schema")
+	kVersionKey   = []byte("version")
+)
+
+// Migration upgrades the schema of a vsafe database from version
+// Version - 1 to Version.
+type Migration = schema.Migration[*bolt.Tx]
+
+// Migrations lists every migration in order. Migrations[i].Version is
+// always i + 1.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial buckets",
+		Up: createBuckets(
+			"users",
+			"users_by_name",
+			"users_by_owner",
+			"categories",
+			"categories_by_owner",
+			"entries",
+			"entries_by_owner",
+			"shares",
+			"shares_by_recipient",
+			"shares_by_sender",
+			"collections",
+			"memberships",
+			"memberships_by_user",
+			"memberships_by_collection"),
+	},
+}
+
+// LatestVersion returns the schema version that this program supports.
+func LatestVersion() int {
+	return runner().LatestVersion()
+}
+
+// SetUpTables creates all needed buckets in database for the vsafe app or
+// upgrades them to the latest version. SetUpTables is the same as Migrate.
+func SetUpTables(tx *bolt.Tx) error {
+	return Migrate(tx)
+}
+
+// Migrate upgrades the database to the latest schema version by running
+// the pending migrations in order within tx. Running Migrate on an up to
+// date database does nothing. If the database schema is newer than this
+// program supports, Migrate returns ErrNewerSchema.
+func Migrate(tx *bolt.Tx) error {
+	return runner().Migrate(tx)
+}
+
+// CheckVersion returns nil if the database schema is at the latest
+// version. Otherwise it returns ErrNewerSchema or ErrOlderSchema.
+func CheckVersion(tx *bolt.Tx) error {
+	return runner().CheckVersion(tx)
+}
+
+// Version returns the schema version of the database or 0 for an empty
+// database.
+func Version(tx *bolt.Tx) (int, error) {
+	bucket := tx.Bucket(kSchemaBucket)
+	if bucket == nil {
+		return 0, nil
+	}
+	value := bucket.Get(kVersionKey)
+	if value == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(value))
+}
+
+func runner() *schema.Runner[*bolt.Tx] {
+	return &schema.Runner[*bolt.Tx]{
+		Name:       "bolt_setup",
+		Migrations: Migrations,
+		Version:    Version,
+		SetVersion: setVersion,
+	}
+}
+
+func setVersion(tx *bolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists(kSchemaBucket)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(kVersionKey, []byte(strconv.Itoa(version)))
+}
+
+func createBuckets(names ...string) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
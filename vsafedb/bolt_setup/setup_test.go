@@ -0,0 +1,87 @@
+package bolt_setup_test
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/keep94/vsafe/vsafedb/bolt_setup"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMigrateEmpty(t *testing.T) {
+	dbase := openDb(t)
+	verifyVersion(t, dbase, 0)
+	if err := dbase.View(bolt_setup.CheckVersion); err != bolt_setup.ErrOlderSchema {
+		t.Errorf("Expected ErrOlderSchema, got %v", err)
+	}
+	if err := dbase.Update(bolt_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	verifyVersion(t, dbase, bolt_setup.LatestVersion())
+	if err := dbase.View(bolt_setup.CheckVersion); err != nil {
+		t.Errorf("Expected latest version, got %v", err)
+	}
+	// Migrating again does nothing
+	if err := dbase.Update(bolt_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating again: %v", err)
+	}
+	verifyVersion(t, dbase, bolt_setup.LatestVersion())
+}
+
+func TestMigrateNewer(t *testing.T) {
+	dbase := openDb(t)
+	if err := dbase.Update(bolt_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	err := dbase.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("schema")).Put(
+			[]byte("version"),
+			[]byte(strconv.Itoa(bolt_setup.LatestVersion()+1)))
+	})
+	if err != nil {
+		t.Fatalf("Error setting version: %v", err)
+	}
+	if err := dbase.Update(bolt_setup.Migrate); err != bolt_setup.ErrNewerSchema {
+		t.Errorf("Expected ErrNewerSchema from Migrate, got %v", err)
+	}
+	if err := dbase.View(bolt_setup.CheckVersion); err != bolt_setup.ErrNewerSchema {
+		t.Errorf("Expected ErrNewerSchema from CheckVersion, got %v", err)
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, migration := range bolt_setup.Migrations {
+		if migration.Version != i+1 {
+			t.Errorf(
+				"Expected version %d at index %d, got %d",
+				i+1, i, migration.Version)
+		}
+	}
+}
+
+func verifyVersion(t *testing.T, dbase *bolt.DB, expected int) {
+	t.Helper()
+	var version int
+	err := dbase.View(func(tx *bolt.Tx) (err error) {
+		version, err = bolt_setup.Version(tx)
+		return
+	})
+	if err != nil {
+		t.Fatalf("Error reading version: %v", err)
+	}
+	if version != expected {
+		t.Errorf("Expected version %d, got %d", expected, version)
+	}
+}
+
+func openDb(t *testing.T) *bolt.DB {
+	t.Helper()
+	dbase, err := bolt.Open(
+		filepath.Join(t.TempDir(), "vsafe.bolt"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { dbase.Close() })
+	return dbase
+}
@@ -0,0 +1,301 @@
+package vsafedb
+
+import (
+	"errors"
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+)
+
+var (
+	// Indicates that a user is already a member of a collection.
+	ErrAlreadyMember = errors.New("vsafedb: Already a member.")
+	// Indicates that the user to add to a collection does not exist.
+	ErrNoSuchMember = errors.New("vsafedb: No such user.")
+)
+
+type AddCollectionRunner interface {
+	// AddCollection adds a new collection to persistent storage.
+	AddCollection(t db.Transaction, collection *vsafe.Collection) error
+}
+
+type CollectionByIdRunner interface {
+	// CollectionById retrieves a collection by id from persistent storage.
+	CollectionById(
+		t db.Transaction, id int64, collection *vsafe.Collection) error
+}
+
+type AddMembershipRunner interface {
+	// AddMembership adds a new membership to persistent storage.
+	AddMembership(t db.Transaction, membership *vsafe.Membership) error
+}
+
+type MembershipsByUserRunner interface {
+	// MembershipsByUser retrieves the memberships of a user ordered by id.
+	MembershipsByUser(
+		t db.Transaction,
+		userId int64,
+		consumer consume2.Consumer[vsafe.Membership]) error
+}
+
+type MembershipsByCollectionRunner interface {
+	// MembershipsByCollection retrieves the memberships of a collection
+	// ordered by id.
+	MembershipsByCollection(
+		t db.Transaction,
+		collectionId int64,
+		consumer consume2.Consumer[vsafe.Membership]) error
+}
+
+type RemoveMembershipRunner interface {
+	// RemoveMembership removes a membership by id from persistent storage.
+	RemoveMembership(t db.Transaction, id int64) error
+}
+
+type SafeCollectionsRunner interface {
+	MembershipsByUserRunner
+	CollectionByIdRunner
+}
+
+type SafeCreateCollectionRunner interface {
+	AddCollectionRunner
+	AddMembershipRunner
+}
+
+type SafeCollectionKeyRunner interface {
+	MembershipsByUserRunner
+}
+
+type SafeMemberKeyRunner interface {
+	SafeUpdateUserRunner
+	MembershipsByUserRunner
+	AddMembershipRunner
+	RemoveMembershipRunner
+}
+
+type SafeAddMemberRunner interface {
+	SafeCollectionKeyRunner
+	UserByNameRunner
+	MembershipsByCollectionRunner
+	AddMembershipRunner
+}
+
+type SafeLeaveCollectionRunner interface {
+	MembershipsByUserRunner
+	RemoveMembershipRunner
+}
+
+// Collections returns the collections of which user is a member ordered by
+// when user joined them. t may be nil.
+func Collections(
+	store SafeCollectionsRunner,
+	t db.Transaction,
+	user *vsafe.User) ([]vsafe.Collection, error) {
+	var memberships []vsafe.Membership
+	if err := store.MembershipsByUser(
+		t, user.Id, consume2.AppendTo(&memberships)); err != nil {
+		return nil, err
+	}
+	result := make([]vsafe.Collection, len(memberships))
+	for i := range memberships {
+		if err := store.CollectionById(
+			t, memberships[i].Collection, &result[i]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// CreateCollection creates a new collection with given name and a new
+// random key and makes user its first member. CreateCollection returns
+// the new collection and its key. If user's role does not allow editing
+// entries, CreateCollection returns ErrPermissionDenied. If user has no
+// key pair for memberships, CreateCollection returns vsafe.ErrNoKeyPair.
+// t, the transaction, must be non nil.
+func CreateCollection(
+	store SafeCreateCollectionRunner,
+	t db.Transaction,
+	name string,
+	user *vsafe.User) (*vsafe.Collection, *vsafe.Key, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	if !user.CanEditEntries() {
+		return nil, nil, ErrPermissionDenied
+	}
+	if !user.HasMemberKeyPair() {
+		return nil, nil, vsafe.ErrNoKeyPair
+	}
+	collection := vsafe.Collection{Name: name}
+	if err := store.AddCollection(t, &collection); err != nil {
+		return nil, nil, err
+	}
+	collectionKey := collection.NewKey()
+	var membership vsafe.Membership
+	if err := membership.Init(collectionKey, user); err != nil {
+		return nil, nil, err
+	}
+	if err := store.AddMembership(t, &membership); err != nil {
+		return nil, nil, err
+	}
+	return &collection, collectionKey, nil
+}
+
+// CollectionKey returns the key of the collection with given id.
+// memberKey is the key from user.MemberKey. If user is not a member of
+// the collection, CollectionKey returns ErrNoSuchId. t may be nil.
+func CollectionKey(
+	store SafeCollectionKeyRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	memberKey *vsafe.Key) (*vsafe.Key, error) {
+	membership, err := membershipOf(store, t, id, user)
+	if err != nil {
+		return nil, err
+	}
+	return membership.Open(user, memberKey)
+}
+
+// MemberKey returns the key from user.MemberKey that opens user's
+// collection memberships. password is user's password, and key is user's
+// key. If user has no key pair for memberships yet, MemberKey gives user
+// one and reseals the collection keys in user's memberships, which used
+// to be sealed with the key pair of user's master, for it. t, the
+// transaction, must be non nil.
+func MemberKey(
+	store SafeMemberKeyRunner,
+	t db.Transaction,
+	user *vsafe.User,
+	key *vsafe.Key,
+	password string) (*vsafe.Key, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	var current vsafe.User
+	if err := store.UserById(t, user.Id, &current); err != nil {
+		return nil, err
+	}
+	if current.HasMemberKeyPair() {
+		return current.MemberKey(password)
+	}
+	if err := current.InitMemberKeyPair(password); err != nil {
+		return nil, err
+	}
+	var memberships []vsafe.Membership
+	if err := store.MembershipsByUser(
+		t, user.Id, consume2.AppendTo(&memberships)); err != nil {
+		return nil, err
+	}
+	if len(memberships) > 0 {
+		var master vsafe.User
+		if err := store.UserById(t, current.GetOwner(), &master); err != nil {
+			return nil, err
+		}
+		for i := range memberships {
+			// Leave alone memberships that user could not open anyway
+			if memberships[i].Rewrap(&master, key, &current) != nil {
+				continue
+			}
+			if err := store.RemoveMembership(t, memberships[i].Id); err != nil {
+				return nil, err
+			}
+			if err := store.AddMembership(t, &memberships[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := store.UpdateUser(t, &current); err != nil {
+		return nil, err
+	}
+	return current.MemberKey(password)
+}
+
+// AddMember adds the user named memberName to the collection with given id
+// on behalf of user. memberKey is the key from user.MemberKey. If user is
+// not a member of the collection, AddMember returns ErrNoSuchId; if user's
+// role does not allow editing entries, AddMember returns
+// ErrPermissionDenied. If there is no user named memberName, AddMember
+// returns ErrNoSuchMember; if that user is already a member, AddMember
+// returns ErrAlreadyMember. If the new member has no key pair for
+// memberships because they have not logged in since members got their own
+// key pairs, AddMember returns vsafe.ErrNoKeyPair. t, the transaction,
+// must be non nil.
+func AddMember(
+	store SafeAddMemberRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	memberKey *vsafe.Key,
+	memberName string) error {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	if !user.CanEditEntries() {
+		return ErrPermissionDenied
+	}
+	collectionKey, err := CollectionKey(store, t, id, user, memberKey)
+	if err != nil {
+		return err
+	}
+	var member vsafe.User
+	err = store.UserByName(t, memberName, &member)
+	if err == ErrNoSuchId {
+		return ErrNoSuchMember
+	}
+	if err != nil {
+		return err
+	}
+	var memberships []vsafe.Membership
+	if err := store.MembershipsByCollection(
+		t, id, consume2.AppendTo(&memberships)); err != nil {
+		return err
+	}
+	for i := range memberships {
+		if memberships[i].User == member.Id {
+			return ErrAlreadyMember
+		}
+	}
+	var membership vsafe.Membership
+	if err := membership.Init(collectionKey, &member); err != nil {
+		return err
+	}
+	return store.AddMembership(t, &membership)
+}
+
+// LeaveCollection removes user from the collection with given id. If user
+// is not a member of the collection, LeaveCollection returns ErrNoSuchId.
+// The collection and its entries remain for the other members. t, the
+// transaction, must be non nil.
+func LeaveCollection(
+	store SafeLeaveCollectionRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User) error {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	membership, err := membershipOf(store, t, id, user)
+	if err != nil {
+		return err
+	}
+	return store.RemoveMembership(t, membership.Id)
+}
+
+func membershipOf(
+	store MembershipsByUserRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User) (*vsafe.Membership, error) {
+	var memberships []vsafe.Membership
+	if err := store.MembershipsByUser(
+		t, user.Id, consume2.AppendTo(&memberships)); err != nil {
+		return nil, err
+	}
+	for i := range memberships {
+		if memberships[i].Collection == id {
+			return &memberships[i], nil
+		}
+	}
+	return nil, ErrNoSuchId
+}
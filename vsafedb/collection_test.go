@@ -0,0 +1,220 @@
+package vsafedb_test
+
+import (
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"testing"
+)
+
+func TestCollections(t *testing.T) {
+	store := newFakeCollectionStore()
+	master, sub, other := addMasterAndSubUser(t, store.FakeUserStore)
+	masterKey, _ := master.MemberKey("password")
+	subKey, _ := sub.MemberKey("pass")
+	otherKey, _ := other.MemberKey("password")
+	readOnly := *sub
+	readOnly.Role = vsafe.RoleReadOnly
+	if _, _, err := vsafedb.CreateCollection(
+		store, kTransaction, "team", &readOnly); err != vsafedb.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	collection, collectionKey, err := vsafedb.CreateCollection(
+		store, kTransaction, "team", master)
+	if err != nil {
+		t.Fatalf("Error creating collection %v", err)
+	}
+	if collectionKey.Id != collection.KeyId() {
+		t.Errorf("Expected key id %d, got %d", collection.KeyId(), collectionKey.Id)
+	}
+	if _, err := vsafedb.CollectionKey(
+		store, nil, collection.Id, other, otherKey); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	// Sharing the master's vault does not make sub a member
+	if _, err := vsafedb.CollectionKey(
+		store, nil, collection.Id, sub, subKey); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if err := vsafedb.AddMember(
+		store, kTransaction, collection.Id, master, masterKey, "nobody"); err != vsafedb.ErrNoSuchMember {
+		t.Errorf("Expected ErrNoSuchMember, got %v", err)
+	}
+	if err := vsafedb.AddMember(
+		store, kTransaction, collection.Id, master, masterKey, "master"); err != vsafedb.ErrAlreadyMember {
+		t.Errorf("Expected ErrAlreadyMember, got %v", err)
+	}
+	if err := vsafedb.AddMember(
+		store, kTransaction, collection.Id, other, otherKey, "other"); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if err := vsafedb.AddMember(
+		store, kTransaction, collection.Id, master, masterKey, "other"); err != nil {
+		t.Fatalf("Error adding member %v", err)
+	}
+	otherCollectionKey, err := vsafedb.CollectionKey(
+		store, nil, collection.Id, other, otherKey)
+	if err != nil {
+		t.Fatalf("Error getting collection key %v", err)
+	}
+	if !otherCollectionKey.Equal(collectionKey) {
+		t.Error("Expected members to share the collection key")
+	}
+	entryId, err := vsafedb.AddEntry(
+		store, nil, master, collectionKey, &vsafe.Entry{Title: "Team", Password: "abc"})
+	if err != nil {
+		t.Fatalf("Error adding entry %v", err)
+	}
+	var entry vsafe.Entry
+	if err := vsafedb.EntryById(
+		store, nil, entryId, other, otherCollectionKey, &entry); err != nil {
+		t.Fatalf("Error reading entry %v", err)
+	}
+	if entry.Password != "abc" || entry.Owner != collection.KeyId() {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+	collections, err := vsafedb.Collections(store, nil, other)
+	if err != nil {
+		t.Fatalf("Error reading collections %v", err)
+	}
+	if len(collections) != 1 || collections[0] != *collection {
+		t.Errorf("Unexpected collections %v", collections)
+	}
+	if err := vsafedb.LeaveCollection(
+		store, kTransaction, collection.Id, other); err != nil {
+		t.Fatalf("Error leaving collection %v", err)
+	}
+	if _, err := vsafedb.CollectionKey(
+		store, nil, collection.Id, other, otherKey); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if err := vsafedb.LeaveCollection(
+		store, kTransaction, collection.Id, other); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+}
+
+func TestAddMemberNoKeyPair(t *testing.T) {
+	store := newFakeCollectionStore()
+	master, sub, _ := addMasterAndSubUser(t, store.FakeUserStore)
+	masterKey, _ := master.MemberKey("password")
+	collection, _, err := vsafedb.CreateCollection(
+		store, kTransaction, "team", master)
+	if err != nil {
+		t.Fatalf("Error creating collection %v", err)
+	}
+	// Users created before collections have no key pair until they log in
+	sub.MemberPublicKey = ""
+	sub.MemberPrivateKey = ""
+	if err := store.UpdateUser(nil, sub); err != nil {
+		t.Fatalf("Error updating user %v", err)
+	}
+	if err := vsafedb.AddMember(
+		store, kTransaction, collection.Id, master, masterKey, "sub"); err != vsafe.ErrNoKeyPair {
+		t.Errorf("Expected ErrNoKeyPair, got %v", err)
+	}
+	key, _ := sub.VerifyPassword("pass")
+	subKey, err := vsafedb.MemberKey(store, kTransaction, sub, key, "pass")
+	if err != nil {
+		t.Fatalf("Error getting member key %v", err)
+	}
+	if err := vsafedb.AddMember(
+		store, kTransaction, collection.Id, master, masterKey, "sub"); err != nil {
+		t.Fatalf("Error adding member %v", err)
+	}
+	if _, err := vsafedb.CollectionKey(
+		store, nil, collection.Id, sub, subKey); err != vsafe.ErrNoKeyPair {
+		t.Errorf("Expected ErrNoKeyPair for stale user, got %v", err)
+	}
+	var current vsafe.User
+	if err := store.UserById(nil, sub.Id, &current); err != nil {
+		t.Fatalf("Error reading user %v", err)
+	}
+	if _, err := vsafedb.CollectionKey(
+		store, nil, collection.Id, &current, subKey); err != nil {
+		t.Errorf("Expected to open membership, got %v", err)
+	}
+	again, err := vsafedb.MemberKey(store, kTransaction, &current, key, "pass")
+	if err != nil {
+		t.Fatalf("Error getting member key %v", err)
+	}
+	if !again.Equal(subKey) {
+		t.Error("Expected the same member key")
+	}
+}
+
+type fakeCollectionStore struct {
+	*FakeUserStore
+	*FakeStore
+	*FakeCollectionStore
+}
+
+func newFakeCollectionStore() fakeCollectionStore {
+	return fakeCollectionStore{
+		FakeUserStore:       &FakeUserStore{},
+		FakeStore:           &FakeStore{},
+		FakeCollectionStore: &FakeCollectionStore{},
+	}
+}
+
+type FakeCollectionStore struct {
+	Collections []vsafe.Collection
+	Memberships []*vsafe.Membership
+}
+
+func (f *FakeCollectionStore) AddCollection(
+	t db.Transaction, c *vsafe.Collection) error {
+	c.Id = int64(len(f.Collections) + 1)
+	f.Collections = append(f.Collections, *c)
+	return nil
+}
+
+func (f *FakeCollectionStore) CollectionById(
+	t db.Transaction, id int64, c *vsafe.Collection) error {
+	if id < 1 || int(id) > len(f.Collections) {
+		return vsafedb.ErrNoSuchId
+	}
+	*c = f.Collections[id-1]
+	return nil
+}
+
+func (f *FakeCollectionStore) AddMembership(
+	t db.Transaction, m *vsafe.Membership) error {
+	m.Id = int64(len(f.Memberships) + 1)
+	stored := *m
+	f.Memberships = append(f.Memberships, &stored)
+	return nil
+}
+
+func (f *FakeCollectionStore) MembershipsByUser(
+	t db.Transaction,
+	userId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	for _, m := range f.Memberships {
+		if m != nil && m.User == userId && consumer.CanConsume() {
+			consumer.Consume(*m)
+		}
+	}
+	return nil
+}
+
+func (f *FakeCollectionStore) MembershipsByCollection(
+	t db.Transaction,
+	collectionId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	for _, m := range f.Memberships {
+		if m != nil && m.Collection == collectionId && consumer.CanConsume() {
+			consumer.Consume(*m)
+		}
+	}
+	return nil
+}
+
+func (f *FakeCollectionStore) RemoveMembership(
+	t db.Transaction, id int64) error {
+	if id >= 1 && int(id) <= len(f.Memberships) {
+		f.Memberships[id-1] = nil
+	}
+	return nil
+}
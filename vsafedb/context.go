@@ -0,0 +1,470 @@
+package vsafedb
+
+import (
+	"context"
+
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+)
+
+// The ContextRunner interfaces below are optional. A store implements them
+// when it can give up on an operation once a context is done. The Context
+// functions in this package take the plain Runner interfaces and call the
+// ContextRunner method when the store has it. Otherwise they check the
+// context once before calling the plain method.
+
+type UserByIdContextRunner interface {
+	// UserByIdContext works like UserById but gives up once ctx is done.
+	UserByIdContext(
+		ctx context.Context, t db.Transaction, id int64, user *vsafe.User) error
+}
+
+type UserByNameContextRunner interface {
+	// UserByNameContext works like UserByName but gives up once ctx is done.
+	UserByNameContext(
+		ctx context.Context,
+		t db.Transaction,
+		name string,
+		user *vsafe.User) error
+}
+
+type UsersByOwnerContextRunner interface {
+	// UsersByOwnerContext works like UsersByOwner but gives up once ctx is
+	// done.
+	UsersByOwnerContext(
+		ctx context.Context,
+		t db.Transaction,
+		owner int64,
+		consumer consume2.Consumer[vsafe.User]) error
+}
+
+type AddCategoryContextRunner interface {
+	// AddCategoryContext works like AddCategory but gives up once ctx is
+	// done.
+	AddCategoryContext(
+		ctx context.Context, t db.Transaction, category *vsafe.Category) error
+}
+
+type CategoryByIdContextRunner interface {
+	// CategoryByIdContext works like CategoryById but gives up once ctx is
+	// done.
+	CategoryByIdContext(
+		ctx context.Context,
+		t db.Transaction,
+		id int64,
+		category *vsafe.Category) error
+}
+
+type CategoriesByOwnerContextRunner interface {
+	// CategoriesByOwnerContext works like CategoriesByOwner but gives up
+	// once ctx is done.
+	CategoriesByOwnerContext(
+		ctx context.Context,
+		t db.Transaction,
+		owner int64) ([]vsafe.Category, error)
+}
+
+type CategoryCountsByOwnerContextRunner interface {
+	// CategoryCountsByOwnerContext works like CategoryCountsByOwner but
+	// gives up once ctx is done.
+	CategoryCountsByOwnerContext(
+		ctx context.Context,
+		t db.Transaction,
+		owner int64) ([]CategoryCount, error)
+}
+
+type UpdateCategoryContextRunner interface {
+	// UpdateCategoryContext works like UpdateCategory but gives up once ctx
+	// is done.
+	UpdateCategoryContext(
+		ctx context.Context, t db.Transaction, category *vsafe.Category) error
+}
+
+type RemoveCategoryContextRunner interface {
+	// RemoveCategoryContext works like RemoveCategory but gives up once ctx
+	// is done.
+	RemoveCategoryContext(
+		ctx context.Context, t db.Transaction, id int64) error
+}
+
+type AddEntryContextRunner interface {
+	// AddEntryContext works like AddEntry but gives up once ctx is done.
+	AddEntryContext(
+		ctx context.Context, t db.Transaction, entry *vsafe.Entry) error
+}
+
+type EntryByIdContextRunner interface {
+	// EntryByIdContext works like EntryById but gives up once ctx is done.
+	EntryByIdContext(
+		ctx context.Context,
+		t db.Transaction,
+		id int64,
+		entry *vsafe.Entry) error
+}
+
+type EntriesByOwnerContextRunner interface {
+	// EntriesByOwnerContext works like EntriesByOwner but gives up once ctx
+	// is done.
+	EntriesByOwnerContext(
+		ctx context.Context,
+		t db.Transaction,
+		owner int64,
+		consumer consume2.Consumer[vsafe.Entry]) error
+}
+
+type SearchEntriesContextRunner interface {
+	// SearchEntriesContext works like SearchEntries but gives up once ctx
+	// is done.
+	SearchEntriesContext(
+		ctx context.Context,
+		t db.Transaction,
+		owner int64,
+		query string,
+		consumer consume2.Consumer[vsafe.Entry]) error
+}
+
+type EntryPageContextRunner interface {
+	// EntryPageContext works like EntryPage but gives up once ctx is done.
+	EntryPageContext(
+		ctx context.Context,
+		t db.Transaction,
+		r *EntryRange,
+		offset, limit int,
+		consumer consume2.Consumer[vsafe.Entry]) (int, error)
+	// EntryPositionContext works like EntryPosition but gives up once ctx
+	// is done.
+	EntryPositionContext(
+		ctx context.Context,
+		t db.Transaction,
+		r *EntryRange,
+		id int64) (int, error)
+}
+
+type UpdateEntryContextRunner interface {
+	// UpdateEntryContext works like UpdateEntry but gives up once ctx is
+	// done.
+	UpdateEntryContext(
+		ctx context.Context, t db.Transaction, entry *vsafe.Entry) error
+}
+
+type RemoveEntryContextRunner interface {
+	// RemoveEntryContext works like RemoveEntry but gives up once ctx is
+	// done.
+	RemoveEntryContext(
+		ctx context.Context, t db.Transaction, id, owner int64) error
+}
+
+type SharesByRecipientContextRunner interface {
+	// SharesByRecipientContext works like SharesByRecipient but gives up
+	// once ctx is done.
+	SharesByRecipientContext(
+		ctx context.Context,
+		t db.Transaction,
+		recipient int64,
+		consumer consume2.Consumer[vsafe.Share]) error
+}
+
+type SharesBySenderContextRunner interface {
+	// SharesBySenderContext works like SharesBySender but gives up once ctx
+	// is done.
+	SharesBySenderContext(
+		ctx context.Context,
+		t db.Transaction,
+		sender int64,
+		consumer consume2.Consumer[vsafe.Share]) error
+}
+
+// UserByIdContext fetches the user with given id from store.
+func UserByIdContext(
+	ctx context.Context,
+	store UserByIdRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User) error {
+	if cstore, ok := store.(UserByIdContextRunner); ok {
+		return cstore.UserByIdContext(ctx, t, id, user)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.UserById(t, id, user)
+}
+
+// UserByNameContext fetches the user with given name from store.
+func UserByNameContext(
+	ctx context.Context,
+	store UserByNameRunner,
+	t db.Transaction,
+	name string,
+	user *vsafe.User) error {
+	if cstore, ok := store.(UserByNameContextRunner); ok {
+		return cstore.UserByNameContext(ctx, t, name, user)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.UserByName(t, name, user)
+}
+
+// UsersByOwnerContext sends owner and the users that owner owns to
+// consumer ordered by name.
+func UsersByOwnerContext(
+	ctx context.Context,
+	store UsersByOwnerRunner,
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.User]) error {
+	if cstore, ok := store.(UsersByOwnerContextRunner); ok {
+		return cstore.UsersByOwnerContext(ctx, t, owner, consumer)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.UsersByOwner(t, owner, consumer)
+}
+
+// AddCategoryContext adds a new category to store.
+func AddCategoryContext(
+	ctx context.Context,
+	store AddCategoryRunner,
+	t db.Transaction,
+	category *vsafe.Category) error {
+	if cstore, ok := store.(AddCategoryContextRunner); ok {
+		return cstore.AddCategoryContext(ctx, t, category)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.AddCategory(t, category)
+}
+
+// CategoriesByOwnerContext returns the categories of owner ordered by
+// name.
+func CategoriesByOwnerContext(
+	ctx context.Context,
+	store CategoriesByOwnerRunner,
+	t db.Transaction,
+	owner int64) ([]vsafe.Category, error) {
+	if cstore, ok := store.(CategoriesByOwnerContextRunner); ok {
+		return cstore.CategoriesByOwnerContext(ctx, t, owner)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return store.CategoriesByOwner(t, owner)
+}
+
+// SharesByRecipientContext sends the shares sent to recipient to consumer
+// ordered by id.
+func SharesByRecipientContext(
+	ctx context.Context,
+	store SharesByRecipientRunner,
+	t db.Transaction,
+	recipient int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	if cstore, ok := store.(SharesByRecipientContextRunner); ok {
+		return cstore.SharesByRecipientContext(ctx, t, recipient, consumer)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.SharesByRecipient(t, recipient, consumer)
+}
+
+// SharesBySenderContext sends the shares that sender sent to consumer
+// ordered by id.
+func SharesBySenderContext(
+	ctx context.Context,
+	store SharesBySenderRunner,
+	t db.Transaction,
+	sender int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	if cstore, ok := store.(SharesBySenderContextRunner); ok {
+		return cstore.SharesBySenderContext(ctx, t, sender, consumer)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.SharesBySender(t, sender, consumer)
+}
+
+func categoryById(
+	ctx context.Context,
+	store CategoryByIdRunner,
+	t db.Transaction,
+	id int64,
+	category *vsafe.Category) error {
+	if cstore, ok := store.(CategoryByIdContextRunner); ok {
+		return cstore.CategoryByIdContext(ctx, t, id, category)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.CategoryById(t, id, category)
+}
+
+func updateCategory(
+	ctx context.Context,
+	store UpdateCategoryRunner,
+	t db.Transaction,
+	category *vsafe.Category) error {
+	if cstore, ok := store.(UpdateCategoryContextRunner); ok {
+		return cstore.UpdateCategoryContext(ctx, t, category)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.UpdateCategory(t, category)
+}
+
+func removeCategory(
+	ctx context.Context,
+	store RemoveCategoryRunner,
+	t db.Transaction,
+	id int64) error {
+	if cstore, ok := store.(RemoveCategoryContextRunner); ok {
+		return cstore.RemoveCategoryContext(ctx, t, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.RemoveCategory(t, id)
+}
+
+func addEntry(
+	ctx context.Context,
+	store AddEntryRunner,
+	t db.Transaction,
+	entry *vsafe.Entry) error {
+	if cstore, ok := store.(AddEntryContextRunner); ok {
+		return cstore.AddEntryContext(ctx, t, entry)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.AddEntry(t, entry)
+}
+
+func entryById(
+	ctx context.Context,
+	store EntryByIdRunner,
+	t db.Transaction,
+	id int64,
+	entry *vsafe.Entry) error {
+	if cstore, ok := store.(EntryByIdContextRunner); ok {
+		return cstore.EntryByIdContext(ctx, t, id, entry)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.EntryById(t, id, entry)
+}
+
+func entriesByOwner(
+	ctx context.Context,
+	store EntriesByOwnerRunner,
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	if cstore, ok := store.(EntriesByOwnerContextRunner); ok {
+		return cstore.EntriesByOwnerContext(ctx, t, owner, consumer)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.EntriesByOwner(t, owner, consumer)
+}
+
+// searchEntries returns ErrSearchUnsupported if store implements neither
+// SearchEntriesContextRunner nor SearchRunner.
+func searchEntries(
+	ctx context.Context,
+	store interface{},
+	t db.Transaction,
+	owner int64,
+	query string,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	if cstore, ok := store.(SearchEntriesContextRunner); ok {
+		return cstore.SearchEntriesContext(ctx, t, owner, query, consumer)
+	}
+	sstore, ok := store.(SearchRunner)
+	if !ok {
+		return ErrSearchUnsupported
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return sstore.SearchEntries(t, owner, query, consumer)
+}
+
+// canReadEntryPage returns true if store implements EntryPageRunner or
+// EntryPageContextRunner.
+func canReadEntryPage(store interface{}) bool {
+	if _, ok := store.(EntryPageContextRunner); ok {
+		return true
+	}
+	_, ok := store.(EntryPageRunner)
+	return ok
+}
+
+// entryPage panics if canReadEntryPage(store) is false.
+func entryPage(
+	ctx context.Context,
+	store interface{},
+	t db.Transaction,
+	r *EntryRange,
+	offset, limit int,
+	consumer consume2.Consumer[vsafe.Entry]) (int, error) {
+	if cstore, ok := store.(EntryPageContextRunner); ok {
+		return cstore.EntryPageContext(ctx, t, r, offset, limit, consumer)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return store.(EntryPageRunner).EntryPage(t, r, offset, limit, consumer)
+}
+
+// entryPosition panics if canReadEntryPage(store) is false.
+func entryPosition(
+	ctx context.Context,
+	store interface{},
+	t db.Transaction,
+	r *EntryRange,
+	id int64) (int, error) {
+	if cstore, ok := store.(EntryPageContextRunner); ok {
+		return cstore.EntryPositionContext(ctx, t, r, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return store.(EntryPageRunner).EntryPosition(t, r, id)
+}
+
+func updateEntry(
+	ctx context.Context,
+	store UpdateEntryRunner,
+	t db.Transaction,
+	entry *vsafe.Entry) error {
+	if cstore, ok := store.(UpdateEntryContextRunner); ok {
+		return cstore.UpdateEntryContext(ctx, t, entry)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.UpdateEntry(t, entry)
+}
+
+func removeEntry(
+	ctx context.Context,
+	store RemoveEntryRunner,
+	t db.Transaction,
+	id, owner int64) error {
+	if cstore, ok := store.(RemoveEntryContextRunner); ok {
+		return cstore.RemoveEntryContext(ctx, t, id, owner)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.RemoveEntry(t, id, owner)
+}
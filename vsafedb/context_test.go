@@ -0,0 +1,99 @@
+package vsafedb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+)
+
+type kCtxKeyType int
+
+const kCtxKey kCtxKeyType = 0
+
+func TestContextFallback(t *testing.T) {
+	var store FakeStore
+	entry := vsafe.Entry{Title: "first"}
+	id, err := vsafedb.AddEntryContext(
+		context.Background(), &store, nil, kUser, kKey, &entry)
+	if err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var readEntry vsafe.Entry
+	if err := vsafedb.EntryByIdContext(
+		ctx, store, nil, id, kUser, kKey, &readEntry); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	cancel()
+	if err := vsafedb.EntryByIdContext(
+		ctx, store, nil, id, kUser, kKey, &readEntry); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := vsafedb.EntriesContext(
+		ctx, store, kUser, kKey, "", 0); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	err = vsafedb.UpdateEntryWithEtagContext(
+		ctx, store, kTransaction, id, 57, kUser, kKey, changeToAnEntry)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestContextRunner(t *testing.T) {
+	store := &contextStore{}
+	vsafedb.AddEntry(&store.FakeStore, nil, kUser, kKey, &vsafe.Entry{Title: "first"})
+	ctx := context.WithValue(context.Background(), kCtxKey, "request")
+	entries, err := vsafedb.EntriesContext(ctx, store, kUser, kKey, "", 0)
+	if err != nil {
+		t.Fatalf("Error reading entries: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %d", len(entries))
+	}
+	if store.ctx != ctx {
+		t.Error("Expected store to receive ctx")
+	}
+	store.ctx = nil
+	err = vsafedb.UpdateEntryWithEtagContext(
+		ctx, store, kTransaction, 1, 57, kUser, kKey, changeToAnEntry)
+	if err != nil {
+		t.Fatalf("Error updating entry: %v", err)
+	}
+	if store.ctx != ctx {
+		t.Error("Expected store to receive ctx")
+	}
+}
+
+// contextStore is a FakeStore that records the context passed to it.
+type contextStore struct {
+	FakeStore
+	ctx context.Context
+}
+
+func (c *contextStore) EntryByIdContext(
+	ctx context.Context,
+	t db.Transaction,
+	id int64,
+	entry *vsafe.Entry) error {
+	c.ctx = ctx
+	return c.FakeStore.EntryById(t, id, entry)
+}
+
+func (c *contextStore) EntriesByOwnerContext(
+	ctx context.Context,
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	c.ctx = ctx
+	return c.FakeStore.EntriesByOwner(t, owner, consumer)
+}
+
+var (
+	_ vsafedb.EntryByIdContextRunner      = (*contextStore)(nil)
+	_ vsafedb.EntriesByOwnerContextRunner = (*contextStore)(nil)
+)
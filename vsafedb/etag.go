@@ -0,0 +1,22 @@
+package vsafedb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+// Etag returns the etag of a stored entry given the fields of its row.
+// Stores whose database does not compute etags use Etag. Each field goes
+// into the hash with its length in front so that rows with different
+// fields always hash different input.
+func Etag(fields ...interface{}) uint64 {
+	h := fnv.New64a()
+	var length [binary.MaxVarintLen64]byte
+	for _, field := range fields {
+		s := fmt.Sprint(field)
+		h.Write(length[:binary.PutUvarint(length[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+	return h.Sum64()
+}
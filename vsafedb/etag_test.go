@@ -0,0 +1,18 @@
+package vsafedb_test
+
+import (
+	"github.com/keep94/vsafe/vsafedb"
+	"testing"
+)
+
+func TestEtag(t *testing.T) {
+	if vsafedb.Etag(int64(1), "a", "b") != vsafedb.Etag(int64(1), "a", "b") {
+		t.Error("Expected same fields to have same etag")
+	}
+	if vsafedb.Etag("a b", "") == vsafedb.Etag("a", "b") {
+		t.Error("Expected moving a space between fields to change etag")
+	}
+	if vsafedb.Etag("ab", "c") == vsafedb.Etag("a", "bc") {
+		t.Error("Expected moving text between fields to change etag")
+	}
+}
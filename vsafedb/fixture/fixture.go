@@ -3,12 +3,15 @@
 package fixture
 
 import (
+	"fmt"
 	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/idset"
 	"github.com/keep94/vsafe"
 	"github.com/keep94/vsafe/vsafedb"
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const (
@@ -29,30 +32,37 @@ var (
 		Checksum: "baz",
 	}
 	kSecondUser = &vsafe.User{
-		Name:     "blow",
-		Key:      "slow",
-		Checksum: "mow",
+		Name:             "blow",
+		Key:              "slow",
+		Checksum:         "mow",
+		IdleTimeout:      15 * time.Minute,
+		SessionLifetime:  4 * time.Hour,
+		Role:             vsafe.RoleEditor,
+		Categories:       "2,5",
+		PublicKey:        "public",
+		PrivateKey:       "private",
+		PasswordHistory:  "old1,old2",
+		MemberPublicKey:  "member public",
+		MemberPrivateKey: "member private",
 	}
 	kFirstEntry = &vsafe.Entry{
-		Owner:      kOwner,
-		Url:        url1,
-		Title:      "zbar",
-		Desc:       "baz",
-		UName:      "keep94",
-		Password:   "password",
-		Special:    "special",
-		Categories: "3,7",
+		Owner:    kOwner,
+		Url:      url1,
+		Title:    "zbar",
+		Desc:     "baz",
+		UName:    "keep94",
+		Password: "password",
+		Special:  "special",
 	}
 
 	kSecondEntry = &vsafe.Entry{
-		Owner:      kOwner,
-		Url:        url2,
-		Title:      "title",
-		Desc:       "desc",
-		UName:      "keep95",
-		Password:   "loco",
-		Special:    "never",
-		Categories: "2",
+		Owner:    kOwner,
+		Url:      url2,
+		Title:    "title",
+		Desc:     "desc",
+		UName:    "keep95",
+		Password: "loco",
+		Special:  "never",
 	}
 )
 
@@ -71,6 +81,11 @@ type UsersStore interface {
 	vsafedb.UsersRunner
 }
 
+type UsersByOwnerStore interface {
+	vsafedb.AddUserRunner
+	vsafedb.UsersByOwnerRunner
+}
+
 type UpdateUserStore interface {
 	UserByIdStore
 	vsafedb.UpdateUserRunner
@@ -101,16 +116,26 @@ type RemoveCategoryStore interface {
 	vsafedb.RemoveCategoryRunner
 }
 
-type EntryByIdStore interface {
+type AddEntryStore interface {
+	vsafedb.AddCategoryRunner
 	vsafedb.AddEntryRunner
+}
+
+type EntryByIdStore interface {
+	AddEntryStore
 	vsafedb.EntryByIdRunner
 }
 
 type EntriesByOwnerStore interface {
-	vsafedb.AddEntryRunner
+	AddEntryStore
 	vsafedb.EntriesByOwnerRunner
 }
 
+type CategoryCountsStore interface {
+	AddEntryStore
+	vsafedb.CategoryCountsStore
+}
+
 type UpdateEntryStore interface {
 	EntryByIdStore
 	vsafedb.UpdateEntryRunner
@@ -121,6 +146,39 @@ type RemoveEntryStore interface {
 	vsafedb.RemoveEntryRunner
 }
 
+type ShareByIdStore interface {
+	vsafedb.AddShareRunner
+	vsafedb.ShareByIdRunner
+}
+
+type SharesStore interface {
+	vsafedb.AddShareRunner
+	vsafedb.SharesByRecipientRunner
+	vsafedb.SharesBySenderRunner
+}
+
+type UpdateShareStore interface {
+	ShareByIdStore
+	vsafedb.UpdateShareRunner
+}
+
+type RemoveShareStore interface {
+	ShareByIdStore
+	vsafedb.RemoveShareRunner
+}
+
+type CollectionByIdStore interface {
+	vsafedb.AddCollectionRunner
+	vsafedb.CollectionByIdRunner
+}
+
+type MembershipsStore interface {
+	vsafedb.AddMembershipRunner
+	vsafedb.MembershipsByUserRunner
+	vsafedb.MembershipsByCollectionRunner
+	vsafedb.RemoveMembershipRunner
+}
+
 func UserById(t *testing.T, store UserByIdStore) {
 	var first, second vsafe.User
 	var firstResult, secondResult vsafe.User
@@ -169,12 +227,41 @@ func Users(t *testing.T, store UsersStore) {
 	}
 }
 
+func UsersByOwner(t *testing.T, store UsersByOwnerStore) {
+	var first, second vsafe.User
+	createUsers(t, store, &first, &second)
+	sub := vsafe.User{Owner: first.Id, Name: "sub", Key: "k", Checksum: "c"}
+	createUser(t, store, &sub, &sub)
+	var users []*vsafe.User
+	if err := store.UsersByOwner(
+		nil, first.Id, consume2.AppendPtrsTo(&users)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if out := len(users); out != 2 {
+		t.Fatalf("Expected 2, got %d", out)
+	}
+	assertUserEqual(t, &first, users[0])
+	assertUserEqual(t, &sub, users[1])
+	users = nil
+	if err := store.UsersByOwner(
+		nil, second.Id, consume2.AppendPtrsTo(&users)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if out := len(users); out != 1 {
+		t.Fatalf("Expected 1, got %d", out)
+	}
+	assertUserEqual(t, &second, users[0])
+}
+
 func UpdateUser(t *testing.T, store UpdateUserStore) {
 	var first, second vsafe.User
 	var firstResult, secondResult vsafe.User
 	createUsers(t, store, &first, &second)
 	first.Name = "John Doe"
 	first.Key = "John Doe Key"
+	first.IdleTimeout = 10 * time.Minute
+	first.Role = vsafe.RoleReadOnly
+	first.Categories = "7"
 	if err := store.UpdateUser(nil, &first); err != nil {
 		t.Fatalf("Got error updating user: %v", err)
 	}
@@ -191,6 +278,19 @@ func UpdateUser(t *testing.T, store UpdateUserStore) {
 	}
 }
 
+func UserNameTaken(t *testing.T, store UpdateUserStore) {
+	var first, second vsafe.User
+	createUsers(t, store, &first, &second)
+	duplicate := *kFirstUser
+	if err := store.AddUser(nil, &duplicate); err != vsafedb.ErrNameTaken {
+		t.Errorf("Expected ErrNameTaken, got %v", err)
+	}
+	second.Name = first.Name
+	if err := store.UpdateUser(nil, &second); err != vsafedb.ErrNameTaken {
+		t.Errorf("Expected ErrNameTaken, got %v", err)
+	}
+}
+
 func RemoveUser(t *testing.T, store RemoveUserStore) {
 	var first, second vsafe.User
 	var firstResult vsafe.User
@@ -266,6 +366,25 @@ func RemoveCategory(t *testing.T, store RemoveCategoryStore) {
 	assertCategoryNames(t, categories, "one", "two")
 }
 
+func CategoryCountsByOwner(t *testing.T, store CategoryCountsStore) {
+	var first, second vsafe.Entry
+	createEntries(t, store, &first, &second)
+	empty := vsafe.Category{Name: "empty", Owner: kOwner}
+	createCategory(t, store, &empty)
+	counts, err := vsafedb.CategoryCountsByOwner(store, nil, kOwner)
+	if err != nil {
+		t.Fatalf("Got error reading counts: %v", err)
+	}
+	var actual []string
+	for _, count := range counts {
+		actual = append(actual, fmt.Sprintf("%s:%d", count.Name, count.Entries))
+	}
+	expected := []string{"empty:0", "one:1", "two:2"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
 func EntryById(t *testing.T, store EntryByIdStore) {
 	var first, second vsafe.Entry
 	var firstResult, secondResult vsafe.Entry
@@ -313,6 +432,10 @@ func UpdateEntry(t *testing.T, store UpdateEntryStore) {
 	first.UName = "back"
 	first.Password = "aardvark"
 	first.Special = "new again"
+	// Entries may only be in categories of their own owner
+	moved := vsafe.Category{Name: "moved", Owner: 23}
+	createCategory(t, store, &moved)
+	first.Categories = idset.New(map[int64]bool{moved.Id: true})
 	if err := store.UpdateEntry(nil, &first); err != nil {
 		t.Fatalf("Got error updating database: %v", err)
 	}
@@ -344,6 +467,157 @@ func RemoveEntry(t *testing.T, store RemoveEntryStore) {
 	}
 }
 
+func ShareById(t *testing.T, store ShareByIdStore) {
+	var first, second vsafe.Share
+	var firstResult, secondResult vsafe.Share
+	createShares(t, store, &first, &second)
+	if err := store.ShareById(nil, first.Id, &firstResult); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	if err := store.ShareById(nil, second.Id, &secondResult); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	assertShareEqual(t, &first, &firstResult)
+	assertShareEqual(t, &second, &secondResult)
+	if err := store.ShareById(nil, kBadId, &firstResult); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+}
+
+func Shares(t *testing.T, store SharesStore) {
+	var first, second vsafe.Share
+	createShares(t, store, &first, &second)
+	var received []vsafe.Share
+	if err := store.SharesByRecipient(
+		nil, kOwner+1, consume2.AppendTo(&received)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 share, got %d", len(received))
+	}
+	assertShareEqual(t, &first, &received[0])
+	var sent []vsafe.Share
+	if err := store.SharesBySender(
+		nil, kOwner, consume2.AppendTo(&sent)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 shares, got %d", len(sent))
+	}
+	assertShareEqual(t, &first, &sent[0])
+	assertShareEqual(t, &second, &sent[1])
+}
+
+func UpdateShare(t *testing.T, store UpdateShareStore) {
+	var first, second vsafe.Share
+	createShares(t, store, &first, &second)
+	first.Sender = kOwner + 3
+	first.Recipient = kOwner + 4
+	if err := store.UpdateShare(nil, &first); err != nil {
+		t.Fatalf("Got error updating share: %v", err)
+	}
+	var firstResult, secondResult vsafe.Share
+	if err := store.ShareById(nil, first.Id, &firstResult); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	if err := store.ShareById(nil, second.Id, &secondResult); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	assertShareEqual(t, &first, &firstResult)
+	assertShareEqual(t, &second, &secondResult)
+}
+
+func RemoveShare(t *testing.T, store RemoveShareStore) {
+	var first, second vsafe.Share
+	var result vsafe.Share
+	createShares(t, store, &first, &second)
+	if err := store.RemoveShare(nil, first.Id); err != nil {
+		t.Fatalf("Got error removing by id: %v", err)
+	}
+	if err := store.ShareById(nil, first.Id, &result); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if err := store.ShareById(nil, second.Id, &result); err != nil {
+		t.Errorf("Got error reading database: %v", err)
+	}
+}
+
+func CollectionById(t *testing.T, store CollectionByIdStore) {
+	first := vsafe.Collection{Name: "team"}
+	second := vsafe.Collection{Name: "family"}
+	if err := store.AddCollection(nil, &first); err != nil {
+		t.Fatalf("Got %v adding to store", err)
+	}
+	if err := store.AddCollection(nil, &second); err != nil {
+		t.Fatalf("Got %v adding to store", err)
+	}
+	var result vsafe.Collection
+	if err := store.CollectionById(nil, second.Id, &result); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	if result != second {
+		t.Errorf("Expected %v, got %v", second, result)
+	}
+	if err := store.CollectionById(nil, kBadId, &result); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+}
+
+func Memberships(t *testing.T, store MembershipsStore) {
+	first := vsafe.Membership{Collection: 3, User: kOwner, Key: "abc"}
+	second := vsafe.Membership{Collection: 4, User: kOwner, Key: "def"}
+	third := vsafe.Membership{Collection: 3, User: kOwner + 1, Key: "ghi"}
+	for _, m := range []*vsafe.Membership{&first, &second, &third} {
+		if err := store.AddMembership(nil, m); err != nil {
+			t.Fatalf("Got %v adding to store", err)
+		}
+	}
+	var byUser []vsafe.Membership
+	if err := store.MembershipsByUser(
+		nil, kOwner, consume2.AppendTo(&byUser)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	expected := []vsafe.Membership{first, second}
+	if !reflect.DeepEqual(expected, byUser) {
+		t.Errorf("Expected %v, got %v", expected, byUser)
+	}
+	if err := store.RemoveMembership(nil, first.Id); err != nil {
+		t.Fatalf("Got error removing by id: %v", err)
+	}
+	var byCollection []vsafe.Membership
+	if err := store.MembershipsByCollection(
+		nil, 3, consume2.AppendTo(&byCollection)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	expected = []vsafe.Membership{third}
+	if !reflect.DeepEqual(expected, byCollection) {
+		t.Errorf("Expected %v, got %v", expected, byCollection)
+	}
+}
+
+func createShares(
+	t *testing.T,
+	store vsafedb.AddShareRunner,
+	first *vsafe.Share,
+	second *vsafe.Share) {
+	*first = vsafe.Share{
+		Sender: kOwner, Recipient: kOwner + 1, EntryId: 3, Payload: "abc"}
+	if err := store.AddShare(nil, first); err != nil {
+		t.Fatalf("Got %v adding to store", err)
+	}
+	*second = vsafe.Share{
+		Sender: kOwner, Recipient: kOwner + 2, EntryId: 4, Payload: "def"}
+	if err := store.AddShare(nil, second); err != nil {
+		t.Fatalf("Got %v adding to store", err)
+	}
+}
+
+func assertShareEqual(t *testing.T, expected, actual *vsafe.Share) {
+	if *expected != *actual {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
 func createUsers(
 	t *testing.T,
 	store vsafedb.AddUserRunner,
@@ -392,19 +666,31 @@ func createCategory(
 
 func createEntries(
 	t *testing.T,
-	store vsafedb.AddEntryRunner,
+	store AddEntryStore,
 	first *vsafe.Entry,
 	second *vsafe.Entry) {
-	createEntry(t, store, kFirstEntry, first)
-	createEntry(t, store, kSecondEntry, second)
+	one := vsafe.Category{Name: "one", Owner: kOwner}
+	createCategory(t, store, &one)
+	two := vsafe.Category{Name: "two", Owner: kOwner}
+	createCategory(t, store, &two)
+	createEntry(
+		t,
+		store,
+		kFirstEntry,
+		idset.New(map[int64]bool{one.Id: true, two.Id: true}),
+		first)
+	createEntry(
+		t, store, kSecondEntry, idset.New(map[int64]bool{two.Id: true}), second)
 }
 
 func createEntry(
 	t *testing.T,
 	store vsafedb.AddEntryRunner,
 	toBeAdded *vsafe.Entry,
+	categories idset.IdSet,
 	result *vsafe.Entry) {
 	*result = *toBeAdded
+	result.Categories = categories
 	if err := store.AddEntry(nil, result); err != nil {
 		t.Fatalf("Got %v adding to store", err)
 	}
@@ -0,0 +1,40 @@
+package fixture
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	// Names the environment variable holding the URL of the PostgreSQL
+	// database to test against, e.g
+	// postgres://vsafe@localhost/vsafe_test?sslmode=disable. Tests erase
+	// everything in that database.
+	PostgresUrlEnv = "VSAFE_POSTGRES_URL"
+)
+
+// PostgresUrl returns the URL of the PostgreSQL database to test against.
+// If PostgresUrlEnv is not set, PostgresUrl skips the test. Since tests
+// erase everything in the database, PostgresUrl fails the test if the
+// database name does not contain "test".
+func PostgresUrl(t *testing.T) string {
+	t.Helper()
+	rawUrl := os.Getenv(PostgresUrlEnv)
+	if rawUrl == "" {
+		t.Skipf("%s not set", PostgresUrlEnv)
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatalf("Error parsing %s: %v", PostgresUrlEnv, err)
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if !strings.Contains(strings.ToLower(name), "test") {
+		t.Fatalf(
+			"Refusing to erase database %q; %s must name a test database",
+			name,
+			PostgresUrlEnv)
+	}
+	return rawUrl
+}
@@ -0,0 +1,663 @@
+// Package for_bolt provides a bolt implementation of interfaces in vsafedb
+// package. bolt is an embedded key-value database written in pure Go, so
+// unlike for_sqlite, for_bolt needs no C compiler.
+//
+// Each kind of record lives in its own bucket keyed by id. Secondary
+// indexes on user name and on owner, sender, recipient, user, and
+// collection ids live in buckets of their own and are kept up to date by
+// every add, update, and remove.
+package for_bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/url"
+	"sort"
+
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/idset"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	bolt "go.etcd.io/bbolt"
+)
+
+// kUsersByName maps each user name to the id of its user.
+var kUsersByName = []byte("users_by_name")
+
+var (
+	kUsersByOwner = &index[vsafe.User]{
+		bucket: []byte("users_by_owner"),
+		key:    func(u *vsafe.User) int64 { return u.Owner },
+	}
+	kUsers = &table[vsafe.User]{
+		bucket:  []byte("users"),
+		id:      func(u *vsafe.User) *int64 { return &u.Id },
+		indexes: []*index[vsafe.User]{kUsersByOwner},
+	}
+	kCategoriesByOwner = &index[vsafe.Category]{
+		bucket: []byte("categories_by_owner"),
+		key:    func(c *vsafe.Category) int64 { return c.Owner },
+	}
+	kCategories = &table[vsafe.Category]{
+		bucket:  []byte("categories"),
+		id:      func(c *vsafe.Category) *int64 { return &c.Id },
+		indexes: []*index[vsafe.Category]{kCategoriesByOwner},
+	}
+	kEntriesByOwner = &index[rawEntry]{
+		bucket: []byte("entries_by_owner"),
+		key:    func(e *rawEntry) int64 { return e.Owner },
+	}
+	kEntries = &table[rawEntry]{
+		bucket:  []byte("entries"),
+		id:      func(e *rawEntry) *int64 { return &e.Id },
+		indexes: []*index[rawEntry]{kEntriesByOwner},
+	}
+	kSharesByRecipient = &index[vsafe.Share]{
+		bucket: []byte("shares_by_recipient"),
+		key:    func(s *vsafe.Share) int64 { return s.Recipient },
+	}
+	kSharesBySender = &index[vsafe.Share]{
+		bucket: []byte("shares_by_sender"),
+		key:    func(s *vsafe.Share) int64 { return s.Sender },
+	}
+	kShares = &table[vsafe.Share]{
+		bucket: []byte("shares"),
+		id:     func(s *vsafe.Share) *int64 { return &s.Id },
+		indexes: []*index[vsafe.Share]{
+			kSharesByRecipient, kSharesBySender},
+	}
+	kCollections = &table[vsafe.Collection]{
+		bucket: []byte("collections"),
+		id:     func(c *vsafe.Collection) *int64 { return &c.Id },
+	}
+	kMembershipsByUser = &index[vsafe.Membership]{
+		bucket: []byte("memberships_by_user"),
+		key:    func(m *vsafe.Membership) int64 { return m.User },
+	}
+	kMembershipsByCollection = &index[vsafe.Membership]{
+		bucket: []byte("memberships_by_collection"),
+		key:    func(m *vsafe.Membership) int64 { return m.Collection },
+	}
+	kMemberships = &table[vsafe.Membership]{
+		bucket: []byte("memberships"),
+		id:     func(m *vsafe.Membership) *int64 { return &m.Id },
+		indexes: []*index[vsafe.Membership]{
+			kMembershipsByUser, kMembershipsByCollection},
+	}
+)
+
+// NewDoer returns a db.Doer for bdb. Each transaction is a read-write bolt
+// transaction, so only one runs at a time. If the action returns an error
+// or panics, none of its changes take effect. Actions must pass the
+// transaction they are given to each Store method; passing nil from within
+// an action deadlocks.
+func NewDoer(bdb *bolt.DB) db.Doer {
+	return doer{bdb}
+}
+
+type doer struct {
+	db *bolt.DB
+}
+
+func (d doer) Do(action db.Action) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return action(tx)
+	})
+}
+
+type Store struct {
+	db *bolt.DB
+}
+
+// New creates a bolt implementation of the vsafe app datastore. The
+// buckets in bdb must already be set up with bolt_setup.
+func New(bdb *bolt.DB) Store {
+	return Store{bdb}
+}
+
+func (s Store) AddUser(t db.Transaction, user *vsafe.User) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		if nameTaken(tx, 0, user.Name) {
+			return vsafedb.ErrNameTaken
+		}
+		if err := kUsers.add(tx, user); err != nil {
+			return err
+		}
+		return tx.Bucket(kUsersByName).Put([]byte(user.Name), itob(user.Id))
+	})
+}
+
+func (s Store) UserById(
+	t db.Transaction, id int64, user *vsafe.User) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		return kUsers.get(tx, id, user)
+	})
+}
+
+func (s Store) UserByName(
+	t db.Transaction, name string, user *vsafe.User) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		id := tx.Bucket(kUsersByName).Get([]byte(name))
+		if id == nil {
+			return vsafedb.ErrNoSuchId
+		}
+		return kUsers.get(tx, btoi(id), user)
+	})
+}
+
+func (s Store) Users(
+	t db.Transaction, consumer consume2.Consumer[vsafe.User]) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kUsersByName).Cursor()
+		for k, v := cursor.First(); k != nil && consumer.CanConsume(); k, v = cursor.Next() {
+			var user vsafe.User
+			if err := kUsers.get(tx, btoi(v), &user); err != nil {
+				return err
+			}
+			consumer.Consume(user)
+		}
+		return nil
+	})
+}
+
+func (s Store) UsersByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.User]) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		users, err := kUsers.byIndex(tx, kUsersByOwner, owner)
+		if err != nil {
+			return err
+		}
+		var user vsafe.User
+		err = kUsers.get(tx, owner, &user)
+		if err == nil && user.Owner != owner {
+			users = append(users, user)
+		} else if err != nil && err != vsafedb.ErrNoSuchId {
+			return err
+		}
+		sort.SliceStable(users, func(i, j int) bool {
+			return users[i].Name < users[j].Name
+		})
+		consumeAll(users, consumer)
+		return nil
+	})
+}
+
+func (s Store) UpdateUser(t db.Transaction, user *vsafe.User) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		if nameTaken(tx, user.Id, user.Name) {
+			return vsafedb.ErrNameTaken
+		}
+		var old vsafe.User
+		if err := kUsers.get(tx, user.Id, &old); err != nil {
+			return ignoreNoSuchId(err)
+		}
+		byName := tx.Bucket(kUsersByName)
+		if err := byName.Delete([]byte(old.Name)); err != nil {
+			return err
+		}
+		if err := kUsers.update(tx, user); err != nil {
+			return err
+		}
+		return byName.Put([]byte(user.Name), itob(user.Id))
+	})
+}
+
+func (s Store) RemoveUser(t db.Transaction, name string) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		byName := tx.Bucket(kUsersByName)
+		id := byName.Get([]byte(name))
+		if id == nil {
+			return nil
+		}
+		if err := kUsers.remove(tx, btoi(id)); err != nil {
+			return err
+		}
+		return byName.Delete([]byte(name))
+	})
+}
+
+func (s Store) AddCategory(
+	t db.Transaction, category *vsafe.Category) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kCategories.add(tx, category)
+	})
+}
+
+func (s Store) CategoriesByOwner(
+	t db.Transaction, owner int64) (result []vsafe.Category, err error) {
+	err = s.view(t, func(tx *bolt.Tx) (err error) {
+		result, err = kCategories.byIndex(tx, kCategoriesByOwner, owner)
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return
+	})
+	return
+}
+
+func (s Store) CategoryById(
+	t db.Transaction, id int64, category *vsafe.Category) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		return kCategories.get(tx, id, category)
+	})
+}
+
+func (s Store) UpdateCategory(
+	t db.Transaction, category *vsafe.Category) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kCategories.update(tx, category)
+	})
+}
+
+func (s Store) RemoveCategory(t db.Transaction, id int64) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kCategories.remove(tx, id)
+	})
+}
+
+func (s Store) AddEntry(t db.Transaction, entry *vsafe.Entry) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		raw := toRawEntry(entry)
+		if err := kEntries.add(tx, &raw); err != nil {
+			return err
+		}
+		entry.Id = raw.Id
+		return nil
+	})
+}
+
+func (s Store) EntryById(
+	t db.Transaction, id int64, entry *vsafe.Entry) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		var raw rawEntry
+		if err := kEntries.get(tx, id, &raw); err != nil {
+			return err
+		}
+		return raw.toEntry(entry)
+	})
+}
+
+func (s Store) EntriesByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		raws, err := kEntries.byIndex(tx, kEntriesByOwner, owner)
+		if err != nil {
+			return err
+		}
+		for i := range raws {
+			if !consumer.CanConsume() {
+				break
+			}
+			var entry vsafe.Entry
+			if err := raws[i].toEntry(&entry); err != nil {
+				return err
+			}
+			consumer.Consume(entry)
+		}
+		return nil
+	})
+}
+
+func (s Store) UpdateEntry(t db.Transaction, entry *vsafe.Entry) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		raw := toRawEntry(entry)
+		return kEntries.update(tx, &raw)
+	})
+}
+
+func (s Store) RemoveEntry(t db.Transaction, id, owner int64) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		var raw rawEntry
+		if err := kEntries.get(tx, id, &raw); err != nil {
+			return ignoreNoSuchId(err)
+		}
+		if raw.Owner != owner {
+			return nil
+		}
+		return kEntries.remove(tx, id)
+	})
+}
+
+func (s Store) AddShare(t db.Transaction, share *vsafe.Share) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kShares.add(tx, share)
+	})
+}
+
+func (s Store) ShareById(
+	t db.Transaction, id int64, share *vsafe.Share) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		return kShares.get(tx, id, share)
+	})
+}
+
+func (s Store) SharesByRecipient(
+	t db.Transaction,
+	recipient int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		return kShares.consumeByIndex(
+			tx, kSharesByRecipient, recipient, consumer)
+	})
+}
+
+func (s Store) SharesBySender(
+	t db.Transaction,
+	sender int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		return kShares.consumeByIndex(tx, kSharesBySender, sender, consumer)
+	})
+}
+
+func (s Store) UpdateShare(t db.Transaction, share *vsafe.Share) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kShares.update(tx, share)
+	})
+}
+
+func (s Store) RemoveShare(t db.Transaction, id int64) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kShares.remove(tx, id)
+	})
+}
+
+func (s Store) AddCollection(
+	t db.Transaction, collection *vsafe.Collection) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kCollections.add(tx, collection)
+	})
+}
+
+func (s Store) CollectionById(
+	t db.Transaction, id int64, collection *vsafe.Collection) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		return kCollections.get(tx, id, collection)
+	})
+}
+
+func (s Store) AddMembership(
+	t db.Transaction, membership *vsafe.Membership) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kMemberships.add(tx, membership)
+	})
+}
+
+func (s Store) MembershipsByUser(
+	t db.Transaction,
+	userId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		return kMemberships.consumeByIndex(
+			tx, kMembershipsByUser, userId, consumer)
+	})
+}
+
+func (s Store) MembershipsByCollection(
+	t db.Transaction,
+	collectionId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	return s.view(t, func(tx *bolt.Tx) error {
+		return kMemberships.consumeByIndex(
+			tx, kMembershipsByCollection, collectionId, consumer)
+	})
+}
+
+func (s Store) RemoveMembership(t db.Transaction, id int64) error {
+	return s.update(t, func(tx *bolt.Tx) error {
+		return kMemberships.remove(tx, id)
+	})
+}
+
+// view runs f in a read-only transaction of its own if t is nil or in t
+// otherwise.
+func (s Store) view(t db.Transaction, f func(tx *bolt.Tx) error) error {
+	if t == nil {
+		return s.db.View(f)
+	}
+	return f(s.tx(t))
+}
+
+// update runs f in a read-write transaction of its own if t is nil or in
+// t otherwise.
+func (s Store) update(t db.Transaction, f func(tx *bolt.Tx) error) error {
+	if t == nil {
+		return s.db.Update(f)
+	}
+	return f(s.tx(t))
+}
+
+func (s Store) tx(t db.Transaction) *bolt.Tx {
+	tx := t.(*bolt.Tx)
+	if tx.DB() != s.db {
+		panic("for_bolt: Transaction belongs to a different DB")
+	}
+	return tx
+}
+
+// nameTaken returns true if a user other than the one with given id has
+// name. Use 0 for id when adding a user.
+func nameTaken(tx *bolt.Tx, id int64, name string) bool {
+	otherId := tx.Bucket(kUsersByName).Get([]byte(name))
+	return otherId != nil && btoi(otherId) != id
+}
+
+// table stores rows of type T as JSON in a bucket keyed by id. Like sqlite
+// AUTOINCREMENT, table never reuses the id of a removed row.
+type table[T any] struct {
+	bucket  []byte
+	id      func(row *T) *int64
+	indexes []*index[T]
+}
+
+// index maps a foreign id such as an owner to the rows that have it. Its
+// keys are the foreign id followed by the row id, both big-endian, so the
+// rows for one foreign id are adjacent and ordered by id.
+type index[T any] struct {
+	bucket []byte
+	key    func(row *T) int64
+}
+
+func (ix *index[T]) put(tx *bolt.Tx, row *T, id int64) error {
+	return tx.Bucket(ix.bucket).Put(indexKey(ix.key(row), id), nil)
+}
+
+func (ix *index[T]) delete(tx *bolt.Tx, row *T, id int64) error {
+	return tx.Bucket(ix.bucket).Delete(indexKey(ix.key(row), id))
+}
+
+// add stores row under a new id which it writes to the Id field of row.
+func (t *table[T]) add(tx *bolt.Tx, row *T) error {
+	seq, err := tx.Bucket(t.bucket).NextSequence()
+	if err != nil {
+		return err
+	}
+	id := t.id(row)
+	*id = int64(seq)
+	if err := t.put(tx, row); err != nil {
+		return err
+	}
+	for _, ix := range t.indexes {
+		if err := ix.put(tx, row, *id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *table[T]) get(tx *bolt.Tx, id int64, row *T) error {
+	value := tx.Bucket(t.bucket).Get(itob(id))
+	if value == nil {
+		return vsafedb.ErrNoSuchId
+	}
+	return json.Unmarshal(value, row)
+}
+
+// update replaces the row with the same id as row. Like a SQL update, it
+// does nothing if there is no such row.
+func (t *table[T]) update(tx *bolt.Tx, row *T) error {
+	id := *t.id(row)
+	var old T
+	if err := t.get(tx, id, &old); err != nil {
+		return ignoreNoSuchId(err)
+	}
+	for _, ix := range t.indexes {
+		if err := ix.delete(tx, &old, id); err != nil {
+			return err
+		}
+		if err := ix.put(tx, row, id); err != nil {
+			return err
+		}
+	}
+	return t.put(tx, row)
+}
+
+// remove removes the row with given id if there is one.
+func (t *table[T]) remove(tx *bolt.Tx, id int64) error {
+	var old T
+	if err := t.get(tx, id, &old); err != nil {
+		return ignoreNoSuchId(err)
+	}
+	for _, ix := range t.indexes {
+		if err := ix.delete(tx, &old, id); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(t.bucket).Delete(itob(id))
+}
+
+// byIndex returns the rows whose value for ix is key ordered by id.
+func (t *table[T]) byIndex(tx *bolt.Tx, ix *index[T], key int64) (
+	[]T, error) {
+	var result []T
+	err := t.scanIndex(tx, ix, key, func(row T) bool {
+		result = append(result, row)
+		return true
+	})
+	return result, err
+}
+
+// consumeByIndex sends the rows whose value for ix is key to consumer
+// ordered by id.
+func (t *table[T]) consumeByIndex(
+	tx *bolt.Tx, ix *index[T], key int64, consumer consume2.Consumer[T]) error {
+	return t.scanIndex(tx, ix, key, func(row T) bool {
+		if !consumer.CanConsume() {
+			return false
+		}
+		consumer.Consume(row)
+		return true
+	})
+}
+
+func (t *table[T]) scanIndex(
+	tx *bolt.Tx, ix *index[T], key int64, f func(row T) bool) error {
+	prefix := itob(key)
+	cursor := tx.Bucket(ix.bucket).Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		var row T
+		if err := t.get(tx, btoi(k[len(prefix):]), &row); err != nil {
+			return err
+		}
+		if !f(row) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (t *table[T]) put(tx *bolt.Tx, row *T) error {
+	value, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(t.bucket).Put(itob(*t.id(row)), value)
+}
+
+// rawEntry is how for_bolt stores a vsafe.Entry.
+type rawEntry struct {
+	Id         int64
+	Owner      int64
+	Url        string
+	Title      string
+	Desc       string
+	UName      string
+	Password   string
+	Special    string
+	Categories idset.IdSet
+}
+
+func toRawEntry(entry *vsafe.Entry) rawEntry {
+	result := rawEntry{
+		Id:         entry.Id,
+		Owner:      entry.Owner,
+		Title:      entry.Title,
+		Desc:       entry.Desc,
+		UName:      entry.UName,
+		Password:   entry.Password,
+		Special:    entry.Special,
+		Categories: entry.Categories,
+	}
+	if entry.Url != nil {
+		result.Url = entry.Url.String()
+	}
+	return result
+}
+
+func (r *rawEntry) toEntry(entry *vsafe.Entry) error {
+	*entry = vsafe.Entry{
+		Id:         r.Id,
+		Owner:      r.Owner,
+		Title:      r.Title,
+		Desc:       r.Desc,
+		UName:      r.UName,
+		Password:   r.Password,
+		Special:    r.Special,
+		Categories: r.Categories,
+	}
+	if r.Url != "" {
+		var err error
+		if entry.Url, err = url.Parse(r.Url); err != nil {
+			return err
+		}
+	}
+	entry.Etag = vsafedb.Etag(
+		r.Owner, r.Url, r.Title, r.Desc, r.UName, r.Password, r.Special, string(r.Categories), r.Id)
+	return nil
+}
+
+func ignoreNoSuchId(err error) error {
+	if err == vsafedb.ErrNoSuchId {
+		return nil
+	}
+	return err
+}
+
+func consumeAll[T any](values []T, consumer consume2.Consumer[T]) {
+	for _, value := range values {
+		if !consumer.CanConsume() {
+			return
+		}
+		consumer.Consume(value)
+	}
+}
+
+func indexKey(key, id int64) []byte {
+	return append(itob(key), itob(id)...)
+}
+
+func itob(id int64) []byte {
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, uint64(id))
+	return result
+}
+
+func btoi(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
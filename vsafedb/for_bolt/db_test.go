@@ -0,0 +1,241 @@
+package for_bolt_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"github.com/keep94/vsafe/vsafedb/bolt_setup"
+	"github.com/keep94/vsafe/vsafedb/fixture"
+	"github.com/keep94/vsafe/vsafedb/for_bolt"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestUserById(t *testing.T) {
+	fixture.UserById(t, newStore(t))
+}
+
+func TestUserByName(t *testing.T) {
+	fixture.UserByName(t, newStore(t))
+}
+
+func TestUsers(t *testing.T) {
+	fixture.Users(t, newStore(t))
+}
+
+func TestUsersByOwner(t *testing.T) {
+	fixture.UsersByOwner(t, newStore(t))
+}
+
+func TestUpdateUser(t *testing.T) {
+	fixture.UpdateUser(t, newStore(t))
+}
+
+func TestUserNameTaken(t *testing.T) {
+	fixture.UserNameTaken(t, newStore(t))
+}
+
+func TestRemoveUser(t *testing.T) {
+	fixture.RemoveUser(t, newStore(t))
+}
+
+func TestUserDupName(t *testing.T) {
+	fixture.UserDupName(t, newStore(t))
+}
+
+func TestCategoriesByOwner(t *testing.T) {
+	fixture.CategoriesByOwner(t, newStore(t))
+}
+
+func TestCategoryById(t *testing.T) {
+	fixture.CategoryById(t, newStore(t))
+}
+
+func TestUpdateCategory(t *testing.T) {
+	fixture.UpdateCategory(t, newStore(t))
+}
+
+func TestRemoveCategory(t *testing.T) {
+	fixture.RemoveCategory(t, newStore(t))
+}
+
+func TestCategoryCountsByOwner(t *testing.T) {
+	fixture.CategoryCountsByOwner(t, newStore(t))
+}
+
+func TestEntryById(t *testing.T) {
+	fixture.EntryById(t, newStore(t))
+}
+
+func TestEntriesByOwner(t *testing.T) {
+	fixture.EntriesByOwner(t, newStore(t))
+}
+
+func TestUpdateEntry(t *testing.T) {
+	fixture.UpdateEntry(t, newStore(t))
+}
+
+func TestRemoveEntry(t *testing.T) {
+	fixture.RemoveEntry(t, newStore(t))
+}
+
+func TestShareById(t *testing.T) {
+	fixture.ShareById(t, newStore(t))
+}
+
+func TestShares(t *testing.T) {
+	fixture.Shares(t, newStore(t))
+}
+
+func TestUpdateShare(t *testing.T) {
+	fixture.UpdateShare(t, newStore(t))
+}
+
+func TestRemoveShare(t *testing.T) {
+	fixture.RemoveShare(t, newStore(t))
+}
+
+func TestCollectionById(t *testing.T) {
+	fixture.CollectionById(t, newStore(t))
+}
+
+func TestMemberships(t *testing.T) {
+	fixture.Memberships(t, newStore(t))
+}
+
+func TestRollback(t *testing.T) {
+	dbase := openDb(t)
+	store := for_bolt.New(dbase)
+	doer := for_bolt.NewDoer(dbase)
+	errRollback := errors.New("rollback")
+	err := doer.Do(func(t db.Transaction) error {
+		added := vsafe.User{Name: "foo"}
+		if err := store.AddUser(t, &added); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("Expected errRollback, got %v", err)
+	}
+	var user vsafe.User
+	if err := store.UserByName(nil, "foo", &user); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId after rollback, got %v", err)
+	}
+	err = doer.Do(func(t db.Transaction) error {
+		user = vsafe.User{Name: "foo"}
+		return store.AddUser(t, &user)
+	})
+	if err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	if err := store.UserByName(nil, "foo", &user); err != nil {
+		t.Errorf("Expected committed user, got %v", err)
+	}
+}
+
+func TestEtag(t *testing.T) {
+	store := newStore(t)
+	entry := vsafe.Entry{Owner: 1, Title: "foo"}
+	if err := store.AddEntry(nil, &entry); err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+	var first, second vsafe.Entry
+	if err := store.EntryById(nil, entry.Id, &first); err != nil {
+		t.Fatalf("Error reading entry: %v", err)
+	}
+	if err := store.EntryById(nil, entry.Id, &second); err != nil {
+		t.Fatalf("Error reading entry: %v", err)
+	}
+	if first.Etag == 0 || first.Etag != second.Etag {
+		t.Errorf("Expected same non-zero etags, got %d and %d", first.Etag, second.Etag)
+	}
+	second.Title = "bar"
+	if err := store.UpdateEntry(nil, &second); err != nil {
+		t.Fatalf("Error updating entry: %v", err)
+	}
+	if err := store.EntryById(nil, entry.Id, &second); err != nil {
+		t.Fatalf("Error reading entry: %v", err)
+	}
+	if first.Etag == second.Etag {
+		t.Error("Expected etag to change")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	store := newStore(t)
+	user := vsafe.User{Owner: 7, Name: "foo"}
+	if err := store.AddUser(nil, &user); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	user.Name = "bar"
+	user.Owner = 8
+	if err := store.UpdateUser(nil, &user); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+	var fetched vsafe.User
+	if err := store.UserByName(nil, "foo", &fetched); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected old name to be gone, got %v", err)
+	}
+	if err := store.UserByName(nil, "bar", &fetched); err != nil {
+		t.Errorf("Expected new name, got %v", err)
+	}
+	var users []vsafe.User
+	if err := store.UsersByOwner(nil, 7, consume2.AppendTo(&users)); err != nil {
+		t.Fatalf("Error reading users: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("Expected no users for old owner, got %v", users)
+	}
+	if err := store.UsersByOwner(nil, 8, consume2.AppendTo(&users)); err != nil {
+		t.Fatalf("Error reading users: %v", err)
+	}
+	if len(users) != 1 || users[0].Name != "bar" {
+		t.Errorf("Expected bar for new owner, got %v", users)
+	}
+	if err := store.RemoveUser(nil, "bar"); err != nil {
+		t.Fatalf("Error removing user: %v", err)
+	}
+	users = nil
+	if err := store.UsersByOwner(nil, 8, consume2.AppendTo(&users)); err != nil {
+		t.Fatalf("Error reading users: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("Expected no users after remove, got %v", users)
+	}
+	// The name is free again
+	user = vsafe.User{Name: "bar"}
+	if err := store.AddUser(nil, &user); err != nil {
+		t.Errorf("Expected name to be free, got %v", err)
+	}
+}
+
+func newStore(t *testing.T) for_bolt.Store {
+	return for_bolt.New(openDb(t))
+}
+
+func openDb(t *testing.T) *bolt.DB {
+	t.Helper()
+	dbase, err := bolt.Open(filepath.Join(t.TempDir(), "vsafe.bolt"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { dbase.Close() })
+	if err := dbase.Update(bolt_setup.SetUpTables); err != nil {
+		t.Fatalf("Error setting up database: %v", err)
+	}
+	return dbase
+}
+
+var (
+	_ vsafedb.SafeRemoveUserCascadeRunner = for_bolt.Store{}
+	_ vsafedb.SafeTransferOwnershipRunner = for_bolt.Store{}
+	_ vsafedb.SafeCreateCollectionRunner  = for_bolt.Store{}
+	_ vsafedb.SafeAddMemberRunner         = for_bolt.Store{}
+	_ vsafedb.SafeAcceptShareRunner       = for_bolt.Store{}
+	_ vsafedb.SafeRenameUserRunner        = for_bolt.Store{}
+)
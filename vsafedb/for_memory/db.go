@@ -0,0 +1,512 @@
+// Package for_memory provides an in-memory implementation of interfaces in
+// vsafedb package. It is meant for tests and for ephemeral demo servers;
+// everything stored is lost when the process exits.
+package for_memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+)
+
+// Db is an in-memory database. Use New to access it as a vsafe datastore
+// and NewDoer to run several operations in one transaction.
+type Db struct {
+	mutex sync.Mutex
+	data  *data
+}
+
+// NewDb returns a new, empty in-memory database.
+func NewDb() *Db {
+	return &Db{data: newData()}
+}
+
+// NewDoer returns a db.Doer for db. Each transaction sees the changes of
+// the transactions before it. A transaction holds db exclusively until it
+// finishes. If the action returns an error or panics, none of its changes
+// take effect. Actions must pass the transaction they are given to each
+// Store method; passing nil from within an action deadlocks.
+func NewDoer(db *Db) db.Doer {
+	return doer{db}
+}
+
+type doer struct {
+	db *Db
+}
+
+func (d doer) Do(action db.Action) (err error) {
+	d.db.mutex.Lock()
+	defer d.db.mutex.Unlock()
+	backup := d.db.data.clone()
+	committed := false
+	defer func() {
+		if !committed {
+			d.db.data = backup
+		}
+	}()
+	if err = action(&transaction{d.db}); err != nil {
+		return
+	}
+	committed = true
+	return
+}
+
+type transaction struct {
+	db *Db
+}
+
+type Store struct {
+	db *Db
+}
+
+// New creates an in-memory implementation of the vsafe app datastore.
+func New(db *Db) Store {
+	return Store{db}
+}
+
+func (s Store) AddUser(t db.Transaction, user *vsafe.User) error {
+	return s.do(t, func(d *data) error {
+		if d.nameTaken(0, user.Name) {
+			return vsafedb.ErrNameTaken
+		}
+		d.users.add(user, &user.Id)
+		return nil
+	})
+}
+
+func (s Store) UserById(
+	t db.Transaction, id int64, user *vsafe.User) error {
+	return s.do(t, func(d *data) error {
+		return d.users.get(id, user)
+	})
+}
+
+func (s Store) UserByName(
+	t db.Transaction, name string, user *vsafe.User) error {
+	return s.do(t, func(d *data) error {
+		for _, u := range d.users.rows {
+			if u.Name == name {
+				*user = u
+				return nil
+			}
+		}
+		return vsafedb.ErrNoSuchId
+	})
+}
+
+func (s Store) Users(
+	t db.Transaction, consumer consume2.Consumer[vsafe.User]) error {
+	return s.do(t, func(d *data) error {
+		consumeUsersByName(
+			d.users.filter(func(vsafe.User) bool { return true }), consumer)
+		return nil
+	})
+}
+
+func (s Store) UsersByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.User]) error {
+	return s.do(t, func(d *data) error {
+		consumeUsersByName(
+			d.users.filter(func(u vsafe.User) bool {
+				return u.Id == owner || u.Owner == owner
+			}),
+			consumer)
+		return nil
+	})
+}
+
+func (s Store) UpdateUser(t db.Transaction, user *vsafe.User) error {
+	return s.do(t, func(d *data) error {
+		if d.nameTaken(user.Id, user.Name) {
+			return vsafedb.ErrNameTaken
+		}
+		d.users.update(user.Id, *user)
+		return nil
+	})
+}
+
+func (s Store) RemoveUser(t db.Transaction, name string) error {
+	return s.do(t, func(d *data) error {
+		for id, u := range d.users.rows {
+			if u.Name == name {
+				delete(d.users.rows, id)
+			}
+		}
+		return nil
+	})
+}
+
+func (s Store) AddCategory(
+	t db.Transaction, category *vsafe.Category) error {
+	return s.do(t, func(d *data) error {
+		d.categories.add(category, &category.Id)
+		return nil
+	})
+}
+
+func (s Store) CategoriesByOwner(
+	t db.Transaction, owner int64) (result []vsafe.Category, err error) {
+	err = s.do(t, func(d *data) error {
+		result = d.categories.filter(func(c vsafe.Category) bool {
+			return c.Owner == owner
+		})
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return nil
+	})
+	return
+}
+
+func (s Store) CategoryById(
+	t db.Transaction, id int64, category *vsafe.Category) error {
+	return s.do(t, func(d *data) error {
+		return d.categories.get(id, category)
+	})
+}
+
+func (s Store) UpdateCategory(
+	t db.Transaction, category *vsafe.Category) error {
+	return s.do(t, func(d *data) error {
+		d.categories.update(category.Id, *category)
+		return nil
+	})
+}
+
+func (s Store) RemoveCategory(t db.Transaction, id int64) error {
+	return s.do(t, func(d *data) error {
+		delete(d.categories.rows, id)
+		return nil
+	})
+}
+
+func (s Store) AddEntry(t db.Transaction, entry *vsafe.Entry) error {
+	return s.do(t, func(d *data) error {
+		stored := storedEntry(entry)
+		d.entries.add(&stored, &stored.Id)
+		entry.Id = stored.Id
+		return nil
+	})
+}
+
+func (s Store) EntryById(
+	t db.Transaction, id int64, entry *vsafe.Entry) error {
+	return s.do(t, func(d *data) error {
+		if err := d.entries.get(id, entry); err != nil {
+			return err
+		}
+		*entry = readEntry(*entry)
+		return nil
+	})
+}
+
+func (s Store) EntriesByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	return s.do(t, func(d *data) error {
+		entries := d.entries.filter(func(e vsafe.Entry) bool {
+			return e.Owner == owner
+		})
+		for i := range entries {
+			entries[i] = readEntry(entries[i])
+		}
+		consumeAll(entries, consumer)
+		return nil
+	})
+}
+
+func (s Store) UpdateEntry(t db.Transaction, entry *vsafe.Entry) error {
+	return s.do(t, func(d *data) error {
+		d.entries.update(entry.Id, storedEntry(entry))
+		return nil
+	})
+}
+
+func (s Store) RemoveEntry(t db.Transaction, id, owner int64) error {
+	return s.do(t, func(d *data) error {
+		if e, ok := d.entries.rows[id]; ok && e.Owner == owner {
+			delete(d.entries.rows, id)
+		}
+		return nil
+	})
+}
+
+func (s Store) AddShare(t db.Transaction, share *vsafe.Share) error {
+	return s.do(t, func(d *data) error {
+		d.shares.add(share, &share.Id)
+		return nil
+	})
+}
+
+func (s Store) ShareById(
+	t db.Transaction, id int64, share *vsafe.Share) error {
+	return s.do(t, func(d *data) error {
+		return d.shares.get(id, share)
+	})
+}
+
+func (s Store) SharesByRecipient(
+	t db.Transaction,
+	recipient int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return s.do(t, func(d *data) error {
+		consumeAll(
+			d.shares.filter(func(sh vsafe.Share) bool {
+				return sh.Recipient == recipient
+			}),
+			consumer)
+		return nil
+	})
+}
+
+func (s Store) SharesBySender(
+	t db.Transaction,
+	sender int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return s.do(t, func(d *data) error {
+		consumeAll(
+			d.shares.filter(func(sh vsafe.Share) bool {
+				return sh.Sender == sender
+			}),
+			consumer)
+		return nil
+	})
+}
+
+func (s Store) UpdateShare(t db.Transaction, share *vsafe.Share) error {
+	return s.do(t, func(d *data) error {
+		d.shares.update(share.Id, *share)
+		return nil
+	})
+}
+
+func (s Store) RemoveShare(t db.Transaction, id int64) error {
+	return s.do(t, func(d *data) error {
+		delete(d.shares.rows, id)
+		return nil
+	})
+}
+
+func (s Store) AddCollection(
+	t db.Transaction, collection *vsafe.Collection) error {
+	return s.do(t, func(d *data) error {
+		d.collections.add(collection, &collection.Id)
+		return nil
+	})
+}
+
+func (s Store) CollectionById(
+	t db.Transaction, id int64, collection *vsafe.Collection) error {
+	return s.do(t, func(d *data) error {
+		return d.collections.get(id, collection)
+	})
+}
+
+func (s Store) AddMembership(
+	t db.Transaction, membership *vsafe.Membership) error {
+	return s.do(t, func(d *data) error {
+		d.memberships.add(membership, &membership.Id)
+		return nil
+	})
+}
+
+func (s Store) MembershipsByUser(
+	t db.Transaction,
+	userId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	return s.do(t, func(d *data) error {
+		consumeAll(
+			d.memberships.filter(func(m vsafe.Membership) bool {
+				return m.User == userId
+			}),
+			consumer)
+		return nil
+	})
+}
+
+func (s Store) MembershipsByCollection(
+	t db.Transaction,
+	collectionId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	return s.do(t, func(d *data) error {
+		consumeAll(
+			d.memberships.filter(func(m vsafe.Membership) bool {
+				return m.Collection == collectionId
+			}),
+			consumer)
+		return nil
+	})
+}
+
+func (s Store) RemoveMembership(t db.Transaction, id int64) error {
+	return s.do(t, func(d *data) error {
+		delete(d.memberships.rows, id)
+		return nil
+	})
+}
+
+// do runs f on the data of this store. If t is nil, do runs f in its own
+// transaction; otherwise t must come from the Doer of this store's Db.
+func (s Store) do(t db.Transaction, f func(d *data) error) error {
+	if t == nil {
+		// Each method changes nothing before it can no longer fail, so
+		// a single method needs no backup to roll back to.
+		s.db.mutex.Lock()
+		defer s.db.mutex.Unlock()
+		return f(s.db.data)
+	}
+	if t.(*transaction).db != s.db {
+		panic("for_memory: Transaction belongs to a different Db")
+	}
+	return f(s.db.data)
+}
+
+type data struct {
+	users       *table[vsafe.User]
+	categories  *table[vsafe.Category]
+	entries     *table[vsafe.Entry]
+	shares      *table[vsafe.Share]
+	collections *table[vsafe.Collection]
+	memberships *table[vsafe.Membership]
+}
+
+func newData() *data {
+	return &data{
+		users:       newTable[vsafe.User](),
+		categories:  newTable[vsafe.Category](),
+		entries:     newTable[vsafe.Entry](),
+		shares:      newTable[vsafe.Share](),
+		collections: newTable[vsafe.Collection](),
+		memberships: newTable[vsafe.Membership](),
+	}
+}
+
+func (d *data) clone() *data {
+	return &data{
+		users:       d.users.clone(),
+		categories:  d.categories.clone(),
+		entries:     d.entries.clone(),
+		shares:      d.shares.clone(),
+		collections: d.collections.clone(),
+		memberships: d.memberships.clone(),
+	}
+}
+
+// nameTaken returns true if a user other than the one with given id has
+// name. Use 0 for id when adding a user.
+func (d *data) nameTaken(id int64, name string) bool {
+	for otherId, u := range d.users.rows {
+		if otherId != id && u.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// table stores rows by id. Like sqlite AUTOINCREMENT, table never reuses
+// the id of a removed row.
+type table[T any] struct {
+	rows   map[int64]T
+	lastId int64
+}
+
+func newTable[T any]() *table[T] {
+	return &table[T]{rows: make(map[int64]T)}
+}
+
+func (t *table[T]) clone() *table[T] {
+	result := &table[T]{rows: make(map[int64]T, len(t.rows)), lastId: t.lastId}
+	for id, row := range t.rows {
+		result.rows[id] = row
+	}
+	return result
+}
+
+// add stores row under a new id which it writes to id, the address of
+// the Id field of row.
+func (t *table[T]) add(row *T, id *int64) {
+	t.lastId++
+	*id = t.lastId
+	t.rows[t.lastId] = *row
+}
+
+func (t *table[T]) get(id int64, row *T) error {
+	stored, ok := t.rows[id]
+	if !ok {
+		return vsafedb.ErrNoSuchId
+	}
+	*row = stored
+	return nil
+}
+
+// update replaces the row with given id. Like a SQL update, it does
+// nothing if there is no such row.
+func (t *table[T]) update(id int64, row T) {
+	if _, ok := t.rows[id]; ok {
+		t.rows[id] = row
+	}
+}
+
+// filter returns the rows for which f returns true ordered by id.
+func (t *table[T]) filter(f func(T) bool) []T {
+	ids := make([]int64, 0, len(t.rows))
+	for id := range t.rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var result []T
+	for _, id := range ids {
+		if row := t.rows[id]; f(row) {
+			result = append(result, row)
+		}
+	}
+	return result
+}
+
+// storedEntry returns a copy of entry to store that shares no memory with
+// entry.
+func storedEntry(entry *vsafe.Entry) vsafe.Entry {
+	result := *entry
+	result.Etag = 0
+	if entry.Url != nil {
+		u := *entry.Url
+		result.Url = &u
+	}
+	return result
+}
+
+// readEntry returns a copy of a stored entry with its etag set.
+func readEntry(entry vsafe.Entry) vsafe.Entry {
+	result := storedEntry(&entry)
+	var rawUrl string
+	if result.Url != nil {
+		rawUrl = result.Url.String()
+	}
+	result.Etag = vsafedb.Etag(
+		result.Owner, rawUrl, result.Title, result.Desc, result.UName, result.Password, result.Special, string(result.Categories), result.Id)
+	return result
+}
+
+func consumeUsersByName(
+	users []vsafe.User, consumer consume2.Consumer[vsafe.User]) {
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	consumeAll(users, consumer)
+}
+
+func consumeAll[T any](values []T, consumer consume2.Consumer[T]) {
+	for _, value := range values {
+		if !consumer.CanConsume() {
+			return
+		}
+		consumer.Consume(value)
+	}
+}
@@ -0,0 +1,172 @@
+package for_memory_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"github.com/keep94/vsafe/vsafedb/fixture"
+	"github.com/keep94/vsafe/vsafedb/for_memory"
+)
+
+func TestUserById(t *testing.T) {
+	fixture.UserById(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUserByName(t *testing.T) {
+	fixture.UserByName(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUsers(t *testing.T) {
+	fixture.Users(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUsersByOwner(t *testing.T) {
+	fixture.UsersByOwner(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUpdateUser(t *testing.T) {
+	fixture.UpdateUser(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUserNameTaken(t *testing.T) {
+	fixture.UserNameTaken(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestRemoveUser(t *testing.T) {
+	fixture.RemoveUser(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUserDupName(t *testing.T) {
+	fixture.UserDupName(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestCategoriesByOwner(t *testing.T) {
+	fixture.CategoriesByOwner(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestCategoryById(t *testing.T) {
+	fixture.CategoryById(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUpdateCategory(t *testing.T) {
+	fixture.UpdateCategory(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestRemoveCategory(t *testing.T) {
+	fixture.RemoveCategory(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestCategoryCountsByOwner(t *testing.T) {
+	fixture.CategoryCountsByOwner(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestEntryById(t *testing.T) {
+	fixture.EntryById(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestEntriesByOwner(t *testing.T) {
+	fixture.EntriesByOwner(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUpdateEntry(t *testing.T) {
+	fixture.UpdateEntry(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestRemoveEntry(t *testing.T) {
+	fixture.RemoveEntry(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestShareById(t *testing.T) {
+	fixture.ShareById(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestShares(t *testing.T) {
+	fixture.Shares(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestUpdateShare(t *testing.T) {
+	fixture.UpdateShare(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestRemoveShare(t *testing.T) {
+	fixture.RemoveShare(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestCollectionById(t *testing.T) {
+	fixture.CollectionById(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestMemberships(t *testing.T) {
+	fixture.Memberships(t, for_memory.New(for_memory.NewDb()))
+}
+
+func TestRollback(t *testing.T) {
+	dbase := for_memory.NewDb()
+	store := for_memory.New(dbase)
+	doer := for_memory.NewDoer(dbase)
+	errRollback := errors.New("rollback")
+	err := doer.Do(func(t db.Transaction) error {
+		added := vsafe.User{Name: "foo"}
+		if err := store.AddUser(t, &added); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("Expected errRollback, got %v", err)
+	}
+	var user vsafe.User
+	if err := store.UserByName(nil, "foo", &user); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId after rollback, got %v", err)
+	}
+	err = doer.Do(func(t db.Transaction) error {
+		user = vsafe.User{Name: "foo"}
+		return store.AddUser(t, &user)
+	})
+	if err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	if err := store.UserByName(nil, "foo", &user); err != nil {
+		t.Errorf("Expected committed user, got %v", err)
+	}
+}
+
+func TestEtag(t *testing.T) {
+	store := for_memory.New(for_memory.NewDb())
+	entry := vsafe.Entry{Owner: 1, Title: "foo"}
+	if err := store.AddEntry(nil, &entry); err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+	var first, second vsafe.Entry
+	if err := store.EntryById(nil, entry.Id, &first); err != nil {
+		t.Fatalf("Error reading entry: %v", err)
+	}
+	if err := store.EntryById(nil, entry.Id, &second); err != nil {
+		t.Fatalf("Error reading entry: %v", err)
+	}
+	if first.Etag == 0 || first.Etag != second.Etag {
+		t.Errorf("Expected same non-zero etags, got %d and %d", first.Etag, second.Etag)
+	}
+	second.Title = "bar"
+	if err := store.UpdateEntry(nil, &second); err != nil {
+		t.Fatalf("Error updating entry: %v", err)
+	}
+	if err := store.EntryById(nil, entry.Id, &second); err != nil {
+		t.Fatalf("Error reading entry: %v", err)
+	}
+	if first.Etag == second.Etag {
+		t.Error("Expected etag to change")
+	}
+}
+
+var (
+	_ vsafedb.SafeRemoveUserCascadeRunner = for_memory.Store{}
+	_ vsafedb.SafeTransferOwnershipRunner = for_memory.Store{}
+	_ vsafedb.SafeCreateCollectionRunner  = for_memory.Store{}
+	_ vsafedb.SafeAddMemberRunner         = for_memory.Store{}
+	_ vsafedb.SafeAcceptShareRunner       = for_memory.Store{}
+	_ vsafedb.SafeRenameUserRunner        = for_memory.Store{}
+)
@@ -0,0 +1,577 @@
+// Package for_postgres provides a PostgreSQL implementation of interfaces
+// in vsafedb package. Set up the database with the postgres_setup package.
+package for_postgres
+
+import (
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/db/sqlite3_db"
+	"github.com/keep94/toolbox/db/sqlite3_rw"
+	"github.com/keep94/toolbox/idset"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"github.com/lib/pq"
+)
+
+const (
+	// The PostgreSQL error code for unique constraint violations
+	kUniqueViolation = "23505"
+	// The unique index on user names
+	kUsersNameIdx = "users_name_idx"
+)
+
+const (
+	kSQLUserById        = "select id, owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key from users where id = $1"
+	kSQLUserByName      = "select id, owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key from users where name = $1"
+	kSQLUsers           = "select id, owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key from users order by name"
+	kSQLUsersByOwner    = "select id, owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key from users where id = $1 or owner = $1 order by name"
+	kSQLAddUser         = "insert into users (owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id"
+	kSQLUpdateUser      = "update users set owner = $1, name = $2, key = $3, checksum = $4, idle_timeout = $5, session_lifetime = $6, role = $7, categories = $8, public_key = $9, private_key = $10, password_history = $11, member_public_key = $12, member_private_key = $13 where id = $14"
+	kSQLRemoveUser      = "delete from users where name = $1"
+	kSQLAddCategory     = "insert into categories (owner, name) values ($1, $2) returning id"
+	kSQLCategoryByOwner = "select id, owner, name from categories where owner = $1 order by name"
+	kSQLCategoryById    = "select id, owner, name from categories where id = $1"
+	kSQLUpdateCategory  = "update categories set owner = $1, name = $2 where id = $3"
+	kSQLRemoveCategory  = "delete from categories where id = $1"
+	kSQLEntryById       = "select id, owner, url, title, description, uname, password, special, categories from entries where id = $1"
+	kSQLEntryByOwner    = "select id, owner, url, title, description, uname, password, special, categories from entries where owner = $1 order by id"
+	kSQLAddEntry        = "insert into entries (owner, url, title, description, uname, password, special, categories) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id"
+	kSQLUpdateEntry     = "update entries set owner = $1, url = $2, title = $3, description = $4, uname = $5, password = $6, special = $7, categories = $8 where id = $9"
+	kSQLRemoveEntry     = "delete from entries where id = $1 and owner = $2"
+	kSQLAddShare        = "insert into shares (sender, recipient, entry_id, payload) values ($1, $2, $3, $4) returning id"
+	kSQLShareById       = "select id, sender, recipient, entry_id, payload from shares where id = $1"
+	kSQLShareByRecip    = "select id, sender, recipient, entry_id, payload from shares where recipient = $1 order by id"
+	kSQLShareBySender   = "select id, sender, recipient, entry_id, payload from shares where sender = $1 order by id"
+	kSQLUpdateShare     = "update shares set sender = $1, recipient = $2, entry_id = $3, payload = $4 where id = $5"
+	kSQLRemoveShare     = "delete from shares where id = $1"
+	kSQLAddCollection   = "insert into collections (name) values ($1) returning id"
+	kSQLCollectionById  = "select id, name from collections where id = $1"
+	kSQLAddMembership   = "insert into memberships (collection_id, user_id, key) values ($1, $2, $3) returning id"
+	kSQLMembersByUser   = "select id, collection_id, user_id, key from memberships where user_id = $1 order by id"
+	kSQLMembersByColl   = "select id, collection_id, user_id, key from memberships where collection_id = $1 order by id"
+	kSQLRemoveMember    = "delete from memberships where id = $1"
+)
+
+type Store struct {
+	db sqlite3_db.Doer
+}
+
+// New creates a PostgreSQL implementation of the vsafe app datastore.
+// Although its name says sqlite3, sqlite3_db.Db works with any
+// database/sql driver including the PostgreSQL one.
+func New(db *sqlite3_db.Db) Store {
+	return Store{db}
+}
+
+// ConnNew creates a PostgreSQL implementation of the vsafe app datastore
+// from a connection instance.
+func ConnNew(tx *sql.Tx) Store {
+	return Store{sqlite3_db.NewSqlite3Doer(tx)}
+}
+
+func (s Store) AddUser(
+	t db.Transaction, user *vsafe.User) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return nameTaken(addRow(
+			tx, (&rawUser{}).init(user), &user.Id, kSQLAddUser))
+	})
+}
+
+func (s Store) UserById(
+	t db.Transaction, id int64, user *vsafe.User) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawUser{}).init(user),
+			vsafedb.ErrNoSuchId,
+			kSQLUserById,
+			id)
+	})
+}
+
+func (s Store) UserByName(
+	t db.Transaction, name string, user *vsafe.User) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawUser{}).init(user),
+			vsafedb.ErrNoSuchId,
+			kSQLUserByName,
+			name)
+	})
+}
+
+func (s Store) Users(
+	t db.Transaction, consumer consume2.Consumer[vsafe.User]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.User](
+			tx,
+			(&rawUser{}).init(&vsafe.User{}),
+			consumer,
+			kSQLUsers)
+	})
+}
+
+func (s Store) UsersByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.User]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.User](
+			tx,
+			(&rawUser{}).init(&vsafe.User{}),
+			consumer,
+			kSQLUsersByOwner,
+			owner)
+	})
+}
+
+func (s Store) UpdateUser(
+	t db.Transaction, user *vsafe.User) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return nameTaken(sqlite3_rw.UpdateRow(
+			tx, (&rawUser{}).init(user), kSQLUpdateUser))
+	})
+}
+
+func (s Store) RemoveUser(
+	t db.Transaction, name string) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(kSQLRemoveUser, name)
+		return err
+	})
+}
+
+func (s Store) AddCategory(
+	t db.Transaction, category *vsafe.Category) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return addRow(
+			tx, (&rawCategory{}).init(category), &category.Id, kSQLAddCategory)
+	})
+}
+
+func (s Store) CategoriesByOwner(
+	t db.Transaction, owner int64) ([]vsafe.Category, error) {
+	var result []vsafe.Category
+	consumer := consume2.AppendTo(&result)
+	err := sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.Category](
+			tx,
+			(&rawCategory{}).init(&vsafe.Category{}),
+			consumer,
+			kSQLCategoryByOwner,
+			owner)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s Store) CategoryById(
+	t db.Transaction, id int64, category *vsafe.Category) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawCategory{}).init(category),
+			vsafedb.ErrNoSuchId,
+			kSQLCategoryById,
+			id)
+	})
+}
+
+func (s Store) UpdateCategory(t db.Transaction, category *vsafe.Category) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.UpdateRow(
+			tx, (&rawCategory{}).init(category), kSQLUpdateCategory)
+	})
+}
+
+func (s Store) RemoveCategory(t db.Transaction, id int64) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(kSQLRemoveCategory, id)
+		return err
+	})
+}
+
+func (s Store) AddEntry(
+	t db.Transaction, entry *vsafe.Entry) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return addRow(
+			tx, (&rawEntry{}).init(entry), &entry.Id, kSQLAddEntry)
+	})
+}
+
+func (s Store) EntryById(
+	t db.Transaction, id int64, entry *vsafe.Entry) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawEntry{}).init(entry),
+			vsafedb.ErrNoSuchId,
+			kSQLEntryById,
+			id)
+	})
+}
+
+func (s Store) EntriesByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.Entry](
+			tx,
+			(&rawEntry{}).init(&vsafe.Entry{}),
+			consumer,
+			kSQLEntryByOwner,
+			owner)
+	})
+}
+
+func (s Store) UpdateEntry(t db.Transaction, entry *vsafe.Entry) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.UpdateRow(
+			tx, (&rawEntry{}).init(entry), kSQLUpdateEntry)
+	})
+}
+
+func (s Store) RemoveEntry(t db.Transaction, id, owner int64) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(kSQLRemoveEntry, id, owner)
+		return err
+	})
+}
+
+func (s Store) AddShare(t db.Transaction, share *vsafe.Share) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return addRow(
+			tx, (&rawShare{}).init(share), &share.Id, kSQLAddShare)
+	})
+}
+
+func (s Store) ShareById(
+	t db.Transaction, id int64, share *vsafe.Share) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawShare{}).init(share),
+			vsafedb.ErrNoSuchId,
+			kSQLShareById,
+			id)
+	})
+}
+
+func (s Store) SharesByRecipient(
+	t db.Transaction,
+	recipient int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.Share](
+			tx,
+			(&rawShare{}).init(&vsafe.Share{}),
+			consumer,
+			kSQLShareByRecip,
+			recipient)
+	})
+}
+
+func (s Store) SharesBySender(
+	t db.Transaction,
+	sender int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.Share](
+			tx,
+			(&rawShare{}).init(&vsafe.Share{}),
+			consumer,
+			kSQLShareBySender,
+			sender)
+	})
+}
+
+func (s Store) UpdateShare(t db.Transaction, share *vsafe.Share) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.UpdateRow(
+			tx, (&rawShare{}).init(share), kSQLUpdateShare)
+	})
+}
+
+func (s Store) RemoveShare(t db.Transaction, id int64) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(kSQLRemoveShare, id)
+		return err
+	})
+}
+
+func (s Store) AddCollection(
+	t db.Transaction, collection *vsafe.Collection) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return addRow(
+			tx,
+			(&rawCollection{}).init(collection),
+			&collection.Id,
+			kSQLAddCollection)
+	})
+}
+
+func (s Store) CollectionById(
+	t db.Transaction, id int64, collection *vsafe.Collection) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawCollection{}).init(collection),
+			vsafedb.ErrNoSuchId,
+			kSQLCollectionById,
+			id)
+	})
+}
+
+func (s Store) AddMembership(
+	t db.Transaction, membership *vsafe.Membership) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return addRow(
+			tx,
+			(&rawMembership{}).init(membership),
+			&membership.Id,
+			kSQLAddMembership)
+	})
+}
+
+func (s Store) MembershipsByUser(
+	t db.Transaction,
+	userId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.Membership](
+			tx,
+			(&rawMembership{}).init(&vsafe.Membership{}),
+			consumer,
+			kSQLMembersByUser,
+			userId)
+	})
+}
+
+func (s Store) MembershipsByCollection(
+	t db.Transaction,
+	collectionId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.Membership](
+			tx,
+			(&rawMembership{}).init(&vsafe.Membership{}),
+			consumer,
+			kSQLMembersByColl,
+			collectionId)
+	})
+}
+
+func (s Store) RemoveMembership(t db.Transaction, id int64) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(kSQLRemoveMember, id)
+		return err
+	})
+}
+
+// nameTaken converts a violation of the unique index on user names into
+// vsafedb.ErrNameTaken. Other errors, including violations of other
+// unique constraints, pass through unchanged.
+func nameTaken(err error) error {
+	pqErr, ok := err.(*pq.Error)
+	if ok && pqErr.Code == kUniqueViolation && pqErr.Constraint == kUsersNameIdx {
+		return vsafedb.ErrNameTaken
+	}
+	return err
+}
+
+// addRow adds row's business object as a new row using sql, an insert
+// statement ending in "returning id", and stores the id of the new row at
+// rowId.
+func addRow(
+	tx *sql.Tx,
+	row sqlite3_rw.RowForWriting,
+	rowId *int64,
+	sql string) error {
+	values, err := sqlite3_rw.InsertValues(row)
+	if err != nil {
+		return err
+	}
+	return tx.QueryRow(sql, values...).Scan(rowId)
+}
+
+type rawUser struct {
+	*vsafe.User
+	rawIdleTimeout     int64
+	rawSessionLifetime int64
+	rawCategories      string
+}
+
+func (r *rawUser) init(bo *vsafe.User) *rawUser {
+	r.User = bo
+	return r
+}
+
+func (r *rawUser) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Owner, &r.Name, &r.Key, &r.Checksum, &r.rawIdleTimeout, &r.rawSessionLifetime, &r.Role, &r.rawCategories, &r.PublicKey, &r.PrivateKey, &r.PasswordHistory, &r.MemberPublicKey, &r.MemberPrivateKey}
+}
+
+func (r *rawUser) Values() []interface{} {
+	return []interface{}{r.Owner, r.Name, r.Key, r.Checksum, r.rawIdleTimeout, r.rawSessionLifetime, r.Role, r.rawCategories, r.PublicKey, r.PrivateKey, r.PasswordHistory, r.MemberPublicKey, r.MemberPrivateKey, r.Id}
+}
+
+func (r *rawUser) ValueRead() vsafe.User {
+	return *r.User
+}
+
+func (r *rawUser) Marshall() error {
+	r.rawIdleTimeout = int64(r.IdleTimeout / time.Second)
+	r.rawSessionLifetime = int64(r.SessionLifetime / time.Second)
+	r.rawCategories = string(r.Categories)
+	return nil
+}
+
+func (r *rawUser) Unmarshall() error {
+	r.IdleTimeout = time.Duration(r.rawIdleTimeout) * time.Second
+	r.SessionLifetime = time.Duration(r.rawSessionLifetime) * time.Second
+	r.Categories = idset.IdSet(r.rawCategories)
+	return nil
+}
+
+type rawCategory struct {
+	*vsafe.Category
+	sqlite3_rw.SimpleRow
+}
+
+func (r *rawCategory) init(bo *vsafe.Category) *rawCategory {
+	r.Category = bo
+	return r
+}
+
+func (r *rawCategory) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Owner, &r.Name}
+}
+
+func (r *rawCategory) Values() []interface{} {
+	return []interface{}{r.Owner, r.Name, r.Id}
+}
+
+func (r *rawCategory) ValueRead() vsafe.Category {
+	return *r.Category
+}
+
+type rawShare struct {
+	*vsafe.Share
+	sqlite3_rw.SimpleRow
+}
+
+func (r *rawShare) init(bo *vsafe.Share) *rawShare {
+	r.Share = bo
+	return r
+}
+
+func (r *rawShare) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Sender, &r.Recipient, &r.EntryId, &r.Payload}
+}
+
+func (r *rawShare) Values() []interface{} {
+	return []interface{}{r.Sender, r.Recipient, r.EntryId, r.Payload, r.Id}
+}
+
+func (r *rawShare) ValueRead() vsafe.Share {
+	return *r.Share
+}
+
+type rawCollection struct {
+	*vsafe.Collection
+	sqlite3_rw.SimpleRow
+}
+
+func (r *rawCollection) init(bo *vsafe.Collection) *rawCollection {
+	r.Collection = bo
+	return r
+}
+
+func (r *rawCollection) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Name}
+}
+
+func (r *rawCollection) Values() []interface{} {
+	return []interface{}{r.Name, r.Id}
+}
+
+func (r *rawCollection) ValueRead() vsafe.Collection {
+	return *r.Collection
+}
+
+type rawMembership struct {
+	*vsafe.Membership
+	sqlite3_rw.SimpleRow
+}
+
+func (r *rawMembership) init(bo *vsafe.Membership) *rawMembership {
+	r.Membership = bo
+	return r
+}
+
+func (r *rawMembership) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Collection, &r.User, &r.Key}
+}
+
+func (r *rawMembership) Values() []interface{} {
+	return []interface{}{r.Collection, r.User, r.Key, r.Id}
+}
+
+func (r *rawMembership) ValueRead() vsafe.Membership {
+	return *r.Membership
+}
+
+type rawEntry struct {
+	*vsafe.Entry
+	rawUrl        string
+	rawCategories string
+}
+
+func (r *rawEntry) init(bo *vsafe.Entry) *rawEntry {
+	r.Entry = bo
+	return r
+}
+
+func (r *rawEntry) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Owner, &r.rawUrl, &r.Title, &r.Desc, &r.UName, &r.Password, &r.Special, &r.rawCategories}
+}
+
+func (r *rawEntry) Values() []interface{} {
+	return []interface{}{r.Owner, r.rawUrl, r.Title, r.Desc, r.UName, r.Password, r.Special, r.rawCategories, r.Id}
+}
+
+func (r *rawEntry) ValueRead() vsafe.Entry {
+	return *r.Entry
+}
+
+func (r *rawEntry) SetEtag(etag uint64) {
+	r.Etag = etag
+}
+
+func (r *rawEntry) Marshall() error {
+	r.rawCategories = string(r.Categories)
+	if r.Url == nil {
+		r.rawUrl = ""
+	} else {
+		r.rawUrl = r.Url.String()
+	}
+	return nil
+}
+
+func (r *rawEntry) Unmarshall() error {
+	var err error
+	r.Categories = idset.IdSet(r.rawCategories)
+	if r.rawUrl == "" {
+		r.Url = nil
+	} else {
+		r.Url, err = url.Parse(r.rawUrl)
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package for_postgres_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/keep94/toolbox/db/sqlite3_db"
+	"github.com/keep94/vsafe/vsafedb/fixture"
+	"github.com/keep94/vsafe/vsafedb/for_postgres"
+	"github.com/keep94/vsafe/vsafedb/postgres_setup"
+	_ "github.com/lib/pq"
+)
+
+func TestUserById(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UserById(t, for_postgres.New(db))
+}
+
+func TestUserByName(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UserByName(t, for_postgres.New(db))
+}
+
+func TestUsers(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Users(t, for_postgres.New(db))
+}
+
+func TestUsersByOwner(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UsersByOwner(t, for_postgres.New(db))
+}
+
+func TestUpdateUser(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UpdateUser(t, for_postgres.New(db))
+}
+
+func TestUserNameTaken(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UserNameTaken(t, for_postgres.New(db))
+}
+
+func TestRemoveUser(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.RemoveUser(t, for_postgres.New(db))
+}
+
+func TestUserDupName(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UserDupName(t, for_postgres.New(db))
+}
+
+func TestCategoriesByOwner(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.CategoriesByOwner(t, for_postgres.New(db))
+}
+
+func TestCategoryById(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.CategoryById(t, for_postgres.New(db))
+}
+
+func TestUpdateCategory(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UpdateCategory(t, for_postgres.New(db))
+}
+
+func TestRemoveCategory(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.RemoveCategory(t, for_postgres.New(db))
+}
+
+func TestCategoryCountsByOwner(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.CategoryCountsByOwner(t, for_postgres.New(db))
+}
+
+func TestEntryById(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.EntryById(t, for_postgres.New(db))
+}
+
+func TestEntriesByOwner(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.EntriesByOwner(t, for_postgres.New(db))
+}
+
+func TestUpdateEntry(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UpdateEntry(t, for_postgres.New(db))
+}
+
+func TestRemoveEntry(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.RemoveEntry(t, for_postgres.New(db))
+}
+
+func TestShareById(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ShareById(t, for_postgres.New(db))
+}
+
+func TestShares(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Shares(t, for_postgres.New(db))
+}
+
+func TestUpdateShare(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UpdateShare(t, for_postgres.New(db))
+}
+
+func TestRemoveShare(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.RemoveShare(t, for_postgres.New(db))
+}
+
+func TestCollectionById(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.CollectionById(t, for_postgres.New(db))
+}
+
+func TestMemberships(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Memberships(t, for_postgres.New(db))
+}
+
+func closeDb(t *testing.T, db *sqlite3_db.Db) {
+	if err := db.Close(); err != nil {
+		t.Errorf("Error closing database: %v", err)
+	}
+}
+
+// openDb opens the test database with empty tables. If there is no test
+// database, openDb skips the test.
+func openDb(t *testing.T) *sqlite3_db.Db {
+	rawdb, err := sql.Open("postgres", fixture.PostgresUrl(t))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	db := sqlite3_db.New(rawdb)
+	err = db.Do(func(tx *sql.Tx) error {
+		if err := postgres_setup.SetUpTables(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec("truncate users, categories, entries, shares, collections, memberships restart identity")
+		return err
+	})
+	if err != nil {
+		db.Close()
+		t.Fatalf("Error creating tables: %v", err)
+	}
+	return db
+}
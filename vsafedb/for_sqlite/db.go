@@ -3,8 +3,14 @@
 package for_sqlite
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/keep94/consume2"
 	"github.com/keep94/toolbox/db"
@@ -16,22 +22,61 @@ import (
 )
 
 const (
-	kSQLUserById        = "select id, owner, name, key, checksum from user where id = ?"
-	kSQLUserByName      = "select id, owner, name, key, checksum from user where name = ?"
-	kSQLUsers           = "select id, owner, name, key, checksum from user order by name"
-	kSQLAddUser         = "insert into user (owner, name, key, checksum) values (?, ?, ?, ?)"
-	kSQLUpdateUser      = "update user set owner = ?, name = ?, key = ?, checksum = ? where id = ?"
-	kSQLRemoveUser      = "delete from user where name = ?"
-	kSQLAddCategory     = "insert into category (owner, name) values (?, ?)"
-	kSQLCategoryByOwner = "select id, owner, name from category where owner = ? order by name"
-	kSQLCategoryById    = "select id, owner, name from category where id = ?"
-	kSQLUpdateCategory  = "update category set owner = ?, name = ? where id = ?"
-	kSQLRemoveCategory  = "delete from category where id = ?"
-	kSQLEntryById       = "select id, owner, url, title, desc, uname, password, special, categories from entry where id = ?"
-	kSQLEntryByOwner    = "select id, owner, url, title, desc, uname, password, special, categories from entry where owner = ? order by id"
-	kSQLAddEntry        = "insert into entry (owner, url, title, desc, uname, password, special, categories) values (?, ?, ?, ?, ?, ?, ?, ?)"
-	kSQLUpdateEntry     = "update entry set owner = ?, url = ?, title = ?, desc = ?, uname = ?, password = ?, special = ?, categories = ? where id = ?"
-	kSQLRemoveEntry     = "delete from entry where id = ? and owner = ?"
+	kSQLUserById         = "select id, owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key from user where id = ?"
+	kSQLUserByName       = "select id, owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key from user where name = ?"
+	kSQLUsers            = "select id, owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key from user order by name"
+	kSQLUsersByOwner     = "select id, owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key from user where id = ? or owner = ? order by name"
+	kSQLAddUser          = "insert into user (owner, name, key, checksum, idle_timeout, session_lifetime, role, categories, public_key, private_key, password_history, member_public_key, member_private_key) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	kSQLUpdateUser       = "update user set owner = ?, name = ?, key = ?, checksum = ?, idle_timeout = ?, session_lifetime = ?, role = ?, categories = ?, public_key = ?, private_key = ?, password_history = ?, member_public_key = ?, member_private_key = ? where id = ?"
+	kSQLRemoveUser       = "delete from user where name = ?"
+	kSQLAddCategory      = "insert into category (owner, name) values (?, ?)"
+	kSQLCategoryByOwner  = "select id, owner, name from category where owner = ? order by name"
+	kSQLCategoryById     = "select id, owner, name from category where id = ?"
+	kSQLUpdateCategory   = "update category set owner = ?, name = ? where id = ?"
+	kSQLRemoveCategory   = "delete from category where id = ?"
+	kSQLEntryById        = "select id, owner, url, title, desc, uname, password, special, coalesce((select group_concat(category_id) from (select category_id from entry_category where entry_category.entry_id = entry.id order by category_id)), '') from entry where id = ?"
+	kSQLEntryByOwner     = "select id, owner, url, title, desc, uname, password, special, coalesce((select group_concat(category_id) from (select category_id from entry_category where entry_category.entry_id = entry.id order by category_id)), '') from entry where owner = ? order by id"
+	kSQLSearchEntries    = "select id, owner, url, title, desc, uname, password, special, coalesce((select group_concat(category_id) from (select category_id from entry_category where entry_category.entry_id = entry.id order by category_id)), '') from entry where owner = ? and id in (select rowid from entry_fts where entry_fts match ?) order by id"
+	kSQLEntryRange       = "select id, owner, url, title, desc, uname, password, special, coalesce((select group_concat(category_id) from (select category_id from entry_category where entry_category.entry_id = entry.id order by category_id)), '') from entry where owner = ? and (? = 0 or id in (select entry_id from entry_category where category_id = ?)) order by %s limit ? offset ?"
+	kSQLCountEntryRange  = "select count(*) from entry where owner = ? and (? = 0 or id in (select entry_id from entry_category where category_id = ?))"
+	kSQLHasSearchIndex   = "select count(*) from sqlite_master where type = 'table' and name = 'entry_fts' and sqlite_compileoption_used('ENABLE_FTS5')"
+	kSQLAddEntry         = "insert into entry (owner, url, title, desc, uname, password, special) values (?, ?, ?, ?, ?, ?, ?)"
+	kSQLUpdateEntry      = "update entry set owner = ?, url = ?, title = ?, desc = ?, uname = ?, password = ?, special = ? where id = ?"
+	kSQLRemoveEntry      = "delete from entry where id = ? and owner = ?"
+	kSQLClearEntryCats   = "delete from entry_category where entry_id = ?"
+	kSQLRemoveEntryCats  = "delete from entry_category where entry_id in (select id from entry where id = ? and owner = ?)"
+	kSQLRemoveCatEntries = "delete from entry_category where category_id = ?"
+	kSQLCategoryExists   = "select count(*) from category where id = ? and owner = ?"
+	kSQLAddEntryCat      = "insert into entry_category (entry_id, category_id) values (?, ?)"
+	kSQLCategoryCounts   = "select category.id, category.owner, category.name, count(entry_category.entry_id) from category left join entry_category on entry_category.category_id = category.id where category.owner = ? group by category.id order by category.name"
+	kSQLAddShare         = "insert into share (sender, recipient, entry_id, payload) values (?, ?, ?, ?)"
+	kSQLShareById        = "select id, sender, recipient, entry_id, payload from share where id = ?"
+	kSQLShareByRecip     = "select id, sender, recipient, entry_id, payload from share where recipient = ? order by id"
+	kSQLShareBySender    = "select id, sender, recipient, entry_id, payload from share where sender = ? order by id"
+	kSQLUpdateShare      = "update share set sender = ?, recipient = ?, entry_id = ?, payload = ? where id = ?"
+	kSQLRemoveShare      = "delete from share where id = ?"
+	kSQLAddCollection    = "insert into collection (name) values (?)"
+	kSQLCollectionById   = "select id, name from collection where id = ?"
+	kSQLAddMembership    = "insert into membership (collection_id, user_id, key) values (?, ?, ?)"
+	kSQLMembersByUser    = "select id, collection_id, user_id, key from membership where user_id = ? order by id"
+	kSQLMembersByColl    = "select id, collection_id, user_id, key from membership where collection_id = ? order by id"
+	kSQLRemoveMember     = "delete from membership where id = ?"
+)
+
+var (
+	// The order by clauses for each order that EntryPage supports
+	kEntryOrderBy = map[vsafedb.EntryOrder]string{
+		vsafedb.ById:        "id",
+		vsafedb.ByTitle:     "lower(trim(title, ' ' || char(9, 10, 11, 12, 13))), id",
+		vsafedb.NewestFirst: "id desc",
+	}
+	// The conditions that select the entries before the entry with a
+	// given id for each order that EntryPage supports
+	kEntryBefore = map[vsafedb.EntryOrder]string{
+		vsafedb.ById:        "id < ?",
+		vsafedb.ByTitle:     "(lower(trim(title, ' ' || char(9, 10, 11, 12, 13))), id) < (select lower(trim(title, ' ' || char(9, 10, 11, 12, 13))), id from entry where id = ?)",
+		vsafedb.NewestFirst: "id > ?",
+	}
 )
 
 type Store struct {
@@ -52,15 +97,24 @@ func ConnNew(tx *sql.Tx) Store {
 func (s Store) AddUser(
 	t db.Transaction, user *vsafe.User) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.AddRow(
-			tx, (&rawUser{}).init(user), &user.Id, kSQLAddUser)
+		return nameTaken(sqlite3_rw.AddRow(
+			tx, (&rawUser{}).init(user), &user.Id, kSQLAddUser))
 	})
 }
 
 func (s Store) UserById(
 	t db.Transaction, id int64, user *vsafe.User) error {
+	return s.UserByIdContext(context.Background(), t, id, user)
+}
+
+func (s Store) UserByIdContext(
+	ctx context.Context,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.ReadSingle(
+		return readSingle(
+			ctx,
 			tx,
 			(&rawUser{}).init(user),
 			vsafedb.ErrNoSuchId,
@@ -71,8 +125,17 @@ func (s Store) UserById(
 
 func (s Store) UserByName(
 	t db.Transaction, name string, user *vsafe.User) error {
+	return s.UserByNameContext(context.Background(), t, name, user)
+}
+
+func (s Store) UserByNameContext(
+	ctx context.Context,
+	t db.Transaction,
+	name string,
+	user *vsafe.User) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.ReadSingle(
+		return readSingle(
+			ctx,
 			tx,
 			(&rawUser{}).init(user),
 			vsafedb.ErrNoSuchId,
@@ -92,11 +155,35 @@ func (s Store) Users(
 	})
 }
 
+func (s Store) UsersByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.User]) error {
+	return s.UsersByOwnerContext(context.Background(), t, owner, consumer)
+}
+
+func (s Store) UsersByOwnerContext(
+	ctx context.Context,
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.User]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return readMultiple[vsafe.User](
+			ctx,
+			tx,
+			(&rawUser{}).init(&vsafe.User{}),
+			consumer,
+			kSQLUsersByOwner,
+			owner,
+			owner)
+	})
+}
+
 func (s Store) UpdateUser(
 	t db.Transaction, user *vsafe.User) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.UpdateRow(
-			tx, (&rawUser{}).init(user), kSQLUpdateUser)
+		return nameTaken(sqlite3_rw.UpdateRow(
+			tx, (&rawUser{}).init(user), kSQLUpdateUser))
 	})
 }
 
@@ -110,18 +197,33 @@ func (s Store) RemoveUser(
 
 func (s Store) AddCategory(
 	t db.Transaction, category *vsafe.Category) error {
+	return s.AddCategoryContext(context.Background(), t, category)
+}
+
+func (s Store) AddCategoryContext(
+	ctx context.Context,
+	t db.Transaction,
+	category *vsafe.Category) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.AddRow(
-			tx, (&rawCategory{}).init(category), &category.Id, kSQLAddCategory)
+		return addRow(
+			ctx, tx, (&rawCategory{}).init(category), &category.Id, kSQLAddCategory)
 	})
 }
 
 func (s Store) CategoriesByOwner(
 	t db.Transaction, owner int64) ([]vsafe.Category, error) {
+	return s.CategoriesByOwnerContext(context.Background(), t, owner)
+}
+
+func (s Store) CategoriesByOwnerContext(
+	ctx context.Context,
+	t db.Transaction,
+	owner int64) ([]vsafe.Category, error) {
 	var result []vsafe.Category
 	consumer := consume2.AppendTo(&result)
 	err := sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.ReadMultiple[vsafe.Category](
+		return readMultiple[vsafe.Category](
+			ctx,
 			tx,
 			(&rawCategory{}).init(&vsafe.Category{}),
 			consumer,
@@ -134,10 +236,45 @@ func (s Store) CategoriesByOwner(
 	return result, nil
 }
 
+func (s Store) CategoryCountsByOwner(
+	t db.Transaction, owner int64) ([]vsafedb.CategoryCount, error) {
+	return s.CategoryCountsByOwnerContext(context.Background(), t, owner)
+}
+
+func (s Store) CategoryCountsByOwnerContext(
+	ctx context.Context,
+	t db.Transaction,
+	owner int64) ([]vsafedb.CategoryCount, error) {
+	var result []vsafedb.CategoryCount
+	consumer := consume2.AppendTo(&result)
+	err := sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return readMultiple[vsafedb.CategoryCount](
+			ctx,
+			tx,
+			(&rawCategoryCount{}).init(&vsafedb.CategoryCount{}),
+			consumer,
+			kSQLCategoryCounts,
+			owner)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func (s Store) CategoryById(
 	t db.Transaction, id int64, category *vsafe.Category) error {
+	return s.CategoryByIdContext(context.Background(), t, id, category)
+}
+
+func (s Store) CategoryByIdContext(
+	ctx context.Context,
+	t db.Transaction,
+	id int64,
+	category *vsafe.Category) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.ReadSingle(
+		return readSingle(
+			ctx,
 			tx,
 			(&rawCategory{}).init(category),
 			vsafedb.ErrNoSuchId,
@@ -147,33 +284,71 @@ func (s Store) CategoryById(
 }
 
 func (s Store) UpdateCategory(t db.Transaction, category *vsafe.Category) error {
+	return s.UpdateCategoryContext(context.Background(), t, category)
+}
+
+func (s Store) UpdateCategoryContext(
+	ctx context.Context,
+	t db.Transaction,
+	category *vsafe.Category) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.UpdateRow(
-			tx, (&rawCategory{}).init(category), kSQLUpdateCategory)
+		return updateRow(
+			ctx, tx, (&rawCategory{}).init(category), kSQLUpdateCategory)
 	})
 }
 
 func (s Store) RemoveCategory(t db.Transaction, id int64) error {
+	return s.RemoveCategoryContext(context.Background(), t, id)
+}
+
+func (s Store) RemoveCategoryContext(
+	ctx context.Context,
+	t db.Transaction,
+	id int64) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		_, err := tx.Exec(kSQLRemoveCategory, id)
+		if _, err := tx.ExecContext(ctx, kSQLRemoveCatEntries, id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, kSQLRemoveCategory, id)
 		return err
 	})
 }
 
 func (s Store) AddEntry(
 	t db.Transaction, entry *vsafe.Entry) error {
+	return s.AddEntryContext(context.Background(), t, entry)
+}
+
+func (s Store) AddEntryContext(
+	ctx context.Context,
+	t db.Transaction,
+	entry *vsafe.Entry) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.AddRow(
-			tx, (&rawEntry{}).init(entry), &entry.Id, kSQLAddEntry)
+		err := addRow(
+			ctx, tx, (&rawEntry{}).init(entry), &entry.Id, kSQLAddEntry)
+		if err != nil {
+			return err
+		}
+		return setEntryCategories(
+			ctx, tx, entry.Id, entry.Owner, entry.Categories)
 	})
 }
 
 func (s Store) EntryById(
 	t db.Transaction, id int64, entry *vsafe.Entry) error {
+	return s.EntryByIdContext(context.Background(), t, id, entry)
+}
+
+func (s Store) EntryByIdContext(
+	ctx context.Context,
+	t db.Transaction,
+	id int64,
+	entry *vsafe.Entry) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.ReadSingle(
+		return readSingle(
+			ctx,
 			tx,
-			(&rawEntry{}).init(entry),
+			(&rawEntryRead{}).init(entry),
 			vsafedb.ErrNoSuchId,
 			kSQLEntryById,
 			id)
@@ -181,36 +356,355 @@ func (s Store) EntryById(
 }
 
 func (s Store) EntriesByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	return s.EntriesByOwnerContext(context.Background(), t, owner, consumer)
+}
+
+func (s Store) EntriesByOwnerContext(
+	ctx context.Context,
 	t db.Transaction,
 	owner int64,
 	consumer consume2.Consumer[vsafe.Entry]) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.ReadMultiple[vsafe.Entry](
+		return readMultiple[vsafe.Entry](
+			ctx,
 			tx,
-			(&rawEntry{}).init(&vsafe.Entry{}),
+			(&rawEntryRead{}).init(&vsafe.Entry{}),
 			consumer,
 			kSQLEntryByOwner,
 			owner)
 	})
 }
 
+// SearchEntries returns vsafedb.ErrSearchUnsupported if the database
+// has no full text index, if this program was built without the
+// sqlite_fts5 tag, if query is shorter than 3 characters, or if query
+// contains whitespace. The index holds entry fields as entered while
+// vsafedb collapses their whitespace, so only queries without whitespace
+// match the same entries either way.
+func (s Store) SearchEntries(
+	t db.Transaction,
+	owner int64,
+	query string,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	return s.SearchEntriesContext(
+		context.Background(), t, owner, query, consumer)
+}
+
+func (s Store) SearchEntriesContext(
+	ctx context.Context,
+	t db.Transaction,
+	owner int64,
+	query string,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	// The trigram tokenizer can't match fewer than 3 characters
+	if utf8.RuneCountInString(query) < 3 {
+		return vsafedb.ErrSearchUnsupported
+	}
+	if strings.IndexFunc(query, unicode.IsSpace) != -1 {
+		return vsafedb.ErrSearchUnsupported
+	}
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRowContext(
+			ctx, kSQLHasSearchIndex).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return vsafedb.ErrSearchUnsupported
+		}
+		return readMultiple[vsafe.Entry](
+			ctx,
+			tx,
+			(&rawEntryRead{}).init(&vsafe.Entry{}),
+			consumer,
+			kSQLSearchEntries,
+			owner,
+			ftsPhrase(query))
+	})
+}
+
+func (s Store) EntryPage(
+	t db.Transaction,
+	r *vsafedb.EntryRange,
+	offset, limit int,
+	consumer consume2.Consumer[vsafe.Entry]) (int, error) {
+	return s.EntryPageContext(
+		context.Background(), t, r, offset, limit, consumer)
+}
+
+func (s Store) EntryPageContext(
+	ctx context.Context,
+	t db.Transaction,
+	r *vsafedb.EntryRange,
+	offset, limit int,
+	consumer consume2.Consumer[vsafe.Entry]) (int, error) {
+	orderBy, ok := kEntryOrderBy[r.Order]
+	if !ok {
+		return 0, unsupportedOrder(r.Order)
+	}
+	// sqlite reads all rows after offset when limit is negative
+	if limit <= 0 {
+		limit = -1
+	}
+	var total int
+	err := sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(
+			ctx,
+			kSQLCountEntryRange,
+			r.Owner, r.CatId, r.CatId).Scan(&total); err != nil {
+			return err
+		}
+		return readMultiple[vsafe.Entry](
+			ctx,
+			tx,
+			(&rawEntryRead{}).init(&vsafe.Entry{}),
+			consumer,
+			fmt.Sprintf(kSQLEntryRange, orderBy),
+			r.Owner, r.CatId, r.CatId, limit, offset)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func (s Store) EntryPosition(
+	t db.Transaction, r *vsafedb.EntryRange, id int64) (int, error) {
+	return s.EntryPositionContext(context.Background(), t, r, id)
+}
+
+func (s Store) EntryPositionContext(
+	ctx context.Context,
+	t db.Transaction,
+	r *vsafedb.EntryRange,
+	id int64) (int, error) {
+	before, ok := kEntryBefore[r.Order]
+	if !ok {
+		return 0, unsupportedOrder(r.Order)
+	}
+	var position int
+	err := sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRowContext(
+			ctx,
+			kSQLCountEntryRange+" and id = ?",
+			r.Owner, r.CatId, r.CatId, id).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return vsafedb.ErrNoSuchId
+		}
+		return tx.QueryRowContext(
+			ctx,
+			kSQLCountEntryRange+" and "+before,
+			r.Owner, r.CatId, r.CatId, id).Scan(&position)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return position, nil
+}
+
 func (s Store) UpdateEntry(t db.Transaction, entry *vsafe.Entry) error {
+	return s.UpdateEntryContext(context.Background(), t, entry)
+}
+
+func (s Store) UpdateEntryContext(
+	ctx context.Context,
+	t db.Transaction,
+	entry *vsafe.Entry) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		return sqlite3_rw.UpdateRow(
-			tx, (&rawEntry{}).init(entry), kSQLUpdateEntry)
+		values, err := sqlite3_rw.UpdateValues((&rawEntry{}).init(entry))
+		if err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, kSQLUpdateEntry, values...)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil || rowsAffected == 0 {
+			return err
+		}
+		return setEntryCategories(
+			ctx, tx, entry.Id, entry.Owner, entry.Categories)
 	})
 }
 
 func (s Store) RemoveEntry(t db.Transaction, id, owner int64) error {
+	return s.RemoveEntryContext(context.Background(), t, id, owner)
+}
+
+func (s Store) RemoveEntryContext(
+	ctx context.Context,
+	t db.Transaction,
+	id, owner int64) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(
+			ctx, kSQLRemoveEntryCats, id, owner); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, kSQLRemoveEntry, id, owner)
+		return err
+	})
+}
+
+func (s Store) AddShare(t db.Transaction, share *vsafe.Share) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRow(
+			tx, (&rawShare{}).init(share), &share.Id, kSQLAddShare)
+	})
+}
+
+func (s Store) ShareById(
+	t db.Transaction, id int64, share *vsafe.Share) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawShare{}).init(share),
+			vsafedb.ErrNoSuchId,
+			kSQLShareById,
+			id)
+	})
+}
+
+func (s Store) SharesByRecipient(
+	t db.Transaction,
+	recipient int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return s.SharesByRecipientContext(context.Background(), t, recipient, consumer)
+}
+
+func (s Store) SharesByRecipientContext(
+	ctx context.Context,
+	t db.Transaction,
+	recipient int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return readMultiple[vsafe.Share](
+			ctx,
+			tx,
+			(&rawShare{}).init(&vsafe.Share{}),
+			consumer,
+			kSQLShareByRecip,
+			recipient)
+	})
+}
+
+func (s Store) SharesBySender(
+	t db.Transaction,
+	sender int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return s.SharesBySenderContext(context.Background(), t, sender, consumer)
+}
+
+func (s Store) SharesBySenderContext(
+	ctx context.Context,
+	t db.Transaction,
+	sender int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return readMultiple[vsafe.Share](
+			ctx,
+			tx,
+			(&rawShare{}).init(&vsafe.Share{}),
+			consumer,
+			kSQLShareBySender,
+			sender)
+	})
+}
+
+func (s Store) UpdateShare(t db.Transaction, share *vsafe.Share) error {
 	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
-		_, err := tx.Exec(kSQLRemoveEntry, id, owner)
+		return sqlite3_rw.UpdateRow(
+			tx, (&rawShare{}).init(share), kSQLUpdateShare)
+	})
+}
+
+func (s Store) RemoveShare(t db.Transaction, id int64) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(kSQLRemoveShare, id)
+		return err
+	})
+}
+
+func (s Store) AddCollection(
+	t db.Transaction, collection *vsafe.Collection) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRow(
+			tx,
+			(&rawCollection{}).init(collection),
+			&collection.Id,
+			kSQLAddCollection)
+	})
+}
+
+func (s Store) CollectionById(
+	t db.Transaction, id int64, collection *vsafe.Collection) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawCollection{}).init(collection),
+			vsafedb.ErrNoSuchId,
+			kSQLCollectionById,
+			id)
+	})
+}
+
+func (s Store) AddMembership(
+	t db.Transaction, membership *vsafe.Membership) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRow(
+			tx,
+			(&rawMembership{}).init(membership),
+			&membership.Id,
+			kSQLAddMembership)
+	})
+}
+
+func (s Store) MembershipsByUser(
+	t db.Transaction,
+	userId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.Membership](
+			tx,
+			(&rawMembership{}).init(&vsafe.Membership{}),
+			consumer,
+			kSQLMembersByUser,
+			userId)
+	})
+}
+
+func (s Store) MembershipsByCollection(
+	t db.Transaction,
+	collectionId int64,
+	consumer consume2.Consumer[vsafe.Membership]) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[vsafe.Membership](
+			tx,
+			(&rawMembership{}).init(&vsafe.Membership{}),
+			consumer,
+			kSQLMembersByColl,
+			collectionId)
+	})
+}
+
+func (s Store) RemoveMembership(t db.Transaction, id int64) error {
+	return sqlite3_db.ToDoer(s.db, t).Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(kSQLRemoveMember, id)
 		return err
 	})
 }
 
 type rawUser struct {
 	*vsafe.User
-	sqlite3_rw.SimpleRow
+	rawIdleTimeout     int64
+	rawSessionLifetime int64
+	rawCategories      string
 }
 
 func (r *rawUser) init(bo *vsafe.User) *rawUser {
@@ -219,17 +713,31 @@ func (r *rawUser) init(bo *vsafe.User) *rawUser {
 }
 
 func (r *rawUser) Ptrs() []interface{} {
-	return []interface{}{&r.Id, &r.Owner, &r.Name, &r.Key, &r.Checksum}
+	return []interface{}{&r.Id, &r.Owner, &r.Name, &r.Key, &r.Checksum, &r.rawIdleTimeout, &r.rawSessionLifetime, &r.Role, &r.rawCategories, &r.PublicKey, &r.PrivateKey, &r.PasswordHistory, &r.MemberPublicKey, &r.MemberPrivateKey}
 }
 
 func (r *rawUser) Values() []interface{} {
-	return []interface{}{r.Owner, r.Name, r.Key, r.Checksum, r.Id}
+	return []interface{}{r.Owner, r.Name, r.Key, r.Checksum, r.rawIdleTimeout, r.rawSessionLifetime, r.Role, r.rawCategories, r.PublicKey, r.PrivateKey, r.PasswordHistory, r.MemberPublicKey, r.MemberPrivateKey, r.Id}
 }
 
 func (r *rawUser) ValueRead() vsafe.User {
 	return *r.User
 }
 
+func (r *rawUser) Marshall() error {
+	r.rawIdleTimeout = int64(r.IdleTimeout / time.Second)
+	r.rawSessionLifetime = int64(r.SessionLifetime / time.Second)
+	r.rawCategories = string(r.Categories)
+	return nil
+}
+
+func (r *rawUser) Unmarshall() error {
+	r.IdleTimeout = time.Duration(r.rawIdleTimeout) * time.Second
+	r.SessionLifetime = time.Duration(r.rawSessionLifetime) * time.Second
+	r.Categories = idset.IdSet(r.rawCategories)
+	return nil
+}
+
 type rawCategory struct {
 	*vsafe.Category
 	sqlite3_rw.SimpleRow
@@ -252,35 +760,89 @@ func (r *rawCategory) ValueRead() vsafe.Category {
 	return *r.Category
 }
 
-type rawEntry struct {
-	*vsafe.Entry
-	rawUrl        string
-	rawCategories string
+type rawShare struct {
+	*vsafe.Share
+	sqlite3_rw.SimpleRow
 }
 
-func (r *rawEntry) init(bo *vsafe.Entry) *rawEntry {
-	r.Entry = bo
+func (r *rawShare) init(bo *vsafe.Share) *rawShare {
+	r.Share = bo
 	return r
 }
 
-func (r *rawEntry) Ptrs() []interface{} {
-	return []interface{}{&r.Id, &r.Owner, &r.rawUrl, &r.Title, &r.Desc, &r.UName, &r.Password, &r.Special, &r.rawCategories}
+func (r *rawShare) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Sender, &r.Recipient, &r.EntryId, &r.Payload}
 }
 
-func (r *rawEntry) Values() []interface{} {
-	return []interface{}{r.Owner, r.rawUrl, r.Title, r.Desc, r.UName, r.Password, r.Special, r.rawCategories, r.Id}
+func (r *rawShare) Values() []interface{} {
+	return []interface{}{r.Sender, r.Recipient, r.EntryId, r.Payload, r.Id}
 }
 
-func (r *rawEntry) ValueRead() vsafe.Entry {
-	return *r.Entry
+func (r *rawShare) ValueRead() vsafe.Share {
+	return *r.Share
 }
 
-func (r *rawEntry) SetEtag(etag uint64) {
-	r.Etag = etag
+type rawCollection struct {
+	*vsafe.Collection
+	sqlite3_rw.SimpleRow
+}
+
+func (r *rawCollection) init(bo *vsafe.Collection) *rawCollection {
+	r.Collection = bo
+	return r
+}
+
+func (r *rawCollection) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Name}
+}
+
+func (r *rawCollection) Values() []interface{} {
+	return []interface{}{r.Name, r.Id}
+}
+
+func (r *rawCollection) ValueRead() vsafe.Collection {
+	return *r.Collection
+}
+
+type rawMembership struct {
+	*vsafe.Membership
+	sqlite3_rw.SimpleRow
+}
+
+func (r *rawMembership) init(bo *vsafe.Membership) *rawMembership {
+	r.Membership = bo
+	return r
+}
+
+func (r *rawMembership) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Collection, &r.User, &r.Key}
+}
+
+func (r *rawMembership) Values() []interface{} {
+	return []interface{}{r.Collection, r.User, r.Key, r.Id}
+}
+
+func (r *rawMembership) ValueRead() vsafe.Membership {
+	return *r.Membership
+}
+
+// rawEntry writes the entry table. The categories of an entry live in
+// the entry_category table.
+type rawEntry struct {
+	*vsafe.Entry
+	rawUrl string
+}
+
+func (r *rawEntry) init(bo *vsafe.Entry) *rawEntry {
+	r.Entry = bo
+	return r
+}
+
+func (r *rawEntry) Values() []interface{} {
+	return []interface{}{r.Owner, r.rawUrl, r.Title, r.Desc, r.UName, r.Password, r.Special, r.Id}
 }
 
 func (r *rawEntry) Marshall() error {
-	r.rawCategories = string(r.Categories)
 	if r.Url == nil {
 		r.rawUrl = ""
 	} else {
@@ -289,7 +851,34 @@ func (r *rawEntry) Marshall() error {
 	return nil
 }
 
-func (r *rawEntry) Unmarshall() error {
+// rawEntryRead reads an entry along with its categories.
+type rawEntryRead struct {
+	rawEntry
+	rawCategories string
+}
+
+func (r *rawEntryRead) init(bo *vsafe.Entry) *rawEntryRead {
+	r.Entry = bo
+	return r
+}
+
+func (r *rawEntryRead) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Owner, &r.rawUrl, &r.Title, &r.Desc, &r.UName, &r.Password, &r.Special, &r.rawCategories}
+}
+
+func (r *rawEntryRead) Values() []interface{} {
+	return []interface{}{r.Owner, r.rawUrl, r.Title, r.Desc, r.UName, r.Password, r.Special, r.rawCategories, r.Id}
+}
+
+func (r *rawEntryRead) ValueRead() vsafe.Entry {
+	return *r.Entry
+}
+
+func (r *rawEntryRead) SetEtag(etag uint64) {
+	r.Etag = etag
+}
+
+func (r *rawEntryRead) Unmarshall() error {
 	var err error
 	r.Categories = idset.IdSet(r.rawCategories)
 	if r.rawUrl == "" {
@@ -299,3 +888,61 @@ func (r *rawEntry) Unmarshall() error {
 	}
 	return err
 }
+
+type rawCategoryCount struct {
+	*vsafedb.CategoryCount
+	sqlite3_rw.SimpleRow
+}
+
+func (r *rawCategoryCount) init(bo *vsafedb.CategoryCount) *rawCategoryCount {
+	r.CategoryCount = bo
+	return r
+}
+
+func (r *rawCategoryCount) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Owner, &r.Name, &r.Entries}
+}
+
+func (r *rawCategoryCount) ValueRead() vsafedb.CategoryCount {
+	return *r.CategoryCount
+}
+
+func unsupportedOrder(order vsafedb.EntryOrder) error {
+	return fmt.Errorf("for_sqlite: Can't read entries in order %d.", order)
+}
+
+// ftsPhrase quotes s so that FTS5 matches it as one phrase.
+func ftsPhrase(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// setEntryCategories makes categories the categories of the entry with
+// given id and owner. If one of the categories does not exist or belongs
+// to a different owner, setEntryCategories returns vsafedb.ErrNoSuchId.
+func setEntryCategories(
+	ctx context.Context,
+	tx *sql.Tx,
+	id, owner int64,
+	categories idset.IdSet) error {
+	catMap, err := categories.Map()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, kSQLClearEntryCats, id); err != nil {
+		return err
+	}
+	for catId := range catMap {
+		var count int
+		if err := tx.QueryRowContext(
+			ctx, kSQLCategoryExists, catId, owner).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return vsafedb.ErrNoSuchId
+		}
+		if _, err := tx.ExecContext(ctx, kSQLAddEntryCat, id, catId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package for_sqlite_test
 
 import (
+	"context"
 	"database/sql"
+	"net/url"
+	"reflect"
 	"testing"
 
+	"github.com/keep94/consume2"
 	"github.com/keep94/toolbox/db/sqlite3_db"
+	"github.com/keep94/toolbox/idset"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
 	"github.com/keep94/vsafe/vsafedb/fixture"
 	"github.com/keep94/vsafe/vsafedb/for_sqlite"
 	"github.com/keep94/vsafe/vsafedb/sqlite_setup"
@@ -29,12 +36,24 @@ func TestUsers(t *testing.T) {
 	fixture.Users(t, for_sqlite.New(db))
 }
 
+func TestUsersByOwner(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UsersByOwner(t, for_sqlite.New(db))
+}
+
 func TestUpdateUser(t *testing.T) {
 	db := openDb(t)
 	defer closeDb(t, db)
 	fixture.UpdateUser(t, for_sqlite.New(db))
 }
 
+func TestUserNameTaken(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UserNameTaken(t, for_sqlite.New(db))
+}
+
 func TestRemoveUser(t *testing.T) {
 	db := openDb(t)
 	defer closeDb(t, db)
@@ -71,6 +90,12 @@ func TestRemoveCategory(t *testing.T) {
 	fixture.RemoveCategory(t, for_sqlite.New(db))
 }
 
+func TestCategoryCountsByOwner(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.CategoryCountsByOwner(t, for_sqlite.New(db))
+}
+
 func TestEntryById(t *testing.T) {
 	db := openDb(t)
 	defer closeDb(t, db)
@@ -95,6 +120,414 @@ func TestRemoveEntry(t *testing.T) {
 	fixture.RemoveEntry(t, for_sqlite.New(db))
 }
 
+func TestShareById(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ShareById(t, for_sqlite.New(db))
+}
+
+func TestShares(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Shares(t, for_sqlite.New(db))
+}
+
+func TestUpdateShare(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UpdateShare(t, for_sqlite.New(db))
+}
+
+func TestRemoveShare(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.RemoveShare(t, for_sqlite.New(db))
+}
+
+func TestCollectionById(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.CollectionById(t, for_sqlite.New(db))
+}
+
+func TestMemberships(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Memberships(t, for_sqlite.New(db))
+}
+
+func TestRemoveCategoryCascades(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	removeCategoryCascades(t, for_sqlite.New(db))
+}
+
+func TestRemoveCategoryNoForeignKeys(t *testing.T) {
+	db := openDbWithDsn(t, ":memory:")
+	defer closeDb(t, db)
+	removeCategoryCascades(t, for_sqlite.New(db))
+}
+
+func removeCategoryCascades(t *testing.T, store for_sqlite.Store) {
+	t.Helper()
+	first := vsafe.Category{Owner: 1, Name: "first"}
+	second := vsafe.Category{Owner: 1, Name: "second"}
+	for _, category := range []*vsafe.Category{&first, &second} {
+		if err := store.AddCategory(nil, category); err != nil {
+			t.Fatalf("Error adding category: %v", err)
+		}
+	}
+	entry := vsafe.Entry{
+		Owner:      1,
+		Title:      "foo",
+		Categories: idset.New(map[int64]bool{first.Id: true, second.Id: true}),
+	}
+	if err := store.AddEntry(nil, &entry); err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+	if err := store.RemoveCategory(nil, first.Id); err != nil {
+		t.Fatalf("Error removing category: %v", err)
+	}
+	var fetched vsafe.Entry
+	if err := store.EntryById(nil, entry.Id, &fetched); err != nil {
+		t.Fatalf("Error reading entry: %v", err)
+	}
+	expected := idset.New(map[int64]bool{second.Id: true})
+	if fetched.Categories != expected {
+		t.Errorf("Expected categories %s, got %s", expected, fetched.Categories)
+	}
+	if err := store.RemoveEntry(nil, entry.Id, entry.Owner); err != nil {
+		t.Fatalf("Error removing entry: %v", err)
+	}
+	counts, err := store.CategoryCountsByOwner(nil, 1)
+	if err != nil {
+		t.Fatalf("Error reading counts: %v", err)
+	}
+	if len(counts) != 1 || counts[0].Entries != 0 {
+		t.Errorf("Expected no entries in second category, got %v", counts)
+	}
+}
+
+func TestAddEntryBadCategory(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	store := for_sqlite.New(db)
+	entry := vsafe.Entry{Owner: 1, Title: "foo", Categories: "99"}
+	if err := store.AddEntry(nil, &entry); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	other := vsafe.Category{Owner: 2, Name: "other"}
+	if err := store.AddCategory(nil, &other); err != nil {
+		t.Fatalf("Error adding category: %v", err)
+	}
+	entry = vsafe.Entry{
+		Owner: 1, Title: "foo", Categories: idset.New(map[int64]bool{other.Id: true})}
+	if err := store.AddEntry(nil, &entry); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId for other owner's category, got %v", err)
+	}
+	entry = vsafe.Entry{Owner: 1, Title: "foo"}
+	if err := store.AddEntry(nil, &entry); err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+	entry.Categories = idset.New(map[int64]bool{other.Id: true})
+	if err := store.UpdateEntry(nil, &entry); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId updating to other owner's category, got %v", err)
+	}
+}
+
+func TestSearchEntries(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	store := for_sqlite.New(db)
+	if err := db.Do(sqlite_setup.AddSearchIndex); err == sqlite_setup.ErrNoFts5 {
+		t.Skip("Full text search needs -tags sqlite_fts5")
+	} else if err != nil {
+		t.Fatalf("Error adding search index: %v", err)
+	}
+	google, _ := url.Parse("http://www.google.com")
+	first := vsafe.Entry{Owner: 1, Title: "First Entry", Url: google}
+	second := vsafe.Entry{Owner: 1, Title: "second", Desc: "Say \"hi\""}
+	other := vsafe.Entry{Owner: 2, Title: "first of other owner"}
+	for _, entry := range []*vsafe.Entry{&first, &second, &other} {
+		if err := store.AddEntry(nil, entry); err != nil {
+			t.Fatalf("Error adding entry: %v", err)
+		}
+	}
+	if err := store.SearchEntries(
+		nil, 1, "fi", consume2.Nil[vsafe.Entry]()); err != vsafedb.ErrSearchUnsupported {
+		t.Errorf("Expected ErrSearchUnsupported for short query, got %v", err)
+	}
+	if err := store.SearchEntries(
+		nil, 1, "first entry", consume2.Nil[vsafe.Entry]()); err != vsafedb.ErrSearchUnsupported {
+		t.Errorf("Expected ErrSearchUnsupported for query with space, got %v", err)
+	}
+	assertSearch(t, store, "first", first.Id)
+	assertSearch(t, store, "oogl", first.Id)
+	assertSearch(t, store, "\"hi\"", second.Id)
+	assertSearch(t, store, "xyz")
+	second.Title = "another first"
+	if err := store.UpdateEntry(nil, &second); err != nil {
+		t.Fatalf("Error updating entry: %v", err)
+	}
+	assertSearch(t, store, "first", first.Id, second.Id)
+	if err := store.RemoveEntry(nil, first.Id, first.Owner); err != nil {
+		t.Fatalf("Error removing entry: %v", err)
+	}
+	assertSearch(t, store, "first", second.Id)
+}
+
+func TestEntriesWithSearchIndex(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	store := for_sqlite.New(db)
+	if err := db.Do(sqlite_setup.AddSearchIndex); err == sqlite_setup.ErrNoFts5 {
+		t.Skip("Full text search needs -tags sqlite_fts5")
+	} else if err != nil {
+		t.Fatalf("Error adding search index: %v", err)
+	}
+	var user vsafe.User
+	if err := user.Init("bob", "secret"); err != nil {
+		t.Fatalf("Error initializing user: %v", err)
+	}
+	if err := store.AddUser(nil, &user); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	key, err := user.VerifyPassword("secret")
+	if err != nil {
+		t.Fatalf("Error verifying password: %v", err)
+	}
+	entry := vsafe.Entry{Title: "foo  bar"}
+	if _, err := vsafedb.AddEntry(store, nil, &user, key, &entry); err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+	// Entries finds the same entries whether or not there is an index
+	for _, query := range []string{`"foo bar"`, `"foo  bar"`, "foo bar"} {
+		entries, err := vsafedb.Entries(store, &user, key, query, 0)
+		if err != nil {
+			t.Fatalf("Error reading entries for %q: %v", query, err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("Expected 1 entry for %q, got %d", query, len(entries))
+		}
+	}
+}
+
+func TestSearchEntriesNoIndex(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	store := for_sqlite.New(db)
+	if err := store.SearchEntries(
+		nil, 1, "first", consume2.Nil[vsafe.Entry]()); err != vsafedb.ErrSearchUnsupported {
+		t.Errorf("Expected ErrSearchUnsupported without index, got %v", err)
+	}
+}
+
+func TestEntryPage(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	store := for_sqlite.New(db)
+	category := vsafe.Category{Owner: 1, Name: "cat"}
+	if err := store.AddCategory(nil, &category); err != nil {
+		t.Fatalf("Error adding category: %v", err)
+	}
+	inCategory := idset.New(map[int64]bool{category.Id: true})
+	entries := []*vsafe.Entry{
+		{Owner: 1, Title: "delta", Categories: inCategory},
+		{Owner: 1, Title: " Charlie"},
+		{Owner: 1, Title: "bravo", Categories: inCategory},
+		{Owner: 2, Title: "alpha"},
+		{Owner: 1, Title: "Alpha", Categories: inCategory},
+	}
+	for _, entry := range entries {
+		if err := store.AddEntry(nil, entry); err != nil {
+			t.Fatalf("Error adding entry: %v", err)
+		}
+	}
+	byTitle := &vsafedb.EntryRange{Owner: 1, Order: vsafedb.ByTitle}
+	verifyEntryPage(
+		t, store, byTitle, 0, 2, 4, "Alpha", "bravo")
+	verifyEntryPage(
+		t, store, byTitle, 2, 2, 4, " Charlie", "delta")
+	verifyEntryPage(
+		t, store, byTitle, 1, 0, 4, "bravo", " Charlie", "delta")
+	verifyEntryPage(
+		t,
+		store,
+		&vsafedb.EntryRange{
+			Owner: 1, CatId: category.Id, Order: vsafedb.NewestFirst},
+		0, 2, 3, "Alpha", "bravo")
+	verifyEntryPosition(t, store, byTitle, entries[0].Id, 3)
+	verifyEntryPosition(t, store, byTitle, entries[4].Id, 0)
+	verifyEntryPosition(
+		t,
+		store,
+		&vsafedb.EntryRange{Owner: 1, Order: vsafedb.NewestFirst},
+		entries[1].Id,
+		2)
+	if _, err := store.EntryPosition(
+		nil,
+		&vsafedb.EntryRange{Owner: 1, CatId: category.Id},
+		entries[1].Id); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if _, err := store.EntryPage(
+		nil,
+		&vsafedb.EntryRange{Owner: 1, Order: vsafedb.BestMatch},
+		0,
+		2,
+		consume2.Nil[vsafe.Entry]()); err == nil {
+		t.Error("Expected error for best match order")
+	}
+}
+
+func TestEntryPageSortsLikeSortByTitle(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	store := for_sqlite.New(db)
+	titles := []string{
+		"\u00a0apple", "Banana", "banana", "\tbanana ", "Émile", "éclair",
+		"zebra", "Zebra", "apple", "Ölkanne",
+	}
+	var entries []*vsafe.Entry
+	for _, title := range titles {
+		entry := &vsafe.Entry{Owner: 1, Title: title}
+		if err := store.AddEntry(nil, entry); err != nil {
+			t.Fatalf("Error adding entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	vsafedb.SortByTitle(entries)
+	var page []vsafe.Entry
+	if _, err := store.EntryPage(
+		nil,
+		&vsafedb.EntryRange{Owner: 1, Order: vsafedb.ByTitle},
+		0,
+		len(titles),
+		consume2.AppendTo(&page)); err != nil {
+		t.Fatalf("Error reading entries: %v", err)
+	}
+	if len(page) != len(entries) {
+		t.Fatalf("Expected %d entries, got %d", len(entries), len(page))
+	}
+	for i := range page {
+		if page[i].Id != entries[i].Id {
+			t.Errorf(
+				"At %d, expected %q, got %q",
+				i, entries[i].Title, page[i].Title)
+		}
+	}
+}
+
+func verifyEntryPage(
+	t *testing.T,
+	store for_sqlite.Store,
+	r *vsafedb.EntryRange,
+	offset, limit, expectedTotal int,
+	expectedTitles ...string) {
+	t.Helper()
+	var entries []vsafe.Entry
+	total, err := store.EntryPage(
+		nil, r, offset, limit, consume2.AppendTo(&entries))
+	if err != nil {
+		t.Fatalf("Error reading page: %v", err)
+	}
+	if total != expectedTotal {
+		t.Errorf("Expected total %d, got %d", expectedTotal, total)
+	}
+	var titles []string
+	for _, entry := range entries {
+		titles = append(titles, entry.Title)
+	}
+	if !reflect.DeepEqual(expectedTitles, titles) {
+		t.Errorf("Expected %v, got %v", expectedTitles, titles)
+	}
+}
+
+func verifyEntryPosition(
+	t *testing.T,
+	store for_sqlite.Store,
+	r *vsafedb.EntryRange,
+	id int64,
+	expected int) {
+	t.Helper()
+	position, err := store.EntryPosition(nil, r, id)
+	if err != nil {
+		t.Fatalf("Error reading position: %v", err)
+	}
+	if position != expected {
+		t.Errorf("Expected position %d, got %d", expected, position)
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	store := for_sqlite.New(db)
+	entry := vsafe.Entry{Owner: 1, Title: "foo"}
+	if err := store.AddEntryContext(
+		context.Background(), nil, &entry); err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var fetched vsafe.Entry
+	if err := store.EntryByIdContext(
+		ctx, nil, entry.Id, &fetched); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	var entries []vsafe.Entry
+	if err := store.EntriesByOwnerContext(
+		ctx, nil, 1, consume2.AppendTo(&entries)); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	entry.Title = "bar"
+	if err := store.UpdateEntryContext(ctx, nil, &entry); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := store.EntryById(nil, entry.Id, &fetched); err != nil {
+		t.Fatalf("Error reading entry: %v", err)
+	}
+	if fetched.Title != "foo" {
+		t.Errorf("Expected canceled update to change nothing, got %s", fetched.Title)
+	}
+}
+
+var (
+	_ vsafedb.UserByIdContextRunner              = for_sqlite.Store{}
+	_ vsafedb.UserByNameContextRunner            = for_sqlite.Store{}
+	_ vsafedb.UsersByOwnerContextRunner          = for_sqlite.Store{}
+	_ vsafedb.AddCategoryContextRunner           = for_sqlite.Store{}
+	_ vsafedb.CategoryByIdContextRunner          = for_sqlite.Store{}
+	_ vsafedb.CategoriesByOwnerContextRunner     = for_sqlite.Store{}
+	_ vsafedb.CategoryCountsByOwnerContextRunner = for_sqlite.Store{}
+	_ vsafedb.UpdateCategoryContextRunner        = for_sqlite.Store{}
+	_ vsafedb.RemoveCategoryContextRunner        = for_sqlite.Store{}
+	_ vsafedb.AddEntryContextRunner              = for_sqlite.Store{}
+	_ vsafedb.EntryByIdContextRunner             = for_sqlite.Store{}
+	_ vsafedb.EntriesByOwnerContextRunner        = for_sqlite.Store{}
+	_ vsafedb.UpdateEntryContextRunner           = for_sqlite.Store{}
+	_ vsafedb.RemoveEntryContextRunner           = for_sqlite.Store{}
+	_ vsafedb.SharesByRecipientContextRunner     = for_sqlite.Store{}
+	_ vsafedb.SharesBySenderContextRunner        = for_sqlite.Store{}
+)
+
+func assertSearch(
+	t *testing.T, store for_sqlite.Store, query string, expected ...int64) {
+	t.Helper()
+	var entries []vsafe.Entry
+	if err := store.SearchEntries(
+		nil, 1, query, consume2.AppendTo(&entries)); err != nil {
+		t.Fatalf("Error searching for %s: %v", query, err)
+	}
+	var actual []int64
+	for _, entry := range entries {
+		actual = append(actual, entry.Id)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Searching for %s: expected %v, got %v", query, expected, actual)
+	}
+}
+
 func closeDb(t *testing.T, db *sqlite3_db.Db) {
 	if err := db.Close(); err != nil {
 		t.Errorf("Error closing database: %v", err)
@@ -102,7 +535,11 @@ func closeDb(t *testing.T, db *sqlite3_db.Db) {
 }
 
 func openDb(t *testing.T) *sqlite3_db.Db {
-	rawdb, err := sql.Open("sqlite3", ":memory:")
+	return openDbWithDsn(t, ":memory:?_foreign_keys=on")
+}
+
+func openDbWithDsn(t *testing.T, dsn string) *sqlite3_db.Db {
+	rawdb, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		t.Fatalf("Error opening database: %v", err)
 	}
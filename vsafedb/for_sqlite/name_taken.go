@@ -0,0 +1,21 @@
+//go:build cgo
+
+package for_sqlite
+
+import (
+	"strings"
+
+	"github.com/keep94/vsafe/vsafedb"
+	"github.com/mattn/go-sqlite3"
+)
+
+// nameTaken converts a violation of the unique index on user names into
+// vsafedb.ErrNameTaken. Other errors, including violations of other
+// unique constraints, pass through unchanged.
+func nameTaken(err error) error {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && strings.Contains(sqliteErr.Error(), "user.name") {
+		return vsafedb.ErrNameTaken
+	}
+	return err
+}
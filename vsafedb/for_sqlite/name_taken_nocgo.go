@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package for_sqlite
+
+// nameTaken returns err unchanged. Without cgo, go-sqlite3 cannot open a
+// database at all, so there are no sqlite errors to convert. This file
+// lets programs that also support pure Go backends build without cgo.
+func nameTaken(err error) error {
+	return err
+}
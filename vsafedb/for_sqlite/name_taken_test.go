@@ -0,0 +1,57 @@
+//go:build cgo
+
+package for_sqlite
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/keep94/toolbox/db/sqlite3_db"
+	"github.com/keep94/vsafe/vsafedb"
+	"github.com/keep94/vsafe/vsafedb/sqlite_setup"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestNameTaken(t *testing.T) {
+	rawdb, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	dbase := sqlite3_db.New(rawdb)
+	defer dbase.Close()
+	if err := dbase.Do(sqlite_setup.SetUpTables); err != nil {
+		t.Fatalf("Error creating tables: %v", err)
+	}
+	dupUser := insertTwice(
+		t, dbase, "insert into user (name) values ('foo')")
+	if nameTaken(dupUser) != vsafedb.ErrNameTaken {
+		t.Errorf("Expected ErrNameTaken, got %v", nameTaken(dupUser))
+	}
+	// Other unique constraints are not about names.
+	dupMember := insertTwice(
+		t, dbase, "insert into membership (collection_id, user_id, key) values (1, 2, '')")
+	if nameTaken(dupMember) != dupMember {
+		t.Errorf("Expected error unchanged, got %v", nameTaken(dupMember))
+	}
+}
+
+// insertTwice runs insert twice and returns the error from the second
+// time.
+func insertTwice(
+	t *testing.T, dbase *sqlite3_db.Db, insert string) error {
+	t.Helper()
+	if err := dbase.Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(insert)
+		return err
+	}); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	err := dbase.Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(insert)
+		return err
+	})
+	if err == nil {
+		t.Fatal("Expected unique constraint violation")
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package for_sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db/sqlite3_rw"
+)
+
+// The functions in this file work like their counterparts in sqlite3_rw
+// but run their SQL with ctx so that a done context stops them.
+
+func readSingle(
+	ctx context.Context,
+	tx *sql.Tx,
+	row sqlite3_rw.RowForReading,
+	noSuchRow error,
+	sql string,
+	params ...interface{}) error {
+	dbrows, err := tx.QueryContext(ctx, sql, params...)
+	if err != nil {
+		return err
+	}
+	defer dbrows.Close()
+	return sqlite3_rw.FirstOnly(row, dbrows, noSuchRow)
+}
+
+func readMultiple[T any](
+	ctx context.Context,
+	tx *sql.Tx,
+	row sqlite3_rw.RowsForReading[T],
+	consumer consume2.Consumer[T],
+	sql string,
+	params ...interface{}) error {
+	dbrows, err := tx.QueryContext(ctx, sql, params...)
+	if err != nil {
+		return err
+	}
+	defer dbrows.Close()
+	return sqlite3_rw.ReadRows(row, dbrows, consumer)
+}
+
+func addRow(
+	ctx context.Context,
+	tx *sql.Tx,
+	row sqlite3_rw.RowForWriting,
+	rowId *int64,
+	sql string) error {
+	values, err := sqlite3_rw.InsertValues(row)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, sql, values...)
+	if err != nil {
+		return err
+	}
+	*rowId, err = result.LastInsertId()
+	return err
+}
+
+func updateRow(
+	ctx context.Context,
+	tx *sql.Tx,
+	row sqlite3_rw.RowForWriting,
+	sql string) error {
+	values, err := sqlite3_rw.UpdateValues(row)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, sql, values...)
+	return err
+}
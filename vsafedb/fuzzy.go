@@ -0,0 +1,284 @@
+package vsafedb
+
+import (
+	"context"
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/str_util"
+	"github.com/keep94/vsafe"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// How much a match in each field counts
+	kFuzzyTitleWeight = 1.0
+	kFuzzyHostWeight  = 0.9
+	kFuzzyDescWeight  = 0.7
+
+	// Scores for the different ways a term can match text
+	kFuzzyWordStart   = 1.0
+	kFuzzySubstring   = 0.9
+	kFuzzyCompact     = 0.85
+	kFuzzyEditMax     = 0.8
+	kFuzzySubseqMax   = 0.6
+	kFuzzyMinTermSize = 3
+)
+
+// FuzzyEntries works like Entries except that it tolerates typos and
+// extra spaces in the plain terms of query and orders the entries it
+// returns from best match to worst. Terms with a field prefix, negated
+// terms, and terms joined with OR must still match exactly. Entries that
+// match equally well are ordered by title. If query has no plain terms,
+// FuzzyEntries orders entries by title.
+func FuzzyEntries(
+	store EntriesByOwnerRunner,
+	user *vsafe.User,
+	key *vsafe.Key,
+	query string,
+	catId int64) ([]*vsafe.Entry, error) {
+	return FuzzyEntriesContext(
+		context.Background(), store, user, key, query, catId)
+}
+
+// FuzzyEntriesContext works like FuzzyEntries but passes ctx to store.
+func FuzzyEntriesContext(
+	ctx context.Context,
+	store EntriesByOwnerRunner,
+	user *vsafe.User,
+	key *vsafe.Key,
+	query string,
+	catId int64) ([]*vsafe.Entry, error) {
+	parsed, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	strict, terms := parsed.fuzzyTerms()
+	filter, err := newQueryFilter(ctx, store, user, key, strict, catId)
+	if err != nil {
+		return nil, err
+	}
+	var scored []scoredEntry
+	consumer := consume2.ConsumerFunc[vsafe.Entry](func(entry vsafe.Entry) {
+		if !filter(entry) {
+			return
+		}
+		score := 1.0
+		if len(terms) > 0 {
+			score = fuzzyScore(terms, &entry)
+		}
+		if score > 0 {
+			scored = append(scored, scoredEntry{
+				entry: &entry,
+				score: score,
+				title: strings.TrimSpace(strings.ToLower(entry.Title))})
+		}
+	})
+	if err := entriesByOwner(ctx, store, nil, key.Id, consumer); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].title < scored[j].title
+	})
+	results := make([]*vsafe.Entry, len(scored))
+	for i := range scored {
+		results[i] = scored[i].entry
+	}
+	return results, nil
+}
+
+type scoredEntry struct {
+	entry *vsafe.Entry
+	score float64
+	title string
+}
+
+// fuzzyTerms splits q into the plain terms that can match fuzzily and a
+// query with the clauses that must still match exactly.
+func (q *Query) fuzzyTerms() (strict *Query, terms []string) {
+	strict = &Query{}
+	for _, clause := range q.clauses {
+		if len(clause) == 1 &&
+			clause[0].field == "" &&
+			!clause[0].negate &&
+			clause[0].text != "" {
+			terms = append(terms, clause[0].text)
+		} else {
+			strict.clauses = append(strict.clauses, clause)
+		}
+	}
+	return
+}
+
+// fuzzyScore returns how well entry matches terms from 0 for no match to
+// 1 for a perfect match. Each term must match for the entry to match, but
+// the terms joined together may match instead so that "git hub" finds
+// GitHub.
+func fuzzyScore(terms []string, entry *vsafe.Entry) float64 {
+	texts := []weightedText{
+		{text: str_util.Normalize(entry.Title), weight: kFuzzyTitleWeight},
+		{text: urlHost(entry), weight: kFuzzyHostWeight},
+		{text: str_util.Normalize(entry.Desc), weight: kFuzzyDescWeight},
+	}
+	var total float64
+	for _, term := range terms {
+		score := bestTermScore(term, texts)
+		if score == 0 {
+			total = 0
+			break
+		}
+		total += score
+	}
+	result := total / float64(len(terms))
+	if len(terms) > 1 {
+		joined := bestTermScore(strings.Join(terms, ""), texts)
+		if joined > result {
+			result = joined
+		}
+	}
+	return result
+}
+
+type weightedText struct {
+	text   string
+	weight float64
+}
+
+func bestTermScore(term string, texts []weightedText) float64 {
+	var result float64
+	for _, wt := range texts {
+		if score := wt.weight * termScore(term, wt.text); score > result {
+			result = score
+		}
+	}
+	return result
+}
+
+func urlHost(entry *vsafe.Entry) string {
+	if entry.Url == nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(entry.Url.Hostname()), "www.")
+}
+
+// termScore returns how well term matches text. Both are normalized.
+func termScore(term, text string) float64 {
+	if term == "" || text == "" {
+		return 0
+	}
+	if idx := strings.Index(text, term); idx != -1 {
+		if idx == 0 || !isWordRune(lastRune(text[:idx])) {
+			return kFuzzyWordStart
+		}
+		return kFuzzySubstring
+	}
+	compact := strings.ReplaceAll(text, " ", "")
+	term = strings.ReplaceAll(term, " ", "")
+	if strings.Contains(compact, term) {
+		return kFuzzyCompact
+	}
+	termRunes := []rune(term)
+	if len(termRunes) < kFuzzyMinTermSize {
+		return 0
+	}
+	var result float64
+	maxDistance := len(termRunes) / 4
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !isWordRune(r)
+	}) {
+		wordRunes := []rune(word)
+		distance := editDistance(termRunes, wordRunes)
+		// Let a term match the start of a longer word too
+		if len(wordRunes) > len(termRunes) {
+			prefix := editDistance(termRunes, wordRunes[:len(termRunes)])
+			if prefix < distance {
+				distance = prefix
+			}
+		}
+		if distance <= maxDistance {
+			score := kFuzzyEditMax * (1.0 - float64(distance)/float64(len(termRunes)+1))
+			if score > result {
+				result = score
+			}
+		}
+	}
+	if span := subsequenceSpan(termRunes, []rune(compact)); span > 0 && span <= 2*len(termRunes) {
+		score := kFuzzySubseqMax * float64(len(termRunes)) / float64(span)
+		if score > result {
+			result = score
+		}
+	}
+	return result
+}
+
+// subsequenceSpan returns the length of the shortest part of text that
+// contains the runes of term in order or 0 if there is none.
+func subsequenceSpan(term, text []rune) int {
+	result := 0
+	for start := range text {
+		if text[start] != term[0] {
+			continue
+		}
+		matched := 0
+		end := start
+		for ; end < len(text) && matched < len(term); end++ {
+			if text[end] == term[matched] {
+				matched++
+			}
+		}
+		if matched == len(term) {
+			if span := end - start; result == 0 || span < result {
+				result = span
+			}
+		}
+	}
+	return result
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(
+				previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
@@ -0,0 +1,52 @@
+package vsafedb_test
+
+import (
+	"errors"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyEntries(t *testing.T) {
+	store := &FakeQueryStore{}
+	amazon, _ := url.Parse("https://www.amazon.com")
+	github, _ := url.Parse("https://github.com")
+	addQueryEntry(t, store, &vsafe.Entry{Title: "Amazon", Url: amazon})
+	addQueryEntry(t, store, &vsafe.Entry{Title: "GitHub", Url: github})
+	addQueryEntry(t, store, &vsafe.Entry{
+		Title: "Bank", Desc: "savings at amazing bank"})
+	addQueryEntry(t, store, &vsafe.Entry{Title: "amazon prime video"})
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"Amazon", "amazon prime video", "Bank", "GitHub"}},
+		{"amazn", []string{"Amazon", "amazon prime video", "Bank"}},
+		{"git hub", []string{"GitHub"}},
+		{"githb", []string{"GitHub"}},
+		{"prime", []string{"amazon prime video"}},
+		{"amazon -prime", []string{"Amazon", "Bank"}},
+		{"title:bank", []string{"Bank"}},
+		{"xyzzy", nil},
+	}
+	for _, tc := range testCases {
+		entries, err := vsafedb.FuzzyEntries(store, kUser, kKey, tc.query, 0)
+		if err != nil {
+			t.Errorf("Got error for %s: %v", tc.query, err)
+			continue
+		}
+		var actual []string
+		for _, entry := range entries {
+			actual = append(actual, entry.Title)
+		}
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("For %s: expected %v, got %v", tc.query, tc.expected, actual)
+		}
+	}
+	_, err := vsafedb.FuzzyEntries(store, kUser, kKey, `"unclosed`, 0)
+	if !errors.Is(err, vsafedb.ErrBadQuery) {
+		t.Errorf("Expected ErrBadQuery, got %v", err)
+	}
+}
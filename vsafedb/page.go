@@ -0,0 +1,197 @@
+package vsafedb
+
+import (
+	"context"
+	"github.com/keep94/consume2"
+	"github.com/keep94/vsafe"
+)
+
+// EntryOrder says how PagedEntries orders entries.
+type EntryOrder int
+
+const (
+	// Order by id
+	ById EntryOrder = iota
+	// Order by title ignoring case
+	ByTitle
+	// Order by id with newest entries first
+	NewestFirst
+	// Order from best match to worst. See FuzzyEntries.
+	BestMatch
+)
+
+// EntriesOptions says which entries PagedEntries returns.
+type EntriesOptions struct {
+	// The search query. See ParseQuery.
+	Query string
+	// If non-zero, entries must belong to this category.
+	CatId int64
+	// The order of the entries
+	Order EntryOrder
+	// How many matching entries to skip
+	Offset int
+	// The most entries to return. 0 means no limit.
+	Limit int
+	// If non-zero and one of the matching entries, PagedEntries ignores
+	// Offset and returns the page holding this entry instead.
+	CurrentId int64
+}
+
+// EntryRange says which entries an EntryPageRunner reads.
+type EntryRange struct {
+	// The owner of the entries
+	Owner int64
+	// If non-zero, entries must belong to this category.
+	CatId int64
+	// ById, ByTitle, or NewestFirst
+	Order EntryOrder
+}
+
+// EntriesPage is one page of matching entries.
+type EntriesPage struct {
+	// The entries on this page
+	Entries []*vsafe.Entry
+	// How many matching entries come before this page.
+	Offset int
+	// How many entries match across all pages
+	Total int
+}
+
+// End returns true if this is the last page.
+func (p *EntriesPage) End() bool {
+	return p.Offset+len(p.Entries) >= p.Total
+}
+
+// PagedEntries returns one page of the entries encrypted with key that
+// are visible to user and that match options. When Limit is positive,
+// PagedEntries rounds Offset down to a multiple of Limit and moves it
+// back to the last page if it is past the end. Like Entries, PagedEntries
+// does not decrypt sensitive fields and returns an error wrapping
+// ErrBadQuery if the query does not parse.
+func PagedEntries(
+	store EntriesByOwnerRunner,
+	user *vsafe.User,
+	key *vsafe.Key,
+	options *EntriesOptions) (*EntriesPage, error) {
+	return PagedEntriesContext(context.Background(), store, user, key, options)
+}
+
+// PagedEntriesContext works like PagedEntries but passes ctx to store.
+// If store implements EntryPageRunner, PagedEntriesContext reads only one
+// page from store when Limit is positive, the query is empty, the order
+// is not BestMatch, and user may see every entry. Otherwise it reads all
+// matching entries and pages through them in memory.
+func PagedEntriesContext(
+	ctx context.Context,
+	store EntriesByOwnerRunner,
+	user *vsafe.User,
+	key *vsafe.Key,
+	options *EntriesOptions) (*EntriesPage, error) {
+	parsed, err := ParseQuery(options.Query)
+	if err != nil {
+		return nil, err
+	}
+	if options.Limit > 0 &&
+		options.Order != BestMatch &&
+		parsed.isEmpty() &&
+		!user.IsRestricted() &&
+		canReadEntryPage(store) {
+		return readEntryPage(ctx, store, key, options)
+	}
+	var entries []*vsafe.Entry
+	if options.Order == BestMatch {
+		entries, err = FuzzyEntriesContext(
+			ctx, store, user, key, options.Query, options.CatId)
+	} else {
+		entries, err = EntriesContext(
+			ctx, store, user, key, options.Query, options.CatId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch options.Order {
+	case ByTitle:
+		SortByTitle(entries)
+	case NewestFirst:
+		Reverse(entries)
+	}
+	if options.Limit <= 0 {
+		return &EntriesPage{Entries: entries, Total: len(entries)}, nil
+	}
+	offset := options.Offset
+	if options.CurrentId != 0 {
+		for i := range entries {
+			if entries[i].Id == options.CurrentId {
+				offset = i
+				break
+			}
+		}
+	}
+	offset = pageOffset(offset, len(entries), options.Limit)
+	end := offset + options.Limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+	return &EntriesPage{
+		Entries: entries[offset:end],
+		Offset:  offset,
+		Total:   len(entries),
+	}, nil
+}
+
+// readEntryPage reads the page that options asks for using the
+// EntryPageRunner that store implements.
+func readEntryPage(
+	ctx context.Context,
+	store interface{},
+	key *vsafe.Key,
+	options *EntriesOptions) (*EntriesPage, error) {
+	r := &EntryRange{
+		Owner: key.Id, CatId: options.CatId, Order: options.Order}
+	offset := options.Offset
+	if options.CurrentId != 0 {
+		position, err := entryPosition(
+			ctx, store, nil, r, options.CurrentId)
+		if err == nil {
+			offset = position
+		} else if err != ErrNoSuchId {
+			return nil, err
+		}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	offset -= offset % options.Limit
+	var entries []*vsafe.Entry
+	total, err := entryPage(
+		ctx, store, nil, r, offset, options.Limit,
+		consume2.AppendPtrsTo(&entries))
+	if err != nil {
+		return nil, err
+	}
+	// offset is past the end, so read the last page instead.
+	if total > 0 && offset >= total {
+		offset = pageOffset(offset, total, options.Limit)
+		entries = nil
+		total, err = entryPage(
+			ctx, store, nil, r, offset, options.Limit,
+			consume2.AppendPtrsTo(&entries))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &EntriesPage{Entries: entries, Offset: offset, Total: total}, nil
+}
+
+// pageOffset returns the offset of the page of size limit holding the
+// entry at offset among total entries. If offset is past the end,
+// pageOffset returns the offset of the last page.
+func pageOffset(offset, total, limit int) int {
+	if offset >= total {
+		offset = total - 1
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return offset - offset%limit
+}
@@ -0,0 +1,199 @@
+package vsafedb_test
+
+import (
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"reflect"
+	"testing"
+)
+
+func TestPagedEntries(t *testing.T) {
+	store := &FakeQueryStore{}
+	for _, title := range []string{"e", "d", "c", "b", "a"} {
+		addQueryEntry(t, store, &vsafe.Entry{Title: title})
+	}
+	testCases := []struct {
+		options  vsafedb.EntriesOptions
+		expected []string
+		offset   int
+		end      bool
+	}{
+		{
+			options:  vsafedb.EntriesOptions{},
+			expected: []string{"e", "d", "c", "b", "a"},
+			end:      true,
+		},
+		{
+			options:  vsafedb.EntriesOptions{Order: vsafedb.ByTitle, Limit: 2},
+			expected: []string{"a", "b"},
+		},
+		{
+			options: vsafedb.EntriesOptions{
+				Order: vsafedb.ByTitle, Limit: 2, Offset: 3},
+			expected: []string{"c", "d"},
+			offset:   2,
+		},
+		{
+			options: vsafedb.EntriesOptions{
+				Order: vsafedb.ByTitle, Limit: 2, Offset: 99},
+			expected: []string{"e"},
+			offset:   4,
+			end:      true,
+		},
+		{
+			options: vsafedb.EntriesOptions{
+				Order: vsafedb.NewestFirst, Limit: 2, CurrentId: 1},
+			expected: []string{"e"},
+			offset:   4,
+			end:      true,
+		},
+		{
+			options: vsafedb.EntriesOptions{
+				Order: vsafedb.ByTitle, Limit: 2, Offset: 4, CurrentId: 99},
+			expected: []string{"e"},
+			offset:   4,
+			end:      true,
+		},
+		{
+			options: vsafedb.EntriesOptions{
+				Query: "-c", Order: vsafedb.ById, Limit: 3, Offset: 3},
+			expected: []string{"a"},
+			offset:   3,
+			end:      true,
+		},
+	}
+	for _, tc := range testCases {
+		page, err := vsafedb.PagedEntries(store, kUser, kKey, &tc.options)
+		if err != nil {
+			t.Errorf("Got error for %v: %v", tc.options, err)
+			continue
+		}
+		var actual []string
+		for _, entry := range page.Entries {
+			actual = append(actual, entry.Title)
+		}
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("For %v: expected %v, got %v", tc.options, tc.expected, actual)
+		}
+		if page.Offset != tc.offset {
+			t.Errorf("For %v: expected offset %d, got %d", tc.options, tc.offset, page.Offset)
+		}
+		if page.End() != tc.end {
+			t.Errorf("For %v: expected end %v", tc.options, tc.end)
+		}
+	}
+	page, err := vsafedb.PagedEntries(
+		store, kUser, kKey, &vsafedb.EntriesOptions{Query: "-c", Limit: 2})
+	if err != nil {
+		t.Fatalf("Got error: %v", err)
+	}
+	if page.Total != 4 {
+		t.Errorf("Expected 4 total, got %d", page.Total)
+	}
+}
+
+func TestPagedEntriesFromStore(t *testing.T) {
+	store := &FakePageStore{}
+	for _, title := range []string{"e", "d", "c", "b", "a"} {
+		addQueryEntry(t, &store.FakeQueryStore, &vsafe.Entry{Title: title})
+	}
+	optionsList := []vsafedb.EntriesOptions{
+		{Order: vsafedb.ByTitle, Limit: 2},
+		{Order: vsafedb.ByTitle, Limit: 2, Offset: 99},
+		{Order: vsafedb.ById, Limit: 2, Offset: 3},
+		{Order: vsafedb.NewestFirst, Limit: 2, CurrentId: 1},
+		{Order: vsafedb.NewestFirst, Limit: 2, CurrentId: 99},
+	}
+	for _, options := range optionsList {
+		expected, err := vsafedb.PagedEntries(
+			store.FakeQueryStore, kUser, kKey, &options)
+		if err != nil {
+			t.Fatalf("Got error for %v: %v", options, err)
+		}
+		store.Pages = 0
+		actual, err := vsafedb.PagedEntries(store, kUser, kKey, &options)
+		if err != nil {
+			t.Fatalf("Got error for %v: %v", options, err)
+		}
+		if store.Pages == 0 {
+			t.Errorf("For %v: expected store to read the page", options)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("For %v: expected %v, got %v", options, expected, actual)
+		}
+	}
+	// Queries, best match, and restricted users read every entry.
+	restricted := &vsafe.User{Id: 8, Owner: 7, Categories: "3"}
+	store.Pages = 0
+	vsafedb.PagedEntries(
+		store, kUser, kKey, &vsafedb.EntriesOptions{Query: "-c", Limit: 2})
+	vsafedb.PagedEntries(
+		store,
+		kUser,
+		kKey,
+		&vsafedb.EntriesOptions{Order: vsafedb.BestMatch, Limit: 2})
+	vsafedb.PagedEntries(
+		store, restricted, kKey, &vsafedb.EntriesOptions{Limit: 2})
+	if store.Pages != 0 {
+		t.Errorf("Expected store not to read pages, read %d", store.Pages)
+	}
+}
+
+// FakePageStore reads pages of entries by reading every entry.
+type FakePageStore struct {
+	FakeQueryStore
+	// How many times EntryPage was called
+	Pages int
+}
+
+func (f *FakePageStore) EntryPage(
+	t db.Transaction,
+	r *vsafedb.EntryRange,
+	offset, limit int,
+	consumer consume2.Consumer[vsafe.Entry]) (int, error) {
+	f.Pages++
+	entries := f.entries(r)
+	if offset > len(entries) {
+		offset = len(entries)
+	}
+	end := len(entries)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	for _, entry := range entries[offset:end] {
+		consumer.Consume(*entry)
+	}
+	return len(entries), nil
+}
+
+func (f *FakePageStore) EntryPosition(
+	t db.Transaction, r *vsafedb.EntryRange, id int64) (int, error) {
+	for i, entry := range f.entries(r) {
+		if entry.Id == id {
+			return i, nil
+		}
+	}
+	return 0, vsafedb.ErrNoSuchId
+}
+
+func (f *FakePageStore) entries(r *vsafedb.EntryRange) []*vsafe.Entry {
+	var result []*vsafe.Entry
+	for _, entry := range f.FakeStore {
+		if entry == nil || entry.Owner != r.Owner {
+			continue
+		}
+		if r.CatId != 0 && !entry.Categories.Contains(r.CatId) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	switch r.Order {
+	case vsafedb.ByTitle:
+		vsafedb.SortByTitle(result)
+	case vsafedb.NewestFirst:
+		vsafedb.Reverse(result)
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+// Package postgres_setup sets up a PostgreSQL database for vsafe app.
+//
+// Like sqlite_setup, postgres_setup records the schema version in the
+// schema_version table and upgrades a database by running each pending
+// migration in order. Migrate refuses to touch a database with a schema
+// newer than this package knows about.
+package postgres_setup
+
+import (
+	"database/sql"
+
+	"github.com/keep94/vsafe/vsafedb/schema"
+)
+
+var (
+	// ErrNewerSchema is schema.ErrNewerSchema.
+	ErrNewerSchema = schema.ErrNewerSchema
+	// ErrOlderSchema is schema.ErrOlderSchema.
+	ErrOlderSchema = schema.ErrOlderSchema
+)
+
+// Migration upgrades the schema of a vsafe database from version
+// Version - 1 to Version.
+type Migration = schema.Migration[*sql.Tx]
+
+// Migrations lists every migration in order. Migrations[i].Version is
+// always i + 1. Since vsafe supported PostgreSQL only after the sqlite
+// schema had settled, the first migration creates the whole schema.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up: execAll(
+			"create table users (id BIGSERIAL PRIMARY KEY, owner BIGINT NOT NULL, name TEXT NOT NULL, key TEXT NOT NULL, checksum TEXT NOT NULL, idle_timeout BIGINT NOT NULL, session_lifetime BIGINT NOT NULL, role INTEGER NOT NULL, categories TEXT NOT NULL, public_key TEXT NOT NULL, private_key TEXT NOT NULL, password_history TEXT NOT NULL)",
+			"create unique index users_name_idx on users (name)",
+			"create index users_owner_idx on users (owner)",
+			"create table categories (id BIGSERIAL PRIMARY KEY, owner BIGINT NOT NULL, name TEXT NOT NULL)",
+			"create index categories_owner_idx on categories (owner)",
+			"create table entries (id BIGSERIAL PRIMARY KEY, owner BIGINT NOT NULL, url TEXT NOT NULL, title TEXT NOT NULL, description TEXT NOT NULL, uname TEXT NOT NULL, password TEXT NOT NULL, special TEXT NOT NULL, categories TEXT NOT NULL)",
+			"create index entries_owner_idx on entries (owner)",
+			"create table shares (id BIGSERIAL PRIMARY KEY, sender BIGINT NOT NULL, recipient BIGINT NOT NULL, entry_id BIGINT NOT NULL, payload TEXT NOT NULL)",
+			"create index shares_recipient_idx on shares (recipient)",
+			"create index shares_sender_idx on shares (sender)",
+			"create table collections (id BIGSERIAL PRIMARY KEY, name TEXT NOT NULL)",
+			"create table memberships (id BIGSERIAL PRIMARY KEY, collection_id BIGINT NOT NULL, user_id BIGINT NOT NULL, key TEXT NOT NULL)",
+			"create unique index memberships_user_idx on memberships (user_id, collection_id)",
+			"create index memberships_collection_idx on memberships (collection_id)"),
+	},
+	{
+		Version:     2,
+		Description: "member key pairs",
+		Up: execAll(
+			"alter table users add column member_public_key TEXT NOT NULL DEFAULT ''",
+			"alter table users add column member_private_key TEXT NOT NULL DEFAULT ''"),
+	},
+}
+
+// LatestVersion returns the schema version that this program supports.
+func LatestVersion() int {
+	return runner().LatestVersion()
+}
+
+// SetUpTables creates all needed tables in database for the vsafe app or
+// upgrades them to the latest version. SetUpTables is the same as Migrate.
+func SetUpTables(tx *sql.Tx) error {
+	return Migrate(tx)
+}
+
+// Migrate upgrades the database to the latest schema version by running
+// the pending migrations in order within tx. Running Migrate on an up to
+// date database does nothing. If the database schema is newer than this
+// program supports, Migrate returns ErrNewerSchema.
+func Migrate(tx *sql.Tx) error {
+	return runner().Migrate(tx)
+}
+
+// CheckVersion returns nil if the database schema is at the latest
+// version. Otherwise it returns ErrNewerSchema or ErrOlderSchema.
+func CheckVersion(tx *sql.Tx) error {
+	return runner().CheckVersion(tx)
+}
+
+// Version returns the schema version of the database or 0 for an empty
+// database.
+func Version(tx *sql.Tx) (int, error) {
+	var exists bool
+	err := tx.QueryRow(
+		"select to_regclass('schema_version') is not null").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = tx.QueryRow("select version from schema_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+func runner() *schema.Runner[*sql.Tx] {
+	return &schema.Runner[*sql.Tx]{
+		Name:       "postgres_setup",
+		Migrations: Migrations,
+		Version:    Version,
+		SetVersion: setVersion,
+	}
+}
+
+func setVersion(tx *sql.Tx, version int) error {
+	if _, err := tx.Exec(
+		"create table if not exists schema_version (version INTEGER NOT NULL)"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("delete from schema_version"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(
+		"insert into schema_version (version) values ($1)", version)
+	return err
+}
+
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
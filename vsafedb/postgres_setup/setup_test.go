@@ -0,0 +1,110 @@
+package postgres_setup_test
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keep94/toolbox/db/sqlite3_db"
+	"github.com/keep94/vsafe/vsafedb/fixture"
+	"github.com/keep94/vsafe/vsafedb/postgres_setup"
+	_ "github.com/lib/pq"
+)
+
+func TestMigrateEmpty(t *testing.T) {
+	dbase := openDb(t)
+	verifyVersion(t, dbase, 0)
+	if err := dbase.Do(postgres_setup.CheckVersion); err != postgres_setup.ErrOlderSchema {
+		t.Errorf("Expected ErrOlderSchema, got %v", err)
+	}
+	if err := dbase.Do(postgres_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	verifyVersion(t, dbase, postgres_setup.LatestVersion())
+	if err := dbase.Do(postgres_setup.CheckVersion); err != nil {
+		t.Errorf("Expected latest version, got %v", err)
+	}
+	// Migrating again does nothing
+	if err := dbase.Do(postgres_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating again: %v", err)
+	}
+	verifyVersion(t, dbase, postgres_setup.LatestVersion())
+}
+
+func TestMigrateNewer(t *testing.T) {
+	dbase := openDb(t)
+	if err := dbase.Do(postgres_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	err := dbase.Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"update schema_version set version = $1",
+			postgres_setup.LatestVersion()+1)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error setting version: %v", err)
+	}
+	if err := dbase.Do(postgres_setup.Migrate); err != postgres_setup.ErrNewerSchema {
+		t.Errorf("Expected ErrNewerSchema from Migrate, got %v", err)
+	}
+	if err := dbase.Do(postgres_setup.CheckVersion); err != postgres_setup.ErrNewerSchema {
+		t.Errorf("Expected ErrNewerSchema from CheckVersion, got %v", err)
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, migration := range postgres_setup.Migrations {
+		if migration.Version != i+1 {
+			t.Errorf(
+				"Expected version %d at index %d, got %d",
+				i+1, i, migration.Version)
+		}
+	}
+}
+
+func verifyVersion(t *testing.T, dbase *sqlite3_db.Db, expected int) {
+	t.Helper()
+	var version int
+	err := dbase.Do(func(tx *sql.Tx) (err error) {
+		version, err = postgres_setup.Version(tx)
+		return
+	})
+	if err != nil {
+		t.Fatalf("Error reading version: %v", err)
+	}
+	if version != expected {
+		t.Errorf("Expected version %d, got %d", expected, version)
+	}
+}
+
+// openDb opens the test database with an empty schema of its own that
+// it drops when the test ends so that these tests don't touch the tables
+// that other tests use. If there is no test database, openDb skips the
+// test.
+func openDb(t *testing.T) *sqlite3_db.Db {
+	t.Helper()
+	rawdb, err := sql.Open("postgres", fixture.PostgresUrl(t))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	// search_path belongs to the connection, so use just one.
+	rawdb.SetMaxOpenConns(1)
+	dbase := sqlite3_db.New(rawdb)
+	t.Cleanup(func() { dbase.Close() })
+	name := fmt.Sprintf("vsafe_setup_test_%d", time.Now().UnixNano())
+	if _, err := rawdb.Exec("create schema " + name); err != nil {
+		t.Fatalf("Error creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := rawdb.Exec(
+			"drop schema " + name + " cascade"); err != nil {
+			t.Errorf("Error dropping schema: %v", err)
+		}
+	})
+	if _, err := rawdb.Exec("set search_path to " + name); err != nil {
+		t.Fatalf("Error setting search path: %v", err)
+	}
+	return dbase
+}
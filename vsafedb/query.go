@@ -0,0 +1,328 @@
+package vsafedb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keep94/toolbox/str_util"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/aes"
+	"strings"
+	"unicode"
+)
+
+var (
+	// Indicates that a search query could not be parsed. Errors from
+	// ParseQuery wrap ErrBadQuery.
+	ErrBadQuery = errors.New("vsafedb: Bad query")
+)
+
+const (
+	kFieldTitle  = "title"
+	kFieldUrl    = "url"
+	kFieldDesc   = "desc"
+	kFieldCat    = "cat"
+	kFieldUser   = "user"
+	kFieldDomain = "domain"
+)
+
+var kQueryFields = map[string]bool{
+	kFieldTitle:  true,
+	kFieldUrl:    true,
+	kFieldDesc:   true,
+	kFieldCat:    true,
+	kFieldUser:   true,
+	kFieldDomain: true,
+}
+
+// Query is a parsed search query. The zero value matches every entry.
+type Query struct {
+	// An entry matches when it matches at least one term of every clause.
+	clauses [][]queryTerm
+}
+
+type queryTerm struct {
+	// Empty means url, title, or description
+	field  string
+	text   string
+	negate bool
+}
+
+// ParseQuery parses a search query. A query is a list of terms separated
+// by whitespace, and an entry matches the query if it matches every term.
+// A term is a word or a phrase in double quotes. On its own, a term
+// matches entries whose url, title, or description contain it ignoring
+// case and extra whitespace. A term may start with a field prefix:
+//
+//	title:  matches the title only
+//	url:    matches the url only
+//	desc:   matches the description only
+//	cat:    matches entries in the category with that name
+//	user:   matches the user name
+//	domain: matches entries whose url host is that domain or a subdomain
+//
+// A term starting with - matches entries that don't match the rest of
+// the term. Terms joined with OR match entries that match any of them.
+// OR binds tighter than the implicit AND between terms, so
+// "a b OR c" matches entries matching a and either b or c.
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	result := &Query{}
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].or {
+			return nil, fmt.Errorf(
+				"%w: OR needs a term on each side", ErrBadQuery)
+		}
+		clause := []queryTerm{tokens[i].term}
+		for i+1 < len(tokens) && tokens[i+1].or {
+			if i+2 >= len(tokens) || tokens[i+2].or {
+				return nil, fmt.Errorf(
+					"%w: OR needs a term on each side", ErrBadQuery)
+			}
+			clause = append(clause, tokens[i+2].term)
+			i += 2
+		}
+		result.clauses = append(result.clauses, clause)
+	}
+	return result, nil
+}
+
+// searchText returns the text of the first clause that is one plain
+// term. Every entry matching q contains that text in its url, title, or
+// description.
+func (q *Query) searchText() string {
+	for _, clause := range q.clauses {
+		if len(clause) == 1 && clause[0].field == "" && !clause[0].negate {
+			return clause[0].text
+		}
+	}
+	return ""
+}
+
+// isEmpty returns true if q matches every entry.
+func (q *Query) isEmpty() bool {
+	return len(q.clauses) == 0
+}
+
+func (q *Query) usesField(field string) bool {
+	for _, clause := range q.clauses {
+		for _, term := range clause {
+			if term.field == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filter returns a filter that matches the entries that q matches.
+// categories are the categories that the cat: prefix may name. key
+// decrypts the user names that the user: prefix matches.
+func (q *Query) filter(
+	categories []vsafe.Category, key *vsafe.Key) (
+	func(vsafe.Entry) bool, error) {
+	var clauseFilters [][]func(vsafe.Entry) bool
+	for _, clause := range q.clauses {
+		var termFilters []func(vsafe.Entry) bool
+		for _, term := range clause {
+			f, err := term.filter(categories, key)
+			if err != nil {
+				return nil, err
+			}
+			termFilters = append(termFilters, f)
+		}
+		clauseFilters = append(clauseFilters, termFilters)
+	}
+	return func(entry vsafe.Entry) bool {
+		for _, termFilters := range clauseFilters {
+			if !anyMatch(termFilters, entry) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func (t queryTerm) filter(
+	categories []vsafe.Category, key *vsafe.Key) (
+	func(vsafe.Entry) bool, error) {
+	f, err := t.positiveFilter(categories, key)
+	if err != nil || !t.negate {
+		return f, err
+	}
+	return func(entry vsafe.Entry) bool {
+		return !f(entry)
+	}, nil
+}
+
+func (t queryTerm) positiveFilter(
+	categories []vsafe.Category, key *vsafe.Key) (
+	func(vsafe.Entry) bool, error) {
+	switch t.field {
+	case "":
+		return newEntryFilter(t.text), nil
+	case kFieldTitle:
+		return func(entry vsafe.Entry) bool {
+			return containsNormalized(entry.Title, t.text)
+		}, nil
+	case kFieldUrl:
+		return func(entry vsafe.Entry) bool {
+			return entry.Url != nil && containsNormalized(
+				entry.Url.String(), t.text)
+		}, nil
+	case kFieldDesc:
+		return func(entry vsafe.Entry) bool {
+			return containsNormalized(entry.Desc, t.text)
+		}, nil
+	case kFieldCat:
+		return newCatNameFilter(categories, t.text)
+	case kFieldUser:
+		return func(entry vsafe.Entry) bool {
+			if key == nil {
+				return false
+			}
+			uname, err := aes.Decrypt(entry.UName, key.Value)
+			return err == nil && containsNormalized(uname, t.text)
+		}, nil
+	case kFieldDomain:
+		domain := strings.TrimPrefix(t.text, ".")
+		return func(entry vsafe.Entry) bool {
+			if entry.Url == nil {
+				return false
+			}
+			host := strings.ToLower(entry.Url.Hostname())
+			return host == domain || strings.HasSuffix(host, "."+domain)
+		}, nil
+	default:
+		panic("Unknown field " + t.field)
+	}
+}
+
+func newCatNameFilter(
+	categories []vsafe.Category, name string) (
+	func(vsafe.Entry) bool, error) {
+	var ids []int64
+	for _, category := range categories {
+		if str_util.Normalize(category.Name) == name {
+			ids = append(ids, category.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: no category named %s", ErrBadQuery, name)
+	}
+	return func(entry vsafe.Entry) bool {
+		for _, id := range ids {
+			if entry.Categories.Contains(id) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func anyMatch(filters []func(vsafe.Entry) bool, entry vsafe.Entry) bool {
+	for _, f := range filters {
+		if f(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNormalized(s, pattern string) bool {
+	return strings.Contains(str_util.Normalize(s), pattern)
+}
+
+type queryToken struct {
+	term queryTerm
+	or   bool
+}
+
+func lexQuery(s string) ([]queryToken, error) {
+	var result []queryToken
+	runes := []rune(s)
+	i := 0
+	for {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		if i == len(runes) {
+			return result, nil
+		}
+		var term queryTerm
+		if runes[i] == '-' {
+			term.negate = true
+			i++
+			if i == len(runes) || unicode.IsSpace(runes[i]) {
+				return nil, fmt.Errorf("%w: nothing after -", ErrBadQuery)
+			}
+		}
+		if field, ok := fieldPrefix(runes[i:]); ok {
+			term.field = field
+			i += len(field) + 1
+		}
+		var text string
+		var quoted bool
+		var err error
+		text, quoted, i, err = lexText(runes, i)
+		if err != nil {
+			return nil, err
+		}
+		if !quoted && !term.negate && term.field == "" && text == "OR" {
+			result = append(result, queryToken{or: true})
+			continue
+		}
+		term.text = str_util.Normalize(text)
+		if term.text == "" {
+			if term.field != "" {
+				return nil, fmt.Errorf(
+					"%w: %s: needs a value", ErrBadQuery, term.field)
+			}
+			// An empty phrase matches everything
+			if term.negate {
+				return nil, fmt.Errorf("%w: nothing after -", ErrBadQuery)
+			}
+		}
+		result = append(result, queryToken{term: term})
+	}
+}
+
+// fieldPrefix returns the field if runes starts with a field name
+// followed by a colon.
+func fieldPrefix(runes []rune) (string, bool) {
+	for i, r := range runes {
+		if r == ':' {
+			field := strings.ToLower(string(runes[:i]))
+			return field, kQueryFields[field]
+		}
+		if !unicode.IsLetter(r) {
+			break
+		}
+	}
+	return "", false
+}
+
+// lexText reads a word or a quoted phrase starting at runes[i]. It
+// returns the text, whether it was quoted, and where the next token
+// starts.
+func lexText(runes []rune, i int) (
+	text string, quoted bool, next int, err error) {
+	if i < len(runes) && runes[i] == '"' {
+		end := i + 1
+		for end < len(runes) && runes[end] != '"' {
+			end++
+		}
+		if end == len(runes) {
+			return "", false, 0, fmt.Errorf(
+				"%w: missing closing quote", ErrBadQuery)
+		}
+		return string(runes[i+1 : end]), true, end + 1, nil
+	}
+	end := i
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+	}
+	return string(runes[i:end]), false, end, nil
+}
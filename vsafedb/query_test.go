@@ -0,0 +1,107 @@
+package vsafedb_test
+
+import (
+	"errors"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseQueryErrors(t *testing.T) {
+	badQueries := []string{
+		`"unclosed`,
+		`title:`,
+		`title:""`,
+		`-`,
+		`foo -`,
+		`OR foo`,
+		`foo OR`,
+		`foo OR OR bar`,
+	}
+	for _, query := range badQueries {
+		if _, err := vsafedb.ParseQuery(query); !errors.Is(err, vsafedb.ErrBadQuery) {
+			t.Errorf("Expected ErrBadQuery for %s, got %v", query, err)
+		}
+	}
+}
+
+func TestEntriesQuery(t *testing.T) {
+	store := &FakeQueryStore{
+		FakeCategoryStore: FakeCategoryStore{
+			Category: &vsafe.Category{Id: 3, Owner: kKey.Id, Name: "Work  Stuff"},
+		},
+	}
+	amazon, _ := url.Parse("https://smile.amazon.com/orders")
+	notAmazon, _ := url.Parse("http://notamazon.com")
+	github, _ := url.Parse("https://github.com")
+	addQueryEntry(t, store, &vsafe.Entry{
+		Title: "Amazon", Url: amazon, Desc: "shopping", UName: "alice"})
+	addQueryEntry(t, store, &vsafe.Entry{
+		Title: "Not Amazon", Url: notAmazon, Desc: "phishing", UName: "bob"})
+	addQueryEntry(t, store, &vsafe.Entry{
+		Title:      "GitHub",
+		Url:        github,
+		Desc:       "code hosting",
+		UName:      "alice",
+		Categories: "3"})
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"Amazon", "Not Amazon", "GitHub"}},
+		{"amazon", []string{"Amazon", "Not Amazon"}},
+		{"amazon -not", []string{"Amazon"}},
+		{"title:hub", []string{"GitHub"}},
+		{"url:smile", []string{"Amazon"}},
+		{"desc:shop", []string{"Amazon"}},
+		{`"code hosting"`, []string{"GitHub"}},
+		{`"hosting code"`, nil},
+		{"code hosting", []string{"GitHub"}},
+		{"shopping OR phishing", []string{"Amazon", "Not Amazon"}},
+		{"amazon shopping OR code", []string{"Amazon"}},
+		{"domain:amazon.com", []string{"Amazon"}},
+		{"domain:AMAZON.com", []string{"Amazon"}},
+		{"-domain:amazon.com", []string{"Not Amazon", "GitHub"}},
+		{`cat:"work stuff"`, []string{"GitHub"}},
+		{"user:alice", []string{"Amazon", "GitHub"}},
+		{"user:alice -cat:\"Work Stuff\"", []string{"Amazon"}},
+		{"TITLE:amazon", []string{"Amazon", "Not Amazon"}},
+		{"http://notamazon", []string{"Not Amazon"}},
+	}
+	for _, tc := range testCases {
+		entries, err := vsafedb.Entries(store, kUser, kKey, tc.query, 0)
+		if err != nil {
+			t.Errorf("Got error for %s: %v", tc.query, err)
+			continue
+		}
+		var actual []string
+		for _, entry := range entries {
+			actual = append(actual, entry.Title)
+		}
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("For %s: expected %v, got %v", tc.query, tc.expected, actual)
+		}
+	}
+	_, err := vsafedb.Entries(store, kUser, kKey, "cat:nothing", 0)
+	if !errors.Is(err, vsafedb.ErrBadQuery) {
+		t.Errorf("Expected ErrBadQuery for missing category, got %v", err)
+	}
+	_, err = vsafedb.Entries(store.FakeStore, kUser, kKey, "cat:stuff", 0)
+	if !errors.Is(err, vsafedb.ErrBadQuery) {
+		t.Errorf("Expected ErrBadQuery without categories, got %v", err)
+	}
+}
+
+func addQueryEntry(t *testing.T, store *FakeQueryStore, entry *vsafe.Entry) {
+	t.Helper()
+	if _, err := vsafedb.AddEntry(&store.FakeStore, nil, kUser, kKey, entry); err != nil {
+		t.Fatalf("Error adding entry: %v", err)
+	}
+}
+
+type FakeQueryStore struct {
+	FakeStore
+	FakeCategoryStore
+}
@@ -0,0 +1,161 @@
+package vsafedb
+
+import (
+	"errors"
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+)
+
+var (
+	// Indicates that a master user cannot be removed while it has
+	// sub-users.
+	ErrHasSubUsers = errors.New("vsafedb: User has sub-users.")
+	// Indicates that a master user cannot be removed without also removing
+	// its entries and categories.
+	ErrHasEntries = errors.New("vsafedb: User has entries or categories.")
+)
+
+type SafePlanUserRemovalRunner interface {
+	UserByNameRunner
+	UsersByOwnerRunner
+	CategoriesByOwnerRunner
+	EntriesByOwnerRunner
+	SharesBySenderRunner
+	SharesByRecipientRunner
+	MembershipsByUserRunner
+}
+
+type SafeRemoveUserCascadeRunner interface {
+	SafePlanUserRemovalRunner
+	RemoveUserRunner
+	RemoveCategoryRunner
+	RemoveEntryRunner
+	RemoveShareRunner
+	RemoveMembershipRunner
+}
+
+// UserRemoval lists everything that removing a user removes.
+type UserRemoval struct {
+	// The user to remove
+	User vsafe.User
+	// The sub-users of User if User is a master user. Removal is refused
+	// while there are any.
+	SubUsers []vsafe.User
+	// The categories in User's vault if User is a master user.
+	Categories []vsafe.Category
+	// The entries in User's vault if User is a master user. Their
+	// sensitive fields remain encrypted.
+	Entries []vsafe.Entry
+	// The shares User's vault sent or received if User is a master user.
+	Shares []vsafe.Share
+	// The memberships of User in collections.
+	Memberships []vsafe.Membership
+}
+
+// Check returns ErrHasSubUsers if this removal would leave sub-users
+// without a master. Otherwise if this removal would remove entries or
+// categories and cascade is false, Check returns ErrHasEntries.
+func (r *UserRemoval) Check(cascade bool) error {
+	if len(r.SubUsers) > 0 {
+		return ErrHasSubUsers
+	}
+	if !cascade && (len(r.Entries) > 0 || len(r.Categories) > 0) {
+		return ErrHasEntries
+	}
+	return nil
+}
+
+// PlanUserRemoval reports what removing the user with given name would
+// remove without removing anything. t may be nil.
+func PlanUserRemoval(
+	store SafePlanUserRemovalRunner,
+	t db.Transaction,
+	name string) (*UserRemoval, error) {
+	var result UserRemoval
+	if err := store.UserByName(t, name, &result.User); err != nil {
+		return nil, err
+	}
+	if err := store.MembershipsByUser(
+		t, result.User.Id, consume2.AppendTo(&result.Memberships)); err != nil {
+		return nil, err
+	}
+	if result.User.Owner != 0 {
+		return &result, nil
+	}
+	id := result.User.Id
+	var users []vsafe.User
+	if err := store.UsersByOwner(t, id, consume2.AppendTo(&users)); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if user.Id != id {
+			result.SubUsers = append(result.SubUsers, user)
+		}
+	}
+	var err error
+	if result.Categories, err = store.CategoriesByOwner(t, id); err != nil {
+		return nil, err
+	}
+	if err := store.EntriesByOwner(
+		t, id, consume2.AppendTo(&result.Entries)); err != nil {
+		return nil, err
+	}
+	if err := store.SharesBySender(
+		t, id, consume2.AppendTo(&result.Shares)); err != nil {
+		return nil, err
+	}
+	if err := store.SharesByRecipient(
+		t, id, consume2.AppendTo(&result.Shares)); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RemoveUser removes the user with given name along with its memberships.
+// If the user is a master user, RemoveUser also removes the categories,
+// entries, and shares of its vault. RemoveUser refuses to remove a master
+// user that has sub-users by returning ErrHasSubUsers and refuses to
+// remove a master user with entries or categories unless cascade is true
+// by returning ErrHasEntries. On success, RemoveUser reports what it
+// removed. t, the transaction, must be non nil.
+func RemoveUser(
+	store SafeRemoveUserCascadeRunner,
+	t db.Transaction,
+	name string,
+	cascade bool) (*UserRemoval, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	removal, err := PlanUserRemoval(store, t, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := removal.Check(cascade); err != nil {
+		return nil, err
+	}
+	for _, entry := range removal.Entries {
+		if err := store.RemoveEntry(t, entry.Id, entry.Owner); err != nil {
+			return nil, err
+		}
+	}
+	for _, category := range removal.Categories {
+		if err := store.RemoveCategory(t, category.Id); err != nil {
+			return nil, err
+		}
+	}
+	for _, share := range removal.Shares {
+		if err := store.RemoveShare(t, share.Id); err != nil {
+			return nil, err
+		}
+	}
+	for _, membership := range removal.Memberships {
+		if err := store.RemoveMembership(t, membership.Id); err != nil {
+			return nil, err
+		}
+	}
+	if err := store.RemoveUser(t, name); err != nil {
+		return nil, err
+	}
+	return removal, nil
+}
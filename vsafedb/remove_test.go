@@ -0,0 +1,83 @@
+package vsafedb_test
+
+import (
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"testing"
+)
+
+func TestRemoveUser(t *testing.T) {
+	store := newFakeRemoveStore()
+	master, sub, other := addMasterAndSubUser(t, store.FakeUserStore)
+	masterKey, _ := master.VerifyPassword("password")
+	if _, err := vsafedb.AddEntry(
+		store, nil, master, masterKey, &vsafe.Entry{Title: "Bank"}); err != nil {
+		t.Fatalf("Error adding entry %v", err)
+	}
+	store.FakeCategoryStore.Category = &vsafe.Category{
+		Id: 4, Owner: master.Id, Name: "bank"}
+	store.AddShare(nil, &vsafe.Share{Sender: master.Id, Recipient: other.Id})
+	store.AddMembership(nil, &vsafe.Membership{Collection: 1, User: master.Id})
+
+	removal, err := vsafedb.PlanUserRemoval(store, nil, "master")
+	if err != nil {
+		t.Fatalf("Error planning removal %v", err)
+	}
+	if len(removal.SubUsers) != 1 || removal.SubUsers[0].Id != sub.Id {
+		t.Errorf("Expected sub-user, got %v", removal.SubUsers)
+	}
+	if len(removal.Entries) != 1 || len(removal.Categories) != 1 || len(removal.Shares) != 1 || len(removal.Memberships) != 1 {
+		t.Errorf("Unexpected removal %v", removal)
+	}
+	if _, err := vsafedb.RemoveUser(
+		store, kTransaction, "master", true); err != vsafedb.ErrHasSubUsers {
+		t.Errorf("Expected ErrHasSubUsers, got %v", err)
+	}
+	if _, err := vsafedb.RemoveUser(
+		store, kTransaction, "sub", false); err != nil {
+		t.Fatalf("Error removing sub-user %v", err)
+	}
+	if _, err := vsafedb.RemoveUser(
+		store, kTransaction, "master", false); err != vsafedb.ErrHasEntries {
+		t.Errorf("Expected ErrHasEntries, got %v", err)
+	}
+	var readUser vsafe.User
+	if err := store.UserById(nil, master.Id, &readUser); err != nil {
+		t.Fatalf("Expected master to remain, got %v", err)
+	}
+	if _, err := vsafedb.RemoveUser(
+		store, kTransaction, "master", true); err != nil {
+		t.Fatalf("Error removing master %v", err)
+	}
+	if err := store.UserById(
+		nil, master.Id, &readUser); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if (*store.FakeStore)[0] != nil || store.FakeCategoryStore.Category != nil {
+		t.Error("Expected entries and categories to be removed")
+	}
+	if (*store.FakeShareStore)[0] != nil || store.Memberships[0] != nil {
+		t.Error("Expected shares and memberships to be removed")
+	}
+	if err := store.UserById(nil, other.Id, &readUser); err != nil {
+		t.Errorf("Expected other user to remain, got %v", err)
+	}
+}
+
+type fakeRemoveStore struct {
+	*FakeUserStore
+	*FakeCategoryStore
+	*FakeStore
+	*FakeShareStore
+	*FakeCollectionStore
+}
+
+func newFakeRemoveStore() fakeRemoveStore {
+	return fakeRemoveStore{
+		FakeUserStore:       &FakeUserStore{},
+		FakeCategoryStore:   &FakeCategoryStore{},
+		FakeStore:           &FakeStore{},
+		FakeShareStore:      &FakeShareStore{},
+		FakeCollectionStore: &FakeCollectionStore{},
+	}
+}
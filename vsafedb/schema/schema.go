@@ -0,0 +1,92 @@
+// Package schema runs the schema migrations of a vsafe database. The
+// sqlite_setup, postgres_setup, and bolt_setup packages each list their
+// own migrations and store the schema version their own way, but they
+// all use this package to decide which migrations to run.
+package schema
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// Indicates that the database schema is newer than this program
+	// supports. Upgrade the program.
+	ErrNewerSchema = errors.New(
+		"schema: Database schema is newer than this program supports.")
+	// Indicates that the database schema is older than this program
+	// supports. Run the migrations.
+	ErrOlderSchema = errors.New(
+		"schema: Database schema is older than this program supports.")
+)
+
+// Migration upgrades the schema of a vsafe database from version
+// Version - 1 to Version. T is the type of transaction, for example
+// *sql.Tx.
+type Migration[T any] struct {
+	Version     int
+	Description string
+	Up          func(tx T) error
+}
+
+// Runner runs the migrations of one kind of database.
+type Runner[T any] struct {
+	// The name of the setup package, which error messages start with
+	Name string
+	// Every migration in order. Migrations[i].Version is always i + 1.
+	Migrations []Migration[T]
+	// Returns the schema version of the database or 0 for an empty
+	// database
+	Version func(tx T) (int, error)
+	// Records the schema version of the database
+	SetVersion func(tx T, version int) error
+}
+
+// LatestVersion returns the schema version that this program supports.
+func (r *Runner[T]) LatestVersion() int {
+	return len(r.Migrations)
+}
+
+// Migrate upgrades the database to the latest schema version by running
+// the pending migrations in order within tx. Running Migrate on an up to
+// date database does nothing. If the database schema is newer than this
+// program supports, Migrate returns ErrNewerSchema.
+func (r *Runner[T]) Migrate(tx T) error {
+	version, err := r.Version(tx)
+	if err != nil {
+		return err
+	}
+	if version > r.LatestVersion() {
+		return ErrNewerSchema
+	}
+	if version == r.LatestVersion() {
+		return nil
+	}
+	for _, migration := range r.Migrations[version:] {
+		if err := migration.Up(tx); err != nil {
+			return fmt.Errorf(
+				"%s: Migration to version %d (%s) failed: %w",
+				r.Name,
+				migration.Version,
+				migration.Description,
+				err)
+		}
+	}
+	return r.SetVersion(tx, r.LatestVersion())
+}
+
+// CheckVersion returns nil if the database schema is at the latest
+// version. Otherwise it returns ErrNewerSchema or ErrOlderSchema.
+func (r *Runner[T]) CheckVersion(tx T) error {
+	version, err := r.Version(tx)
+	if err != nil {
+		return err
+	}
+	if version > r.LatestVersion() {
+		return ErrNewerSchema
+	}
+	if version < r.LatestVersion() {
+		return ErrOlderSchema
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package schema_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/keep94/vsafe/vsafedb/schema"
+)
+
+// fakeDb is a database that records the migrations run on it.
+type fakeDb struct {
+	version int
+	ran     []string
+}
+
+func TestMigrate(t *testing.T) {
+	r := newRunner(nil)
+	dbase := &fakeDb{}
+	if err := r.CheckVersion(dbase); err != schema.ErrOlderSchema {
+		t.Errorf("Expected ErrOlderSchema, got %v", err)
+	}
+	if err := r.Migrate(dbase); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	if dbase.version != 2 {
+		t.Errorf("Expected version 2, got %d", dbase.version)
+	}
+	if err := r.CheckVersion(dbase); err != nil {
+		t.Errorf("Expected latest version, got %v", err)
+	}
+	// Migrating again does nothing
+	if err := r.Migrate(dbase); err != nil {
+		t.Fatalf("Error migrating again: %v", err)
+	}
+	expected := []string{"first", "second"}
+	if !reflect.DeepEqual(expected, dbase.ran) {
+		t.Errorf("Expected %v, got %v", expected, dbase.ran)
+	}
+}
+
+func TestMigratePending(t *testing.T) {
+	r := newRunner(nil)
+	dbase := &fakeDb{version: 1}
+	if err := r.Migrate(dbase); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	expected := []string{"second"}
+	if !reflect.DeepEqual(expected, dbase.ran) {
+		t.Errorf("Expected %v, got %v", expected, dbase.ran)
+	}
+}
+
+func TestMigrateNewer(t *testing.T) {
+	r := newRunner(nil)
+	dbase := &fakeDb{version: 3}
+	if err := r.Migrate(dbase); err != schema.ErrNewerSchema {
+		t.Errorf("Expected ErrNewerSchema from Migrate, got %v", err)
+	}
+	if err := r.CheckVersion(dbase); err != schema.ErrNewerSchema {
+		t.Errorf("Expected ErrNewerSchema from CheckVersion, got %v", err)
+	}
+	if len(dbase.ran) != 0 {
+		t.Errorf("Expected no migrations to run, got %v", dbase.ran)
+	}
+}
+
+func TestMigrateFails(t *testing.T) {
+	failure := errors.New("failure")
+	r := newRunner(failure)
+	dbase := &fakeDb{}
+	err := r.Migrate(dbase)
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected wrapped failure, got %v", err)
+	}
+	if err.Error() != "fake_setup: Migration to version 2 (second) failed: failure" {
+		t.Errorf("Wrong message: %v", err)
+	}
+	if dbase.version != 0 {
+		t.Errorf("Expected version to stay 0, got %d", dbase.version)
+	}
+}
+
+// newRunner returns a runner with two migrations. If secondErr is
+// non-nil, the second migration fails with it.
+func newRunner(secondErr error) *schema.Runner[*fakeDb] {
+	return &schema.Runner[*fakeDb]{
+		Name: "fake_setup",
+		Migrations: []schema.Migration[*fakeDb]{
+			{Version: 1, Description: "first", Up: run("first", nil)},
+			{Version: 2, Description: "second", Up: run("second", secondErr)},
+		},
+		Version: func(dbase *fakeDb) (int, error) {
+			return dbase.version, nil
+		},
+		SetVersion: func(dbase *fakeDb, version int) error {
+			dbase.version = version
+			return nil
+		},
+	}
+}
+
+func run(name string, err error) func(dbase *fakeDb) error {
+	return func(dbase *fakeDb) error {
+		if err != nil {
+			return err
+		}
+		dbase.ran = append(dbase.ran, name)
+		return nil
+	}
+}
@@ -0,0 +1,221 @@
+package vsafedb
+
+import (
+	"errors"
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+)
+
+var (
+	// Indicates that an entry was shared with the vault it came from.
+	ErrSameVault = errors.New("vsafedb: Recipient shares your vault.")
+)
+
+type AddShareRunner interface {
+	// AddShare adds a new share to persistent storage.
+	AddShare(t db.Transaction, share *vsafe.Share) error
+}
+
+type ShareByIdRunner interface {
+	// ShareById retrieves a share by id from persistent storage.
+	ShareById(t db.Transaction, id int64, share *vsafe.Share) error
+}
+
+type SharesByRecipientRunner interface {
+	// SharesByRecipient retrieves the shares sent to a master user ordered
+	// by id.
+	SharesByRecipient(
+		t db.Transaction,
+		recipient int64,
+		consumer consume2.Consumer[vsafe.Share]) error
+}
+
+type SharesBySenderRunner interface {
+	// SharesBySender retrieves the shares sent by a master user ordered
+	// by id.
+	SharesBySender(
+		t db.Transaction,
+		sender int64,
+		consumer consume2.Consumer[vsafe.Share]) error
+}
+
+type UpdateShareRunner interface {
+	// UpdateShare updates a share in persistent storage.
+	UpdateShare(t db.Transaction, share *vsafe.Share) error
+}
+
+type RemoveShareRunner interface {
+	// RemoveShare removes a share by id from persistent storage.
+	RemoveShare(t db.Transaction, id int64) error
+}
+
+type SafeShareEntryRunner interface {
+	EntryByIdRunner
+	UserByIdRunner
+	UserByNameRunner
+	AddShareRunner
+}
+
+type SafeAcceptShareRunner interface {
+	ShareByIdRunner
+	UserByIdRunner
+	AddEntryRunner
+	RemoveShareRunner
+}
+
+type SafeRemoveShareRunner interface {
+	ShareByIdRunner
+	RemoveShareRunner
+}
+
+// EnsureKeyPair gives the master user of user a key pair for sharing
+// entries if it does not already have one. key is user's key. EnsureKeyPair
+// returns the master user. t, the transaction, must be non nil.
+func EnsureKeyPair(
+	store SafeUpdateUserRunner,
+	t db.Transaction,
+	user *vsafe.User,
+	key *vsafe.Key) (*vsafe.User, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	var master vsafe.User
+	if err := store.UserById(t, user.GetOwner(), &master); err != nil {
+		return nil, err
+	}
+	if master.HasKeyPair() {
+		return &master, nil
+	}
+	if err := master.InitKeyPair(key); err != nil {
+		return nil, err
+	}
+	if err := store.UpdateUser(t, &master); err != nil {
+		return nil, err
+	}
+	return &master, nil
+}
+
+// ShareEntry sends the entry with given id to the vault of the user named
+// recipientName on behalf of user. key is user's key. If user cannot see
+// the entry, ShareEntry returns ErrNoSuchId; if user's role does not allow
+// editing entries, ShareEntry returns ErrPermissionDenied. If the
+// recipient shares user's vault, ShareEntry returns ErrSameVault. So that
+// users can't use ShareEntry to find out who else has a vault, ShareEntry
+// sends nothing and returns a nil share and a nil error if there is no
+// user named recipientName or if the recipient's vault can't receive
+// shares yet. t, the transaction, must be non nil.
+func ShareEntry(
+	store SafeShareEntryRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key,
+	recipientName string) (*vsafe.Share, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	if !user.CanEditEntries() {
+		return nil, ErrPermissionDenied
+	}
+	var entry vsafe.Entry
+	if err := EntryById(store, t, id, user, key, &entry); err != nil {
+		return nil, err
+	}
+	var recipient vsafe.User
+	err := store.UserByName(t, recipientName, &recipient)
+	if err == ErrNoSuchId {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if recipient.GetOwner() == user.GetOwner() {
+		return nil, ErrSameVault
+	}
+	var master vsafe.User
+	if err := store.UserById(t, recipient.GetOwner(), &master); err != nil {
+		return nil, err
+	}
+	if !master.HasKeyPair() {
+		return nil, nil
+	}
+	share := vsafe.Share{Sender: user.GetOwner(), EntryId: id}
+	if err := share.Seal(&entry, &master); err != nil {
+		return nil, err
+	}
+	if err := store.AddShare(t, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// AcceptShare adds the entry in the share with given id to the vault of
+// user and removes the share. key is user's key. If the share was not sent
+// to user's vault, AcceptShare returns ErrNoSuchId. If user's role does not
+// allow editing entries or user may see only certain categories,
+// AcceptShare returns ErrPermissionDenied. t, the transaction, must be
+// non nil.
+func AcceptShare(
+	store SafeAcceptShareRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key) (newId int64, err error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	if !user.CanEditEntries() || user.IsRestricted() {
+		return 0, ErrPermissionDenied
+	}
+	var share vsafe.Share
+	if err = store.ShareById(t, id, &share); err != nil {
+		return
+	}
+	if share.Recipient != user.GetOwner() {
+		return 0, ErrNoSuchId
+	}
+	var master vsafe.User
+	if err = store.UserById(t, share.Recipient, &master); err != nil {
+		return
+	}
+	var entry vsafe.Entry
+	if err = share.Open(&master, key, &entry); err != nil {
+		return
+	}
+	if newId, err = AddEntry(store, t, user, key, &entry); err != nil {
+		return
+	}
+	if err = store.RemoveShare(t, id); err != nil {
+		return
+	}
+	return newId, nil
+}
+
+// RemoveShare removes the share with given id on behalf of user. The
+// sender of a share removes it to revoke it; the recipient removes it to
+// decline it. If user's vault neither sent nor received the share,
+// RemoveShare returns ErrNoSuchId. If user's role does not allow editing
+// entries, RemoveShare returns ErrPermissionDenied. t, the transaction,
+// must be non nil.
+func RemoveShare(
+	store SafeRemoveShareRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User) error {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	if !user.CanEditEntries() {
+		return ErrPermissionDenied
+	}
+	var share vsafe.Share
+	if err := store.ShareById(t, id, &share); err != nil {
+		return err
+	}
+	owner := user.GetOwner()
+	if share.Sender != owner && share.Recipient != owner {
+		return ErrNoSuchId
+	}
+	return store.RemoveShare(t, id)
+}
@@ -0,0 +1,237 @@
+package vsafedb_test
+
+import (
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"testing"
+)
+
+func TestEnsureKeyPair(t *testing.T) {
+	var users FakeUserStore
+	master, sub, _ := addMasterAndSubUser(t, &users)
+	master.PublicKey = ""
+	master.PrivateKey = ""
+	if err := users.UpdateUser(nil, master); err != nil {
+		t.Fatalf("Error updating user %v", err)
+	}
+	key, err := master.VerifyPassword("password")
+	if err != nil {
+		t.Fatalf("Error verifying password %v", err)
+	}
+	updated, err := vsafedb.EnsureKeyPair(users, kTransaction, sub, key)
+	if err != nil {
+		t.Fatalf("Error ensuring key pair %v", err)
+	}
+	if updated.Id != master.Id || !updated.HasKeyPair() {
+		t.Errorf("Expected master with key pair, got %v", updated)
+	}
+	var readUser vsafe.User
+	if err := users.UserById(nil, master.Id, &readUser); err != nil {
+		t.Fatalf("Got error reading database, %v", err)
+	}
+	if readUser.PublicKey != updated.PublicKey {
+		t.Error("Expected key pair to be stored")
+	}
+	again, err := vsafedb.EnsureKeyPair(users, kTransaction, master, key)
+	if err != nil {
+		t.Fatalf("Error ensuring key pair %v", err)
+	}
+	if again.PublicKey != updated.PublicKey {
+		t.Error("Expected existing key pair to be kept")
+	}
+}
+
+func TestShareEntry(t *testing.T) {
+	store := newFakeShareStore()
+	master, sub, other := addMasterAndSubUser(t, store.FakeUserStore)
+	masterKey, _ := master.VerifyPassword("password")
+	otherKey, _ := other.VerifyPassword("password")
+	entry := vsafe.Entry{
+		Title: "Bank", UName: "joe", Password: "abc", Categories: "3"}
+	entryId, err := vsafedb.AddEntry(store, nil, master, masterKey, &entry)
+	if err != nil {
+		t.Fatalf("Error adding entry %v", err)
+	}
+	if _, err := vsafedb.ShareEntry(
+		store, kTransaction, entryId, master, masterKey, "sub"); err != vsafedb.ErrSameVault {
+		t.Errorf("Expected ErrSameVault, got %v", err)
+	}
+	// Sharing with nobody looks like it worked but sends nothing
+	nobodyShare, err := vsafedb.ShareEntry(
+		store, kTransaction, entryId, master, masterKey, "nobody")
+	if nobodyShare != nil || err != nil {
+		t.Errorf("Expected nil share and nil error, got %v, %v", nobodyShare, err)
+	}
+	// So does sharing with a vault without a key pair
+	noKeys := vsafe.User{}
+	if err := noKeys.Init("nokeys", "password"); err != nil {
+		t.Fatalf("Error initializing user %v", err)
+	}
+	noKeys.PublicKey = ""
+	noKeys.PrivateKey = ""
+	if err := store.AddUser(nil, &noKeys); err != nil {
+		t.Fatalf("Error adding user %v", err)
+	}
+	noKeysShare, err := vsafedb.ShareEntry(
+		store, kTransaction, entryId, master, masterKey, "nokeys")
+	if noKeysShare != nil || err != nil {
+		t.Errorf("Expected nil share and nil error, got %v, %v", noKeysShare, err)
+	}
+	if len(*store.FakeShareStore) != 0 {
+		t.Error("Expected no share to be sent")
+	}
+	readOnly := *sub
+	readOnly.Role = vsafe.RoleReadOnly
+	if _, err := vsafedb.ShareEntry(
+		store, kTransaction, entryId, &readOnly, masterKey, "other"); err != vsafedb.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	share, err := vsafedb.ShareEntry(
+		store, kTransaction, entryId, sub, masterKey, "other")
+	if err != nil {
+		t.Fatalf("Error sharing entry %v", err)
+	}
+	if share.Sender != master.Id || share.Recipient != other.Id || share.EntryId != entryId {
+		t.Errorf("Unexpected share %v", share)
+	}
+
+	// Sender cannot accept its own share
+	if _, err := vsafedb.AcceptShare(
+		store, kTransaction, share.Id, master, masterKey); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	newId, err := vsafedb.AcceptShare(
+		store, kTransaction, share.Id, other, otherKey)
+	if err != nil {
+		t.Fatalf("Error accepting share %v", err)
+	}
+	var accepted vsafe.Entry
+	if err := vsafedb.EntryById(
+		store, nil, newId, other, otherKey, &accepted); err != nil {
+		t.Fatalf("Error reading accepted entry %v", err)
+	}
+	if accepted.Title != "Bank" || accepted.UName != "joe" || accepted.Password != "abc" {
+		t.Errorf("Unexpected accepted entry %v", accepted)
+	}
+	if accepted.Categories != "" {
+		t.Error("Expected categories not to be shared")
+	}
+	if accepted.Owner != other.Id {
+		t.Errorf("Expected owner %d, got %d", other.Id, accepted.Owner)
+	}
+	var readShare vsafe.Share
+	if err := store.ShareById(
+		nil, share.Id, &readShare); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected share to be removed, got %v", err)
+	}
+}
+
+func TestRemoveShare(t *testing.T) {
+	store := newFakeShareStore()
+	master, sub, other := addMasterAndSubUser(t, store.FakeUserStore)
+	masterKey, _ := master.VerifyPassword("password")
+	entryId, err := vsafedb.AddEntry(
+		store, nil, master, masterKey, &vsafe.Entry{Title: "Bank"})
+	if err != nil {
+		t.Fatalf("Error adding entry %v", err)
+	}
+	share, err := vsafedb.ShareEntry(
+		store, kTransaction, entryId, master, masterKey, "other")
+	if err != nil {
+		t.Fatalf("Error sharing entry %v", err)
+	}
+	stranger := &vsafe.User{Id: 99}
+	if err := vsafedb.RemoveShare(
+		store, kTransaction, share.Id, stranger); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if err := vsafedb.RemoveShare(
+		store, kTransaction, share.Id, sub); err != nil {
+		t.Errorf("Expected sender's vault to revoke, got %v", err)
+	}
+	share, err = vsafedb.ShareEntry(
+		store, kTransaction, entryId, master, masterKey, "other")
+	if err != nil {
+		t.Fatalf("Error sharing entry %v", err)
+	}
+	if err := vsafedb.RemoveShare(
+		store, kTransaction, share.Id, other); err != nil {
+		t.Errorf("Expected recipient to decline, got %v", err)
+	}
+	var readShare vsafe.Share
+	if err := store.ShareById(
+		nil, share.Id, &readShare); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected share to be removed, got %v", err)
+	}
+}
+
+type fakeShareStore struct {
+	*FakeUserStore
+	*FakeStore
+	*FakeShareStore
+}
+
+func newFakeShareStore() fakeShareStore {
+	return fakeShareStore{
+		FakeUserStore:  &FakeUserStore{},
+		FakeStore:      &FakeStore{},
+		FakeShareStore: &FakeShareStore{},
+	}
+}
+
+type FakeShareStore []*vsafe.Share
+
+func (f *FakeShareStore) AddShare(t db.Transaction, s *vsafe.Share) error {
+	s.Id = int64(len(*f) + 1)
+	stored := *s
+	*f = append(*f, &stored)
+	return nil
+}
+
+func (f FakeShareStore) ShareById(
+	t db.Transaction, id int64, s *vsafe.Share) error {
+	if int(id) > len(f) || f[id-1] == nil {
+		return vsafedb.ErrNoSuchId
+	}
+	*s = *f[id-1]
+	return nil
+}
+
+func (f FakeShareStore) RemoveShare(t db.Transaction, id int64) error {
+	if int(id) <= len(f) {
+		f[id-1] = nil
+	}
+	return nil
+}
+
+func (f FakeShareStore) SharesBySender(
+	t db.Transaction,
+	sender int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	for _, share := range f {
+		if share != nil && share.Sender == sender && consumer.CanConsume() {
+			consumer.Consume(*share)
+		}
+	}
+	return nil
+}
+
+func (f FakeShareStore) SharesByRecipient(
+	t db.Transaction,
+	recipient int64,
+	consumer consume2.Consumer[vsafe.Share]) error {
+	for _, share := range f {
+		if share != nil && share.Recipient == recipient && consumer.CanConsume() {
+			consumer.Consume(*share)
+		}
+	}
+	return nil
+}
+
+func (f FakeShareStore) UpdateShare(t db.Transaction, s *vsafe.Share) error {
+	stored := *s
+	f[stored.Id-1] = &stored
+	return nil
+}
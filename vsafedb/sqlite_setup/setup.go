@@ -1,24 +1,383 @@
-// Package sqlite_setup sets up a sqlite database for vsafe app
+// Package sqlite_setup sets up a sqlite database for vsafe app.
+//
+// The schema of a vsafe database has a version stored in the
+// schema_version table. Migrate upgrades a database to the latest version
+// by running each pending migration in order. Migrate refuses to touch a
+// database with a schema newer than this package knows about.
 package sqlite_setup
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/keep94/toolbox/idset"
+	"github.com/keep94/vsafe/vsafedb/schema"
+)
+
+var (
+	// ErrNewerSchema is schema.ErrNewerSchema.
+	ErrNewerSchema = schema.ErrNewerSchema
+	// ErrOlderSchema is schema.ErrOlderSchema.
+	ErrOlderSchema = schema.ErrOlderSchema
+	// Indicates that this program was built without the sqlite_fts5 tag
+	// but that the database has or would need a full text index.
+	ErrNoFts5 = errors.New(
+		"sqlite_setup: Full text index needs a program built with -tags sqlite_fts5.")
 )
 
-// SetUpTables creates all needed tables in database for the vsafe app.
+// Migration upgrades the schema of a vsafe database from version
+// Version - 1 to Version.
+type Migration = schema.Migration[*sql.Tx]
+
+// Migrations lists every migration in order. Migrations[i].Version is
+// always i + 1.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "users and entries",
+		Up: execAll(
+			"create table user (id INTEGER PRIMARY KEY AUTOINCREMENT, owner INTEGER, name TEXT, key TEXT, checksum TEXT)",
+			"create unique index user_name_idx on user (name)",
+			"create table entry (id INTEGER PRIMARY KEY AUTOINCREMENT, owner INTEGER, url TEXT, title TEXT, desc TEXT, uname TEXT, password TEXT, special TEXT)"),
+	},
+	{
+		Version:     2,
+		Description: "categories",
+		Up: execAll(
+			"create table category (id INTEGER PRIMARY KEY AUTOINCREMENT, owner INTEGER, name TEXT)",
+			"alter table entry add column categories TEXT",
+			"update entry set categories = ''"),
+	},
+	{
+		Version:     3,
+		Description: "session timeouts",
+		Up: execAll(
+			"alter table user add column idle_timeout INTEGER",
+			"alter table user add column session_lifetime INTEGER",
+			"update user set idle_timeout = 0, session_lifetime = 0"),
+	},
+	{
+		Version:     4,
+		Description: "user roles",
+		Up: execAll(
+			"alter table user add column role INTEGER",
+			"update user set role = 0"),
+	},
+	{
+		Version:     5,
+		Description: "user categories",
+		Up: execAll(
+			"alter table user add column categories TEXT",
+			"update user set categories = ''"),
+	},
+	{
+		Version:     6,
+		Description: "key pairs and shares",
+		Up: execAll(
+			"alter table user add column public_key TEXT",
+			"alter table user add column private_key TEXT",
+			"update user set public_key = '', private_key = ''",
+			"create table share (id INTEGER PRIMARY KEY AUTOINCREMENT, sender INTEGER, recipient INTEGER, entry_id INTEGER, payload TEXT)",
+			"create index share_recipient_idx on share (recipient)",
+			"create index share_sender_idx on share (sender)"),
+	},
+	{
+		Version:     7,
+		Description: "collections",
+		Up: execAll(
+			"create table collection (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)",
+			"create table membership (id INTEGER PRIMARY KEY AUTOINCREMENT, collection_id INTEGER, user_id INTEGER, key TEXT)",
+			"create unique index membership_user_idx on membership (user_id, collection_id)",
+			"create index membership_collection_idx on membership (collection_id)"),
+	},
+	{
+		Version:     8,
+		Description: "password history",
+		Up: execAll(
+			"alter table user add column password_history TEXT",
+			"update user set password_history = ''"),
+	},
+	{
+		Version:     9,
+		Description: "user owner index",
+		Up: execAll(
+			"create index if not exists user_owner_idx on user (owner)"),
+	},
+	{
+		Version:     10,
+		Description: "entry categories join table",
+		Up:          entryCategories,
+	},
+	{
+		Version:     11,
+		Description: "member key pairs",
+		Up: execAll(
+			"alter table user add column member_public_key TEXT",
+			"alter table user add column member_private_key TEXT",
+			"update user set member_public_key = '', member_private_key = ''"),
+	},
+}
+
+// LatestVersion returns the schema version that this program supports.
+func LatestVersion() int {
+	return runner().LatestVersion()
+}
+
+// SetUpTables creates all needed tables in database for the vsafe app or
+// upgrades them to the latest version. SetUpTables is the same as Migrate.
 func SetUpTables(tx *sql.Tx) error {
-	_, err := tx.Exec("create table if not exists user (id INTEGER PRIMARY KEY AUTOINCREMENT, owner INTEGER, name TEXT, key TEXT, checksum TEXT)")
+	return Migrate(tx)
+}
+
+// Migrate upgrades the database to the latest schema version by running
+// the pending migrations in order within tx. If the database schema is
+// newer than this program supports, Migrate returns ErrNewerSchema. If
+// the database has a full text index that this program can't maintain,
+// Migrate returns ErrNoFts5.
+func Migrate(tx *sql.Tx) error {
+	if err := checkSearchIndex(tx); err != nil {
+		return err
+	}
+	return runner().Migrate(tx)
+}
+
+// CheckVersion returns nil if the database schema is at the latest
+// version. Otherwise it returns ErrNewerSchema or ErrOlderSchema.
+// Like Migrate, CheckVersion returns ErrNoFts5 if the database has a full
+// text index that this program can't maintain. Programs that only read
+// the database call CheckVersion instead of Migrate.
+func CheckVersion(tx *sql.Tx) error {
+	if err := checkSearchIndex(tx); err != nil {
+		return err
+	}
+	return runner().CheckVersion(tx)
+}
+
+// AddSearchIndex adds the full text index that for_sqlite uses to search
+// entries if the database does not already have it. The index is not part
+// of the schema version because only programs built with the sqlite_fts5
+// tag can use it. Once a database has the index, Migrate and CheckVersion
+// return ErrNoFts5 in programs built without the tag. If this program was
+// built without the tag, AddSearchIndex returns ErrNoFts5.
+func AddSearchIndex(tx *sql.Tx) error {
+	hasFts5, err := fts5Enabled(tx)
+	if err != nil {
+		return err
+	}
+	if !hasFts5 {
+		return ErrNoFts5
+	}
+	return searchIndex(tx)
+}
+
+// Version returns the schema version of the database. Version returns 0
+// for an empty database. For a database set up before schema versions
+// were recorded, Version infers the version from the tables and columns
+// present.
+func Version(tx *sql.Tx) (int, error) {
+	exists, err := tableExists(tx, "schema_version")
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return inferVersion(tx)
+	}
+	var version int
+	err = tx.QueryRow("select version from schema_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		return inferVersion(tx)
+	}
+	return version, err
+}
+
+func runner() *schema.Runner[*sql.Tx] {
+	return &schema.Runner[*sql.Tx]{
+		Name:       "sqlite_setup",
+		Migrations: Migrations,
+		Version:    Version,
+		SetVersion: setVersion,
+	}
+}
+
+func setVersion(tx *sql.Tx, version int) error {
+	if _, err := tx.Exec(
+		"create table if not exists schema_version (version INTEGER)"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("delete from schema_version"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(
+		"insert into schema_version (version) values (?)", version)
+	return err
+}
+
+// inferVersion returns the schema version of a database without a
+// recorded version.
+func inferVersion(tx *sql.Tx) (int, error) {
+	exists, err := tableExists(tx, "user")
+	if err != nil || !exists {
+		return 0, err
+	}
+	userColumns, err := columns(tx, "user")
+	if err != nil {
+		return 0, err
+	}
+	entryColumns, err := columns(tx, "entry")
+	if err != nil {
+		return 0, err
+	}
+	collectionExists, err := tableExists(tx, "collection")
+	if err != nil {
+		return 0, err
+	}
+	// Each condition holds from the version after it on.
+	conditions := []bool{
+		entryColumns["categories"],
+		userColumns["idle_timeout"],
+		userColumns["role"],
+		userColumns["categories"],
+		userColumns["public_key"],
+		collectionExists,
+		userColumns["password_history"],
+	}
+	version := 1
+	for _, holds := range conditions {
+		if !holds {
+			break
+		}
+		version++
+	}
+	return version, nil
+}
+
+func tableExists(tx *sql.Tx, name string) (bool, error) {
+	var count int
+	err := tx.QueryRow(
+		"select count(*) from sqlite_master where type = 'table' and name = ?",
+		name).Scan(&count)
+	return count > 0, err
+}
+
+func columns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("pragma table_info(%s)", table))
 	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(
+			&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		result[name] = true
+	}
+	return result, rows.Err()
+}
+
+func fts5Enabled(tx *sql.Tx) (result bool, err error) {
+	err = tx.QueryRow(
+		"select sqlite_compileoption_used('ENABLE_FTS5')").Scan(&result)
+	return
+}
+
+// checkSearchIndex returns ErrNoFts5 if the database has the entry_fts
+// table but sqlite has no FTS5. The triggers on entry_fts would make
+// every change to entries fail.
+func checkSearchIndex(tx *sql.Tx) error {
+	exists, err := tableExists(tx, "entry_fts")
+	if err != nil || !exists {
 		return err
 	}
-	_, err = tx.Exec("create unique index if not exists user_name_idx on user (name)")
+	hasFts5, err := fts5Enabled(tx)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec("create table if not exists category (id INTEGER PRIMARY KEY AUTOINCREMENT, owner INTEGER, name TEXT)")
+	if !hasFts5 {
+		return ErrNoFts5
+	}
+	return nil
+}
+
+// searchIndex adds the entry_fts table along with triggers that keep it
+// in sync with the entry table if the table is not already there. The
+// trigram tokenizer lets entry_fts match substrings.
+func searchIndex(tx *sql.Tx) error {
+	exists, err := tableExists(tx, "entry_fts")
+	if err != nil || exists {
+		return err
+	}
+	return execAll(
+		"create virtual table entry_fts using fts5(url, title, \"desc\", content='entry', content_rowid='id', tokenize='trigram')",
+		"create trigger entry_fts_insert after insert on entry begin insert into entry_fts (rowid, url, title, \"desc\") values (new.id, new.url, new.title, new.desc); end",
+		"create trigger entry_fts_delete after delete on entry begin insert into entry_fts (entry_fts, rowid, url, title, \"desc\") values ('delete', old.id, old.url, old.title, old.desc); end",
+		"create trigger entry_fts_update after update on entry begin insert into entry_fts (entry_fts, rowid, url, title, \"desc\") values ('delete', old.id, old.url, old.title, old.desc); insert into entry_fts (rowid, url, title, \"desc\") values (new.id, new.url, new.title, new.desc); end",
+		"insert into entry_fts (entry_fts) values ('rebuild')")(tx)
+}
+
+// entryCategories moves the category ids of each entry from the
+// comma separated categories column to the entry_category table. Ids of
+// categories that no longer exist are dropped.
+func entryCategories(tx *sql.Tx) error {
+	err := execAll(
+		"create table entry_category (entry_id INTEGER NOT NULL REFERENCES entry (id) ON DELETE CASCADE, category_id INTEGER NOT NULL REFERENCES category (id) ON DELETE CASCADE, PRIMARY KEY (entry_id, category_id))",
+		"create index entry_category_category_idx on entry_category (category_id)")(tx)
+	if err != nil {
+		return err
+	}
+	categoriesById, err := entryCategoryStrings(tx)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec("create table if not exists entry (id INTEGER PRIMARY KEY AUTOINCREMENT, owner INTEGER, url TEXT, title TEXT, desc TEXT, uname TEXT, password TEXT, special TEXT, categories TEXT)")
+	for id, categories := range categoriesById {
+		catMap, err := categories.Map()
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", id, err)
+		}
+		for catId := range catMap {
+			if _, err := tx.Exec(
+				"insert into entry_category (entry_id, category_id) select ?, id from category where id = ?",
+				id, catId); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = tx.Exec("alter table entry drop column categories")
 	return err
 }
+
+// entryCategoryStrings returns the categories column of each entry by
+// entry id.
+func entryCategoryStrings(tx *sql.Tx) (map[int64]idset.IdSet, error) {
+	rows, err := tx.Query("select id, categories from entry")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[int64]idset.IdSet)
+	for rows.Next() {
+		var id int64
+		var categories sql.NullString
+		if err := rows.Scan(&id, &categories); err != nil {
+			return nil, err
+		}
+		result[id] = idset.IdSet(categories.String)
+	}
+	return result, rows.Err()
+}
+
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,259 @@
+package sqlite_setup_test
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/keep94/toolbox/db/sqlite3_db"
+	"github.com/keep94/vsafe/vsafedb/sqlite_setup"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMigrateEmpty(t *testing.T) {
+	dbase := openDb(t)
+	defer dbase.Close()
+	verifyVersion(t, dbase, 0)
+	if err := dbase.Do(sqlite_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	verifyVersion(t, dbase, sqlite_setup.LatestVersion())
+	if err := dbase.Do(sqlite_setup.CheckVersion); err != nil {
+		t.Errorf("Expected latest version, got %v", err)
+	}
+	// Migrating again does nothing
+	if err := dbase.Do(sqlite_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating again: %v", err)
+	}
+	verifyVersion(t, dbase, sqlite_setup.LatestVersion())
+}
+
+func TestMigrateUnversioned(t *testing.T) {
+	dbase := openDb(t)
+	defer dbase.Close()
+	err := dbase.Do(func(tx *sql.Tx) error {
+		if err := sqlite_setup.Migrations[0].Up(tx); err != nil {
+			return err
+		}
+		if err := sqlite_setup.Migrations[1].Up(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec("insert into user (owner, name, key, checksum) values (0, 'keep94', 'key', 'checksum')")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error creating old schema: %v", err)
+	}
+	verifyVersion(t, dbase, 2)
+	if err := dbase.Do(sqlite_setup.CheckVersion); err != sqlite_setup.ErrOlderSchema {
+		t.Errorf("Expected ErrOlderSchema, got %v", err)
+	}
+	if err := dbase.Do(sqlite_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	verifyVersion(t, dbase, sqlite_setup.LatestVersion())
+	var role int
+	var history string
+	err = dbase.Do(func(tx *sql.Tx) error {
+		return tx.QueryRow(
+			"select role, password_history from user where name = 'keep94'").Scan(
+			&role, &history)
+	})
+	if err != nil {
+		t.Fatalf("Error reading migrated user: %v", err)
+	}
+	if role != 0 || history != "" {
+		t.Errorf("Expected defaults for new columns, got %d, %q", role, history)
+	}
+}
+
+func TestMigrateEntryCategories(t *testing.T) {
+	dbase := openDb(t)
+	defer dbase.Close()
+	err := dbase.Do(func(tx *sql.Tx) error {
+		for _, migration := range sqlite_setup.Migrations[:9] {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+		}
+		for _, statement := range []string{
+			"create table schema_version (version INTEGER)",
+			"insert into schema_version (version) values (9)",
+			"insert into category (id, owner, name) values (1, 1, 'one')",
+			"insert into category (id, owner, name) values (2, 1, 'two')",
+			"insert into entry (id, owner, categories) values (1, 1, '1,2')",
+			"insert into entry (id, owner, categories) values (2, 1, '2,99')",
+			"insert into entry (id, owner, categories) values (3, 1, '')",
+		} {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error creating old schema: %v", err)
+	}
+	if err := dbase.Do(sqlite_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	verifyVersion(t, dbase, sqlite_setup.LatestVersion())
+	var pairs []string
+	err = dbase.Do(func(tx *sql.Tx) error {
+		rows, err := tx.Query(
+			"select entry_id, category_id from entry_category order by entry_id, category_id")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var entryId, categoryId int64
+			if err := rows.Scan(&entryId, &categoryId); err != nil {
+				return err
+			}
+			pairs = append(pairs, fmt.Sprintf("%d:%d", entryId, categoryId))
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		t.Fatalf("Error reading entry categories: %v", err)
+	}
+	expected := []string{"1:1", "1:2", "2:2"}
+	if !reflect.DeepEqual(expected, pairs) {
+		t.Errorf("Expected %v, got %v", expected, pairs)
+	}
+}
+
+func TestMigrateNewer(t *testing.T) {
+	dbase := openDb(t)
+	defer dbase.Close()
+	if err := dbase.Do(sqlite_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	err := dbase.Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"update schema_version set version = ?",
+			sqlite_setup.LatestVersion()+1)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error setting version: %v", err)
+	}
+	if err := dbase.Do(sqlite_setup.Migrate); err != sqlite_setup.ErrNewerSchema {
+		t.Errorf("Expected ErrNewerSchema from Migrate, got %v", err)
+	}
+	if err := dbase.Do(sqlite_setup.CheckVersion); err != sqlite_setup.ErrNewerSchema {
+		t.Errorf("Expected ErrNewerSchema from CheckVersion, got %v", err)
+	}
+}
+
+func TestAddSearchIndex(t *testing.T) {
+	dbase := openDb(t)
+	defer dbase.Close()
+	if err := dbase.Do(sqlite_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	// Migrate never adds the index on its own
+	verifySearchIndex(t, dbase, false)
+	err := dbase.Do(sqlite_setup.AddSearchIndex)
+	if err == sqlite_setup.ErrNoFts5 {
+		t.Skip("Full text index needs -tags sqlite_fts5")
+	}
+	if err != nil {
+		t.Fatalf("Error adding search index: %v", err)
+	}
+	verifySearchIndex(t, dbase, true)
+	// Adding the index again does nothing
+	if err := dbase.Do(sqlite_setup.AddSearchIndex); err != nil {
+		t.Fatalf("Error adding search index again: %v", err)
+	}
+	if err := dbase.Do(sqlite_setup.CheckVersion); err != nil {
+		t.Errorf("Expected latest version, got %v", err)
+	}
+}
+
+func TestSearchIndexWithoutFts5(t *testing.T) {
+	dbase := openDb(t)
+	defer dbase.Close()
+	var hasFts5 bool
+	err := dbase.Do(func(tx *sql.Tx) error {
+		return tx.QueryRow(
+			"select sqlite_compileoption_used('ENABLE_FTS5')").Scan(&hasFts5)
+	})
+	if err != nil {
+		t.Fatalf("Error reading compile options: %v", err)
+	}
+	if hasFts5 {
+		t.Skip("Test needs a build without -tags sqlite_fts5")
+	}
+	if err := dbase.Do(sqlite_setup.Migrate); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	if err := dbase.Do(sqlite_setup.AddSearchIndex); err != sqlite_setup.ErrNoFts5 {
+		t.Errorf("Expected ErrNoFts5 adding index, got %v", err)
+	}
+	// Stands in for an index added by a program built with the tag
+	err = dbase.Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec("create table entry_fts (url TEXT)")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error creating entry_fts: %v", err)
+	}
+	if err := dbase.Do(sqlite_setup.Migrate); err != sqlite_setup.ErrNoFts5 {
+		t.Errorf("Expected ErrNoFts5 from Migrate, got %v", err)
+	}
+	if err := dbase.Do(sqlite_setup.CheckVersion); err != sqlite_setup.ErrNoFts5 {
+		t.Errorf("Expected ErrNoFts5 from CheckVersion, got %v", err)
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, migration := range sqlite_setup.Migrations {
+		if migration.Version != i+1 {
+			t.Errorf(
+				"Expected version %d at index %d, got %d",
+				i+1, i, migration.Version)
+		}
+	}
+}
+
+func verifyVersion(t *testing.T, dbase *sqlite3_db.Db, expected int) {
+	t.Helper()
+	var version int
+	err := dbase.Do(func(tx *sql.Tx) (err error) {
+		version, err = sqlite_setup.Version(tx)
+		return
+	})
+	if err != nil {
+		t.Fatalf("Error reading version: %v", err)
+	}
+	if version != expected {
+		t.Errorf("Expected version %d, got %d", expected, version)
+	}
+}
+
+func verifySearchIndex(t *testing.T, dbase *sqlite3_db.Db, expected bool) {
+	t.Helper()
+	var count int
+	err := dbase.Do(func(tx *sql.Tx) error {
+		return tx.QueryRow(
+			"select count(*) from sqlite_master where name = 'entry_fts'").Scan(&count)
+	})
+	if err != nil {
+		t.Fatalf("Error reading tables: %v", err)
+	}
+	if actual := count > 0; actual != expected {
+		t.Errorf("Expected search index %v, got %v", expected, actual)
+	}
+}
+
+func openDb(t *testing.T) *sqlite3_db.Db {
+	t.Helper()
+	rawdb, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	return sqlite3_db.New(rawdb)
+}
@@ -3,20 +3,38 @@
 package vsafedb
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/keep94/consume2"
 	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/idset"
 	"github.com/keep94/toolbox/str_util"
 	"github.com/keep94/vsafe"
 	"sort"
 	"strings"
 )
 
+const (
+	// The whitespace SortByTitle trims, the same whitespace stores trim
+	// when reading entries by title
+	kAsciiSpace = " \t\n\v\f\r"
+)
+
 var (
 	// Indicates that the id does not exist in the database.
 	ErrNoSuchId = errors.New("vsafedb: No such Id.")
 	// Indicates concurrent modification
 	ErrConcurrentModification = errors.New("vsafedb: Concurrent Modification")
+	// Indicates that the user's role does not allow the operation.
+	ErrPermissionDenied = errors.New("vsafedb: Permission Denied.")
+	// Indicates that another user already has the name.
+	ErrNameTaken = errors.New("vsafedb: Name taken.")
+	// Indicates that the name is empty or only whitespace.
+	ErrNameRequired = errors.New("vsafedb: Name required.")
+	// Indicates that a store cannot search for a particular query.
+	// Callers fall back to filtering every entry.
+	ErrSearchUnsupported = errors.New("vsafedb: Search unsupported.")
 )
 
 type AddUserRunner interface {
@@ -39,6 +57,15 @@ type UsersRunner interface {
 	Users(t db.Transaction, consumer consume2.Consumer[vsafe.User]) error
 }
 
+type UsersByOwnerRunner interface {
+	// UsersByOwner retrieves the master user with given id along with all
+	// its sub-users from persistent storage ordered by name.
+	UsersByOwner(
+		t db.Transaction,
+		owner int64,
+		consumer consume2.Consumer[vsafe.User]) error
+}
+
 type UpdateUserRunner interface {
 	// UpdateUser modifies a user in persistent storage.
 	UpdateUser(t db.Transaction, user *vsafe.User) error
@@ -49,11 +76,29 @@ type SafeUpdateUserRunner interface {
 	UserByIdRunner
 }
 
+type SafeResetPasswordRunner interface {
+	SafeUpdateUserRunner
+	MembershipsByUserRunner
+	RemoveMembershipRunner
+}
+
 type RemoveUserRunner interface {
 	// RemoveUser removes a user by name from persistent storage.
 	RemoveUser(t db.Transaction, name string) error
 }
 
+type SafeRenameUserRunner interface {
+	SafeUpdateUserRunner
+	UserByNameRunner
+}
+
+type SafeRemoveUserRunner interface {
+	UserByIdRunner
+	RemoveUserRunner
+	MembershipsByUserRunner
+	RemoveMembershipRunner
+}
+
 type AddCategoryRunner interface {
 	// AddCategory adds a new category to persistent storage
 	AddCategory(t db.Transaction, category *vsafe.Category) error
@@ -71,6 +116,24 @@ type CategoriesByOwnerRunner interface {
 		t db.Transaction, owner int64) ([]vsafe.Category, error)
 }
 
+// CategoryCount is a category along with how many entries are in it.
+type CategoryCount struct {
+	vsafe.Category
+	Entries int
+}
+
+type CategoryCountsByOwnerRunner interface {
+	// CategoryCountsByOwner retrieves all categories with a particular owner
+	// along with their entry counts ordered by category name.
+	CategoryCountsByOwner(
+		t db.Transaction, owner int64) ([]CategoryCount, error)
+}
+
+type CategoryCountsStore interface {
+	CategoriesByOwnerRunner
+	EntriesByOwnerRunner
+}
+
 type UpdateCategoryRunner interface {
 	// UpdateCategory updates a category.
 	UpdateCategory(t db.Transaction, category *vsafe.Category) error
@@ -109,6 +172,40 @@ type EntriesByOwnerRunner interface {
 		t db.Transaction, owner int64, consumer consume2.Consumer[vsafe.Entry]) error
 }
 
+// SearchRunner is optional. Stores implement it when they can find
+// matching entries faster than reading every entry of the owner.
+type SearchRunner interface {
+	// SearchEntries sends the entries with given owner whose url, title,
+	// or description contain query ignoring case to consumer ordered by id.
+	// query is already normalized and non-empty. SearchEntries returns
+	// ErrSearchUnsupported without consuming anything if it cannot do the
+	// search.
+	SearchEntries(
+		t db.Transaction,
+		owner int64,
+		query string,
+		consumer consume2.Consumer[vsafe.Entry]) error
+}
+
+// EntryPageRunner is optional. Stores implement it when they can read
+// one page of entries without reading every entry of the owner.
+type EntryPageRunner interface {
+	// EntryPage sends the entries in r to consumer in r.Order skipping the
+	// first offset entries and sending at most limit entries. If limit is
+	// not positive, EntryPage sends all entries after offset. EntryPage
+	// returns how many entries are in r. ByTitle orders by title ignoring
+	// case and surrounding whitespace and then by id.
+	EntryPage(
+		t db.Transaction,
+		r *EntryRange,
+		offset, limit int,
+		consumer consume2.Consumer[vsafe.Entry]) (int, error)
+	// EntryPosition returns how many entries in r come before the entry
+	// with given id in r.Order. If that entry is not in r, EntryPosition
+	// returns ErrNoSuchId.
+	EntryPosition(t db.Transaction, r *EntryRange, id int64) (int, error)
+}
+
 type UpdateEntryRunner interface {
 	// UpdateEntry updates an entry in persistent storage.
 	UpdateEntry(t db.Transaction, entry *vsafe.Entry) error
@@ -125,17 +222,44 @@ type RemoveEntryRunner interface {
 	RemoveEntry(t db.Transaction, id, owner int64) error
 }
 
-// UpdateCategory updates a category name by id and owner. t must be non-nil.
+type SafeRemoveEntryRunner interface {
+	EntryByIdRunner
+	RemoveEntryRunner
+}
+
+// UpdateCategory updates a category name by id on behalf of user.
+// key is the key of the vault holding the category. If user's role does
+// not allow editing categories, UpdateCategory returns ErrPermissionDenied.
+// t must be non-nil.
 func UpdateCategory(
 	store SafeUpdateCategoryRunner,
 	t db.Transaction,
-	id, owner int64,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key,
+	newName string) (oldName string, err error) {
+	return UpdateCategoryContext(
+		context.Background(), store, t, id, user, key, newName)
+}
+
+// UpdateCategoryContext works like UpdateCategory but passes ctx to store.
+func UpdateCategoryContext(
+	ctx context.Context,
+	store SafeUpdateCategoryRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key,
 	newName string) (oldName string, err error) {
 	if t == nil {
 		panic("t must be non-nil")
 	}
+	if !user.CanEditCategories() {
+		return "", ErrPermissionDenied
+	}
+	owner := key.Id
 	var category vsafe.Category
-	err = store.CategoryById(t, id, &category)
+	err = categoryById(ctx, store, t, id, &category)
 	if err != nil {
 		return
 	}
@@ -144,23 +268,97 @@ func UpdateCategory(
 	}
 	lastName := category.Name
 	category.Name = newName
-	err = store.UpdateCategory(t, &category)
+	err = updateCategory(ctx, store, t, &category)
 	if err != nil {
 		return
 	}
 	return lastName, nil
 }
 
-// RemoveCategory removes a category by id and owner. t must be non-nil.
+// CategoryCountsByOwner returns the categories of owner ordered by name
+// along with how many of owner's entries are in each one. If store does
+// not implement CategoryCountsByOwnerRunner, CategoryCountsByOwner counts
+// the entries itself.
+func CategoryCountsByOwner(
+	store CategoryCountsStore,
+	t db.Transaction,
+	owner int64) ([]CategoryCount, error) {
+	return CategoryCountsByOwnerContext(context.Background(), store, t, owner)
+}
+
+// CategoryCountsByOwnerContext works like CategoryCountsByOwner but passes
+// ctx to store.
+func CategoryCountsByOwnerContext(
+	ctx context.Context,
+	store CategoryCountsStore,
+	t db.Transaction,
+	owner int64) ([]CategoryCount, error) {
+	if cstore, ok := store.(CategoryCountsByOwnerContextRunner); ok {
+		return cstore.CategoryCountsByOwnerContext(ctx, t, owner)
+	}
+	if cstore, ok := store.(CategoryCountsByOwnerRunner); ok {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return cstore.CategoryCountsByOwner(t, owner)
+	}
+	categories, err := CategoriesByOwnerContext(ctx, store, t, owner)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int64]int)
+	err = entriesByOwner(
+		ctx,
+		store,
+		t,
+		owner,
+		consume2.ConsumerFunc[vsafe.Entry](func(entry vsafe.Entry) {
+			catMap, _ := entry.Categories.Map()
+			for id := range catMap {
+				counts[id]++
+			}
+		}))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]CategoryCount, len(categories))
+	for i := range categories {
+		result[i] = CategoryCount{
+			Category: categories[i], Entries: counts[categories[i].Id]}
+	}
+	return result, nil
+}
+
+// RemoveCategory removes a category by id on behalf of user.
+// key is the key of the vault holding the category. If user's role does
+// not allow editing categories, RemoveCategory returns ErrPermissionDenied.
+// t must be non-nil.
 func RemoveCategory(
 	store SafeRemoveCategoryRunner,
 	t db.Transaction,
-	id, owner int64) (oldName string, err error) {
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key) (oldName string, err error) {
+	return RemoveCategoryContext(context.Background(), store, t, id, user, key)
+}
+
+// RemoveCategoryContext works like RemoveCategory but passes ctx to store.
+func RemoveCategoryContext(
+	ctx context.Context,
+	store SafeRemoveCategoryRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key) (oldName string, err error) {
 	if t == nil {
 		panic("t must be non-nil")
 	}
+	if !user.CanEditCategories() {
+		return "", ErrPermissionDenied
+	}
+	owner := key.Id
 	var category vsafe.Category
-	err = store.CategoryById(t, id, &category)
+	err = categoryById(ctx, store, t, id, &category)
 	if err != nil {
 		return
 	}
@@ -168,7 +366,7 @@ func RemoveCategory(
 		return "", ErrNoSuchId
 	}
 	lastName := category.Name
-	err = store.RemoveCategory(t, id)
+	err = removeCategory(ctx, store, t, id)
 	if err != nil {
 		return
 	}
@@ -176,17 +374,34 @@ func RemoveCategory(
 }
 
 // AddEntry adds a new entry to persistent storage so that sensitive fields
-// are encrypted in persistent storage.
+// are encrypted in persistent storage. user is the user adding the entry;
+// if user's role does not allow editing entries, AddEntry returns
+// ErrPermissionDenied.
 func AddEntry(
 	store AddEntryRunner,
 	t db.Transaction,
+	user *vsafe.User,
+	key *vsafe.Key,
+	entry *vsafe.Entry) (newId int64, err error) {
+	return AddEntryContext(context.Background(), store, t, user, key, entry)
+}
+
+// AddEntryContext works like AddEntry but passes ctx to store.
+func AddEntryContext(
+	ctx context.Context,
+	store AddEntryRunner,
+	t db.Transaction,
+	user *vsafe.User,
 	key *vsafe.Key,
 	entry *vsafe.Entry) (newId int64, err error) {
+	if !user.CanEditEntries() {
+		return 0, ErrPermissionDenied
+	}
 	encrypted := *entry
 	if err = encrypted.Encrypt(key); err != nil {
 		return
 	}
-	if err = store.AddEntry(t, &encrypted); err != nil {
+	if err = addEntry(ctx, store, t, &encrypted); err != nil {
 		return
 	}
 	return encrypted.Id, nil
@@ -194,32 +409,63 @@ func AddEntry(
 
 // UpdateEntryWithEtag updates an entry in persistent storage in a way that
 // detects concurrent modification. It also prevents users from modifying
-// entries they do not own by returning ErrNoSuchId. t, the transaction,
-// must be non nil.
+// entries they do not own or cannot see by returning ErrNoSuchId. user is
+// the user making the change; if user's role does not allow editing
+// entries, UpdateEntryWithEtag returns ErrPermissionDenied. If user may
+// see only certain categories, the entry keeps the categories user cannot
+// see, and UpdateEntryWithEtag returns ErrPermissionDenied if the change
+// would hide the entry from user. t, the transaction, must be non nil.
 func UpdateEntryWithEtag(
 	store SafeUpdateEntryRunner,
 	t db.Transaction,
 	id int64,
 	tag uint64,
+	user *vsafe.User,
+	key *vsafe.Key,
+	update vsafe.EntryUpdater) error {
+	return UpdateEntryWithEtagContext(
+		context.Background(), store, t, id, tag, user, key, update)
+}
+
+// UpdateEntryWithEtagContext works like UpdateEntryWithEtag but passes ctx
+// to store.
+func UpdateEntryWithEtagContext(
+	ctx context.Context,
+	store SafeUpdateEntryRunner,
+	t db.Transaction,
+	id int64,
+	tag uint64,
+	user *vsafe.User,
 	key *vsafe.Key,
 	update vsafe.EntryUpdater) error {
 	if t == nil {
 		panic("Transaction must be non-nil")
 	}
+	if !user.CanEditEntries() {
+		return ErrPermissionDenied
+	}
 	var origEntry vsafe.Entry
-	err := EntryById(store, t, id, key, &origEntry)
+	err := EntryByIdContext(ctx, store, t, id, user, key, &origEntry)
 	if err != nil {
 		return err
 	}
 	etag := origEntry.Etag
+	origCategories := origEntry.Categories
 	if !update(&origEntry) {
 		return nil
 	}
 	if tag != etag {
 		return ErrConcurrentModification
 	}
+	if user.IsRestricted() {
+		origEntry.Categories = mergeCategories(
+			user, origCategories, origEntry.Categories)
+		if !user.CanSee(origEntry.Categories) {
+			return ErrPermissionDenied
+		}
+	}
 	origEntry.Id = id
-	return UpdateEntry(store, t, key, &origEntry)
+	return UpdateEntryContext(ctx, store, t, key, &origEntry)
 }
 
 // UpdateEntry updates an entry in persistent storage so that sensitive fields
@@ -227,6 +473,16 @@ func UpdateEntryWithEtag(
 // protect against concurrent modification nor does it protect against
 // users clobbering an entry they do not own.
 func UpdateEntry(
+	store UpdateEntryRunner,
+	t db.Transaction,
+	key *vsafe.Key,
+	entry *vsafe.Entry) (err error) {
+	return UpdateEntryContext(context.Background(), store, t, key, entry)
+}
+
+// UpdateEntryContext works like UpdateEntry but passes ctx to store.
+func UpdateEntryContext(
+	ctx context.Context,
 	store UpdateEntryRunner,
 	t db.Transaction,
 	key *vsafe.Key,
@@ -235,62 +491,198 @@ func UpdateEntry(
 	if err = encrypted.Encrypt(key); err != nil {
 		return
 	}
-	if err = store.UpdateEntry(t, &encrypted); err != nil {
+	if err = updateEntry(ctx, store, t, &encrypted); err != nil {
 		return
 	}
 	return
 }
 
-// EntryById retrieves an entry by its id from persistent storage while
-// handling decryption of sensitive fields. If the Id of the provided key
-// does not match the Owner field of fetched entry, that is the current user
-// does not own the entry being fetched, EntryById returns ErrNoSuchId.
+// RemoveEntry removes an entry by id on behalf of user. key is the key of
+// the vault holding the entry. If the entry is not in that vault or user
+// cannot see it because of its categories, RemoveEntry returns ErrNoSuchId.
+// If user's role does not allow editing entries, RemoveEntry returns
+// ErrPermissionDenied. t must be non-nil.
+func RemoveEntry(
+	store SafeRemoveEntryRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key) error {
+	return RemoveEntryContext(context.Background(), store, t, id, user, key)
+}
+
+// RemoveEntryContext works like RemoveEntry but passes ctx to store.
+func RemoveEntryContext(
+	ctx context.Context,
+	store SafeRemoveEntryRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key) error {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	if !user.CanEditEntries() {
+		return ErrPermissionDenied
+	}
+	var entry vsafe.Entry
+	if err := entryById(ctx, store, t, id, &entry); err != nil {
+		return err
+	}
+	if entry.Owner != key.Id || !user.CanSee(entry.Categories) {
+		return ErrNoSuchId
+	}
+	return removeEntry(ctx, store, t, id, entry.Owner)
+}
+
+// EntryById retrieves an entry by its id from persistent storage on behalf
+// of user while handling decryption of sensitive fields. If the Id of the
+// provided key does not match the Owner field of fetched entry, that is the
+// current user does not own the entry being fetched, or if user may not see
+// the categories of the entry, EntryById returns ErrNoSuchId.
 func EntryById(
 	store EntryByIdRunner,
 	t db.Transaction,
 	id int64,
+	user *vsafe.User,
 	key *vsafe.Key,
 	entry *vsafe.Entry) (err error) {
-	if err = store.EntryById(t, id, entry); err != nil {
+	return EntryByIdContext(
+		context.Background(), store, t, id, user, key, entry)
+}
+
+// EntryByIdContext works like EntryById but passes ctx to store.
+func EntryByIdContext(
+	ctx context.Context,
+	store EntryByIdRunner,
+	t db.Transaction,
+	id int64,
+	user *vsafe.User,
+	key *vsafe.Key,
+	entry *vsafe.Entry) (err error) {
+	if err = entryById(ctx, store, t, id, entry); err != nil {
 		return
 	}
+	if !user.CanSee(entry.Categories) {
+		*entry = vsafe.Entry{}
+		return ErrNoSuchId
+	}
 	return decryptHelper(key, entry)
 }
 
-// Entries returns a new slice containing entries encrypted with keyId and
-// matching query and orders them by Id. It does not decrypt the sensitive
-// fields within the fetched entries. query is searched for within url,
-// title, and description of each entry ignoring case to determine whether or
-// not there is a match. Whitespace within query and entry fields are
-// normalized to a single space before matching happens. The empty string
-// matches all entries.
+// Entries returns a new slice containing entries encrypted with key,
+// visible to user, and matching query and orders them by Id. It does not
+// decrypt the sensitive fields within the fetched entries. See ParseQuery
+// for the syntax of query. The empty string matches all entries. If query
+// does not parse, Entries returns an error wrapping ErrBadQuery.
 //
 // If catId is non-zero, returned entries must belong to corresponding
 // category in addition to matching query.
 func Entries(
 	store EntriesByOwnerRunner,
-	keyId int64,
+	user *vsafe.User,
+	key *vsafe.Key,
 	query string,
 	catId int64) ([]*vsafe.Entry, error) {
-	filter := newEntryFilter(query)
-	if catId != 0 {
-		filter = consume2.ComposeFilters(filter, newCatFilter(catId))
+	return EntriesContext(
+		context.Background(), store, user, key, query, catId)
+}
+
+// EntriesContext works like Entries but passes ctx to store. If store
+// implements SearchRunner, EntriesContext lets store narrow down the
+// entries to check. The cat: prefix in query works only if store
+// implements CategoriesByOwnerRunner.
+func EntriesContext(
+	ctx context.Context,
+	store EntriesByOwnerRunner,
+	user *vsafe.User,
+	key *vsafe.Key,
+	query string,
+	catId int64) ([]*vsafe.Entry, error) {
+	parsed, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newQueryFilter(ctx, store, user, key, parsed, catId)
+	if err != nil {
+		return nil, err
 	}
 	var results []*vsafe.Entry
-	if err := store.EntriesByOwner(
-		nil,
-		keyId,
-		consume2.Filter(
-			consume2.AppendPtrsTo(&results),
-			filter)); err != nil {
+	consumer := consume2.Filter(consume2.AppendPtrsTo(&results), filter)
+	if text := parsed.searchText(); text != "" {
+		err := searchEntries(ctx, store, nil, key.Id, text, consumer)
+		if err != ErrSearchUnsupported {
+			if err != nil {
+				return nil, err
+			}
+			return results, nil
+		}
+	}
+	if err := entriesByOwner(ctx, store, nil, key.Id, consumer); err != nil {
 		return nil, err
 	}
 	return results, nil
 }
 
-// SortByTitle sorts entries by title in place ignoring case.
+// newQueryFilter returns a filter matching the entries that user may see
+// and that match query and catId.
+func newQueryFilter(
+	ctx context.Context,
+	store EntriesByOwnerRunner,
+	user *vsafe.User,
+	key *vsafe.Key,
+	query *Query,
+	catId int64) (func(vsafe.Entry) bool, error) {
+	var categories []vsafe.Category
+	if query.usesField(kFieldCat) {
+		cstore, ok := store.(CategoriesByOwnerRunner)
+		if !ok {
+			return nil, fmt.Errorf(
+				"%w: cat: is not supported here", ErrBadQuery)
+		}
+		var err error
+		categories, err = CategoriesByOwnerContext(ctx, cstore, nil, key.Id)
+		if err != nil {
+			return nil, err
+		}
+		categories = VisibleCategories(user, categories)
+	}
+	queryFilter, err := query.filter(categories, key)
+	if err != nil {
+		return nil, err
+	}
+	filters := []func(vsafe.Entry) bool{queryFilter}
+	if user.IsRestricted() {
+		filters = append(filters, newUserFilter(user))
+	}
+	if catId != 0 {
+		filters = append(filters, newCatFilter(catId))
+	}
+	return consume2.ComposeFilters(filters...), nil
+}
+
+// VisibleCategories returns the categories that user may see. It returns
+// categories itself if user may see all of them.
+func VisibleCategories(
+	user *vsafe.User, categories []vsafe.Category) []vsafe.Category {
+	if !user.IsRestricted() {
+		return categories
+	}
+	var result []vsafe.Category
+	for _, category := range categories {
+		if user.CanSeeCategory(category.Id) {
+			result = append(result, category)
+		}
+	}
+	return result
+}
+
+// SortByTitle sorts entries by title in place ignoring case and leading
+// and trailing whitespace. Entries with the same title are sorted by id.
+// SortByTitle orders entries the same way stores that read entries by
+// title do: it folds only ASCII letters and trims only ASCII whitespace.
 func SortByTitle(entries []*vsafe.Entry) {
-	sort.Sort(newSortByTitle(entries))
+	sort.Stable(newSortByTitle(entries))
 }
 
 // Reverse reverses entries in place.
@@ -305,12 +697,15 @@ func Reverse(entries []*vsafe.Entry) {
 }
 
 // ChangePassword changes the password of a user in persistent storage.
-// t, the transaction, must be non nil.
+// Users of every role may change their own password. If newPass does not
+// meet policy, ChangePassword returns a *vsafe.PasswordError. t, the
+// transaction, must be non nil.
 func ChangePassword(
 	store SafeUpdateUserRunner,
 	t db.Transaction,
 	id int64,
-	oldPass, newPass string) (*vsafe.User, error) {
+	oldPass, newPass string,
+	policy *vsafe.PasswordPolicy) (*vsafe.User, error) {
 	if t == nil {
 		panic("Transaction must be non-nil")
 	}
@@ -319,6 +714,12 @@ func ChangePassword(
 	if err != nil {
 		return nil, err
 	}
+	if _, err = user.VerifyPassword(oldPass); err != nil {
+		return nil, err
+	}
+	if err = policy.Check(&user, newPass); err != nil {
+		return nil, err
+	}
 	if err = user.ChangePassword(oldPass, newPass); err != nil {
 		return nil, err
 	}
@@ -328,6 +729,201 @@ func ChangePassword(
 	return &user, nil
 }
 
+// ResetPassword sets the password of a sub-user to newPass without
+// knowing the old password. id is the id of the sub-user; key is the key
+// of the sub-user's master. Since only the old password opens the
+// sub-user's collection memberships, ResetPassword removes them. If the
+// user does not have key's master as its master, ResetPassword returns
+// ErrNoSuchId. If newPass does not meet policy, ResetPassword returns a
+// *vsafe.PasswordError. t, the transaction, must be non nil.
+func ResetPassword(
+	store SafeResetPasswordRunner,
+	t db.Transaction,
+	id int64,
+	key *vsafe.Key,
+	newPass string,
+	policy *vsafe.PasswordPolicy) (*vsafe.User, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	var user vsafe.User
+	err := store.UserById(t, id, &user)
+	if err != nil {
+		return nil, err
+	}
+	if user.Owner == 0 || user.Owner != key.Id {
+		return nil, ErrNoSuchId
+	}
+	if err = policy.Check(&user, newPass); err != nil {
+		return nil, err
+	}
+	if err = user.InitWithKey(user.Name, newPass, key); err != nil {
+		return nil, err
+	}
+	var memberships []vsafe.Membership
+	if err = store.MembershipsByUser(
+		t, user.Id, consume2.AppendTo(&memberships)); err != nil {
+		return nil, err
+	}
+	for i := range memberships {
+		if err = store.RemoveMembership(t, memberships[i].Id); err != nil {
+			return nil, err
+		}
+	}
+	if err = store.UpdateUser(t, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetRole sets the role of a sub-user. owner is the id of the sub-user's
+// master. If the user is not a sub-user of owner, SetRole returns
+// ErrNoSuchId. t, the transaction, must be non nil.
+func SetRole(
+	store SafeUpdateUserRunner,
+	t db.Transaction,
+	id, owner int64,
+	role vsafe.Role) (*vsafe.User, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	var user vsafe.User
+	err := store.UserById(t, id, &user)
+	if err != nil {
+		return nil, err
+	}
+	if user.Owner == 0 || user.Owner != owner {
+		return nil, ErrNoSuchId
+	}
+	user.Role = role
+	if err = store.UpdateUser(t, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetCategories restricts a sub-user to seeing only entries in categories.
+// Empty categories lifts the restriction. owner is the id of the sub-user's
+// master. If the user is not a sub-user of owner, SetCategories returns
+// ErrNoSuchId. t, the transaction, must be non nil.
+func SetCategories(
+	store SafeUpdateUserRunner,
+	t db.Transaction,
+	id, owner int64,
+	categories idset.IdSet) (*vsafe.User, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	var user vsafe.User
+	err := store.UserById(t, id, &user)
+	if err != nil {
+		return nil, err
+	}
+	if user.Owner == 0 || user.Owner != owner {
+		return nil, ErrNoSuchId
+	}
+	user.Categories = categories
+	if err = store.UpdateUser(t, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RenameUser changes the name of the user with given id to newName. If
+// newName is empty or only whitespace, RenameUser returns ErrNameRequired.
+// If another user already has newName, RenameUser returns ErrNameTaken. t,
+// the transaction, must be non nil.
+func RenameUser(
+	store SafeRenameUserRunner,
+	t db.Transaction,
+	id int64,
+	newName string) (oldName string, err error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	if strings.TrimSpace(newName) == "" {
+		return "", ErrNameRequired
+	}
+	var other vsafe.User
+	err = store.UserByName(t, newName, &other)
+	if err == nil && other.Id != id {
+		return "", ErrNameTaken
+	}
+	if err != nil && err != ErrNoSuchId {
+		return
+	}
+	var user vsafe.User
+	if err = store.UserById(t, id, &user); err != nil {
+		return
+	}
+	oldName = user.Name
+	user.Name = newName
+	if err = store.UpdateUser(t, &user); err != nil {
+		return
+	}
+	return oldName, nil
+}
+
+// RemoveSubUser removes a sub-user by id along with its memberships in
+// collections. owner is the id of the sub-user's master. If the user is
+// not a sub-user of owner, RemoveSubUser returns ErrNoSuchId. t, the
+// transaction, must be non nil.
+func RemoveSubUser(
+	store SafeRemoveUserRunner,
+	t db.Transaction,
+	id, owner int64) (name string, err error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	var user vsafe.User
+	if err = store.UserById(t, id, &user); err != nil {
+		return
+	}
+	if user.Owner == 0 || user.Owner != owner {
+		return "", ErrNoSuchId
+	}
+	var memberships []vsafe.Membership
+	if err = store.MembershipsByUser(
+		t, id, consume2.AppendTo(&memberships)); err != nil {
+		return
+	}
+	for _, membership := range memberships {
+		if err = store.RemoveMembership(t, membership.Id); err != nil {
+			return
+		}
+	}
+	if err = store.RemoveUser(t, user.Name); err != nil {
+		return
+	}
+	return user.Name, nil
+}
+
+func newUserFilter(user *vsafe.User) func(vsafe.Entry) bool {
+	return func(entry vsafe.Entry) bool {
+		return user.CanSee(entry.Categories)
+	}
+}
+
+// mergeCategories returns the categories in updated that user can see
+// plus the categories in orig that user cannot see.
+func mergeCategories(
+	user *vsafe.User, orig, updated idset.IdSet) idset.IdSet {
+	result := make(map[int64]bool)
+	origMap, _ := orig.Map()
+	for id := range origMap {
+		if !user.CanSeeCategory(id) {
+			result[id] = true
+		}
+	}
+	updatedMap, _ := updated.Map()
+	for id := range updatedMap {
+		if user.CanSeeCategory(id) {
+			result[id] = true
+		}
+	}
+	return idset.New(result)
+}
+
 func newCatFilter(cat int64) func(vsafe.Entry) bool {
 	return func(entry vsafe.Entry) bool {
 		return entry.Categories.Contains(cat)
@@ -365,7 +961,7 @@ type sortByTitle struct {
 func newSortByTitle(entries []*vsafe.Entry) sort.Interface {
 	titles := make([]string, len(entries))
 	for i := range entries {
-		titles[i] = strings.TrimSpace(strings.ToLower(entries[i].Title))
+		titles[i] = asciiToLower(strings.Trim(entries[i].Title, kAsciiSpace))
 	}
 	return &sortByTitle{entries: entries, trimmedLowerTitles: titles}
 }
@@ -375,7 +971,10 @@ func (s *sortByTitle) Len() int {
 }
 
 func (s *sortByTitle) Less(i, j int) bool {
-	return s.trimmedLowerTitles[i] < s.trimmedLowerTitles[j]
+	if s.trimmedLowerTitles[i] != s.trimmedLowerTitles[j] {
+		return s.trimmedLowerTitles[i] < s.trimmedLowerTitles[j]
+	}
+	return s.entries[i].Id < s.entries[j].Id
 }
 
 func (s *sortByTitle) Swap(i, j int) {
@@ -384,6 +983,17 @@ func (s *sortByTitle) Swap(i, j int) {
 		s.trimmedLowerTitles[j], s.trimmedLowerTitles[i]
 }
 
+// asciiToLower returns s with only its ASCII letters in lower case.
+func asciiToLower(s string) string {
+	result := []byte(s)
+	for i, c := range result {
+		if 'A' <= c && c <= 'Z' {
+			result[i] = c + ('a' - 'A')
+		}
+	}
+	return string(result)
+}
+
 func decryptHelper(key *vsafe.Key, entry *vsafe.Entry) (err error) {
 	if err = entry.Decrypt(key); err != nil {
 		if err == vsafe.ErrKeyMismatch {
@@ -1,6 +1,7 @@
 package vsafedb_test
 
 import (
+	"errors"
 	"github.com/keep94/consume2"
 	"github.com/keep94/toolbox/db"
 	"github.com/keep94/toolbox/kdf"
@@ -22,20 +23,24 @@ var (
 		Special:  "xxx",
 	}
 	kKey                        = &vsafe.Key{Id: 7, Value: kdf.Random(32)}
+	kUser                       = &vsafe.User{Id: 7}
 	kTransaction db.Transaction = 0
+	kPolicy                     = &vsafe.PasswordPolicy{MinLength: 5}
 )
 
 func TestUpdateCategory(t *testing.T) {
 	store := &FakeCategoryStore{
 		Category: &vsafe.Category{Id: 5, Owner: 3, Name: "five"}}
 	// wrong Id throws ErrNoSuchId
-	_, err := vsafedb.UpdateCategory(store, kTransaction, 2, 3, "updated")
+	_, err := vsafedb.UpdateCategory(
+		store, kTransaction, 2, &vsafe.User{Id: 3}, &vsafe.Key{Id: 3}, "updated")
 	if err != vsafedb.ErrNoSuchId {
 		t.Error("Expected ErrNoSuchId")
 	}
 
 	// Wrong owner throws ErrNoSuchId
-	_, err = vsafedb.UpdateCategory(store, kTransaction, 5, 2, "updated")
+	_, err = vsafedb.UpdateCategory(
+		store, kTransaction, 5, &vsafe.User{Id: 2}, &vsafe.Key{Id: 2}, "updated")
 	if err != vsafedb.ErrNoSuchId {
 		t.Error("Expected ErrNoSuchId")
 	}
@@ -45,7 +50,7 @@ func TestUpdateCategory(t *testing.T) {
 	}
 
 	oldName, err := vsafedb.UpdateCategory(
-		store, kTransaction, 5, 3, "updated")
+		store, kTransaction, 5, &vsafe.User{Id: 3}, &vsafe.Key{Id: 3}, "updated")
 	if err != nil {
 		t.Fatal("Got error updating category")
 	}
@@ -62,13 +67,15 @@ func TestRemoveCategory(t *testing.T) {
 	store := &FakeCategoryStore{
 		Category: &vsafe.Category{Id: 5, Owner: 3, Name: "five"}}
 	// wrong Id throws ErrNoSuchId
-	_, err := vsafedb.RemoveCategory(store, kTransaction, 2, 3)
+	_, err := vsafedb.RemoveCategory(
+		store, kTransaction, 2, &vsafe.User{Id: 3}, &vsafe.Key{Id: 3})
 	if err != vsafedb.ErrNoSuchId {
 		t.Error("Expected ErrNoSuchId")
 	}
 
 	// Wrong owner throws ErrNoSuchId
-	_, err = vsafedb.RemoveCategory(store, kTransaction, 5, 2)
+	_, err = vsafedb.RemoveCategory(
+		store, kTransaction, 5, &vsafe.User{Id: 2}, &vsafe.Key{Id: 2})
 	if err != vsafedb.ErrNoSuchId {
 		t.Error("Expected ErrNoSuchId")
 	}
@@ -77,7 +84,8 @@ func TestRemoveCategory(t *testing.T) {
 		t.Error("Expected category to remain unchanged")
 	}
 
-	oldName, err := vsafedb.RemoveCategory(store, kTransaction, 5, 3)
+	oldName, err := vsafedb.RemoveCategory(
+		store, kTransaction, 5, &vsafe.User{Id: 3}, &vsafe.Key{Id: 3})
 	if err != nil {
 		t.Fatal("Got error removing category")
 	}
@@ -90,12 +98,34 @@ func TestRemoveCategory(t *testing.T) {
 	}
 }
 
+func TestCategoryPermissionDenied(t *testing.T) {
+	store := &FakeCategoryStore{
+		Category: &vsafe.Category{Id: 5, Owner: 3, Name: "five"}}
+	editor := &vsafe.User{Id: 4, Owner: 3, Role: vsafe.RoleEditor}
+	if _, err := vsafedb.UpdateCategory(
+		store, kTransaction, 5, editor, &vsafe.Key{Id: 3}, "updated"); err != vsafedb.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := vsafedb.RemoveCategory(
+		store, kTransaction, 5, editor, &vsafe.Key{Id: 3}); err != vsafedb.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if store.Category == nil || store.Category.Name != "five" {
+		t.Error("Expected category to remain unchanged")
+	}
+	admin := &vsafe.User{Id: 4, Owner: 3, Role: vsafe.RoleAdmin}
+	if _, err := vsafedb.RemoveCategory(
+		store, kTransaction, 5, admin, &vsafe.Key{Id: 3}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
 func TestAddEntry(t *testing.T) {
 	var store FakeStore
 	entry := *kAnEntry
 	var id int64
 	var err error
-	if id, err = vsafedb.AddEntry(&store, nil, kKey, &entry); err != nil {
+	if id, err = vsafedb.AddEntry(&store, nil, kUser, kKey, &entry); err != nil {
 		t.Fatalf("Error adding tostore: %v", err)
 	}
 	if id != 1 {
@@ -113,7 +143,7 @@ func TestAddEntry(t *testing.T) {
 		t.Error("Expected database to be encrypted.")
 	}
 	var readEntry vsafe.Entry
-	if err = vsafedb.EntryById(store, nil, 1, kKey, &readEntry); err != nil {
+	if err = vsafedb.EntryById(store, nil, 1, kUser, kKey, &readEntry); err != nil {
 		t.Fatalf("Error reading store: %v", err)
 	}
 	origEntry := *kAnEntry
@@ -124,11 +154,24 @@ func TestAddEntry(t *testing.T) {
 		t.Errorf("Expected %v, got %v", origEntry, readEntry)
 	}
 	if err = vsafedb.EntryById(
-		store, nil, 9999, kKey, &readEntry); err != vsafedb.ErrNoSuchId {
+		store, nil, 9999, kUser, kKey, &readEntry); err != vsafedb.ErrNoSuchId {
 		t.Errorf("Expected ErrNoSuchId, got %v", err)
 	}
 }
 
+func TestAddEntryReadOnly(t *testing.T) {
+	var store FakeStore
+	readOnly := &vsafe.User{Id: 8, Owner: 7, Role: vsafe.RoleReadOnly}
+	entry := *kAnEntry
+	if _, err := vsafedb.AddEntry(
+		&store, nil, readOnly, kKey, &entry); err != vsafedb.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if len(store) != 0 {
+		t.Error("Expected no entry to be added")
+	}
+}
+
 func TestUpdateEntry(t *testing.T) {
 	store := make(FakeStore, 1)
 	origEntry := *kAnEntry
@@ -149,7 +192,7 @@ func TestUpdateEntry(t *testing.T) {
 		t.Error("Expected database to be encrypted.")
 	}
 	var readEntry vsafe.Entry
-	if err := vsafedb.EntryById(store, nil, 1, kKey, &readEntry); err != nil {
+	if err := vsafedb.EntryById(store, nil, 1, kUser, kKey, &readEntry); err != nil {
 		t.Fatalf("Error reading store: %v", err)
 	}
 	origEntry.Owner = kKey.Id
@@ -162,13 +205,13 @@ func TestUpdateEntry(t *testing.T) {
 func TestUpdateEntryWithEtag(t *testing.T) {
 	origEntry := *kOrigEntry
 	var store FakeStore
-	newId, err := vsafedb.AddEntry(&store, nil, kKey, &origEntry)
+	newId, err := vsafedb.AddEntry(&store, nil, kUser, kKey, &origEntry)
 	if err != nil {
 		t.Fatalf("Error saving original entry %v", err)
 	}
 	var origEntryWithEtag vsafe.Entry
 	if err := vsafedb.EntryById(
-		store, nil, newId, kKey, &origEntryWithEtag); err != nil {
+		store, nil, newId, kUser, kKey, &origEntryWithEtag); err != nil {
 		t.Fatalf("Error reading original entry %v", err)
 	}
 	if err := vsafedb.UpdateEntryWithEtag(
@@ -176,12 +219,13 @@ func TestUpdateEntryWithEtag(t *testing.T) {
 		kTransaction,
 		newId,
 		origEntryWithEtag.Etag,
+		kUser,
 		kKey,
 		changeToAnEntry); err != nil {
 		t.Fatalf("Error updating store: %v", err)
 	}
 	var readEntry vsafe.Entry
-	if err := vsafedb.EntryById(store, nil, newId, kKey, &readEntry); err != nil {
+	if err := vsafedb.EntryById(store, nil, newId, kUser, kKey, &readEntry); err != nil {
 		t.Fatalf("Error reading store: %v", err)
 	}
 	entry := *kAnEntry
@@ -196,13 +240,13 @@ func TestUpdateEntryWithEtag(t *testing.T) {
 func TestUpdateEntryConcurrent(t *testing.T) {
 	origEntry := *kOrigEntry
 	var store FakeStore
-	newId, err := vsafedb.AddEntry(&store, nil, kKey, &origEntry)
+	newId, err := vsafedb.AddEntry(&store, nil, kUser, kKey, &origEntry)
 	if err != nil {
 		t.Fatalf("Error saving original entry %v", err)
 	}
 	var origEntryWithEtag vsafe.Entry
 	if err := vsafedb.EntryById(
-		store, nil, newId, kKey, &origEntryWithEtag); err != nil {
+		store, nil, newId, kUser, kKey, &origEntryWithEtag); err != nil {
 		t.Fatalf("Error reading original entry %v", err)
 	}
 	update := changeToAnEntry
@@ -216,6 +260,7 @@ func TestUpdateEntryConcurrent(t *testing.T) {
 		kTransaction,
 		newId,
 		origEntryWithEtag.Etag+1,
+		kUser,
 		kKey,
 		updateSkipped); err != nil {
 		t.Fatalf("Error updating store: %v", err)
@@ -225,12 +270,13 @@ func TestUpdateEntryConcurrent(t *testing.T) {
 		kTransaction,
 		newId,
 		origEntryWithEtag.Etag+1,
+		kUser,
 		kKey,
 		update); err != vsafedb.ErrConcurrentModification {
 		t.Errorf("Expected ErrConcurrentModfication, got %v", err)
 	}
 	var readEntry vsafe.Entry
-	if err := vsafedb.EntryById(store, nil, newId, kKey, &readEntry); err != nil {
+	if err := vsafedb.EntryById(store, nil, newId, kUser, kKey, &readEntry); err != nil {
 		t.Fatalf("Error reading store: %v", err)
 	}
 	if readEntry != origEntryWithEtag {
@@ -241,13 +287,13 @@ func TestUpdateEntryConcurrent(t *testing.T) {
 func TestUpdateEntryWithEtagBadKey(t *testing.T) {
 	origEntry := *kOrigEntry
 	var store FakeStore
-	newId, err := vsafedb.AddEntry(&store, nil, kKey, &origEntry)
+	newId, err := vsafedb.AddEntry(&store, nil, kUser, kKey, &origEntry)
 	if err != nil {
 		t.Fatalf("Error saving original entry %v", err)
 	}
 	var origEntryWithEtag vsafe.Entry
 	if err := vsafedb.EntryById(
-		store, nil, newId, kKey, &origEntryWithEtag); err != nil {
+		store, nil, newId, kUser, kKey, &origEntryWithEtag); err != nil {
 		t.Fatalf("Error readingoriginal entry %v", err)
 	}
 	badKey := *kKey
@@ -257,12 +303,13 @@ func TestUpdateEntryWithEtagBadKey(t *testing.T) {
 		kTransaction,
 		newId,
 		origEntryWithEtag.Etag,
+		kUser,
 		&badKey,
 		changeToAnEntry); err != vsafedb.ErrNoSuchId {
 		t.Errorf("Expected ErrNoSuchId, got %v", err)
 	}
 	var readEntry vsafe.Entry
-	if err := vsafedb.EntryById(store, nil, newId, kKey, &readEntry); err != nil {
+	if err := vsafedb.EntryById(store, nil, newId, kUser, kKey, &readEntry); err != nil {
 		t.Fatalf("Error reading store: %v", err)
 	}
 	if readEntry != origEntryWithEtag {
@@ -270,17 +317,160 @@ func TestUpdateEntryWithEtagBadKey(t *testing.T) {
 	}
 }
 
-func TestEntryByIdKeyMismatch(t *testing.T) {
+func TestUpdateEntryWithEtagReadOnly(t *testing.T) {
+	origEntry := *kOrigEntry
 	var store FakeStore
-	entry := *kAnEntry
-	vsafedb.AddEntry(&store, nil, kKey, &entry)
+	newId, err := vsafedb.AddEntry(&store, nil, kUser, kKey, &origEntry)
+	if err != nil {
+		t.Fatalf("Error saving original entry %v", err)
+	}
+	var origEntryWithEtag vsafe.Entry
+	if err := vsafedb.EntryById(
+		store, nil, newId, kUser, kKey, &origEntryWithEtag); err != nil {
+		t.Fatalf("Error reading original entry %v", err)
+	}
+	readOnly := &vsafe.User{Id: 8, Owner: 7, Role: vsafe.RoleReadOnly}
+	if err := vsafedb.UpdateEntryWithEtag(
+		store,
+		kTransaction,
+		newId,
+		origEntryWithEtag.Etag,
+		readOnly,
+		kKey,
+		changeToAnEntry); err != vsafedb.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	var readEntry vsafe.Entry
+	if err := vsafedb.EntryById(store, nil, newId, kUser, kKey, &readEntry); err != nil {
+		t.Fatalf("Error reading store: %v", err)
+	}
+	if readEntry != origEntryWithEtag {
+		t.Errorf("Entry should not have been updated")
+	}
+}
+
+func TestRemoveEntry(t *testing.T) {
+	var store FakeStore
+	entry1 := vsafe.Entry{Title: "first", Categories: "3"}
+	entry2 := vsafe.Entry{Title: "second", Categories: "4"}
+	id1, _ := vsafedb.AddEntry(&store, nil, kUser, kKey, &entry1)
+	id2, _ := vsafedb.AddEntry(&store, nil, kUser, kKey, &entry2)
+	readOnly := &vsafe.User{Id: 8, Owner: 7, Role: vsafe.RoleReadOnly}
+	if err := vsafedb.RemoveEntry(
+		&store, kTransaction, id1, readOnly, kKey); err != vsafedb.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	stranger := &vsafe.User{Id: 9, Owner: 6, Role: vsafe.RoleEditor}
+	if err := vsafedb.RemoveEntry(
+		&store, kTransaction, id1, stranger, &vsafe.Key{Id: 6}); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	restricted := &vsafe.User{
+		Id: 8, Owner: 7, Role: vsafe.RoleEditor, Categories: "3"}
+	if err := vsafedb.RemoveEntry(
+		&store, kTransaction, id2, restricted, kKey); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if err := vsafedb.RemoveEntry(
+		&store, kTransaction, id1, restricted, kKey); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	var readEntry vsafe.Entry
+	if err := store.EntryById(
+		nil, id1, &readEntry); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected entry removed, got %v", err)
+	}
+	if err := store.EntryById(nil, id2, &readEntry); err != nil {
+		t.Errorf("Expected entry to remain, got %v", err)
+	}
+}
+
+func TestEntryByIdRestricted(t *testing.T) {
+	var store FakeStore
+	entry1 := vsafe.Entry{Title: "first", Categories: "3,5"}
+	entry2 := vsafe.Entry{Title: "second", Categories: "4"}
+	id1, _ := vsafedb.AddEntry(&store, nil, kUser, kKey, &entry1)
+	id2, _ := vsafedb.AddEntry(&store, nil, kUser, kKey, &entry2)
+	restricted := &vsafe.User{Id: 8, Owner: 7, Categories: "3"}
 	var readEntry vsafe.Entry
-	badKey := *kKey
-	badKey.Id++
 	if err := vsafedb.EntryById(
-		store, nil, 1, &badKey, &readEntry); err != vsafedb.ErrNoSuchId {
+		store, nil, id1, restricted, kKey, &readEntry); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := vsafedb.EntryById(
+		store, nil, id2, restricted, kKey, &readEntry); err != vsafedb.ErrNoSuchId {
 		t.Errorf("Expected ErrNoSuchId, got %v", err)
 	}
+	if readEntry.Title != "" {
+		t.Error("Expected hidden entry not to be returned")
+	}
+	entries, err := vsafedb.Entries(store, restricted, kKey, "", 0)
+	if err != nil {
+		t.Fatalf("Got error fetching entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Id != id1 {
+		t.Errorf("Expected only first entry, got %v", entries)
+	}
+}
+
+func TestVisibleCategories(t *testing.T) {
+	categories := []vsafe.Category{{Id: 3}, {Id: 4}, {Id: 5}}
+	if out := vsafedb.VisibleCategories(kUser, categories); len(out) != 3 {
+		t.Errorf("Expected 3 categories, got %v", out)
+	}
+	restricted := &vsafe.User{Id: 8, Owner: 7, Categories: "3,5"}
+	out := vsafedb.VisibleCategories(restricted, categories)
+	if len(out) != 2 || out[0].Id != 3 || out[1].Id != 5 {
+		t.Errorf("Expected categories 3 and 5, got %v", out)
+	}
+}
+
+func TestUpdateEntryWithEtagRestricted(t *testing.T) {
+	var store FakeStore
+	entry := vsafe.Entry{Title: "first", Categories: "3,4"}
+	id, _ := vsafedb.AddEntry(&store, nil, kUser, kKey, &entry)
+	restricted := &vsafe.User{Id: 8, Owner: 7, Categories: "3,5"}
+	var origEntry vsafe.Entry
+	if err := vsafedb.EntryById(
+		store, nil, id, restricted, kKey, &origEntry); err != nil {
+		t.Fatalf("Error reading original entry %v", err)
+	}
+	hide := func(e *vsafe.Entry) bool {
+		e.Categories = ""
+		return true
+	}
+	if err := vsafedb.UpdateEntryWithEtag(
+		store,
+		kTransaction,
+		id,
+		origEntry.Etag,
+		restricted,
+		kKey,
+		hide); err != vsafedb.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	move := func(e *vsafe.Entry) bool {
+		e.Categories = "5"
+		return true
+	}
+	if err := vsafedb.UpdateEntryWithEtag(
+		store,
+		kTransaction,
+		id,
+		origEntry.Etag,
+		restricted,
+		kKey,
+		move); err != nil {
+		t.Fatalf("Error updating store: %v", err)
+	}
+	var readEntry vsafe.Entry
+	if err := vsafedb.EntryById(
+		store, nil, id, kUser, kKey, &readEntry); err != nil {
+		t.Fatalf("Error reading store: %v", err)
+	}
+	if readEntry.Categories != "4,5" {
+		t.Errorf("Expected 4,5, got %s", readEntry.Categories)
+	}
 }
 
 func TestEntries(t *testing.T) {
@@ -290,10 +480,10 @@ func TestEntries(t *testing.T) {
 	entry1 := vsafe.Entry{Title: " First", Url: yahoo, Desc: "the SeconD   oNe"}
 	entry2 := vsafe.Entry{Title: "aGAiN  sEcond", Url: google, Desc: "a desc"}
 	entry3 := vsafe.Entry{Title: "third again", Desc: "foo bar", Categories: "17"}
-	vsafedb.AddEntry(&store, nil, kKey, &entry1)
-	vsafedb.AddEntry(&store, nil, kKey, &entry2)
-	vsafedb.AddEntry(&store, nil, kKey, &entry3)
-	entries, err := vsafedb.Entries(store, kKey.Id, "", 0)
+	vsafedb.AddEntry(&store, nil, kUser, kKey, &entry1)
+	vsafedb.AddEntry(&store, nil, kUser, kKey, &entry2)
+	vsafedb.AddEntry(&store, nil, kUser, kKey, &entry3)
+	entries, err := vsafedb.Entries(store, kUser, kKey, "", 0)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
@@ -303,63 +493,63 @@ func TestEntries(t *testing.T) {
 	if entries[0].Title != entry1.Title || entries[1].Title != entry2.Title || entries[2].Title != entry3.Title {
 		t.Error("Returned 3 entries in wrong order")
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, "  first", 0)
+	entries, err = vsafedb.Entries(store, kUser, kKey, "  first", 0)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
 	if len(entries) != 1 {
 		t.Errorf("Expected 1 entries, got %v", len(entries))
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, "second  ", 0)
+	entries, err = vsafedb.Entries(store, kUser, kKey, "second  ", 0)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
 	if len(entries) != 2 {
 		t.Errorf("Expected 2 entries, got %v", len(entries))
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, "google", 0)
+	entries, err = vsafedb.Entries(store, kUser, kKey, "google", 0)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
 	if len(entries) != 1 {
 		t.Errorf("Expected 1 entries, got %v", len(entries))
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, "biz", 0)
+	entries, err = vsafedb.Entries(store, kUser, kKey, "biz", 0)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
 	if len(entries) != 0 {
 		t.Errorf("Expected 0 entries, got %v", len(entries))
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, " eCond  one ", 0)
+	entries, err = vsafedb.Entries(store, kUser, kKey, " eCond  one ", 0)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
 	if len(entries) != 1 {
 		t.Errorf("Expected 1 entries, got %v", len(entries))
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, " Gain   SEco ", 0)
+	entries, err = vsafedb.Entries(store, kUser, kKey, " Gain   SEco ", 0)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
 	if len(entries) != 1 {
 		t.Errorf("Expected 1 entries, got %v", len(entries))
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, " hain   SEco ", 0)
+	entries, err = vsafedb.Entries(store, kUser, kKey, " hain   SEco ", 0)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
 	if len(entries) != 0 {
 		t.Errorf("Expected 0 entries, got %v", len(entries))
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, "", 17)
+	entries, err = vsafedb.Entries(store, kUser, kKey, "", 17)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
 	if len(entries) != 1 {
 		t.Errorf("Expected 1 entry, got %v", len(entries))
 	}
-	entries, err = vsafedb.Entries(store, kKey.Id, "", 16)
+	entries, err = vsafedb.Entries(store, kUser, kKey, "", 16)
 	if err != nil {
 		t.Fatalf("Got error fetching entries: %v", err)
 	}
@@ -368,6 +558,39 @@ func TestEntries(t *testing.T) {
 	}
 }
 
+func TestEntriesSearchRunner(t *testing.T) {
+	store := &FakeSearchStore{}
+	entry1 := vsafe.Entry{Title: "first", Categories: "3"}
+	entry2 := vsafe.Entry{Title: "first", Desc: "second"}
+	id1, _ := vsafedb.AddEntry(&store.FakeStore, nil, kUser, kKey, &entry1)
+	id2, _ := vsafedb.AddEntry(&store.FakeStore, nil, kUser, kKey, &entry2)
+	entries, err := vsafedb.Entries(store, kUser, kKey, "  FIRST ", 3)
+	if err != nil {
+		t.Fatalf("Got error fetching entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Id != id1 {
+		t.Errorf("Expected first entry, got %v", entries)
+	}
+	if store.Query != "first" {
+		t.Errorf("Expected normalized query passed to store, got %q", store.Query)
+	}
+	entries, err = vsafedb.Entries(store, kUser, kKey, "second", 0)
+	if err != nil {
+		t.Fatalf("Got error fetching entries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected store to do the search, got %v", entries)
+	}
+	store.Unsupported = true
+	entries, err = vsafedb.Entries(store, kUser, kKey, "second", 0)
+	if err != nil {
+		t.Fatalf("Got error fetching entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Id != id2 {
+		t.Errorf("Expected fallback to find second entry, got %v", entries)
+	}
+}
+
 func TestSortByTitle(t *testing.T) {
 	entry1 := vsafe.Entry{Title: " First"}
 	entry2 := vsafe.Entry{Title: "aGAiN  sEcond"}
@@ -380,6 +603,30 @@ func TestSortByTitle(t *testing.T) {
 	}
 }
 
+func TestSortByTitleTies(t *testing.T) {
+	entry1 := vsafe.Entry{Id: 3, Title: "same"}
+	entry2 := vsafe.Entry{Id: 1, Title: " Same"}
+	entry3 := vsafe.Entry{Id: 2, Title: "SAME\t"}
+	s := []*vsafe.Entry{&entry1, &entry2, &entry3}
+	vsafedb.SortByTitle(s)
+	if s[0] != &entry2 || s[1] != &entry3 || s[2] != &entry1 {
+		t.Error("Expected entries with the same title sorted by id.")
+	}
+}
+
+func TestSortByTitleAscii(t *testing.T) {
+	// Only ASCII letters are folded and only ASCII whitespace is trimmed
+	entry1 := vsafe.Entry{Id: 1, Title: "éclair"}
+	entry2 := vsafe.Entry{Id: 2, Title: "Émile"}
+	entry3 := vsafe.Entry{Id: 3, Title: "\u00a0apple"}
+	entry4 := vsafe.Entry{Id: 4, Title: "Banana"}
+	s := []*vsafe.Entry{&entry1, &entry2, &entry3, &entry4}
+	vsafedb.SortByTitle(s)
+	if s[0] != &entry4 || s[1] != &entry3 || s[2] != &entry2 || s[3] != &entry1 {
+		t.Error("Sort in wrong order.")
+	}
+}
+
 func TestSortByTitleEmpty(t *testing.T) {
 	var s []*vsafe.Entry
 	vsafedb.SortByTitle(s)
@@ -421,18 +668,30 @@ func TestChangePassword(t *testing.T) {
 		t.Fatalf("Error adding user %v", err)
 	}
 	if _, err := vsafedb.ChangePassword(
-		store, kTransaction, user.Id+1, "password", "board"); err != vsafedb.ErrNoSuchId {
+		store, kTransaction, user.Id+1, "password", "board", kPolicy); err != vsafedb.ErrNoSuchId {
 		t.Errorf("Expected ErrNoSuchId, got %v", err)
 	}
 	if _, err := vsafedb.ChangePassword(
-		store, kTransaction, user.Id, "wrong", "board"); err != vsafe.ErrWrongPassword {
+		store, kTransaction, user.Id, "wrong", "board", kPolicy); err != vsafe.ErrWrongPassword {
 		t.Errorf("Expected ErrWrongPassword, got %v", err)
 	}
+	if _, err := vsafedb.ChangePassword(
+		store, kTransaction, user.Id, "password", "foo", kPolicy); !errors.Is(err, vsafe.ErrPasswordTooShort) {
+		t.Errorf("Expected ErrPasswordTooShort, got %v", err)
+	}
+	if _, err := vsafedb.ChangePassword(
+		store, kTransaction, user.Id, "password", "password", kPolicy); !errors.Is(err, vsafe.ErrPasswordReused) {
+		t.Errorf("Expected ErrPasswordReused, got %v", err)
+	}
 	newUser, err := vsafedb.ChangePassword(
-		store, kTransaction, user.Id, "password", "board")
+		store, kTransaction, user.Id, "password", "board", kPolicy)
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
+	if _, err := vsafedb.ChangePassword(
+		store, kTransaction, user.Id, "board", "password", kPolicy); !errors.Is(err, vsafe.ErrPasswordReused) {
+		t.Errorf("Expected ErrPasswordReused for old password, got %v", err)
+	}
 	if _, err := newUser.VerifyPassword("board"); err != nil {
 		t.Errorf("Got error verifying password, %v", err)
 	}
@@ -445,6 +704,230 @@ func TestChangePassword(t *testing.T) {
 	}
 }
 
+func TestChangePasswordReadOnly(t *testing.T) {
+	var store FakeUserStore
+	_, sub, _ := addMasterAndSubUser(t, &store)
+	sub.Role = vsafe.RoleReadOnly
+	if err := store.UpdateUser(nil, sub); err != nil {
+		t.Fatalf("Error updating user %v", err)
+	}
+	// Read-only users may still change their own password
+	newUser, err := vsafedb.ChangePassword(
+		store, kTransaction, sub.Id, "pass", "board", kPolicy)
+	if err != nil {
+		t.Fatalf("Error changing password: %v", err)
+	}
+	if _, err := newUser.VerifyPassword("board"); err != nil {
+		t.Errorf("Expected new password to work, got %v", err)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	var store FakeUserStore
+	master, sub, other := addMasterAndSubUser(t, &store)
+	if _, err := vsafedb.SetRole(
+		store, kTransaction, master.Id, master.Id, vsafe.RoleReadOnly); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId for master, got %v", err)
+	}
+	if _, err := vsafedb.SetRole(
+		store, kTransaction, other.Id, master.Id, vsafe.RoleReadOnly); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId for other user, got %v", err)
+	}
+	if _, err := vsafedb.SetRole(
+		store, kTransaction, sub.Id, master.Id, vsafe.RoleReadOnly); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var readUser vsafe.User
+	if err := store.UserById(nil, sub.Id, &readUser); err != nil {
+		t.Fatalf("Got error reading database, %v", err)
+	}
+	if readUser.Role != vsafe.RoleReadOnly {
+		t.Errorf("Expected read-only, got %v", readUser.Role)
+	}
+}
+
+func TestSetCategories(t *testing.T) {
+	var store FakeUserStore
+	master, sub, other := addMasterAndSubUser(t, &store)
+	if _, err := vsafedb.SetCategories(
+		store, kTransaction, master.Id, master.Id, "3"); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId for master, got %v", err)
+	}
+	if _, err := vsafedb.SetCategories(
+		store, kTransaction, other.Id, master.Id, "3"); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId for other user, got %v", err)
+	}
+	if _, err := vsafedb.SetCategories(
+		store, kTransaction, sub.Id, master.Id, "3,4"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var readUser vsafe.User
+	if err := store.UserById(nil, sub.Id, &readUser); err != nil {
+		t.Fatalf("Got error reading database, %v", err)
+	}
+	if readUser.Categories != "3,4" {
+		t.Errorf("Expected 3,4, got %s", readUser.Categories)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	store := newFakeCollectionStore()
+	master, sub, other := addMasterAndSubUser(t, store.FakeUserStore)
+	key, err := master.VerifyPassword("password")
+	if err != nil {
+		t.Fatalf("Error verifying password %v", err)
+	}
+	collection, _, err := vsafedb.CreateCollection(
+		store, kTransaction, "team", sub)
+	if err != nil {
+		t.Fatalf("Error creating collection %v", err)
+	}
+	if _, err := vsafedb.ResetPassword(
+		store, kTransaction, master.Id, key, "board", kPolicy); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId resetting master, got %v", err)
+	}
+	if _, err := vsafedb.ResetPassword(
+		store, kTransaction, other.Id, key, "board", kPolicy); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId resetting other user, got %v", err)
+	}
+	if _, err := vsafedb.ResetPassword(
+		store, kTransaction, sub.Id, key, "sub", kPolicy); !errors.Is(err, vsafe.ErrPasswordTooShort) {
+		t.Errorf("Expected ErrPasswordTooShort, got %v", err)
+	}
+	if _, err := vsafedb.ResetPassword(
+		store, kTransaction, sub.Id, key, "SUB", &vsafe.PasswordPolicy{}); !errors.Is(err, vsafe.ErrPasswordIsName) {
+		t.Errorf("Expected ErrPasswordIsName, got %v", err)
+	}
+	newUser, err := vsafedb.ResetPassword(
+		store, kTransaction, sub.Id, key, "board", kPolicy)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if newUser.Name != "sub" || newUser.Owner != master.Id {
+		t.Errorf("Expected name and owner to be unchanged, got %v", newUser)
+	}
+	var readUser vsafe.User
+	if err := store.UserById(nil, sub.Id, &readUser); err != nil {
+		t.Fatalf("Got error reading database, %v", err)
+	}
+	subKey, err := readUser.VerifyPassword("board")
+	if err != nil {
+		t.Fatalf("Got error verifying password, %v", err)
+	}
+	if !subKey.Equal(key) {
+		t.Error("Expected sub-user to share master's key")
+	}
+	// The new password can't open the old membership, so it is gone.
+	memberKey, err := readUser.MemberKey("board")
+	if err != nil {
+		t.Fatalf("Got error getting member key, %v", err)
+	}
+	if _, err := vsafedb.CollectionKey(
+		store, nil, collection.Id, &readUser, memberKey); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+}
+
+func TestRenameUser(t *testing.T) {
+	var store FakeUserStore
+	master, sub, _ := addMasterAndSubUser(t, &store)
+	if _, err := vsafedb.RenameUser(
+		store, kTransaction, sub.Id, "other"); err != vsafedb.ErrNameTaken {
+		t.Errorf("Expected ErrNameTaken, got %v", err)
+	}
+	for _, blank := range []string{"", " \t"} {
+		if _, err := vsafedb.RenameUser(
+			store, kTransaction, sub.Id, blank); err != vsafedb.ErrNameRequired {
+			t.Errorf("Expected ErrNameRequired for %q, got %v", blank, err)
+		}
+	}
+	if _, err := vsafedb.RenameUser(
+		store, kTransaction, 99, "nobody"); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	oldName, err := vsafedb.RenameUser(store, kTransaction, sub.Id, "helper")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if oldName != "sub" {
+		t.Errorf("Expected sub, got %s", oldName)
+	}
+	var readUser vsafe.User
+	if err := store.UserByName(nil, "helper", &readUser); err != nil {
+		t.Fatalf("Expected renamed user, got %v", err)
+	}
+	if readUser.Id != sub.Id || readUser.Owner != master.Id {
+		t.Errorf("Unexpected user %v", readUser)
+	}
+	if _, err := vsafedb.RenameUser(
+		store, kTransaction, sub.Id, "helper"); err != nil {
+		t.Errorf("Expected renaming to same name to work, got %v", err)
+	}
+}
+
+func TestRemoveSubUser(t *testing.T) {
+	store := newFakeCollectionStore()
+	master, sub, other := addMasterAndSubUser(t, store.FakeUserStore)
+	store.AddMembership(nil, &vsafe.Membership{Collection: 1, User: sub.Id})
+	if _, err := vsafedb.RemoveSubUser(
+		store, kTransaction, master.Id, master.Id); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId removing master, got %v", err)
+	}
+	if _, err := vsafedb.RemoveSubUser(
+		store, kTransaction, other.Id, master.Id); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId removing other user, got %v", err)
+	}
+	name, err := vsafedb.RemoveSubUser(
+		store, kTransaction, sub.Id, master.Id)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if name != "sub" {
+		t.Errorf("Expected sub, got %s", name)
+	}
+	var readUser vsafe.User
+	if err := store.UserById(
+		nil, sub.Id, &readUser); err != vsafedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if err := store.UserById(nil, master.Id, &readUser); err != nil {
+		t.Errorf("Expected master to remain, got %v", err)
+	}
+	if store.Memberships[0] != nil {
+		t.Error("Expected membership to be removed")
+	}
+}
+
+func addMasterAndSubUser(
+	t *testing.T, store *FakeUserStore) (master, sub, other *vsafe.User) {
+	master = &vsafe.User{}
+	if err := master.Init("master", "password"); err != nil {
+		t.Fatalf("Error initializing user %v", err)
+	}
+	if err := store.AddUser(nil, master); err != nil {
+		t.Fatalf("Error adding user %v", err)
+	}
+	key, err := master.VerifyPassword("password")
+	if err != nil {
+		t.Fatalf("Error verifying password %v", err)
+	}
+	sub = &vsafe.User{}
+	if err := sub.InitWithKey("sub", "pass", key); err != nil {
+		t.Fatalf("Error initializing user %v", err)
+	}
+	if err := store.AddUser(nil, sub); err != nil {
+		t.Fatalf("Error adding user %v", err)
+	}
+	other = &vsafe.User{}
+	if err := other.Init("other", "password"); err != nil {
+		t.Fatalf("Error initializing user %v", err)
+	}
+	if err := store.AddUser(nil, other); err != nil {
+		t.Fatalf("Error adding user %v", err)
+	}
+	return
+}
+
 type FakeUserStore []*vsafe.User
 
 func (f *FakeUserStore) AddUser(t db.Transaction, u *vsafe.User) error {
@@ -463,13 +946,49 @@ func (f FakeUserStore) UpdateUser(
 
 func (f FakeUserStore) UserById(
 	t db.Transaction, id int64, u *vsafe.User) error {
-	if int(id) > len(f) {
+	if int(id) > len(f) || f[id-1] == nil {
 		return vsafedb.ErrNoSuchId
 	}
 	*u = *f[id-1]
 	return nil
 }
 
+func (f FakeUserStore) UserByName(
+	t db.Transaction, name string, u *vsafe.User) error {
+	for _, user := range f {
+		if user != nil && user.Name == name {
+			*u = *user
+			return nil
+		}
+	}
+	return vsafedb.ErrNoSuchId
+}
+
+func (f FakeUserStore) RemoveUser(t db.Transaction, name string) error {
+	for i := range f {
+		if f[i] != nil && f[i].Name == name {
+			f[i] = nil
+		}
+	}
+	return nil
+}
+
+func (f FakeUserStore) UsersByOwner(
+	t db.Transaction,
+	owner int64,
+	consumer consume2.Consumer[vsafe.User]) error {
+	for _, user := range f {
+		if !consumer.CanConsume() {
+			break
+		}
+		if user == nil || user.GetOwner() != owner {
+			continue
+		}
+		consumer.Consume(*user)
+	}
+	return nil
+}
+
 type FakeCategoryStore struct {
 	Category *vsafe.Category
 }
@@ -483,6 +1002,14 @@ func (f *FakeCategoryStore) CategoryById(
 	return nil
 }
 
+func (f *FakeCategoryStore) CategoriesByOwner(
+	t db.Transaction, owner int64) ([]vsafe.Category, error) {
+	if f.Category == nil || f.Category.Owner != owner {
+		return nil, nil
+	}
+	return []vsafe.Category{*f.Category}, nil
+}
+
 func (f *FakeCategoryStore) UpdateCategory(
 	t db.Transaction, c *vsafe.Category) error {
 	if f.Category == nil || f.Category.Id != c.Id {
@@ -518,7 +1045,7 @@ func (f FakeStore) UpdateEntry(t db.Transaction, e *vsafe.Entry) error {
 }
 
 func (f FakeStore) EntryById(t db.Transaction, id int64, e *vsafe.Entry) error {
-	if int(id) > len(f) {
+	if int(id) > len(f) || f[id-1] == nil {
 		return vsafedb.ErrNoSuchId
 	}
 	*e = *f[id-1]
@@ -526,6 +1053,13 @@ func (f FakeStore) EntryById(t db.Transaction, id int64, e *vsafe.Entry) error {
 	return nil
 }
 
+func (f FakeStore) RemoveEntry(t db.Transaction, id, owner int64) error {
+	if int(id) <= len(f) && f[id-1] != nil && f[id-1].Owner == owner {
+		f[id-1] = nil
+	}
+	return nil
+}
+
 func (f FakeStore) EntriesByOwner(
 	t db.Transaction,
 	owner int64,
@@ -534,7 +1068,7 @@ func (f FakeStore) EntriesByOwner(
 		if !consumer.CanConsume() {
 			break
 		}
-		if entry.Owner != owner {
+		if entry == nil || entry.Owner != owner {
 			continue
 		}
 		consumer.Consume(*entry)
@@ -542,6 +1076,30 @@ func (f FakeStore) EntriesByOwner(
 	return nil
 }
 
+// FakeSearchStore searches by exact title.
+type FakeSearchStore struct {
+	FakeStore
+	Query       string
+	Unsupported bool
+}
+
+func (f *FakeSearchStore) SearchEntries(
+	t db.Transaction,
+	owner int64,
+	query string,
+	consumer consume2.Consumer[vsafe.Entry]) error {
+	f.Query = query
+	if f.Unsupported {
+		return vsafedb.ErrSearchUnsupported
+	}
+	return f.EntriesByOwner(
+		t,
+		owner,
+		consume2.Filter(consumer, func(entry vsafe.Entry) bool {
+			return entry.Title == query
+		}))
+}
+
 func changeToAnEntry(entryPtr *vsafe.Entry) bool {
 	*entryPtr = *kAnEntry
 	return true
@@ -0,0 +1,129 @@
+package vsafedb
+
+import (
+	"errors"
+	"github.com/keep94/consume2"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/vsafe"
+)
+
+var (
+	// Indicates that a user is not a sub-user.
+	ErrNotSubUser = errors.New("vsafedb: Not a sub-user.")
+)
+
+type SafeTransferOwnershipRunner interface {
+	SafeUpdateUserRunner
+	UsersByOwnerRunner
+	CategoriesByOwnerRunner
+	UpdateCategoryRunner
+	EntriesByOwnerRunner
+	UpdateEntryRunner
+	SharesBySenderRunner
+	SharesByRecipientRunner
+	UpdateShareRunner
+}
+
+// TransferOwnership makes the sub-user with given id the master user of its
+// vault. The old master user becomes an admin sub-user of the new master
+// and may then be removed without losing the vault. TransferOwnership
+// rewrites the owner of every user, category, and entry in the vault and
+// moves the vault's key pair and shares to the new master. Since the key
+// ID of the vault changes, everyone in the vault must log in again. If the
+// user is a master user, TransferOwnership returns ErrNotSubUser. It
+// returns the old master user. t, the transaction, must be non nil.
+func TransferOwnership(
+	store SafeTransferOwnershipRunner,
+	t db.Transaction,
+	id int64) (*vsafe.User, error) {
+	if t == nil {
+		panic("Transaction must be non-nil")
+	}
+	var newMaster vsafe.User
+	if err := store.UserById(t, id, &newMaster); err != nil {
+		return nil, err
+	}
+	if newMaster.Owner == 0 {
+		return nil, ErrNotSubUser
+	}
+	oldId := newMaster.Owner
+	var users []vsafe.User
+	if err := store.UsersByOwner(
+		t, oldId, consume2.AppendTo(&users)); err != nil {
+		return nil, err
+	}
+	var oldMaster *vsafe.User
+	for i := range users {
+		if users[i].Id == oldId {
+			oldMaster = &users[i]
+		}
+	}
+	if oldMaster == nil {
+		return nil, ErrNoSuchId
+	}
+	publicKey, privateKey := oldMaster.PublicKey, oldMaster.PrivateKey
+	for i := range users {
+		user := &users[i]
+		switch user.Id {
+		case id:
+			user.Owner = 0
+			user.Role = vsafe.RoleAdmin
+			user.Categories = ""
+			user.PublicKey = publicKey
+			user.PrivateKey = privateKey
+		case oldId:
+			user.Owner = id
+			user.Role = vsafe.RoleAdmin
+			user.PublicKey = ""
+			user.PrivateKey = ""
+		default:
+			user.Owner = id
+		}
+		if err := store.UpdateUser(t, user); err != nil {
+			return nil, err
+		}
+	}
+	categories, err := store.CategoriesByOwner(t, oldId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range categories {
+		categories[i].Owner = id
+		if err := store.UpdateCategory(t, &categories[i]); err != nil {
+			return nil, err
+		}
+	}
+	var entries []vsafe.Entry
+	if err := store.EntriesByOwner(
+		t, oldId, consume2.AppendTo(&entries)); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Owner = id
+		if err := store.UpdateEntry(t, &entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	var sent, received []vsafe.Share
+	if err := store.SharesBySender(
+		t, oldId, consume2.AppendTo(&sent)); err != nil {
+		return nil, err
+	}
+	if err := store.SharesByRecipient(
+		t, oldId, consume2.AppendTo(&received)); err != nil {
+		return nil, err
+	}
+	for i := range sent {
+		sent[i].Sender = id
+		if err := store.UpdateShare(t, &sent[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range received {
+		received[i].Recipient = id
+		if err := store.UpdateShare(t, &received[i]); err != nil {
+			return nil, err
+		}
+	}
+	return oldMaster, nil
+}
@@ -0,0 +1,105 @@
+package vsafedb_test
+
+import (
+	"github.com/keep94/vsafe"
+	"github.com/keep94/vsafe/vsafedb"
+	"testing"
+)
+
+func TestTransferOwnership(t *testing.T) {
+	store := newFakeTransferStore()
+	master, sub, other := addMasterAndSubUser(t, store.FakeUserStore)
+	sub.Role = vsafe.RoleReadOnly
+	sub.Categories = "4"
+	if err := store.UpdateUser(nil, sub); err != nil {
+		t.Fatalf("Error updating user %v", err)
+	}
+	masterKey, _ := master.VerifyPassword("password")
+	entryId, err := vsafedb.AddEntry(
+		store, nil, master, masterKey, &vsafe.Entry{Title: "Bank", Password: "abc"})
+	if err != nil {
+		t.Fatalf("Error adding entry %v", err)
+	}
+	store.FakeCategoryStore.Category = &vsafe.Category{
+		Id: 4, Owner: master.Id, Name: "bank"}
+	if err := store.AddShare(nil, &vsafe.Share{
+		Sender: master.Id, Recipient: other.Id}); err != nil {
+		t.Fatalf("Error adding share %v", err)
+	}
+	if err := store.AddShare(nil, &vsafe.Share{
+		Sender: other.Id, Recipient: master.Id}); err != nil {
+		t.Fatalf("Error adding share %v", err)
+	}
+
+	if _, err := vsafedb.TransferOwnership(
+		store, kTransaction, master.Id); err != vsafedb.ErrNotSubUser {
+		t.Errorf("Expected ErrNotSubUser, got %v", err)
+	}
+	oldMaster, err := vsafedb.TransferOwnership(store, kTransaction, sub.Id)
+	if err != nil {
+		t.Fatalf("Error transferring ownership %v", err)
+	}
+	if oldMaster.Id != master.Id || oldMaster.Owner != sub.Id {
+		t.Errorf("Unexpected old master %v", oldMaster)
+	}
+
+	var newMaster vsafe.User
+	if err := store.UserById(nil, sub.Id, &newMaster); err != nil {
+		t.Fatalf("Error reading user %v", err)
+	}
+	if newMaster.Owner != 0 || newMaster.Categories != "" || newMaster.PublicKey != master.PublicKey {
+		t.Errorf("Unexpected new master %v", newMaster)
+	}
+	newKey, err := newMaster.VerifyPassword("pass")
+	if err != nil {
+		t.Fatalf("Error verifying password %v", err)
+	}
+	if newKey.Id != sub.Id {
+		t.Errorf("Expected key id %d, got %d", sub.Id, newKey.Id)
+	}
+	var entry vsafe.Entry
+	if err := vsafedb.EntryById(
+		store, nil, entryId, &newMaster, newKey, &entry); err != nil {
+		t.Fatalf("Error reading entry %v", err)
+	}
+	if entry.Owner != sub.Id || entry.Password != "abc" {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+	if store.FakeCategoryStore.Category.Owner != sub.Id {
+		t.Error("Expected category owner to change")
+	}
+	var readMaster vsafe.User
+	if err := store.UserById(nil, master.Id, &readMaster); err != nil {
+		t.Fatalf("Error reading user %v", err)
+	}
+	if readMaster.Owner != sub.Id || readMaster.HasKeyPair() {
+		t.Errorf("Unexpected old master %v", readMaster)
+	}
+	oldKey, err := readMaster.VerifyPassword("password")
+	if err != nil {
+		t.Fatalf("Error verifying password %v", err)
+	}
+	if !oldKey.Equal(newKey) {
+		t.Error("Expected old master to share the new master's key")
+	}
+	shares := *store.FakeShareStore
+	if shares[0].Sender != sub.Id || shares[1].Recipient != sub.Id {
+		t.Errorf("Expected shares to move, got %v %v", shares[0], shares[1])
+	}
+}
+
+type fakeTransferStore struct {
+	*FakeUserStore
+	*FakeCategoryStore
+	*FakeStore
+	*FakeShareStore
+}
+
+func newFakeTransferStore() fakeTransferStore {
+	return fakeTransferStore{
+		FakeUserStore:     &FakeUserStore{},
+		FakeCategoryStore: &FakeCategoryStore{},
+		FakeStore:         &FakeStore{},
+		FakeShareStore:    &FakeShareStore{},
+	}
+}